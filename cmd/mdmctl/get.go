@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/liuds832/micromdm/pkg/crypto"
@@ -70,6 +72,8 @@ func (cmd *getCommand) Run(args []string) error {
 		run = cmd.getUsers
 	case "apps":
 		run = cmd.getApps
+	case "apps-installed":
+		run = cmd.getInstalledApps
 	case "dep-autoassigners":
 		run = cmd.getDEPAutoAssigners
 	default:
@@ -96,14 +100,21 @@ Valid resource types:
   * users
   * profiles
   * apps
+  * apps-installed
 
 Examples:
   # Get a list of devices
   mdmctl get devices
 
-  # Get a device by serial (TODO implement filtering)
+  # Get a device by serial
   mdmctl get devices -serials=C02ABCDEF
 
+  # Get the enrolled devices not seen for a week, oldest first
+  mdmctl get devices -enrolled=true -not-seen-within=168h -sort=last_seen
+
+  # Get the devices with an app installed below a version
+  mdmctl get apps-installed -bundle-id=com.example.app -below-version=2.0
+
 `
 	fmt.Print(getUsage)
 	return nil
@@ -112,7 +123,7 @@ Examples:
 type devicesTableOutput struct{ w *tabwriter.Writer }
 
 func (out *devicesTableOutput) BasicHeader() {
-	fmt.Fprintf(out.w, "UDID\tSerialNumber\tEnrollmentStatus\tLastSeen\n")
+	fmt.Fprintf(out.w, "UDID\tSerialNumber\tProductName\tOSVersion\tEnrollmentStatus\tLastSeen\n")
 }
 
 func (out *devicesTableOutput) BasicFooter() {
@@ -122,35 +133,81 @@ func (out *devicesTableOutput) BasicFooter() {
 func (cmd *getCommand) getDevices(args []string) error {
 	flagset := flag.NewFlagSet("devices", flag.ExitOnError)
 	var (
-		flFilterSerials = flagset.String("serials", "", "device serial, optionally comma-separated")
+		flFilterSerials     = flagset.String("serials", "", "device serial, optionally comma-separated")
+		flFilterUDIDs       = flagset.String("udids", "", "device UDID, optionally comma-separated")
+		flFilterOSVersions  = flagset.String("os-versions", "", "OS version, optionally comma-separated")
+		flFilterModels      = flagset.String("models", "", "model, optionally comma-separated")
+		flFilterProducts    = flagset.String("product-names", "", "product name, optionally comma-separated")
+		flFilterDEPStatuses = flagset.String("dep-profile-statuses", "", "DEP profile status, optionally comma-separated")
+		flFilterEnrolled    = flagset.String("enrolled", "", "only list enrolled (true) or unenrolled (false) devices")
+		flLastSeenAfter     = flagset.Duration("seen-within", 0, "only list devices last seen within this duration, e.g. 24h")
+		flLastSeenBefore    = flagset.Duration("not-seen-within", 0, "only list devices not seen within this duration, e.g. 720h")
+		flSortBy            = flagset.String("sort", "", "sort by one of: "+strings.Join(device.SortFields, ", "))
+		flSortDesc          = flagset.Bool("desc", false, "sort in descending order")
+		flPage              = flagset.Int("page", 1, "page of devices to list")
+		flPerPage           = flagset.Int("per-page", 0, "number of devices per page, all devices if zero")
 	)
 	flagset.Usage = usageFor(flagset, "mdmctl get devices [flags]")
 	if err := flagset.Parse(args); err != nil {
 		return err
 	}
-	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
-	out := &devicesTableOutput{w}
-	out.BasicHeader()
-	defer out.BasicFooter()
-	ctx := context.Background()
 
-	// convert string to []string in case a filter serial is defined
-	// nil serials will return all serials from the database
-	var flFilterSerialsSlice []string
-	if *flFilterSerials != "" {
-		flFilterSerialsSlice = strings.Split(*flFilterSerials, ",")
+	opt := device.ListDevicesOption{
+		Page:              *flPage,
+		PerPage:           *flPerPage,
+		FilterSerial:      splitList(*flFilterSerials),
+		FilterUDID:        splitList(*flFilterUDIDs),
+		FilterOSVersion:   splitList(*flFilterOSVersions),
+		FilterModel:       splitList(*flFilterModels),
+		FilterProductName: splitList(*flFilterProducts),
+		SortBy:            *flSortBy,
+		SortDesc:          *flSortDesc,
+	}
+	for _, status := range splitList(*flFilterDEPStatuses) {
+		opt.FilterDEPProfileStatus = append(opt.FilterDEPProfileStatus, device.DEPProfileStatus(status))
+	}
+	if *flFilterEnrolled != "" {
+		enrolled, err := strconv.ParseBool(*flFilterEnrolled)
+		if err != nil {
+			return errors.Wrap(err, "parse -enrolled flag")
+		}
+		opt.FilterEnrolled = &enrolled
+	}
+	now := time.Now()
+	if *flLastSeenAfter > 0 {
+		opt.LastSeenAfter = now.Add(-*flLastSeenAfter)
+	}
+	if *flLastSeenBefore > 0 {
+		opt.LastSeenBefore = now.Add(-*flLastSeenBefore)
 	}
 
-	devices, err := cmd.devicesvc.ListDevices(ctx, device.ListDevicesOption{FilterSerial: flFilterSerialsSlice})
+	devices, total, err := cmd.devicesvc.ListDevices(context.Background(), opt)
 	if err != nil {
 		return err
 	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	out := &devicesTableOutput{w}
+	out.BasicHeader()
 	for _, d := range devices {
-		fmt.Fprintf(out.w, "%s\t%s\t%v\t%s\n", d.UDID, d.SerialNumber, d.EnrollmentStatus, d.LastSeen)
+		fmt.Fprintf(out.w, "%s\t%s\t%s\t%s\t%v\t%s\n", d.UDID, d.SerialNumber, d.ProductName, d.OSVersion, d.EnrollmentStatus, d.LastSeen)
+	}
+	out.BasicFooter()
+	if *flPerPage > 0 {
+		fmt.Printf("\nShowing %d of %d devices.\n", len(devices), total)
 	}
 	return nil
 }
 
+// splitList splits a comma-separated flag value. An empty value is an
+// empty list.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
 const defaultmdmctlFilesPath = "mdm-files"
 
 func (cmd *getCommand) getDepTokens(args []string) error {
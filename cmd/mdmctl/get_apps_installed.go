@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+type installedAppsTableOutput struct{ w *tabwriter.Writer }
+
+func (out *installedAppsTableOutput) BasicHeader() {
+	fmt.Fprintf(out.w, "UDID\tSerialNumber\tBundleID\tName\tVersion\tManaged\n")
+}
+
+func (out *installedAppsTableOutput) BasicFooter() {
+	out.w.Flush()
+}
+
+func (cmd *getCommand) getInstalledApps(args []string) error {
+	flagset := flag.NewFlagSet("apps-installed", flag.ExitOnError)
+	var (
+		flBundleIDs    = flagset.String("bundle-id", "", "app bundle identifier, optionally comma-separated")
+		flUDIDs        = flagset.String("udid", "", "device UDID, optionally comma-separated")
+		flMinVersion   = flagset.String("min-version", "", "only list apps with this version or later")
+		flBelowVersion = flagset.String("below-version", "", "only list apps with a version before this one")
+	)
+	flagset.Usage = usageFor(flagset, "mdmctl get apps-installed [flags]")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+
+	opt := device.ListInstalledAppsOption{
+		FilterBundleID: splitList(*flBundleIDs),
+		FilterUDID:     splitList(*flUDIDs),
+		MinVersion:     *flMinVersion,
+		BelowVersion:   *flBelowVersion,
+	}
+
+	apps, err := cmd.devicesvc.ListInstalledApps(context.TODO(), opt)
+	if err != nil {
+		return errors.Wrap(err, "list installed apps")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	out := &installedAppsTableOutput{w}
+	out.BasicHeader()
+	defer out.BasicFooter()
+	for _, a := range apps {
+		version := a.ShortVersion
+		if version == "" {
+			version = a.Version
+		}
+		fmt.Fprintf(out.w, "%s\t%s\t%s\t%s\t%s\t%v\n", a.UDID, a.SerialNumber, a.BundleID, a.Name, version, a.Managed)
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ import (
 
 	"github.com/liuds832/micromdm/pkg/crypto"
 	"github.com/liuds832/micromdm/pkg/crypto/mdmcertutil"
+	"github.com/liuds832/micromdm/platform/config"
 )
 
 type mdmcertCommand struct {
@@ -56,10 +57,15 @@ Once you created the push CSR, you mush sign the push CSR with the MDM Vendor Ce
 Once generated, upload the PushCertificateRequest.plist file to https://identity.apple.com to obtain your MDM Push Certificate.
 Use the push private key and the push cert you got from identity.apple.com in your MDM server.
 
+Instead of the push certificate, pushes can be authenticated with an APNs auth key (.p8 file) from the Apple Developer portal.
+
+    mdmctl mdmcert upload-auth-key -key=AuthKey_ABC123DEFG.p8 -key-id=ABC123DEFG -team-id=DEF123GHIJ -topic=com.apple.mgmt.External.xxx
+
 Commands:
     vendor
     push
     upload
+    upload-auth-key
 
 `
 	fmt.Print(usageText)
@@ -85,6 +91,8 @@ func (cmd *mdmcertCommand) Run(args []string) error {
 		run = cmd.runPush
 	case "upload":
 		run = cmd.runUpload
+	case "upload-auth-key":
+		run = cmd.runUploadAuthKey
 	default:
 		cmd.Usage()
 		os.Exit(1)
@@ -213,6 +221,7 @@ func (cmd *mdmcertCommand) runUpload(args []string) error {
 		flKeyPass  = flagset.String("password", "", "Password to encrypt/read the RSA key.")
 		flKeyPath  = flagset.String("private-key", filepath.Join(mdmcertdir, pushCertificatePrivateKeyFilename), "Path to the push certificate private key.")
 		flCertPath = flagset.String("cert", "", "Path to the MDM Push Certificate.")
+		flForce    = flagset.Bool("force", false, "Replace a push certificate for a different topic. Enrolled devices will have to enroll again.")
 	)
 	if err := flagset.Parse(args); err != nil {
 		return err
@@ -223,13 +232,51 @@ func (cmd *mdmcertCommand) runUpload(args []string) error {
 		return errors.Wrap(err, "load push certificate")
 	}
 
-	if err := cmd.configsvc.SavePushCertificate(context.Background(), cert, key); err != nil {
+	if err := cmd.configsvc.SavePushCertificate(context.Background(), cert, key, *flForce); err != nil {
 		return errors.Wrap(err, "upload push certificate and key to server")
 	}
 
 	return nil
 }
 
+func (cmd *mdmcertCommand) runUploadAuthKey(args []string) error {
+	flagset := flag.NewFlagSet("upload-auth-key", flag.ExitOnError)
+	flagset.Usage = usageFor(flagset, "mdmctl mdmcert upload-auth-key [flags]")
+	var (
+		flKeyPath = flagset.String("key", "", "Path to the APNs auth key (.p8 file).")
+		flKeyID   = flagset.String("key-id", "", "Key ID of the APNs auth key.")
+		flTeamID  = flagset.String("team-id", "", "Team ID of the Apple Developer account.")
+		flTopic   = flagset.String("topic", "", "APNs topic to push to.")
+		flForce   = flagset.Bool("force", false, "Replace a push certificate or auth key for a different topic. Enrolled devices will have to enroll again.")
+	)
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+	if *flKeyPath == "" || *flKeyID == "" || *flTeamID == "" || *flTopic == "" {
+		flagset.Usage()
+		return errors.New("bad input: -key, -key-id, -team-id and -topic flags are required")
+	}
+
+	key, err := ioutil.ReadFile(*flKeyPath)
+	if err != nil {
+		return errors.Wrap(err, "read APNs auth key")
+	}
+	authKey := config.APNSAuthKey{
+		Key:    key,
+		KeyID:  *flKeyID,
+		TeamID: *flTeamID,
+		Topic:  *flTopic,
+	}
+	if err := authKey.Validate(); err != nil {
+		return err
+	}
+
+	if err := cmd.configsvc.SaveAPNSAuthKey(context.Background(), authKey, *flForce); err != nil {
+		return errors.Wrap(err, "upload APNs auth key to server")
+	}
+	return nil
+}
+
 func loadPushCerts(certPath, keyPath, keyPass string) (cert, key []byte, err error) {
 	isP12 := filepath.Ext(certPath) == ".p12"
 	if isP12 {
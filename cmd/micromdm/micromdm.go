@@ -28,6 +28,8 @@ func main() {
 		return
 	case "serve":
 		run = serve
+	case "migrate":
+		run = migrate
 	default:
 		usage()
 		os.Exit(1)
@@ -44,6 +46,7 @@ func usage() error {
 
 Available Commands:
 	serve
+	migrate
 	version
 
 Use micromdm <command> -h for additional usage of each command.
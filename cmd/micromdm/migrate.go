@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/micromdm/go4/env"
+	"github.com/pkg/errors"
+	sq "gopkg.in/Masterminds/squirrel.v1"
+
+	"github.com/liuds832/micromdm/pg"
+	"github.com/liuds832/micromdm/platform/apns"
+	apnsbuiltin "github.com/liuds832/micromdm/platform/apns/builtin"
+	apnspg "github.com/liuds832/micromdm/platform/apns/pg"
+	"github.com/liuds832/micromdm/platform/blueprint"
+	blueprintbuiltin "github.com/liuds832/micromdm/platform/blueprint/builtin"
+	"github.com/liuds832/micromdm/platform/device"
+	devicebuiltin "github.com/liuds832/micromdm/platform/device/builtin"
+	devicepg "github.com/liuds832/micromdm/platform/device/pg"
+	"github.com/liuds832/micromdm/platform/profile"
+	profilebuiltin "github.com/liuds832/micromdm/platform/profile/builtin"
+	"github.com/liuds832/micromdm/platform/queue"
+	queuepg "github.com/liuds832/micromdm/platform/queue/pg"
+	"github.com/liuds832/micromdm/platform/user"
+	userbuiltin "github.com/liuds832/micromdm/platform/user/builtin"
+)
+
+// unexported bucket names of the builtin device store.
+const (
+	deviceIndexBucket  = "mdm.DeviceIdx"
+	udidCertAuthBucket = "mdm.UDIDCertAuth"
+)
+
+// duplicateCommand is a command which was not migrated because a command
+// with the same UUID was read before it. Postgres keys device_commands on
+// the command UUID alone.
+type duplicateCommand struct {
+	uuid     string
+	udid     string
+	keptUDID string
+}
+
+type udidCertHash struct {
+	udid     []byte
+	certHash []byte
+}
+
+// boltData holds the decoded records of a micromdm.db file.
+type boltData struct {
+	devices        []device.Device
+	danglingIndex  int
+	badHistoryKeys int
+	udidCertHashes []udidCertHash
+	pushInfo       []apns.PushInfo
+	deviceCommands []queue.DeviceCommand
+	duplicates     []duplicateCommand
+	profiles       []profile.Profile
+	blueprints     []blueprint.Blueprint
+	users          []user.User
+}
+
+func (d *boltData) commandCount() int {
+	var n int
+	for _, dc := range d.deviceCommands {
+		n += len(dc.Commands) + len(dc.NotNow) + len(dc.Completed) + len(dc.Failed)
+	}
+	return n
+}
+
+func migrate(args []string) error {
+	flagset := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var (
+		flConfigPath  = flagset.String("config-path", env.String("MICROMDM_CONFIG_PATH", "/var/db/micromdm"), "Path to configuration directory containing micromdm.db")
+		flPostgresDSN = flagset.String("postgres-dsn", env.String("MICROMDM_POSTGRES_DSN", ""), "Postgres connection string to migrate the data to")
+		flDryRun      = flagset.Bool("dry-run", false, "Only read and decode the bolt database, do not write to Postgres")
+	)
+	flagset.Usage = usageFor(flagset, "micromdm migrate [flags]")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+	if !*flDryRun && *flPostgresDSN == "" {
+		return errors.New("must supply -postgres-dsn or -dry-run")
+	}
+
+	dbPath := filepath.Join(*flConfigPath, "micromdm.db")
+	boltDB, err := bolt.Open(dbPath, 0644, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return errors.Wrapf(err, "opening boltdb %s, is micromdm still running?", dbPath)
+	}
+	defer boltDB.Close()
+
+	data, err := readBolt(boltDB)
+	if err != nil {
+		return err
+	}
+
+	if *flDryRun {
+		fmt.Println("dry run, nothing was written to postgres")
+		printMigrateReport(data, nil)
+		return nil
+	}
+
+	ctx := context.Background()
+	db, err := sqlx.Connect("postgres", *flPostgresDSN)
+	if err != nil {
+		return errors.Wrap(err, "connecting to postgres")
+	}
+	defer db.Close()
+	if err := pg.Migrate(ctx, db); err != nil {
+		return errors.Wrap(err, "migrating postgres schema")
+	}
+
+	if err := writePostgres(ctx, db, data); err != nil {
+		return err
+	}
+
+	counts, err := countPostgres(ctx, db, data)
+	if err != nil {
+		return err
+	}
+	printMigrateReport(data, counts)
+	return nil
+}
+
+func readBolt(db *bolt.DB) (*boltData, error) {
+	data := new(boltData)
+	err := db.View(func(tx *bolt.Tx) error {
+		err := forEach(tx, devicebuiltin.DeviceBucket, func(k, v []byte) error {
+			var dev device.Device
+			if err := device.UnmarshalDevice(v, &dev); err != nil {
+				return errors.Wrapf(err, "decode device %s", k)
+			}
+			data.devices = append(data.devices, dev)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the index maps UDIDs and serials to device UUIDs. Postgres
+		// indexes the columns directly, so only check its consistency.
+		devices := tx.Bucket([]byte(devicebuiltin.DeviceBucket))
+		err = forEach(tx, deviceIndexBucket, func(k, v []byte) error {
+			if devices == nil || devices.Get(v) == nil {
+				data.danglingIndex++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEach(tx, udidCertAuthBucket, func(k, v []byte) error {
+			data.udidCertHashes = append(data.udidCertHashes, udidCertHash{
+				udid:     append([]byte(nil), k...),
+				certHash: append([]byte(nil), v...),
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEach(tx, apnsbuiltin.PushBucket, func(k, v []byte) error {
+			var info apns.PushInfo
+			if err := apns.UnmarshalPushInfo(v, &info); err != nil {
+				return errors.Wrapf(err, "decode push info %s", k)
+			}
+			data.pushInfo = append(data.pushInfo, info)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEach(tx, queue.DeviceCommandBucket, func(k, v []byte) error {
+			var dc queue.DeviceCommand
+			if err := queue.UnmarshalDeviceCommand(v, &dc); err != nil {
+				return errors.Wrapf(err, "decode device commands %s", k)
+			}
+			data.deviceCommands = append(data.deviceCommands, dc)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// finished commands live in their own bucket, keyed by UDID.
+		history := make(map[string]*queue.DeviceCommand)
+		err = forEach(tx, queue.CommandHistoryBucket, func(k, v []byte) error {
+			// keys are the UDID, a zero byte, the big endian time the
+			// command finished in nanoseconds and the command UUID.
+			sep := bytes.IndexByte(k, 0)
+			if sep < 0 || len(k) < sep+9 {
+				data.badHistoryKeys++
+				return nil
+			}
+			var cmd queue.Command
+			if err := queue.UnmarshalCommand(v, &cmd); err != nil {
+				return errors.Wrapf(err, "decode command history %q", k)
+			}
+			udid := string(k[:sep])
+			dc, ok := history[udid]
+			if !ok {
+				dc = &queue.DeviceCommand{DeviceUDID: udid}
+				history[udid] = dc
+			}
+			if cmd.LastStatus == "Acknowledged" {
+				dc.Completed = append(dc.Completed, cmd)
+			} else {
+				dc.Failed = append(dc.Failed, cmd)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, dc := range history {
+			data.deviceCommands = append(data.deviceCommands, *dc)
+		}
+
+		err = forEach(tx, profilebuiltin.ProfileBucket, func(k, v []byte) error {
+			var p profile.Profile
+			if err := profile.UnmarshalProfile(v, &p); err != nil {
+				return errors.Wrapf(err, "decode profile %s", k)
+			}
+			data.profiles = append(data.profiles, p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = forEach(tx, blueprintbuiltin.BlueprintBucket, func(k, v []byte) error {
+			var bp blueprint.Blueprint
+			if err := blueprint.UnmarshalBlueprint(v, &bp); err != nil {
+				return errors.Wrapf(err, "decode blueprint %s", k)
+			}
+			data.blueprints = append(data.blueprints, bp)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return forEach(tx, userbuiltin.UserBucket, func(k, v []byte) error {
+			var u user.User
+			if err := user.UnmarshalUser(v, &u); err != nil {
+				return errors.Wrapf(err, "decode user %s", k)
+			}
+			data.users = append(data.users, u)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "reading boltdb")
+	}
+	data.removeDuplicateCommands()
+	return data, nil
+}
+
+// removeDuplicateCommands keeps the first command read for each UUID.
+// Writing the others would overwrite that row, and could move it to
+// another device, so they are recorded as duplicates instead.
+func (d *boltData) removeDuplicateCommands() {
+	seen := make(map[string]string)
+	unique := func(udid string, cmds []queue.Command) []queue.Command {
+		kept := cmds[:0]
+		for _, cmd := range cmds {
+			if keptUDID, ok := seen[cmd.UUID]; ok {
+				d.duplicates = append(d.duplicates, duplicateCommand{
+					uuid:     cmd.UUID,
+					udid:     udid,
+					keptUDID: keptUDID,
+				})
+				continue
+			}
+			seen[cmd.UUID] = udid
+			kept = append(kept, cmd)
+		}
+		return kept
+	}
+	for i := range d.deviceCommands {
+		dc := &d.deviceCommands[i]
+		dc.Commands = unique(dc.DeviceUDID, dc.Commands)
+		dc.NotNow = unique(dc.DeviceUDID, dc.NotNow)
+		dc.Completed = unique(dc.DeviceUDID, dc.Completed)
+		dc.Failed = unique(dc.DeviceUDID, dc.Failed)
+	}
+}
+
+// forEach calls fn for every key in bucket. Missing buckets are skipped,
+// as they only exist once the corresponding service was used.
+func forEach(tx *bolt.Tx, bucket string, fn func(k, v []byte) error) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(fn)
+}
+
+func writePostgres(ctx context.Context, db *sqlx.DB, data *boltData) error {
+	devDB := devicepg.New(db)
+	for i := range data.devices {
+		if err := devDB.Save(ctx, &data.devices[i]); err != nil {
+			return errors.Wrapf(err, "write device %s", data.devices[i].UUID)
+		}
+	}
+	for _, h := range data.udidCertHashes {
+		if err := devDB.SaveUDIDCertHash(h.udid, h.certHash); err != nil {
+			return errors.Wrapf(err, "write udid cert hash %s", h.udid)
+		}
+	}
+
+	pushDB := apnspg.New(db)
+	for i := range data.pushInfo {
+		if err := pushDB.Save(ctx, &data.pushInfo[i]); err != nil {
+			return errors.Wrapf(err, "write push info %s", data.pushInfo[i].UDID)
+		}
+	}
+
+	for i := range data.deviceCommands {
+		if err := queuepg.Import(ctx, db, &data.deviceCommands[i]); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range data.profiles {
+		query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Insert("profiles").
+			Columns("identifier", "mobileconfig").
+			Values(p.Identifier, []byte(p.Mobileconfig)).
+			Suffix("ON CONFLICT (identifier) DO UPDATE SET mobileconfig = EXCLUDED.mobileconfig").
+			ToSql()
+		if err != nil {
+			return errors.Wrap(err, "building profile insert query")
+		}
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			return errors.Wrapf(err, "write profile %s", p.Identifier)
+		}
+	}
+
+	for _, bp := range data.blueprints {
+		query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Insert("blueprints").
+			Columns(
+				"uuid",
+				"name",
+				"application_urls",
+				"profile_ids",
+				"user_uuids",
+				"skip_primary_setup_account_creation",
+				"set_primary_setup_account_as_regular_user",
+				"apply_at",
+			).
+			Values(
+				bp.UUID,
+				bp.Name,
+				pq.Array(bp.ApplicationURLs),
+				pq.Array(bp.ProfileIdentifiers),
+				pq.Array(bp.UserUUID),
+				bp.SkipPrimarySetupAccountCreation,
+				bp.SetPrimarySetupAccountAsRegularUser,
+				pq.Array(bp.ApplyAt),
+			).
+			Suffix(`ON CONFLICT (uuid) DO UPDATE SET
+				name = EXCLUDED.name,
+				application_urls = EXCLUDED.application_urls,
+				profile_ids = EXCLUDED.profile_ids,
+				user_uuids = EXCLUDED.user_uuids,
+				skip_primary_setup_account_creation = EXCLUDED.skip_primary_setup_account_creation,
+				set_primary_setup_account_as_regular_user = EXCLUDED.set_primary_setup_account_as_regular_user,
+				apply_at = EXCLUDED.apply_at`).
+			ToSql()
+		if err != nil {
+			return errors.Wrap(err, "building blueprint insert query")
+		}
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			return errors.Wrapf(err, "write blueprint %s", bp.Name)
+		}
+	}
+
+	for _, u := range data.users {
+		query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Insert("users").
+			Columns(
+				"uuid",
+				"udid",
+				"user_id",
+				"user_shortname",
+				"user_longname",
+				"auth_token",
+				"password_hash",
+				"hidden",
+			).
+			Values(
+				u.UUID,
+				u.UDID,
+				u.UserID,
+				u.UserShortname,
+				u.UserLongname,
+				u.AuthToken,
+				u.PasswordHash,
+				u.Hidden,
+			).
+			Suffix(`ON CONFLICT (uuid) DO UPDATE SET
+				udid = EXCLUDED.udid,
+				user_id = EXCLUDED.user_id,
+				user_shortname = EXCLUDED.user_shortname,
+				user_longname = EXCLUDED.user_longname,
+				auth_token = EXCLUDED.auth_token,
+				password_hash = EXCLUDED.password_hash,
+				hidden = EXCLUDED.hidden`).
+			ToSql()
+		if err != nil {
+			return errors.Wrap(err, "building user insert query")
+		}
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			return errors.Wrapf(err, "write user %s", u.UUID)
+		}
+	}
+	return nil
+}
+
+var migrateTables = []string{
+	"devices",
+	"udid_cert_auth",
+	"push_info",
+	"device_commands",
+	"profiles",
+	"blueprints",
+	"users",
+}
+
+// tableKeys are the values of the primary key column of rows in a table.
+type tableKeys struct {
+	column string
+	keys   []string
+}
+
+// migratedKeys returns the keys of the rows written to each of the
+// migrateTables.
+func (d *boltData) migratedKeys() map[string]tableKeys {
+	keys := make(map[string]tableKeys)
+	add := func(table, column, key string) {
+		k := keys[table]
+		k.column = column
+		k.keys = append(k.keys, key)
+		keys[table] = k
+	}
+	for _, dev := range d.devices {
+		add("devices", "uuid", dev.UUID)
+	}
+	for _, h := range d.udidCertHashes {
+		add("udid_cert_auth", "udid", string(h.udid))
+	}
+	for _, info := range d.pushInfo {
+		add("push_info", "udid", info.UDID)
+	}
+	for _, dc := range d.deviceCommands {
+		for _, list := range [][]queue.Command{dc.Commands, dc.NotNow, dc.Completed, dc.Failed} {
+			for _, cmd := range list {
+				add("device_commands", "uuid", cmd.UUID)
+			}
+		}
+	}
+	for _, p := range d.profiles {
+		add("profiles", "identifier", p.Identifier)
+	}
+	for _, bp := range d.blueprints {
+		add("blueprints", "uuid", bp.UUID)
+	}
+	for _, u := range d.users {
+		add("users", "uuid", u.UUID)
+	}
+	return keys
+}
+
+// countPostgres counts the rows of each table which have the key of a
+// migrated record. Rows which were in the tables before the migration are
+// not counted, so the counts can be compared with the bolt records on a
+// database which is not empty, or when the migration is run again.
+func countPostgres(ctx context.Context, db *sqlx.DB, data *boltData) (map[string]int, error) {
+	migrated := data.migratedKeys()
+	counts := make(map[string]int)
+	for _, table := range migrateTables {
+		k, ok := migrated[table]
+		if !ok {
+			counts[table] = 0
+			continue
+		}
+		query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Select("count(*)").
+			From(table).
+			Where(sq.Expr(k.column+" = ANY(?)", pq.Array(k.keys))).
+			ToSql()
+		if err != nil {
+			return nil, errors.Wrap(err, "building count query")
+		}
+		var n int
+		if err := db.GetContext(ctx, &n, query, args...); err != nil {
+			return nil, errors.Wrapf(err, "count rows in %s", table)
+		}
+		counts[table] = n
+	}
+	return counts, nil
+}
+
+// printMigrateReport prints the number of records read from bolt and,
+// unless counts is nil, the number of migrated rows found in Postgres
+// afterwards.
+func printMigrateReport(data *boltData, counts map[string]int) {
+	boltCounts := map[string]int{
+		"devices":         len(data.devices),
+		"udid_cert_auth":  len(data.udidCertHashes),
+		"push_info":       len(data.pushInfo),
+		"device_commands": data.commandCount(),
+		"profiles":        len(data.profiles),
+		"blueprints":      len(data.blueprints),
+		"users":           len(data.users),
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if counts == nil {
+		fmt.Fprintf(w, "TABLE\tBOLT\n")
+	} else {
+		fmt.Fprintf(w, "TABLE\tBOLT\tPOSTGRES\tSTATUS\n")
+	}
+	for _, table := range migrateTables {
+		if counts == nil {
+			fmt.Fprintf(w, "%s\t%d\n", table, boltCounts[table])
+			continue
+		}
+		status := "ok"
+		if counts[table] != boltCounts[table] {
+			status = "mismatch"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", table, boltCounts[table], counts[table], status)
+	}
+	w.Flush()
+
+	if data.danglingIndex > 0 {
+		fmt.Printf("\nwarning: %d entries in %s reference missing devices and were skipped\n", data.danglingIndex, deviceIndexBucket)
+	}
+	if data.badHistoryKeys > 0 {
+		fmt.Printf("\nwarning: %d entries in %s have malformed keys and were skipped\n", data.badHistoryKeys, queue.CommandHistoryBucket)
+	}
+	if len(data.duplicates) > 0 {
+		fmt.Printf("\nwarning: %d commands reuse the UUID of another command and were skipped, device_commands is keyed by command UUID:\n", len(data.duplicates))
+		for _, dup := range data.duplicates {
+			fmt.Printf("  command %s of device %s, kept for device %s\n", dup.uuid, dup.udid, dup.keptUDID)
+		}
+	}
+}
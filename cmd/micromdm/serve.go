@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -29,8 +30,10 @@ import (
 	depapi "github.com/liuds832/micromdm/platform/dep"
 	"github.com/liuds832/micromdm/platform/dep/sync"
 	"github.com/liuds832/micromdm/platform/device"
-	devicebuiltin "github.com/liuds832/micromdm/platform/device/builtin"
 	"github.com/liuds832/micromdm/platform/profile"
+	"github.com/liuds832/micromdm/platform/pubsub"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+	"github.com/liuds832/micromdm/platform/queue"
 	block "github.com/liuds832/micromdm/platform/remove"
 	"github.com/liuds832/micromdm/platform/user"
 	userbuiltin "github.com/liuds832/micromdm/platform/user/builtin"
@@ -119,13 +122,29 @@ func serve(args []string) error {
 		flHomePage               = flagset.Bool("homepage", env.Bool("MICROMDM_HTTP_HOMEPAGE", true), "Hosts a simple built-in webpage at the / address")
 		flSCEPClientValidity     = flagset.Int("scep-client-validity", env.Int("MICROMDM_SCEP_CLIENT_VALIDITY", 365), "Sets the scep certificate validity in days")
 		flNoCmdHistory           = flagset.Bool("no-command-history", env.Bool("MICROMDM_NO_COMMAND_HISTORY", false), "disables saving of command history")
+		flCmdHistoryMaxDays      = flagset.Int("command-history-max-days", env.Int("MICROMDM_COMMAND_HISTORY_MAX_DAYS", 0), "Prune command history older than this many days (0 keeps all history)")
+		flBulkPushRate           = flagset.Int("bulk-push-rate", env.Int("MICROMDM_BULK_PUSH_RATE", queue.DefaultBulkPushRate), "Number of devices per second notified about commands queued in bulk")
+		flPushConcurrency        = flagset.Int("push-concurrency", env.Int("MICROMDM_PUSH_CONCURRENCY", apns.DefaultPushConcurrency), "Number of APNs pushes sent at once for queued commands and bulk pushes")
+		flPushRate               = flagset.Int("push-rate", env.Int("MICROMDM_PUSH_RATE", apns.DefaultPushRate), "Number of APNs pushes per second sent for queued commands and bulk pushes (0 for no limit)")
+		flPushCoalesceSeconds    = flagset.Int("push-coalesce-seconds", env.Int("MICROMDM_PUSH_COALESCE_SECONDS", int(apns.DefaultCoalesceWindow/time.Second)), "Send at most one push to a device within this many seconds for queued commands and bulk pushes")
+		flRenotifyMinutes        = flagset.Int("renotify-minutes", env.Int("MICROMDM_RENOTIFY_MINUTES", int(apns.DefaultRenotifyAfter/time.Minute)), "Push again to devices whose queued commands stay unsent for this many minutes, with exponential backoff (0 disables)")
+		flRenotifyMaxHours       = flagset.Int("renotify-max-hours", env.Int("MICROMDM_RENOTIFY_MAX_HOURS", int(apns.DefaultRenotifyMaxInterval/time.Hour)), "Longest time in hours between automatic pushes to a device with unsent commands")
+		flPushCertExpiryWarnings = flagset.String("push-cert-expiry-warning-days", env.String("MICROMDM_PUSH_CERT_EXPIRY_WARNING_DAYS", "30,14,7,1"), "Comma separated days before the push certificate expires at which to log a warning and send a webhook event (empty disables)")
+		flCmdCoalescing          = flagset.Bool("command-coalescing", env.Bool("MICROMDM_COMMAND_COALESCING", false), "Return the pending command instead of queueing an identical command for the same device")
+		flCmdHistoryMaxEntries   = flagset.Int("command-history-max-entries", env.Int("MICROMDM_COMMAND_HISTORY_MAX_ENTRIES", 0), "Keep at most this many command history entries per device (0 keeps all history)")
 		flUseDynChallenge        = flagset.Bool("use-dynamic-challenge", env.Bool("MICROMDM_USE_DYNAMIC_CHALLENGE", false), "require dynamic SCEP challenges")
 		flGenDynChalEnroll       = flagset.Bool("gen-dynamic-challenge", env.Bool("MICROMDM_GEN_DYNAMIC_CHALLENGE", false), "generate dynamic SCEP challenges in enrollment profile (built-in only)")
 		flValidateSCEPIssuer     = flagset.Bool("validate-scep-issuer", env.Bool("MICROMDM_VALIDATE_SCEP_ISSUER", false), "validate only the issuer of the SCEP certificate rather than the whole certificate")
 		flUDIDCertAuthWarnOnly   = flagset.Bool("udid-cert-auth-warn-only", env.Bool("MICROMDM_UDID_CERT_AUTH_WARN_ONLY", false), "warn only for udid cert mismatches")
 		flValidateSCEPExpiration = flagset.Bool("validate-scep-expiration", env.Bool("MICROMDM_VALIDATE_SCEP_EXPIRATION", false), "validate that the SCEP certificate is still valid")
 		flPrintArgs              = flagset.Bool("print-flags", false, "Print all flags and their values")
-		flQueue                  = flagset.String("queue", env.String("MICROMDM_QUEUE", "builtin"), "command queue type")
+		flQueue                  = flagset.String("queue", env.String("MICROMDM_QUEUE", "builtin"), "command queue type (builtin, inmem or postgres)")
+		flPubSub                 = flagset.String("pubsub", env.String("MICROMDM_PUBSUB", "inmem"), "pubsub type (inmem, builtin or postgres). builtin stores events in boltdb and replays them after a restart, postgres stores events in postgres and requires -datastore=postgres and -queue=postgres. Only one instance may run, as config, push certificates, profiles, SCEP and DEP tokens are still stored in boltdb")
+		flPubSubBufferSize       = flagset.Int("pubsub-buffer-size", env.Int("MICROMDM_PUBSUB_BUFFER_SIZE", inmem.DefaultBufferSize), "Number of events buffered in memory for each inmem pubsub subscription")
+		flPubSubOverflow         = flagset.String("pubsub-overflow", env.String("MICROMDM_PUBSUB_OVERFLOW", string(inmem.Spill)), "What the inmem pubsub does when a subscription buffer is full (block, drop-oldest or spill). spill drops the oldest events once the spill file reaches -pubsub-spill-limit")
+		flPubSubSpillLimit       = flagset.Int("pubsub-spill-limit", env.Int("MICROMDM_PUBSUB_SPILL_LIMIT", inmem.DefaultSpillLimit>>20), "Largest size in MiB of the spill file of each inmem pubsub subscription")
+		flDatastore              = flagset.String("datastore", env.String("MICROMDM_DATASTORE", "builtin"), "datastore type for devices and push info (builtin or postgres)")
+		flPostgresDSN            = flagset.String("postgres-dsn", env.String("MICROMDM_POSTGRES_DSN", ""), "Postgres connection string, required for -datastore=postgres, -queue=postgres and -pubsub=postgres")
 		flDMURL                  = flagset.String("dm", env.String("DM", ""), "URL to send Declarative Management requests to")
 		flLogTime                = flagset.Bool("log-time", false, "Include timestamp in log messages")
 		flP7Skew                 = flagset.Int("device-signature-skew", env.Int("MICROMDM_DEVICE_SIGNATURE_SKEW", 0), "Sets the allowable clock skew (in seconds) when verifying device signatures")
@@ -168,6 +187,10 @@ func serve(args []string) error {
 	if err := os.MkdirAll(*flConfigPath, 0755); err != nil {
 		return errors.Wrapf(err, "creating config directory %s", *flConfigPath)
 	}
+	expiryWarnings, err := parseDays(*flPushCertExpiryWarnings)
+	if err != nil {
+		return errors.Wrap(err, "parse push-cert-expiry-warning-days")
+	}
 	sm := &server.Server{
 		ConfigPath:             *flConfigPath,
 		ServerPublicURL:        strings.TrimRight(*flServerURL, "/"),
@@ -175,6 +198,16 @@ func serve(args []string) error {
 		TLSCertPath:            *flTLSCert,
 		CommandWebhookURL:      *flCommandWebhookURL,
 		NoCmdHistory:           *flNoCmdHistory,
+		CmdHistoryMaxAge:       time.Duration(*flCmdHistoryMaxDays) * 24 * time.Hour,
+		CmdHistoryMaxEntries:   *flCmdHistoryMaxEntries,
+		BulkPushRate:           *flBulkPushRate,
+		CommandCoalescing:      *flCmdCoalescing,
+		PushConcurrency:        *flPushConcurrency,
+		PushRate:               *flPushRate,
+		PushCoalesceWindow:     time.Duration(*flPushCoalesceSeconds) * time.Second,
+		RenotifyAfter:          time.Duration(*flRenotifyMinutes) * time.Minute,
+		RenotifyMaxInterval:    time.Duration(*flRenotifyMaxHours) * time.Hour,
+		PushCertExpiryWarnings: expiryWarnings,
 		UseDynSCEPChallenge:    *flUseDynChallenge,
 		GenDynSCEPChallenge:    *flGenDynChalEnroll,
 		ValidateSCEPIssuer:     *flValidateSCEPIssuer,
@@ -185,6 +218,12 @@ func serve(args []string) error {
 
 		SCEPClientValidity: *flSCEPClientValidity,
 		Queue:              *flQueue,
+		PubSub:             *flPubSub,
+		PubSubBufferSize:   *flPubSubBufferSize,
+		PubSubOverflow:     *flPubSubOverflow,
+		PubSubSpillLimit:   int64(*flPubSubSpillLimit) << 20,
+		Datastore:          *flDatastore,
+		PostgresDSN:        *flPostgresDSN,
 		DMURL:              *flDMURL,
 	}
 	if !sm.UseDynSCEPChallenge {
@@ -212,11 +251,7 @@ func serve(args []string) error {
 		removeService = block.LoggingMiddleware(logger)(svc)
 	}
 
-	devDB, err := devicebuiltin.NewDB(sm.DB)
-	if err != nil {
-		stdlog.Fatal(err)
-	}
-
+	devDB := sm.DeviceDB
 	devWorker := device.NewWorker(devDB, sm.PubClient, logger)
 	go devWorker.Run(context.Background())
 
@@ -240,7 +275,9 @@ func serve(args []string) error {
 		sm.PubClient,
 		logger,
 	)
-	go blueprintWorker.Run(context.Background())
+	sm.Elector.Go("blueprint-worker", func(ctx context.Context) {
+		blueprintWorker.Run(ctx)
+	})
 
 	ctx := context.Background()
 	httpLogger := log.With(logger, "transport", "http")
@@ -284,7 +321,7 @@ func serve(args []string) error {
 		apnsEndpoints := apns.MakeServerEndpoints(sm.APNSPushService, basicAuthEndpointMiddleware)
 		apns.RegisterHTTPHandlers(r, apnsEndpoints, options...)
 
-		devicesvc := device.New(devDB)
+		devicesvc := device.New(devDB, device.WithPushStatus(apns.DevicePushStatus{Store: sm.PushDB}))
 		deviceEndpoints := device.MakeServerEndpoints(devicesvc, basicAuthEndpointMiddleware)
 		device.RegisterHTTPHandlers(r, deviceEndpoints, options...)
 
@@ -328,6 +365,9 @@ func serve(args []string) error {
 		}
 
 		r.HandleFunc("/boltbackup", httputil2.RequireBasicAuth(boltBackup(sm.DB), "micromdm", *flAPIKey, "micromdm"))
+		if reporter, ok := sm.PubClient.(pubsub.StatsReporter); ok {
+			r.HandleFunc("/v1/pubsub/stats", httputil2.RequireBasicAuth(pubsubStats(reporter), "micromdm", *flAPIKey, "micromdm")).Methods("GET")
+		}
 	} else {
 		mainLogger.Log("msg", "no api key specified")
 	}
@@ -367,9 +407,28 @@ func serve(args []string) error {
 		*flDisableRedirect,
 	)
 	err = httputil.ListenAndServe(serveOpts...)
+	// hand the singleton workers over to another instance.
+	sm.Elector.Stop()
 	return errors.Wrap(err, "calling ListenAndServe")
 }
 
+// parseDays parses a comma separated list of days into durations.
+func parseDays(s string) ([]time.Duration, error) {
+	var days []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n <= 0 {
+			return nil, errors.Errorf("invalid number of days %q", field)
+		}
+		days = append(days, time.Duration(n)*24*time.Hour)
+	}
+	return days, nil
+}
+
 // serveOptions configures the []httputil.Options for ListenAndServe
 func serveOptions(
 	handler http.Handler,
@@ -421,6 +480,19 @@ func boltBackup(db *bolt.DB) http.HandlerFunc {
 	}
 }
 
+// pubsubStats reports the depth and lag of every pubsub subscription.
+func pubsubStats(reporter pubsub.StatsReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		resp := struct {
+			Subscriptions []pubsub.SubscriptionStats `json:"subscriptions"`
+		}{reporter.Stats()}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
 func printExamples() {
 	const exampleText = `
 		Quickstart:
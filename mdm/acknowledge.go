@@ -20,7 +20,9 @@ func (svc *MDMService) Acknowledge(ctx context.Context, req AcknowledgeEvent) (p
 		return nil, errors.Wrap(err, "publish connect Response on pubsub")
 	}
 
-	payload, err = svc.queue.Next(ctx, req.Response)
+	resp := req.Response
+	resp.Raw = req.Raw
+	payload, err = svc.queue.Next(ctx, resp)
 	return payload, errors.Wrap(err, "calling Next with mdm response")
 
 }
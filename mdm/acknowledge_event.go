@@ -21,9 +21,14 @@ type Response struct {
 	UDID         string
 	UserID       *string `json:"user_id,omitempty" plist:"UserID,omitempty"`
 	EnrollmentID *string `json:"enrollment_id,omitempty" plist:"EnrollmentID,omitempty"`
-	Status       string
-	CommandUUID  string
-	ErrorChain   []ErrorChainItem `json:"error_chain" plist:",omitempty"`
+	// EnrollmentUserID is set for the user channel of a User Enrollment.
+	EnrollmentUserID *string `json:"enrollment_user_id,omitempty" plist:"EnrollmentUserID,omitempty"`
+	Status           string
+	CommandUUID      string
+	ErrorChain       []ErrorChainItem `json:"error_chain" plist:",omitempty"`
+
+	// Raw is the response plist sent by the device.
+	Raw []byte `json:"-" plist:"-"`
 }
 
 type ErrorChainItem struct {
@@ -46,6 +51,9 @@ func MarshalAcknowledgeEvent(e *AcknowledgeEvent) ([]byte, error) {
 	if e.Response.EnrollmentID != nil {
 		response.EnrollmentId = *e.Response.EnrollmentID
 	}
+	if e.Response.EnrollmentUserID != nil {
+		response.EnrollmentUserId = *e.Response.EnrollmentUserID
+	}
 
 	return proto.Marshal(&connectproto.Event{
 		Id:       e.ID,
@@ -68,12 +76,13 @@ func UnmarshalAcknowledgeEvent(data []byte, e *AcknowledgeEvent) error {
 	}
 	r := pb.GetResponse()
 	e.Response = Response{
-		UDID:         r.GetUdid(),
-		UserID:       strPtr(r.GetUserId()),
-		EnrollmentID: strPtr(r.GetEnrollmentId()),
-		Status:       r.GetStatus(),
-		RequestType:  r.GetRequestType(),
-		CommandUUID:  r.GetCommandUuid(),
+		UDID:             r.GetUdid(),
+		UserID:           strPtr(r.GetUserId()),
+		EnrollmentID:     strPtr(r.GetEnrollmentId()),
+		EnrollmentUserID: strPtr(r.GetEnrollmentUserId()),
+		Status:           r.GetStatus(),
+		RequestType:      r.GetRequestType(),
+		CommandUUID:      r.GetCommandUuid(),
 	}
 	e.Raw = pb.GetRaw()
 	e.Params = pb.GetParams()
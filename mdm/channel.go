@@ -0,0 +1,38 @@
+package mdm
+
+// ChannelID returns the ID of the enrollment channel the checkin was
+// sent on. Commands are queued, and push info is stored, by this ID:
+//
+//   - the EnrollmentUserID for the user channel of a User Enrollment
+//   - the EnrollmentID for a User Enrollment
+//   - the UserID for the user channel of a device enrollment
+//   - the UDID for a device
+func (c CheckinCommand) ChannelID() string {
+	return channelID(c.UDID, c.UserID, c.EnrollmentID, c.EnrollmentUserID)
+}
+
+// ChannelID returns the ID of the enrollment channel the response was
+// sent on. See CheckinCommand.ChannelID.
+func (r Response) ChannelID() string {
+	return channelID(r.UDID, deref(r.UserID), deref(r.EnrollmentID), deref(r.EnrollmentUserID))
+}
+
+func channelID(udid, userID, enrollmentID, enrollmentUserID string) string {
+	switch {
+	case enrollmentUserID != "":
+		return enrollmentUserID
+	case enrollmentID != "":
+		return enrollmentID
+	case userID != "":
+		return userID
+	default:
+		return udid
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
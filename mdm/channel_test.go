@@ -0,0 +1,55 @@
+package mdm
+
+import "testing"
+
+func TestChannelID(t *testing.T) {
+	tests := []struct {
+		name    string
+		command CheckinCommand
+		want    string
+	}{
+		{"device", CheckinCommand{UDID: "UDID"}, "UDID"},
+		{"user channel", CheckinCommand{UDID: "UDID", UserID: "USER"}, "USER"},
+		{"user enrollment", CheckinCommand{EnrollmentID: "ENROLLMENT"}, "ENROLLMENT"},
+		{"user enrollment user channel", CheckinCommand{EnrollmentID: "ENROLLMENT", EnrollmentUserID: "ENROLLMENT-USER"}, "ENROLLMENT-USER"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if have := tt.command.ChannelID(); have != tt.want {
+				t.Errorf("have checkin channel %s, want %s", have, tt.want)
+			}
+
+			resp := Response{
+				UDID:             tt.command.UDID,
+				UserID:           strPtr(tt.command.UserID),
+				EnrollmentID:     strPtr(tt.command.EnrollmentID),
+				EnrollmentUserID: strPtr(tt.command.EnrollmentUserID),
+			}
+			if have := resp.ChannelID(); have != tt.want {
+				t.Errorf("have response channel %s, want %s", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckinEvent_ChannelRoundTrip(t *testing.T) {
+	for _, messageType := range []string{"TokenUpdate", "CheckOut"} {
+		ev := CheckinEvent{Command: CheckinCommand{
+			MessageType:      messageType,
+			EnrollmentID:     "ENROLLMENT",
+			EnrollmentUserID: "ENROLLMENT-USER",
+			UserID:           "USER",
+		}}
+		data, err := MarshalCheckinEvent(&ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var have CheckinEvent
+		if err := UnmarshalCheckinEvent(data, &have); err != nil {
+			t.Fatal(err)
+		}
+		if have.Command.UserID != "USER" || have.Command.EnrollmentUserID != "ENROLLMENT-USER" {
+			t.Errorf("%s: lost the user channel IDs: %+v", messageType, have.Command)
+		}
+	}
+}
@@ -65,13 +65,7 @@ func (svc *MDMService) Checkin(ctx context.Context, event CheckinEvent) ([]byte,
 			return nil, stderrors.New("no Declarative Management handler")
 		}
 
-		udid := event.Command.UDID
-		if event.Command.UserID != "" {
-			udid = event.Command.UserID
-		}
-		if event.Command.EnrollmentID != "" {
-			udid = event.Command.EnrollmentID
-		}
+		udid := event.Command.ChannelID()
 
 		resp, err = svc.dm.DeclarativeManagement(ctx, udid, event.Command.Endpoint, event.Command.Data)
 		if err != nil {
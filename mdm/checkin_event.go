@@ -22,10 +22,17 @@ type CheckinCommand struct {
 	// MessageType can be either
 	// Authenticate, CheckOut, TokenUpdate,
 	// GetBootstrapToken, or SetBootstrapToken
-	MessageType  string
-	Topic        string
-	UDID         string
-	EnrollmentID string
+	MessageType string
+	Topic       string
+	UDID        string
+
+	// UserID is set for the user channel of a device enrollment.
+	UserID string `plist:",omitempty"`
+	// EnrollmentID is set for a User Enrollment, and EnrollmentUserID for
+	// its user channel.
+	EnrollmentID     string `plist:",omitempty"`
+	EnrollmentUserID string `plist:",omitempty"`
+
 	auth
 	update
 	getBootstrap
@@ -58,7 +65,6 @@ type update struct {
 
 // TokenUpdate with user keys
 type userTokenUpdate struct {
-	UserID        string `plist:",omitempty"`
 	UserLongName  string `plist:",omitempty"`
 	UserShortName string `plist:",omitempty"`
 	NotOnConsole  bool   `plist:",omitempty"`
@@ -93,10 +99,12 @@ func (d hexData) String() string {
 // MarshalCheckinEvent serializes an event to a protocol buffer wire format.
 func MarshalCheckinEvent(e *CheckinEvent) ([]byte, error) {
 	command := &checkinproto.Command{
-		MessageType:  e.Command.MessageType,
-		Topic:        e.Command.Topic,
-		Udid:         e.Command.UDID,
-		EnrollmentId: e.Command.EnrollmentID,
+		MessageType:      e.Command.MessageType,
+		Topic:            e.Command.Topic,
+		Udid:             e.Command.UDID,
+		EnrollmentId:     e.Command.EnrollmentID,
+		UserId:           e.Command.UserID,
+		EnrollmentUserId: e.Command.EnrollmentUserID,
 	}
 	switch e.Command.MessageType {
 	case "Authenticate":
@@ -160,10 +168,12 @@ func UnmarshalCheckinEvent(data []byte, e *CheckinEvent) error {
 		return nil
 	}
 	e.Command = CheckinCommand{
-		MessageType:  pb.Command.MessageType,
-		Topic:        pb.Command.Topic,
-		UDID:         pb.Command.Udid,
-		EnrollmentID: pb.Command.EnrollmentId,
+		MessageType:      pb.Command.MessageType,
+		Topic:            pb.Command.Topic,
+		UDID:             pb.Command.Udid,
+		UserID:           pb.Command.UserId,
+		EnrollmentID:     pb.Command.EnrollmentId,
+		EnrollmentUserID: pb.Command.EnrollmentUserId,
 	}
 	switch pb.Command.MessageType {
 	case "Authenticate":
@@ -182,7 +192,10 @@ func UnmarshalCheckinEvent(data []byte, e *CheckinEvent) error {
 		e.Command.PushMagic = pb.Command.TokenUpdate.PushMagic
 		e.Command.UnlockToken = pb.Command.TokenUpdate.UnlockToken
 		e.Command.AwaitingConfiguration = pb.Command.TokenUpdate.AwaitingConfiguration
-		e.Command.UserID = pb.Command.TokenUpdate.UserId
+		if e.Command.UserID == "" {
+			// events published before the UserID moved to the Command.
+			e.Command.UserID = pb.Command.TokenUpdate.UserId
+		}
 		e.Command.UserLongName = pb.Command.TokenUpdate.UserLongName
 		e.Command.UserShortName = pb.Command.TokenUpdate.UserShortName
 		e.Command.NotOnConsole = pb.Command.TokenUpdate.NotOnConsole
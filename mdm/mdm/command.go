@@ -2,6 +2,7 @@ package mdm
 
 import (
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/liuds832/micromdm/mdm/appmanifest"
@@ -10,6 +11,20 @@ import (
 type CommandRequest struct {
 	UDID        string `json:"udid"`
 	CommandUUID string `json:"command_uuid"`
+
+	// NotBefore and NotAfter limit when the command may be sent to the
+	// device. Zero values mean no limit.
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+
+	// IdempotencyKey identifies a request which may be repeated. A
+	// command with the same key which is still pending for the device is
+	// returned instead of queueing a new one.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Priority orders the command queue of the device. Commands with a
+	// higher priority are sent first.
+	Priority int `json:"priority,omitempty"`
 	*Command
 }
 
@@ -3,15 +3,20 @@ package mdm
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 func (c *CommandRequest) UnmarshalJSON(data []byte) error {
 	var request = struct {
-		UDID        string `json:"udid"`
-		RequestType string `json:"request_type"`
-		CommandUUID string `json:"command_uuid"`
+		UDID           string    `json:"udid"`
+		RequestType    string    `json:"request_type"`
+		CommandUUID    string    `json:"command_uuid"`
+		NotBefore      time.Time `json:"not_before"`
+		NotAfter       time.Time `json:"not_after"`
+		IdempotencyKey string    `json:"idempotency_key"`
+		Priority       int       `json:"priority"`
 	}{}
 	if err := json.Unmarshal(data, &request); err != nil {
 		return errors.Wrap(err, "mdm: unmarshal json command request")
@@ -19,6 +24,10 @@ func (c *CommandRequest) UnmarshalJSON(data []byte) error {
 	c.UDID = request.UDID
 	c.Command = &Command{}
 	c.CommandUUID = request.CommandUUID
+	c.NotBefore = request.NotBefore
+	c.NotAfter = request.NotAfter
+	c.IdempotencyKey = request.IdempotencyKey
+	c.Priority = request.Priority
 	return c.Command.UnmarshalJSON(data)
 }
 
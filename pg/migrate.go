@@ -0,0 +1,197 @@
+// Package pg holds the Postgres schema used by the pg datastores.
+//
+// The SQL files in the migrations folder use the goose format, and can be
+// applied with the goose CLI or by calling Migrate. Both track the applied
+// versions in the goose_db_version table.
+package pg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"embed"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+const versionTable = "goose_db_version"
+
+type migration struct {
+	version    int64
+	name       string
+	statements []string
+}
+
+// Migrate applies all pending migrations in a single transaction.
+// Concurrent callers are serialized with an advisory lock, so multiple
+// instances may call Migrate on startup.
+func Migrate(ctx context.Context, db *sqlx.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin migration transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, versionTable); err != nil {
+		return errors.Wrap(err, "lock migrations")
+	}
+
+	applied, err := appliedVersions(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		for _, stmt := range m.statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return errors.Wrapf(err, "apply migration %s", m.name)
+			}
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO `+versionTable+` (version_id, is_applied) VALUES ($1, true)`, m.version)
+		if err != nil {
+			return errors.Wrapf(err, "record migration %s", m.name)
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "commit migrations")
+}
+
+func appliedVersions(ctx context.Context, tx *sqlx.Tx) (map[int64]bool, error) {
+	_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+versionTable+` (
+		id serial NOT NULL,
+		version_id bigint NOT NULL,
+		is_applied boolean NOT NULL,
+		tstamp timestamp NULL default now(),
+		PRIMARY KEY(id)
+	)`)
+	if err != nil {
+		return nil, errors.Wrap(err, "create migration version table")
+	}
+
+	rows, err := tx.QueryxContext(ctx, `SELECT version_id, is_applied FROM `+versionTable+` ORDER BY id DESC`)
+	if err != nil {
+		return nil, errors.Wrap(err, "select migration versions")
+	}
+	defer rows.Close()
+
+	// the most recent row for a version decides whether it is applied.
+	seen := make(map[int64]bool)
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var (
+			version   int64
+			isApplied bool
+		)
+		if err := rows.Scan(&version, &isApplied); err != nil {
+			return nil, errors.Wrap(err, "scan migration version")
+		}
+		if seen[version] {
+			continue
+		}
+		seen[version] = true
+		applied[version] = isApplied
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "read migration versions")
+	}
+
+	// goose records version 0 when it creates the version table.
+	if len(seen) == 0 {
+		_, err := tx.ExecContext(ctx, `INSERT INTO `+versionTable+` (version_id, is_applied) VALUES (0, true)`)
+		return applied, errors.Wrap(err, "record initial migration version")
+	}
+	return applied, nil
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "read migrations")
+	}
+
+	var migrations []migration
+	for _, e := range entries {
+		name := e.Name()
+		version, err := strconv.ParseInt(strings.SplitN(name, "_", 2)[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse version of migration %s", name)
+		}
+		data, err := migrationFS.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read migration %s", name)
+		}
+		migrations = append(migrations, migration{
+			version:    version,
+			name:       name,
+			statements: upStatements(data),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// upStatements returns the statements in the "+goose Up" section of a
+// migration. Statements end with a semicolon, unless they are wrapped in
+// "+goose StatementBegin" and "+goose StatementEnd".
+func upStatements(data []byte) []string {
+	var (
+		statements []string
+		buf        bytes.Buffer
+		up         bool
+		inBlock    bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "-- +goose") {
+			switch strings.TrimSpace(strings.TrimPrefix(trimmed, "-- +goose")) {
+			case "Up":
+				up = true
+			case "Down":
+				up = false
+			case "StatementBegin":
+				inBlock = true
+			case "StatementEnd":
+				inBlock = false
+				if up && buf.Len() > 0 {
+					statements = append(statements, buf.String())
+				}
+				buf.Reset()
+			}
+			continue
+		}
+		if !up || (!inBlock && (trimmed == "" || strings.HasPrefix(trimmed, "--"))) {
+			continue
+		}
+
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if !inBlock && strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, buf.String())
+			buf.Reset()
+		}
+	}
+	return statements
+}
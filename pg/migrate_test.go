@@ -0,0 +1,49 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpStatements(t *testing.T) {
+	data := []byte(`-- +goose Up
+-- create the table
+CREATE TABLE foo (
+    id TEXT PRIMARY KEY
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION bar() RETURNS void AS $$
+BEGIN
+    PERFORM 1;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE foo;
+`)
+
+	want := []string{
+		"CREATE TABLE foo (\n    id TEXT PRIMARY KEY\n);\n",
+		"CREATE FUNCTION bar() RETURNS void AS $$\nBEGIN\n    PERFORM 1;\nEND;\n$$ LANGUAGE plpgsql;\n",
+	}
+	if have := upStatements(data); !reflect.DeepEqual(have, want) {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if have, want := m.version, int64(i+1); have != want {
+			t.Errorf("%s: have version %d, want %d", m.name, have, want)
+		}
+		if len(m.statements) == 0 {
+			t.Errorf("%s: no up statements", m.name)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE SEQUENCE IF NOT EXISTS device_commands_position_seq;
+
+CREATE TABLE IF NOT EXISTS device_commands (
+    uuid TEXT PRIMARY KEY,
+    device_udid TEXT NOT NULL,
+    payload BYTEA,
+    state TEXT NOT NULL DEFAULT 'pending',
+    position BIGINT NOT NULL DEFAULT nextval('device_commands_position_seq'),
+    created_at TIMESTAMP DEFAULT (now() at time zone 'utc'),
+    last_sent_at TIMESTAMP DEFAULT '1970-01-01 00:00:00',
+    acknowledged TIMESTAMP DEFAULT '1970-01-01 00:00:00',
+    times_sent INTEGER DEFAULT 0,
+    last_status TEXT DEFAULT '',
+    failure_message BYTEA
+);
+
+CREATE INDEX IF NOT EXISTS device_commands_udid_state_position_idx
+    ON device_commands (device_udid, state, position);
+
+
+-- +goose Down
+DROP TABLE IF EXISTS device_commands;
+DROP SEQUENCE IF EXISTS device_commands_position_seq;
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS bootstrap_token BYTEA;
+
+CREATE TABLE IF NOT EXISTS udid_cert_auth (
+    udid TEXT PRIMARY KEY,
+    cert_hash BYTEA NOT NULL
+);
+
+
+-- +goose Down
+DROP TABLE IF EXISTS udid_cert_auth;
+ALTER TABLE devices DROP COLUMN IF EXISTS bootstrap_token;
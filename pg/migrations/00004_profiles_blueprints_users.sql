@@ -0,0 +1,35 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS profiles (
+    identifier TEXT PRIMARY KEY,
+    mobileconfig BYTEA
+);
+
+CREATE TABLE IF NOT EXISTS blueprints (
+    uuid TEXT PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    application_urls TEXT[] DEFAULT '{}',
+    profile_ids TEXT[] DEFAULT '{}',
+    user_uuids TEXT[] DEFAULT '{}',
+    skip_primary_setup_account_creation BOOLEAN DEFAULT false,
+    set_primary_setup_account_as_regular_user BOOLEAN DEFAULT false,
+    apply_at TEXT[] DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    uuid TEXT PRIMARY KEY,
+    udid TEXT DEFAULT '',
+    user_id TEXT DEFAULT '',
+    user_shortname TEXT DEFAULT '',
+    user_longname TEXT DEFAULT '',
+    auth_token TEXT DEFAULT '',
+    password_hash BYTEA,
+    hidden BOOLEAN DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS users_udid_idx ON users (udid);
+
+
+-- +goose Down
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS blueprints;
+DROP TABLE IF EXISTS profiles;
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS max_attempts INTEGER DEFAULT 0;
+ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+
+-- +goose Down
+ALTER TABLE device_commands DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE device_commands DROP COLUMN IF EXISTS max_attempts;
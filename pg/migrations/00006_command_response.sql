@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS response BYTEA;
+
+
+-- +goose Down
+ALTER TABLE device_commands DROP COLUMN IF EXISTS response;
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS not_before TIMESTAMP;
+ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS not_after TIMESTAMP;
+
+
+-- +goose Down
+ALTER TABLE device_commands DROP COLUMN IF EXISTS not_after;
+ALTER TABLE device_commands DROP COLUMN IF EXISTS not_before;
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS depends_on TEXT[];
+
+
+-- +goose Down
+ALTER TABLE device_commands DROP COLUMN IF EXISTS depends_on;
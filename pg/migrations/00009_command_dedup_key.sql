@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS dedup_key TEXT;
+CREATE INDEX IF NOT EXISTS device_commands_dedup_key_idx ON device_commands (device_udid, dedup_key) WHERE dedup_key IS NOT NULL;
+
+
+-- +goose Down
+DROP INDEX IF EXISTS device_commands_dedup_key_idx;
+ALTER TABLE device_commands DROP COLUMN IF EXISTS dedup_key;
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE device_commands ADD COLUMN IF NOT EXISTS priority INTEGER DEFAULT 0;
+
+
+-- +goose Down
+ALTER TABLE device_commands DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS pubsub_events (
+    id BIGSERIAL PRIMARY KEY,
+    topic TEXT NOT NULL,
+    message BYTEA,
+    created_at TIMESTAMP DEFAULT (now() at time zone 'utc')
+);
+
+CREATE INDEX IF NOT EXISTS pubsub_events_topic_id_idx
+    ON pubsub_events (topic, id);
+
+CREATE TABLE IF NOT EXISTS pubsub_subscriptions (
+    topic TEXT NOT NULL,
+    name TEXT NOT NULL,
+    last_id BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (topic, name)
+);
+
+
+-- +goose Down
+DROP TABLE IF EXISTS pubsub_subscriptions;
+DROP TABLE IF EXISTS pubsub_events;
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE push_info ADD COLUMN IF NOT EXISTS last_push_at TIMESTAMP;
+ALTER TABLE push_info ADD COLUMN IF NOT EXISTS last_push_id TEXT DEFAULT '';
+ALTER TABLE push_info ADD COLUMN IF NOT EXISTS last_push_status INTEGER DEFAULT 0;
+ALTER TABLE push_info ADD COLUMN IF NOT EXISTS last_push_reason TEXT DEFAULT '';
+ALTER TABLE push_info ADD COLUMN IF NOT EXISTS invalid BOOLEAN DEFAULT FALSE;
+
+
+-- +goose Down
+ALTER TABLE push_info DROP COLUMN IF EXISTS last_push_at;
+ALTER TABLE push_info DROP COLUMN IF EXISTS last_push_id;
+ALTER TABLE push_info DROP COLUMN IF EXISTS last_push_status;
+ALTER TABLE push_info DROP COLUMN IF EXISTS last_push_reason;
+ALTER TABLE push_info DROP COLUMN IF EXISTS invalid;
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE push_info ADD COLUMN IF NOT EXISTS renotify_attempts INTEGER DEFAULT 0;
+
+
+-- +goose Down
+ALTER TABLE push_info DROP COLUMN IF EXISTS renotify_attempts;
@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS device_inventory (
+    udid TEXT PRIMARY KEY,
+    device_information_at TIMESTAMP,
+    security_info_at TIMESTAMP,
+    certificate_list_at TIMESTAMP,
+    os_version TEXT DEFAULT '',
+    build_version TEXT DEFAULT '',
+    product_name TEXT DEFAULT '',
+    model_name TEXT DEFAULT '',
+    model TEXT DEFAULT '',
+    device_name TEXT DEFAULT '',
+    serial_number TEXT DEFAULT '',
+    battery_level DOUBLE PRECISION DEFAULT 0,
+    device_capacity DOUBLE PRECISION DEFAULT 0,
+    available_device_capacity DOUBLE PRECISION DEFAULT 0,
+    is_supervised BOOLEAN DEFAULT FALSE,
+    wifi_mac TEXT DEFAULT '',
+    bluetooth_mac TEXT DEFAULT '',
+    fde_enabled BOOLEAN DEFAULT FALSE,
+    sip_enabled BOOLEAN DEFAULT FALSE,
+    firewall_enabled BOOLEAN DEFAULT FALSE,
+    passcode_present BOOLEAN DEFAULT FALSE,
+    certificates JSONB DEFAULT '[]'
+);
+
+
+-- +goose Down
+DROP TABLE IF EXISTS device_inventory;
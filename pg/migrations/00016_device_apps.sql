@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS device_app_inventory (
+    udid TEXT PRIMARY KEY,
+    installed_application_list_at TIMESTAMP,
+    managed_application_list_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS device_installed_apps (
+    udid TEXT NOT NULL,
+    bundle_id TEXT NOT NULL,
+    version TEXT DEFAULT '',
+    short_version TEXT DEFAULT '',
+    name TEXT DEFAULT '',
+    bundle_size BIGINT DEFAULT 0,
+    managed BOOLEAN DEFAULT FALSE,
+    managed_status TEXT DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS device_installed_apps_udid_idx ON device_installed_apps (udid);
+CREATE INDEX IF NOT EXISTS device_installed_apps_bundle_id_idx ON device_installed_apps (bundle_id);
+
+CREATE TABLE IF NOT EXISTS device_managed_apps (
+    udid TEXT NOT NULL,
+    bundle_id TEXT NOT NULL,
+    status TEXT DEFAULT '',
+    PRIMARY KEY (udid, bundle_id)
+);
+
+
+-- +goose Down
+DROP TABLE IF EXISTS device_managed_apps;
+DROP TABLE IF EXISTS device_installed_apps;
+DROP TABLE IF EXISTS device_app_inventory;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS devices_udid_idx ON devices (udid);
+CREATE INDEX IF NOT EXISTS devices_serial_number_idx ON devices (serial_number);
+CREATE INDEX IF NOT EXISTS devices_os_version_idx ON devices (os_version);
+CREATE INDEX IF NOT EXISTS devices_model_idx ON devices (model);
+CREATE INDEX IF NOT EXISTS devices_product_name_idx ON devices (product_name);
+CREATE INDEX IF NOT EXISTS devices_last_seen_idx ON devices (last_seen);
+
+
+-- +goose Down
+DROP INDEX IF EXISTS devices_udid_idx;
+DROP INDEX IF EXISTS devices_serial_number_idx;
+DROP INDEX IF EXISTS devices_os_version_idx;
+DROP INDEX IF EXISTS devices_model_idx;
+DROP INDEX IF EXISTS devices_product_name_idx;
+DROP INDEX IF EXISTS devices_last_seen_idx;
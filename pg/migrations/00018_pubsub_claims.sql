@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE pubsub_subscriptions ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP;
+
+
+-- +goose Down
+ALTER TABLE pubsub_subscriptions DROP COLUMN IF EXISTS claimed_until;
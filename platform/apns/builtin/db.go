@@ -61,17 +61,51 @@ func (db *DB) Save(ctx context.Context, info *apns.PushInfo) error {
 	if err != nil {
 		return errors.Wrap(err, "begin transaction")
 	}
+	defer tx.Rollback()
 	bkt := tx.Bucket([]byte(PushBucket))
 	if bkt == nil {
 		return fmt.Errorf("bucket %q not found!", PushBucket)
 	}
-	pushproto, err := apns.MarshalPushInfo(info)
+	key := []byte(info.UDID)
+	// keep the result of the last push. A new token is valid until
+	// a push to it fails.
+	save := *info
+	if v := bkt.Get(key); v != nil {
+		var prev apns.PushInfo
+		if err := apns.UnmarshalPushInfo(v, &prev); err != nil {
+			return errors.Wrap(err, "unmarshal existing PushInfo")
+		}
+		if last := prev.LastPush(); last != nil {
+			save.SetLastPush(last)
+		}
+		save.Invalid = prev.Invalid && prev.Token == info.Token
+	}
+	pushproto, err := apns.MarshalPushInfo(&save)
 	if err != nil {
 		return errors.Wrap(err, "marshalling PushInfo")
 	}
-	key := []byte(info.UDID)
 	if err := bkt.Put(key, pushproto); err != nil {
 		return errors.Wrap(err, "put PushInfo to boltdb")
 	}
 	return tx.Commit()
 }
+
+func (db *DB) SavePushResult(ctx context.Context, r *apns.PushResult) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(PushBucket))
+		v := bkt.Get([]byte(r.UDID))
+		if v == nil {
+			return &notFound{"PushInfo", fmt.Sprintf("udid %s", r.UDID)}
+		}
+		var info apns.PushInfo
+		if err := apns.UnmarshalPushInfo(v, &info); err != nil {
+			return err
+		}
+		info.SetLastPush(r)
+		pushproto, err := apns.MarshalPushInfo(&info)
+		if err != nil {
+			return errors.Wrap(err, "marshalling PushInfo")
+		}
+		return errors.Wrap(bkt.Put([]byte(r.UDID), pushproto), "put PushInfo to boltdb")
+	})
+}
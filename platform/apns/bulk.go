@@ -0,0 +1,111 @@
+package apns
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/pkg/httputil"
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+// DeviceLister lists the devices for a push to all enrolled devices.
+type DeviceLister interface {
+	List(ctx context.Context, opt device.ListDevicesOption) ([]device.Device, error)
+}
+
+// BulkPushOptions selects the devices of a bulk push.
+type BulkPushOptions struct {
+	UDIDs       []string `json:"udids,omitempty"`
+	AllEnrolled bool     `json:"all_enrolled,omitempty"`
+}
+
+// BulkPushResult counts the pushes of a bulk push. The pushes are sent in
+// the background, and their results recorded with the PushInfo.
+type BulkPushResult struct {
+	// Queued pushes are scheduled to be sent.
+	Queued int `json:"queued"`
+	// Coalesced pushes were merged with a push which was already scheduled.
+	Coalesced int `json:"coalesced"`
+}
+
+func (svc *PushService) BulkPush(ctx context.Context, opt BulkPushOptions) (BulkPushResult, error) {
+	var result BulkPushResult
+	udids := opt.UDIDs
+	if opt.AllEnrolled {
+		if svc.devices == nil {
+			return result, errors.New("push to all enrolled devices is not supported")
+		}
+		devices, err := svc.devices.List(ctx, device.ListDevicesOption{})
+		if err != nil {
+			return result, errors.Wrap(err, "list enrolled devices for bulk push")
+		}
+		for _, d := range devices {
+			if d.Enrolled {
+				udids = append(udids, d.UDID)
+			}
+		}
+	}
+	if len(udids) == 0 {
+		return result, errors.New("bulk push requires udids or all_enrolled")
+	}
+	seen := make(map[string]bool, len(udids))
+	for _, udid := range udids {
+		if seen[udid] {
+			result.Coalesced++
+			continue
+		}
+		seen[udid] = true
+		if svc.dispatcher.Dispatch(udid) {
+			result.Queued++
+		} else {
+			result.Coalesced++
+		}
+	}
+	return result, nil
+}
+
+type bulkPushRequest struct {
+	BulkPushOptions
+}
+
+type bulkPushResponse struct {
+	BulkPushResult
+	Err error `json:"error,omitempty"`
+}
+
+func (r bulkPushResponse) Failed() error { return r.Err }
+
+func decodeBulkPushRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req bulkPushRequest
+	err := httputil.DecodeJSONRequest(r, &req)
+	return req, err
+}
+
+func MakeBulkPushEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(bulkPushRequest)
+		result, err := svc.BulkPush(ctx, req.BulkPushOptions)
+		return bulkPushResponse{BulkPushResult: result, Err: err}, nil
+	}
+}
+
+func (mw loggingMiddleware) BulkPush(ctx context.Context, opt BulkPushOptions) (result BulkPushResult, err error) {
+	defer func(begin time.Time) {
+		_ = mw.logger.Log(
+			"method", "BulkPush",
+			"devices", len(opt.UDIDs),
+			"all_enrolled", opt.AllEnrolled,
+			"queued", result.Queued,
+			"coalesced", result.Coalesced,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+
+	result, err = mw.next.BulkPush(ctx, opt)
+	return
+}
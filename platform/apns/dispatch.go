@@ -0,0 +1,148 @@
+package apns
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPushConcurrency is the number of pushes sent to APNs at once.
+	DefaultPushConcurrency = 10
+
+	// DefaultPushRate is the number of pushes per second sent to APNs.
+	DefaultPushRate = 100
+
+	// DefaultCoalesceWindow is the time in which at most one push is sent
+	// to a device. A push makes the device fetch all of its commands, so
+	// more pushes in the window would not deliver commands sooner.
+	DefaultCoalesceWindow = 5 * time.Second
+)
+
+// dispatcher sends pushes in the background with limited concurrency and
+// rate. Pushes to a device which is still waiting for a push, or which
+// was pushed to within the coalesce window, are coalesced. A coalesced
+// push is sent at the end of the window, so that the device still learns
+// about commands queued after its last push.
+type dispatcher struct {
+	push        func(ctx context.Context, udid string) error
+	concurrency int
+	rate        int
+	window      time.Duration
+	now         func() time.Time
+
+	mtx      sync.Mutex
+	ready    *sync.Cond
+	queue    []string
+	pending  map[string]bool
+	lastPush map[string]time.Time
+}
+
+func newDispatcher(push func(ctx context.Context, udid string) error, concurrency, rate int, window time.Duration) *dispatcher {
+	if concurrency <= 0 {
+		concurrency = DefaultPushConcurrency
+	}
+	d := &dispatcher{
+		push:        push,
+		concurrency: concurrency,
+		rate:        rate,
+		window:      window,
+		now:         time.Now,
+		pending:     make(map[string]bool),
+		lastPush:    make(map[string]time.Time),
+	}
+	d.ready = sync.NewCond(&d.mtx)
+
+	var tokens <-chan time.Time
+	if rate > 0 {
+		tokens = time.NewTicker(time.Second / time.Duration(rate)).C
+	}
+	for i := 0; i < concurrency; i++ {
+		go d.work(tokens)
+	}
+	if window > 0 {
+		go d.forget()
+	}
+	return d
+}
+
+// Dispatch schedules a push to udid. It reports false if the push was
+// coalesced with one which is scheduled already.
+func (d *dispatcher) Dispatch(udid string) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.pending[udid] {
+		return false
+	}
+	d.pending[udid] = true
+
+	wait := d.lastPush[udid].Add(d.window).Sub(d.now())
+	if wait <= 0 {
+		d.enqueue(udid)
+		return true
+	}
+	time.AfterFunc(wait, func() {
+		d.mtx.Lock()
+		d.enqueue(udid)
+		d.mtx.Unlock()
+	})
+	return true
+}
+
+func (d *dispatcher) enqueue(udid string) {
+	d.queue = append(d.queue, udid)
+	d.ready.Signal()
+}
+
+// Len returns the number of pushes waiting to be sent.
+func (d *dispatcher) Len() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return len(d.pending)
+}
+
+func (d *dispatcher) next() string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for len(d.queue) == 0 {
+		d.ready.Wait()
+	}
+	udid := d.queue[0]
+	d.queue[0] = ""
+	d.queue = d.queue[1:]
+	delete(d.pending, udid)
+	if d.window > 0 {
+		d.lastPush[udid] = d.now()
+	}
+	return udid
+}
+
+// work sends the queued pushes. Without a rate limit, tokens is nil.
+func (d *dispatcher) work(tokens <-chan time.Time) {
+	for {
+		udid := d.next()
+		if tokens != nil {
+			<-tokens
+		}
+		if err := d.push(context.Background(), udid); err != nil {
+			log.Printf("push: dispatch push to %s: %s\n", udid, err)
+		}
+	}
+}
+
+// forget removes the push times which are past the coalesce window.
+func (d *dispatcher) forget() {
+	ticker := time.NewTicker(10 * d.window)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.mtx.Lock()
+		now := d.now()
+		for udid, pushed := range d.lastPush {
+			if now.Sub(pushed) >= d.window {
+				delete(d.lastPush, udid)
+			}
+		}
+		d.mtx.Unlock()
+	}
+}
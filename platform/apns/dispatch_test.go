@@ -0,0 +1,137 @@
+package apns
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+type recorder struct {
+	pushed  chan string
+	release chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{pushed: make(chan string, 100), release: make(chan struct{})}
+}
+
+func (r *recorder) push(_ context.Context, udid string) error {
+	r.pushed <- udid
+	<-r.release
+	return nil
+}
+
+func (r *recorder) next(t *testing.T, timeout time.Duration) (string, bool) {
+	t.Helper()
+	select {
+	case udid := <-r.pushed:
+		return udid, true
+	case <-time.After(timeout):
+		return "", false
+	}
+}
+
+func TestDispatcher_Coalesce(t *testing.T) {
+	rec := newRecorder()
+	defer close(rec.release)
+	window := 200 * time.Millisecond
+	d := newDispatcher(rec.push, 1, 0, window)
+
+	d.Dispatch("A")
+	if have, _ := rec.next(t, time.Second); have != "A" {
+		t.Fatalf("have push to %q, want A", have)
+	}
+	// the only worker is busy with A.
+	if !d.Dispatch("B") {
+		t.Error("first push to B was coalesced")
+	}
+	if d.Dispatch("B") {
+		t.Error("second push to B was not coalesced")
+	}
+	// A was pushed within the window, so its next push waits.
+	start := time.Now()
+	d.Dispatch("A")
+	rec.release <- struct{}{}
+
+	if have, _ := rec.next(t, time.Second); have != "B" {
+		t.Fatalf("have push to %q, want B", have)
+	}
+	rec.release <- struct{}{}
+	if have, _ := rec.next(t, time.Second); have != "A" {
+		t.Fatalf("have push to %q, want A", have)
+	}
+	if elapsed := time.Since(start); elapsed < window/2 {
+		t.Errorf("second push to A after %s, want it delayed by the window", elapsed)
+	}
+	rec.release <- struct{}{}
+	if have, ok := rec.next(t, 2*window); ok {
+		t.Errorf("unexpected push to %s", have)
+	}
+}
+
+func TestDispatcher_Rate(t *testing.T) {
+	pushed := make(chan string, 10)
+	d := newDispatcher(func(_ context.Context, udid string) error {
+		pushed <- udid
+		return nil
+	}, 4, 20, 0)
+
+	start := time.Now()
+	for _, udid := range []string{"A", "B", "C", "D", "E", "F"} {
+		d.Dispatch(udid)
+	}
+	for i := 0; i < 6; i++ {
+		select {
+		case <-pushed:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out after %d pushes", i)
+		}
+	}
+	// six pushes at 20 per second take at least 250ms.
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("six pushes took %s, want them rate limited", elapsed)
+	}
+}
+
+type deviceList []device.Device
+
+func (l deviceList) List(context.Context, device.ListDevicesOption) ([]device.Device, error) {
+	return l, nil
+}
+
+func TestBulkPush_AllEnrolled(t *testing.T) {
+	rec := newRecorder()
+	close(rec.release)
+	svc := &PushService{
+		devices: deviceList{
+			{UDID: "A", Enrolled: true},
+			{UDID: "B", Enrolled: false},
+			{UDID: "C", Enrolled: true},
+		},
+		dispatcher: newDispatcher(rec.push, 2, 0, time.Minute),
+	}
+
+	result, err := svc.BulkPush(context.Background(), BulkPushOptions{UDIDs: []string{"C"}, AllEnrolled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Queued != 2 || result.Coalesced != 1 {
+		t.Errorf("have %+v, want 2 queued and 1 coalesced", result)
+	}
+
+	var pushed []string
+	for i := 0; i < 2; i++ {
+		udid, ok := rec.next(t, time.Second)
+		if !ok {
+			t.Fatal("timed out waiting for push")
+		}
+		pushed = append(pushed, udid)
+	}
+	sort.Strings(pushed)
+	if pushed[0] != "A" || pushed[1] != "C" {
+		t.Errorf("have pushes to %v, want A and C", pushed)
+	}
+}
@@ -3,7 +3,6 @@ package pg
 import (
 	"context"
 	"database/sql"
-	"strings"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -24,35 +23,33 @@ func columns() []string {
 		"push_magic",
 		"token",
 		"mdm_topic",
+		"last_push_at",
+		"last_push_id",
+		"last_push_status",
+		"last_push_reason",
+		"invalid",
+		"renotify_attempts",
 	}
 }
 
 const tableName = "push_info"
 
 func (d *Postgres) Save(ctx context.Context, i *apns.PushInfo) error {
-	updateQuery, _, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
-		Update(tableName).
-		Prefix("ON CONFLICT (udid) DO").
-		Set("udid", i.UDID).
-		Set("push_magic", i.PushMagic).
-		Set("token", i.Token).
-		Set("mdm_topic", i.MDMTopic).
-		ToSql()
-	if err != nil {
-		return errors.Wrap(err, "building update query for push_info save")
-	}
-	updateQuery = strings.Replace(updateQuery, tableName, "", -1)
-
 	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
 		Insert(tableName).
-		Columns(columns()...).
+		Columns("udid", "push_magic", "token", "mdm_topic").
 		Values(
 			i.UDID,
 			i.PushMagic,
 			i.Token,
 			i.MDMTopic,
 		).
-		Suffix(updateQuery).
+		// a new token is valid until a push to it fails.
+		Suffix(`ON CONFLICT (udid) DO UPDATE SET
+			push_magic = EXCLUDED.push_magic,
+			token = EXCLUDED.token,
+			mdm_topic = EXCLUDED.mdm_topic,
+			invalid = push_info.invalid AND push_info.token = EXCLUDED.token`).
 		ToSql()
 	if err != nil {
 		return errors.Wrap(err, "building push_info save query")
@@ -72,14 +69,50 @@ func (d *Postgres) PushInfo(ctx context.Context, udid string) (*apns.PushInfo, e
 		return nil, errors.Wrap(err, "building sql")
 	}
 
-	var i apns.PushInfo
-	err = d.db.QueryRowxContext(ctx, query, args...).StructScan(&i)
+	var (
+		i          apns.PushInfo
+		lastPushAt sql.NullTime
+	)
+	err = d.db.QueryRowxContext(ctx, query, args...).Scan(
+		&i.UDID, &i.PushMagic, &i.Token, &i.MDMTopic,
+		&lastPushAt, &i.LastPushID, &i.LastPushStatus, &i.LastPushReason, &i.Invalid,
+		&i.RenotifyAttempts,
+	)
 	if errors.Cause(err) == sql.ErrNoRows {
 		return nil, pushInfoNotFoundErr{}
 	}
+	if lastPushAt.Valid {
+		i.LastPushAt = lastPushAt.Time.UTC()
+	}
 	return &i, errors.Wrap(err, "finding push_info by udid")
 }
 
+func (d *Postgres) SavePushResult(ctx context.Context, r *apns.PushResult) error {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Update(tableName).
+		Set("last_push_at", r.PushedAt.UTC()).
+		Set("last_push_id", r.ID).
+		Set("last_push_status", r.Status).
+		Set("last_push_reason", r.Reason).
+		// the result of a push to an older token doesn't change
+		// whether the stored token is valid.
+		Set("invalid", sq.Expr("CASE WHEN token = ? THEN ? ELSE invalid END", r.Token, r.TokenInvalid)).
+		Set("renotify_attempts", r.RenotifyAttempt).
+		Where(sq.Eq{"udid": r.UDID}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building push_info result query")
+	}
+	res, err := d.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "exec push_info result update in pg")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return pushInfoNotFoundErr{}
+	}
+	return nil
+}
+
 type pushInfoNotFoundErr struct{}
 
 func (e pushInfoNotFoundErr) Error() string  { return "push_info not found" }
@@ -6,6 +6,7 @@ package pg
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/kolide/kit/dbutil"
@@ -36,6 +37,74 @@ func TestPGCrud(t *testing.T) {
 	}
 }
 
+func TestSavePushResult(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	info := apns.PushInfo{UDID: "UDID-result", Token: "tok"}
+	if err := db.Save(ctx, &info); err != nil {
+		t.Fatal(err)
+	}
+	// a push to an older token doesn't invalidate the stored one.
+	stale := apns.PushResult{
+		UDID:         info.UDID,
+		PushedAt:     time.Now().UTC().Truncate(time.Millisecond),
+		Token:        "old",
+		Status:       410,
+		TokenInvalid: true,
+	}
+	if err := db.SavePushResult(ctx, &stale); err != nil {
+		t.Fatal(err)
+	}
+	found, err := db.PushInfo(ctx, info.UDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Invalid {
+		t.Error("token is marked invalid by a push to an older token")
+	}
+
+	result := apns.PushResult{
+		UDID:         info.UDID,
+		PushedAt:     time.Now().UTC().Truncate(time.Millisecond),
+		Token:        info.Token,
+		Status:       410,
+		Reason:       "Unregistered",
+		TokenInvalid: true,
+
+		RenotifyAttempt: 2,
+	}
+	if err := db.SavePushResult(ctx, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same token stays invalid.
+	if err := db.Save(ctx, &info); err != nil {
+		t.Fatal(err)
+	}
+	found, err = db.PushInfo(ctx, info.UDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found.Invalid || found.LastPushReason != "Unregistered" || !found.LastPushAt.Equal(result.PushedAt) ||
+		found.RenotifyAttempts != 2 {
+		t.Errorf("have %+v, want the push result", found)
+	}
+
+	// a new token is valid again.
+	info.Token = "tok2"
+	if err := db.Save(ctx, &info); err != nil {
+		t.Fatal(err)
+	}
+	found, err = db.PushInfo(ctx, info.UDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Invalid {
+		t.Error("new token is marked invalid")
+	}
+}
+
 func setup(t *testing.T) *Postgres {
 	db, err := dbutil.OpenDBX(
 		"postgres",
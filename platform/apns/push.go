@@ -19,7 +19,8 @@ import (
 )
 
 type pushOpts struct {
-	expiration time.Time
+	expiration      time.Time
+	renotifyAttempt int
 }
 
 // WithExpiration sets the expiration of the APNS message.
@@ -30,6 +31,13 @@ func WithExpiration(t time.Time) PushOption {
 	}
 }
 
+// withRenotifyAttempt records the push as automatic re-push attempt n.
+func withRenotifyAttempt(n int) PushOption {
+	return func(opt *pushOpts) {
+		opt.renotifyAttempt = n
+	}
+}
+
 // PushOption adds optional parameters to the Push method.
 type PushOption func(*pushOpts)
 
@@ -49,6 +57,9 @@ func (svc *PushService) Push(ctx context.Context, deviceUDID string, opts ...Pus
 	if err != nil {
 		return "", errors.Wrap(err, "retrieving PushInfo by UDID")
 	}
+	if info.Invalid {
+		return "", errors.Errorf("push token was reported invalid by APNs: %s", info.LastPushReason)
+	}
 
 	p := payload.MDM{Token: info.PushMagic}
 	valid := push.IsDeviceTokenValid(info.Token)
@@ -60,7 +71,16 @@ func (svc *PushService) Push(ctx context.Context, deviceUDID string, opts ...Pus
 		return "", errors.Wrap(err, "marshalling push notification payload")
 	}
 
-	result, err := svc.pushsvc.Push(info.Token, headers, jsonPayload)
+	svc.mu.RLock()
+	pushsvc := svc.pushsvc
+	svc.mu.RUnlock()
+	if pushsvc == nil {
+		return "", errors.New("push service is not configured with a push certificate or auth key")
+	}
+	result, err := pushsvc.Push(info.Token, headers, jsonPayload)
+	r := newPushResult(info.UDID, info.Token, result, err, time.Now())
+	r.RenotifyAttempt = opt.renotifyAttempt
+	svc.recordResult(ctx, r)
 	if err != nil && strings.HasSuffix(err.Error(), "remote error: tls: internal error") {
 		// TODO: yuck, error substring searching. see:
 		// https://github.com/liuds832/micromdm/issues/150
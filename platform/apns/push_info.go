@@ -1,6 +1,8 @@
 package apns
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 	"google.golang.org/protobuf/proto"
 
@@ -12,14 +14,68 @@ type PushInfo struct {
 	PushMagic string `db:"push_magic"`
 	Token     string `db:"token"`
 	MDMTopic  string `db:"mdm_topic"`
+
+	// The result of the last push to Token.
+	LastPushAt     time.Time `db:"last_push_at"`
+	LastPushID     string    `db:"last_push_id"`
+	LastPushStatus int       `db:"last_push_status"`
+	LastPushReason string    `db:"last_push_reason"`
+
+	// Invalid is set once APNs reports Token as unregistered or bad. Pushes
+	// to the device are refused until it sends a new token.
+	Invalid bool `db:"invalid"`
+
+	// RenotifyAttempts is the number of automatic re-pushes since the
+	// last push for a queued command.
+	RenotifyAttempts int `db:"renotify_attempts"`
+}
+
+// LastPush returns the result of the last push, or nil if there was none.
+func (p *PushInfo) LastPush() *PushResult {
+	if p.LastPushAt.IsZero() {
+		return nil
+	}
+	return &PushResult{
+		UDID:         p.UDID,
+		PushedAt:     p.LastPushAt,
+		Token:        p.Token,
+		ID:           p.LastPushID,
+		Status:       p.LastPushStatus,
+		Reason:       p.LastPushReason,
+		TokenInvalid: p.Invalid,
+
+		RenotifyAttempt: p.RenotifyAttempts,
+	}
+}
+
+// SetLastPush records r as the result of the last push. The result of a
+// push to an older token does not change whether Token is valid.
+func (p *PushInfo) SetLastPush(r *PushResult) {
+	p.LastPushAt = r.PushedAt
+	p.LastPushID = r.ID
+	p.LastPushStatus = r.Status
+	p.LastPushReason = r.Reason
+	if r.Token == p.Token {
+		p.Invalid = r.TokenInvalid
+	}
+	p.RenotifyAttempts = r.RenotifyAttempt
 }
 
 func MarshalPushInfo(p *PushInfo) ([]byte, error) {
 	protopush := pushproto.PushInfo{
-		Udid:      p.UDID,
-		PushMagic: p.PushMagic,
-		Token:     p.Token,
-		MdmTopic:  p.MDMTopic,
+		Udid:           p.UDID,
+		PushMagic:      p.PushMagic,
+		Token:          p.Token,
+		MdmTopic:       p.MDMTopic,
+		LastPushId:     p.LastPushID,
+		LastPushStatus: int32(p.LastPushStatus),
+		LastPushReason: p.LastPushReason,
+		Invalid:        p.Invalid,
+
+		RenotifyAttempts: int32(p.RenotifyAttempts),
+	}
+	if !p.LastPushAt.IsZero() {
+		protopush.LastPushAt = p.LastPushAt.UnixNano()
 	}
 	return proto.Marshal(&protopush)
 }
@@ -33,5 +89,13 @@ func UnmarshalPushInfo(data []byte, p *PushInfo) error {
 	p.Token = pb.GetToken()
 	p.PushMagic = pb.GetPushMagic()
 	p.MDMTopic = pb.GetMdmTopic()
+	if pb.GetLastPushAt() != 0 {
+		p.LastPushAt = time.Unix(0, pb.GetLastPushAt()).UTC()
+	}
+	p.LastPushID = pb.GetLastPushId()
+	p.LastPushStatus = int(pb.GetLastPushStatus())
+	p.LastPushReason = pb.GetLastPushReason()
+	p.Invalid = pb.GetInvalid()
+	p.RenotifyAttempts = int(pb.GetRenotifyAttempts())
 	return nil
 }
@@ -0,0 +1,128 @@
+package apns
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RobotsAndPencils/buford/push"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuds832/micromdm/platform/apns/internal/pushproto"
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+// PushResultTopic is a PubSub topic that the result of every push is
+// published to.
+const PushResultTopic = "mdm.PushResult"
+
+// PushResult is the outcome of a push to a device.
+type PushResult struct {
+	UDID     string    `json:"udid"`
+	PushedAt time.Time `json:"pushed_at"`
+	// Token is the push token the push was sent to. It is not
+	// published.
+	Token string `json:"-"`
+	// ID is the apns-id of a successful push.
+	ID string `json:"push_notification_id,omitempty"`
+	// Status is the HTTP status of the APNs response, or zero if APNs
+	// could not be reached.
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// TokenInvalid is set when APNs reported the push token as
+	// unregistered or bad.
+	TokenInvalid bool `json:"token_invalid"`
+	// RenotifyAttempt is the number of the automatic re-push for stale
+	// commands which sent the push, or zero.
+	RenotifyAttempt int `json:"renotify_attempt,omitempty"`
+}
+
+func newPushResult(udid, token, id string, err error, now time.Time) *PushResult {
+	r := &PushResult{UDID: udid, PushedAt: now, Token: token, ID: id}
+	if err == nil {
+		r.Status = http.StatusOK
+		return r
+	}
+	r.Reason = err.Error()
+	if perr, ok := errors.Cause(err).(*push.Error); ok {
+		r.Status = perr.Status
+		if perr.Reason != nil {
+			r.Reason = perr.Reason.Error()
+		}
+		r.TokenInvalid = perr.Reason == push.ErrUnregistered ||
+			perr.Reason == push.ErrBadDeviceToken ||
+			perr.Status == http.StatusGone
+	}
+	return r
+}
+
+func MarshalPushResult(r *PushResult) ([]byte, error) {
+	return proto.Marshal(&pushproto.PushResult{
+		Udid:         r.UDID,
+		PushedAt:     r.PushedAt.UnixNano(),
+		PushId:       r.ID,
+		Status:       int32(r.Status),
+		Reason:       r.Reason,
+		TokenInvalid: r.TokenInvalid,
+
+		RenotifyAttempt: int32(r.RenotifyAttempt),
+	})
+}
+
+func UnmarshalPushResult(data []byte, r *PushResult) error {
+	var pb pushproto.PushResult
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return errors.Wrap(err, "unmarshal proto to PushResult")
+	}
+	r.UDID = pb.GetUdid()
+	r.PushedAt = time.Unix(0, pb.GetPushedAt()).UTC()
+	r.ID = pb.GetPushId()
+	r.Status = int(pb.GetStatus())
+	r.Reason = pb.GetReason()
+	r.TokenInvalid = pb.GetTokenInvalid()
+	r.RenotifyAttempt = int(pb.GetRenotifyAttempt())
+	return nil
+}
+
+// recordResult stores the result of a push and publishes it.
+func (svc *PushService) recordResult(ctx context.Context, r *PushResult) {
+	if err := svc.store.SavePushResult(ctx, r); err != nil {
+		log.Printf("push: save push result for %s: %s\n", r.UDID, err)
+	}
+	if svc.pub == nil {
+		return
+	}
+	data, err := MarshalPushResult(r)
+	if err != nil {
+		log.Printf("push: marshal push result for %s: %s\n", r.UDID, err)
+		return
+	}
+	if err := svc.pub.Publish(ctx, PushResultTopic, data); err != nil {
+		log.Printf("push: publish push result for %s: %s\n", r.UDID, err)
+	}
+}
+
+// DevicePushStatus reports the results of pushes in the device list.
+type DevicePushStatus struct {
+	Store Store
+}
+
+func (s DevicePushStatus) PushStatus(ctx context.Context, udid string) (*device.PushStatus, error) {
+	info, err := s.Store.PushInfo(ctx, udid)
+	if err != nil {
+		return nil, err
+	}
+	last := info.LastPush()
+	if last == nil {
+		return nil, nil
+	}
+	return &device.PushStatus{
+		PushedAt:     last.PushedAt,
+		ID:           last.ID,
+		Status:       last.Status,
+		Reason:       last.Reason,
+		TokenInvalid: last.TokenInvalid,
+	}, nil
+}
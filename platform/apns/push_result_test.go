@@ -0,0 +1,103 @@
+package apns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/RobotsAndPencils/buford/push"
+)
+
+type memStore struct {
+	mtx   sync.Mutex
+	infos map[string]PushInfo
+}
+
+func (s *memStore) PushInfo(_ context.Context, udid string) (*PushInfo, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	info := s.infos[udid]
+	return &info, nil
+}
+
+func (s *memStore) SavePushResult(_ context.Context, r *PushResult) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	info := s.infos[r.UDID]
+	info.SetLastPush(r)
+	s.infos[r.UDID] = info
+	return nil
+}
+
+func (s *memStore) last(udid string) *PushResult {
+	info, _ := s.PushInfo(context.Background(), udid)
+	return info.LastPush()
+}
+
+func TestPush_Results(t *testing.T) {
+	var status int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch status {
+		case http.StatusOK:
+			w.Header().Set("apns-id", "EC1BF194-B3B2-424A-89A9-5A918A6E6B5B")
+		case http.StatusGone:
+			w.WriteHeader(status)
+			w.Write([]byte(`{"reason":"Unregistered","timestamp":1500000000000}`))
+		}
+	}))
+	defer srv.Close()
+
+	store := &memStore{infos: map[string]PushInfo{
+		"UDID-1": {UDID: "UDID-1", Token: testDeviceToken, PushMagic: "magic"},
+	}}
+	svc := &PushService{
+		store:   store,
+		pushsvc: &push.Service{Client: srv.Client(), Host: srv.URL},
+	}
+	ctx := context.Background()
+
+	status = http.StatusOK
+	if _, err := svc.Push(ctx, "UDID-1"); err != nil {
+		t.Fatal(err)
+	}
+	last := store.last("UDID-1")
+	if last == nil || last.Status != http.StatusOK || last.ID != "EC1BF194-B3B2-424A-89A9-5A918A6E6B5B" {
+		t.Fatalf("have last push %+v", last)
+	}
+
+	status = http.StatusGone
+	if _, err := svc.Push(ctx, "UDID-1"); err == nil {
+		t.Fatal("expected an error for an unregistered token")
+	}
+	last = store.last("UDID-1")
+	if last.Status != http.StatusGone || last.Reason != "Unregistered" || !last.TokenInvalid {
+		t.Fatalf("have last push %+v", last)
+	}
+
+	// no more pushes go to the invalid token.
+	status = http.StatusOK
+	if _, err := svc.Push(ctx, "UDID-1"); err == nil {
+		t.Error("expected an error for an invalid token")
+	}
+	if last := store.last("UDID-1"); last.Status != http.StatusGone {
+		t.Errorf("pushed to an invalid token: %+v", last)
+	}
+}
+
+func TestSetLastPush_OlderToken(t *testing.T) {
+	info := PushInfo{UDID: "UDID-1", Token: "new"}
+	info.SetLastPush(&PushResult{UDID: "UDID-1", Token: "old", Status: http.StatusGone, TokenInvalid: true})
+	if info.Invalid {
+		t.Error("a push to an older token invalidated the new token")
+	}
+	if info.LastPushStatus != http.StatusGone {
+		t.Errorf("have last push status %d, want %d", info.LastPushStatus, http.StatusGone)
+	}
+
+	info.SetLastPush(&PushResult{UDID: "UDID-1", Token: "new", Status: http.StatusGone, TokenInvalid: true})
+	if !info.Invalid {
+		t.Error("expected the token to be invalid")
+	}
+}
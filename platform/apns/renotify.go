@@ -0,0 +1,149 @@
+package apns
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/queue"
+)
+
+const (
+	// DefaultRenotifyAfter is the time commands stay unsent before their
+	// device is pushed to again.
+	DefaultRenotifyAfter = 15 * time.Minute
+
+	// DefaultRenotifyMaxInterval is the longest time between two automatic
+	// re-pushes to a device.
+	DefaultRenotifyMaxInterval = 24 * time.Hour
+
+	renotifyInterval = time.Minute
+)
+
+// StaleQueueLister is implemented by the command queues to find the
+// devices which did not fetch their commands.
+type StaleQueueLister interface {
+	StaleQueues(ctx context.Context, before time.Time) ([]queue.StaleQueue, error)
+}
+
+// renotifier pushes again to devices whose commands stay unsent, in case
+// APNs dropped the first push. The time between the pushes to a device
+// doubles with each attempt, up to maxInterval. The attempts are recorded
+// with the push results, so the backoff resumes after a restart and is
+// reset by the next push for a queued command.
+type renotifier struct {
+	svc         *PushService
+	queues      StaleQueueLister
+	after       time.Duration
+	maxInterval time.Duration
+	now         func() time.Time
+}
+
+// WithRenotifier pushes again to the devices of queued commands which stay
+// unsent for longer than after, backing off exponentially up to
+// maxInterval between pushes.
+func WithRenotifier(queues StaleQueueLister, after, maxInterval time.Duration) Option {
+	return func(p *PushService) {
+		p.renotifier = &renotifier{
+			svc:         p,
+			queues:      queues,
+			after:       after,
+			maxInterval: maxInterval,
+			now:         time.Now,
+		}
+	}
+}
+
+func (r *renotifier) start() {
+	if r.svc.elector != nil {
+		r.svc.elector.Go("apns-renotify", r.run)
+		return
+	}
+	go r.run(context.TODO())
+}
+
+// run re-pushes to stale devices until ctx is done.
+func (r *renotifier) run(ctx context.Context) {
+	ticker := time.NewTicker(renotifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		n, err := r.renotify(ctx)
+		if err != nil {
+			log.Printf("push: renotify stale devices: %s\n", err)
+		}
+		if n > 0 {
+			log.Printf("push: renotified %d stale devices\n", n)
+		}
+	}
+}
+
+// renotify pushes to the devices which are due for another push and
+// returns the number of pushes.
+func (r *renotifier) renotify(ctx context.Context) (int, error) {
+	now := r.now()
+	stale, err := r.queues.StaleQueues(ctx, now.Add(-r.after))
+	if err != nil {
+		return 0, errors.Wrap(err, "list stale queues")
+	}
+
+	var tokens <-chan time.Time
+	if r.svc.rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(r.svc.rate))
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	var pushed int
+	for _, q := range stale {
+		info, err := r.svc.store.PushInfo(ctx, q.DeviceUDID)
+		if err != nil || info.Invalid {
+			continue
+		}
+		attempt, due := r.due(info, q.Since)
+		if now.Before(due) {
+			continue
+		}
+		if tokens != nil {
+			select {
+			case <-tokens:
+			case <-ctx.Done():
+				return pushed, ctx.Err()
+			}
+		}
+		pushed++
+		if _, err := r.svc.Push(ctx, q.DeviceUDID, withRenotifyAttempt(attempt)); err != nil {
+			log.Printf("push: renotify %s, attempt %d: %s\n", q.DeviceUDID, attempt, err)
+		}
+	}
+	return pushed, nil
+}
+
+// due returns the number of the next re-push to info and the time it is
+// due at. Earlier attempts only count if they were for commands which
+// became eligible at since or later.
+func (r *renotifier) due(info *PushInfo, since time.Time) (int, time.Time) {
+	last, attempts := info.LastPushAt, info.RenotifyAttempts
+	if last.Before(since) {
+		last, attempts = since, 0
+	}
+	return attempts + 1, last.Add(r.backoff(attempts))
+}
+
+// backoff returns the time to wait after the push of attempt n.
+func (r *renotifier) backoff(n int) time.Duration {
+	d := r.after
+	for i := 0; i < n && (r.maxInterval <= 0 || d < r.maxInterval); i++ {
+		d *= 2
+	}
+	if r.maxInterval > 0 && d > r.maxInterval {
+		d = r.maxInterval
+	}
+	return d
+}
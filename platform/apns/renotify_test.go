@@ -0,0 +1,91 @@
+package apns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RobotsAndPencils/buford/push"
+
+	"github.com/liuds832/micromdm/platform/queue"
+)
+
+type staleQueues []queue.StaleQueue
+
+func (q staleQueues) StaleQueues(context.Context, time.Time) ([]queue.StaleQueue, error) {
+	return q, nil
+}
+
+func TestRenotifier_Backoff(t *testing.T) {
+	r := &renotifier{after: 15 * time.Minute, maxInterval: time.Hour}
+	for n, want := range []time.Duration{15 * time.Minute, 30 * time.Minute, time.Hour, time.Hour} {
+		if have := r.backoff(n); have != want {
+			t.Errorf("have backoff %s after attempt %d, want %s", have, n, want)
+		}
+	}
+	if have := r.backoff(100); have != time.Hour {
+		t.Errorf("have backoff %s after attempt 100, want the cap", have)
+	}
+}
+
+func TestRenotifier_Renotify(t *testing.T) {
+	var pushes int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes++
+	}))
+	defer srv.Close()
+
+	now := time.Now().UTC()
+	since := now.Add(-20 * time.Minute)
+	info := func(udid string, lastPush time.Time, attempts int) PushInfo {
+		return PushInfo{
+			UDID: udid, Token: testDeviceToken, PushMagic: "magic",
+			LastPushAt: lastPush, RenotifyAttempts: attempts,
+		}
+	}
+	store := &memStore{infos: map[string]PushInfo{
+		// pushed when the command was queued.
+		"due": info("due", since, 0),
+		// renotified once, waits for twice the threshold.
+		"backoff": info("backoff", since, 1),
+		// attempts for older commands don't count.
+		"new": info("new", since.Add(-time.Hour), 5),
+	}}
+	invalid := info("invalid", since, 0)
+	invalid.Invalid = true
+	store.infos["invalid"] = invalid
+
+	svc := &PushService{
+		store:   store,
+		pushsvc: &push.Service{Client: srv.Client(), Host: srv.URL},
+	}
+	var stale staleQueues
+	for udid := range store.infos {
+		stale = append(stale, queue.StaleQueue{DeviceUDID: udid, Since: since})
+	}
+	r := &renotifier{
+		svc:         svc,
+		queues:      stale,
+		after:       15 * time.Minute,
+		maxInterval: time.Hour,
+		now:         func() time.Time { return now },
+	}
+
+	n, err := r.renotify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || pushes != 2 {
+		t.Errorf("have %d renotified and %d pushes, want 2", n, pushes)
+	}
+	for udid, want := range map[string]int{"due": 1, "new": 1, "backoff": 1} {
+		if have := store.infos[udid].RenotifyAttempts; have != want {
+			t.Errorf("have %d attempts recorded for %s, want %d", have, udid, want)
+		}
+	}
+	if last := store.last("backoff"); !last.PushedAt.Equal(since) {
+		t.Errorf("pushed to backoff before its backoff passed: %+v", last)
+	}
+}
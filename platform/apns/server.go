@@ -9,18 +9,21 @@ import (
 )
 
 type Endpoints struct {
-	PushEndpoint endpoint.Endpoint
+	PushEndpoint     endpoint.Endpoint
+	BulkPushEndpoint endpoint.Endpoint
 }
 
 func MakeServerEndpoints(s Service, outer endpoint.Middleware, others ...endpoint.Middleware) Endpoints {
 	return Endpoints{
-		PushEndpoint: endpoint.Chain(outer, others...)(MakePushEndpoint(s)),
+		PushEndpoint:     endpoint.Chain(outer, others...)(MakePushEndpoint(s)),
+		BulkPushEndpoint: endpoint.Chain(outer, others...)(MakeBulkPushEndpoint(s)),
 	}
 }
 
 func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.ServerOption) {
 	// GET    /push/:udid		create an APNS Push notification for a managed device or user(deprecated)
 	// POST   /v1/push/:udid	create an APNS Push notification for a managed device or user
+	// POST   /v1/push		create APNS Push notifications for many devices, or all enrolled devices
 
 	r.Methods("GET").Path("/push/{udid}").Handler(httptransport.NewServer(
 		e.PushEndpoint,
@@ -35,4 +38,11 @@ func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.S
 		httputil.EncodeJSONResponse,
 		options...,
 	))
+
+	r.Methods("POST").Path("/v1/push").Handler(httptransport.NewServer(
+		e.BulkPushEndpoint,
+		decodeBulkPushRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
 }
@@ -14,22 +14,37 @@ import (
 	"golang.org/x/net/http2"
 
 	"github.com/liuds832/micromdm/platform/config"
+	"github.com/liuds832/micromdm/platform/leader"
 	"github.com/liuds832/micromdm/platform/pubsub"
 	"github.com/liuds832/micromdm/platform/queue"
 )
 
 type Service interface {
 	Push(ctx context.Context, udid string, opts ...PushOption) (string, error)
+	BulkPush(ctx context.Context, opt BulkPushOptions) (BulkPushResult, error)
 }
 
 type Store interface {
 	PushInfo(ctx context.Context, udid string) (*PushInfo, error)
+
+	// SavePushResult records the result of the last push to the PushInfo
+	// of r.UDID.
+	SavePushResult(ctx context.Context, r *PushResult) error
 }
 
 type PushService struct {
 	store    Store
 	start    chan struct{}
 	provider PushCertificateProvider
+	elector  leader.Elector
+	pub      pubsub.Publisher
+	devices  DeviceLister
+
+	concurrency    int
+	rate           int
+	coalesceWindow time.Duration
+	dispatcher     *dispatcher
+	renotifier     *renotifier
 
 	mu      sync.RWMutex
 	pushsvc *push.Service
@@ -39,6 +54,13 @@ type PushCertificateProvider interface {
 	PushCertificate() (*tls.Certificate, error)
 }
 
+// AuthKeyProvider is implemented by a PushCertificateProvider which can
+// also provide an APNs auth key. A stored auth key is used instead of the
+// push certificate.
+type AuthKeyProvider interface {
+	APNSAuthKey() (*config.APNSAuthKey, error)
+}
+
 type Option func(*PushService)
 
 func WithPushService(svc *push.Service) Option {
@@ -47,15 +69,71 @@ func WithPushService(svc *push.Service) Option {
 	}
 }
 
+// WithElector pushes for queued commands only while this instance leads
+// "apns-queued-push".
+func WithElector(elector leader.Elector) Option {
+	return func(p *PushService) {
+		p.elector = elector
+	}
+}
+
+// WithPublisher publishes the result of every push to PushResultTopic.
+func WithPublisher(pub pubsub.Publisher) Option {
+	return func(p *PushService) {
+		p.pub = pub
+	}
+}
+
+// WithDeviceLister allows bulk pushes to all enrolled devices.
+func WithDeviceLister(devices DeviceLister) Option {
+	return func(p *PushService) {
+		p.devices = devices
+	}
+}
+
+// WithPushConcurrency sets the number of pushes sent to APNs at once for
+// queued commands and bulk pushes. The default is DefaultPushConcurrency.
+func WithPushConcurrency(n int) Option {
+	return func(p *PushService) {
+		p.concurrency = n
+	}
+}
+
+// WithPushRate sets the number of pushes per second sent to APNs for queued
+// commands and bulk pushes. Zero or less removes the limit. The default is
+// DefaultPushRate.
+func WithPushRate(perSecond int) Option {
+	return func(p *PushService) {
+		p.rate = perSecond
+	}
+}
+
+// WithCoalesceWindow sets the time in which pushes to a device for queued
+// commands and bulk pushes are coalesced. The default is
+// DefaultCoalesceWindow.
+func WithCoalesceWindow(window time.Duration) Option {
+	return func(p *PushService) {
+		p.coalesceWindow = window
+	}
+}
+
 func New(db Store, provider PushCertificateProvider, sub pubsub.Subscriber, opts ...Option) (*PushService, error) {
 	pushSvc := PushService{
 		store:    db,
 		provider: provider,
 		start:    make(chan struct{}),
+
+		concurrency:    DefaultPushConcurrency,
+		rate:           DefaultPushRate,
+		coalesceWindow: DefaultCoalesceWindow,
 	}
 	for _, opt := range opts {
 		opt(&pushSvc)
 	}
+	pushSvc.dispatcher = newDispatcher(func(ctx context.Context, udid string) error {
+		_, err := pushSvc.Push(ctx, udid)
+		return err
+	}, pushSvc.concurrency, pushSvc.rate, pushSvc.coalesceWindow)
 
 	pushsvc, _ := NewPushService(provider)
 	if pushsvc != nil {
@@ -71,19 +149,41 @@ func New(db Store, provider PushCertificateProvider, sub pubsub.Subscriber, opts
 	if err := pushSvc.startQueuedSubscriber(sub); err != nil {
 		return &pushSvc, err
 	}
+	if pushSvc.renotifier != nil {
+		pushSvc.renotifier.start()
+	}
 	return &pushSvc, nil
 }
 
 func (svc *PushService) startQueuedSubscriber(sub pubsub.Subscriber) error {
-	commandQueuedEvents, err := sub.Subscribe(context.TODO(), "push-info", queue.CommandQueuedTopic)
+	if svc.elector != nil {
+		svc.elector.Go("apns-queued-push", func(ctx context.Context) {
+			if err := svc.runQueuedSubscriber(ctx, sub); err != nil {
+				log.Println(err)
+			}
+		})
+		return nil
+	}
+	return svc.runQueuedSubscriber(context.TODO(), sub)
+}
+
+// runQueuedSubscriber pushes to the devices of queued commands until ctx
+// is done.
+func (svc *PushService) runQueuedSubscriber(ctx context.Context, sub pubsub.Subscriber) error {
+	commandQueuedEvents, err := sub.Subscribe(ctx, "push-info", queue.CommandQueuedTopic)
 	if err != nil {
 		return errors.Wrapf(err,
 			"subscribing push to %s topic", queue.CommandQueuedTopic)
 	}
 	go func() {
-		if svc.pushsvc == nil {
+		svc.mu.RLock()
+		started := svc.pushsvc != nil
+		svc.mu.RUnlock()
+		if !started {
 			log.Println("push: waiting for push certificate before enabling APNS service provider")
-			<-svc.start
+			if !svc.discardUntilStarted(ctx, commandQueuedEvents) {
+				return
+			}
 			log.Println("push: service started")
 		}
 		for {
@@ -94,11 +194,9 @@ func (svc *PushService) startQueuedSubscriber(sub pubsub.Subscriber) error {
 					fmt.Println(err)
 					continue
 				}
-				_, err = svc.Push(context.TODO(), cq.DeviceUDID)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
+				svc.dispatcher.Dispatch(cq.DeviceUDID)
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -106,6 +204,22 @@ func (svc *PushService) startQueuedSubscriber(sub pubsub.Subscriber) error {
 	return nil
 }
 
+// discardUntilStarted receives and drops the queued command events until
+// the push service is started, so that the publishers are not held up. No
+// push can be sent before then; the renotifier pushes to the devices whose
+// commands stay unsent. It reports false if ctx is done first.
+func (svc *PushService) discardUntilStarted(ctx context.Context, events <-chan pubsub.Event) bool {
+	for {
+		select {
+		case <-svc.start:
+			return true
+		case <-events:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
 func updateClient(svc *PushService, sub pubsub.Subscriber) error {
 	configEvents, err := sub.Subscribe(context.TODO(), "push-server-configs", config.ConfigTopic)
 	if err != nil {
@@ -152,6 +266,16 @@ func newClient(cert tls.Certificate) (*http.Client, error) {
 }
 
 func NewPushService(provider PushCertificateProvider) (*push.Service, error) {
+	if kp, ok := provider.(AuthKeyProvider); ok {
+		if authKey, err := kp.APNSAuthKey(); err == nil {
+			client, err := newTokenClient(authKey, nil)
+			if err != nil {
+				return nil, errors.Wrap(err, "create push service token client")
+			}
+			return push.NewService(client, push.Production), nil
+		}
+	}
+
 	cert, err := provider.PushCertificate()
 	if err != nil {
 		return nil, errors.Wrap(err, "get push certificate from store")
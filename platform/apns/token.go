@@ -0,0 +1,131 @@
+package apns
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+
+	"github.com/liuds832/micromdm/platform/config"
+)
+
+// APNs rejects provider tokens which are older than an hour, and tokens
+// which are replaced more often than every 20 minutes.
+const tokenRefreshInterval = 50 * time.Minute
+
+// tokenSigner creates the provider tokens, JWTs signed with ES256 by an
+// APNs auth key.
+type tokenSigner struct {
+	key    *ecdsa.PrivateKey
+	keyID  string
+	teamID string
+	now    func() time.Time
+
+	mtx    sync.Mutex
+	token  string
+	issued time.Time
+}
+
+func newTokenSigner(authKey *config.APNSAuthKey) (*tokenSigner, error) {
+	key, err := authKey.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &tokenSigner{
+		key:    key,
+		keyID:  authKey.KeyID,
+		teamID: authKey.TeamID,
+		now:    time.Now,
+	}, nil
+}
+
+// Token returns the current provider token, and signs a new one once it
+// is due for a refresh.
+func (s *tokenSigner) Token() (string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := s.now()
+	if s.token != "" && now.Sub(s.issued) < tokenRefreshInterval {
+		return s.token, nil
+	}
+	token, err := s.sign(now)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.issued = token, now
+	return token, nil
+}
+
+func (s *tokenSigner) sign(iat time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": s.keyID})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal provider token header")
+	}
+	claims, err := json.Marshal(map[string]interface{}{"iss": s.teamID, "iat": iat.Unix()})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal provider token claims")
+	}
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "sign provider token")
+	}
+	// ES256 signatures are r and s as 32 byte big endian integers.
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	sig.FillBytes(signature[32:])
+	return signingInput + "." + enc.EncodeToString(signature), nil
+}
+
+// tokenTransport authenticates the requests to APNs with a provider token.
+type tokenTransport struct {
+	signer *tokenSigner
+	topic  string
+	base   http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.signer.Token()
+	if err != nil {
+		return nil, err
+	}
+	// RoundTrippers must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set("authorization", "bearer "+token)
+	// with token authentication, the topic is not part of a certificate.
+	if req.Header.Get("apns-topic") == "" {
+		req.Header.Set("apns-topic", t.topic)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func newTokenClient(authKey *config.APNSAuthKey, base http.RoundTripper) (*http.Client, error) {
+	signer, err := newTokenSigner(authKey)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		transport := &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			IdleConnTimeout: 90 * time.Second,
+		}
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, err
+		}
+		base = transport
+	}
+	return &http.Client{
+		Transport: &tokenTransport{signer: signer, topic: authKey.Topic, base: base},
+		Timeout:   20 * time.Second,
+	}, nil
+}
@@ -0,0 +1,191 @@
+package apns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RobotsAndPencils/buford/push"
+
+	"github.com/liuds832/micromdm/platform/config"
+)
+
+const testDeviceToken = "c2732227a1d8021cfaf781d71fb2f908c61f5861079a00954a5453f1d0281433"
+
+// apnsServer is a stand-in for APNs which checks the provider tokens.
+type apnsServer struct {
+	*httptest.Server
+	t   *testing.T
+	key *ecdsa.PublicKey
+
+	mtx    sync.Mutex
+	tokens []string
+}
+
+func newAPNSServer(t *testing.T, key *ecdsa.PublicKey) *apnsServer {
+	s := &apnsServer{t: t, key: key}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *apnsServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		s.t.Errorf("have protocol %s, want HTTP/2", r.Proto)
+	}
+	if have, want := r.URL.Path, "/3/device/"+testDeviceToken; have != want {
+		s.t.Errorf("have path %s, want %s", have, want)
+	}
+	if have, want := r.Header.Get("apns-topic"), "com.apple.mgmt.External.test"; have != want {
+		s.t.Errorf("have topic %q, want %q", have, want)
+	}
+	token := strings.TrimPrefix(r.Header.Get("authorization"), "bearer ")
+	if err := s.verify(token); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"reason":"InvalidProviderToken"}`))
+		s.t.Error(err)
+		return
+	}
+	s.mtx.Lock()
+	s.tokens = append(s.tokens, token)
+	s.mtx.Unlock()
+	w.Header().Set("apns-id", "EC1BF194-B3B2-424A-89A9-5A918A6E6B5B")
+}
+
+func (s *apnsServer) verify(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed provider token %q", token)
+	}
+	enc := base64.RawURLEncoding
+	var header, claims map[string]interface{}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		data, err := enc.DecodeString(parts[i])
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+	}
+	if header["alg"] != "ES256" || header["kid"] != "ABC123DEFG" {
+		return fmt.Errorf("unexpected token header %v", header)
+	}
+	if claims["iss"] != "DEF123GHIJ" {
+		return fmt.Errorf("unexpected token claims %v", claims)
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return fmt.Errorf("malformed token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, ss := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(s.key, digest[:], r, ss) {
+		return fmt.Errorf("invalid token signature")
+	}
+	return nil
+}
+
+func (s *apnsServer) received() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]string(nil), s.tokens...)
+}
+
+func testAuthKey(t *testing.T) (*config.APNSAuthKey, *ecdsa.PublicKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &config.APNSAuthKey{
+		Key:    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		KeyID:  "ABC123DEFG",
+		TeamID: "DEF123GHIJ",
+		Topic:  "com.apple.mgmt.External.test",
+	}, &key.PublicKey
+}
+
+func TestTokenClient(t *testing.T) {
+	authKey, pub := testAuthKey(t)
+	srv := newAPNSServer(t, pub)
+
+	client, err := newTokenClient(authKey, srv.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &push.Service{Client: client, Host: srv.URL}
+	id, err := svc.Push(testDeviceToken, &push.Headers{}, []byte(`{"mdm":"magic"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "EC1BF194-B3B2-424A-89A9-5A918A6E6B5B" {
+		t.Errorf("have apns-id %q", id)
+	}
+	if len(srv.received()) != 1 {
+		t.Errorf("have %d pushes, want 1", len(srv.received()))
+	}
+}
+
+func TestTokenClient_Refresh(t *testing.T) {
+	authKey, pub := testAuthKey(t)
+	srv := newAPNSServer(t, pub)
+
+	client, err := newTokenClient(authKey, srv.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	signer := client.Transport.(*tokenTransport).signer
+	signer.now = func() time.Time { return now }
+	svc := &push.Service{Client: client, Host: srv.URL}
+
+	pushAt := func(at time.Time) {
+		t.Helper()
+		now = at
+		if _, err := svc.Push(testDeviceToken, &push.Headers{}, []byte(`{"mdm":"magic"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := now
+	pushAt(start)
+	pushAt(start.Add(30 * time.Minute))
+	pushAt(start.Add(55 * time.Minute))
+
+	tokens := srv.received()
+	if len(tokens) != 3 {
+		t.Fatalf("have %d pushes, want 3", len(tokens))
+	}
+	if tokens[0] != tokens[1] {
+		t.Error("token refreshed within 30 minutes")
+	}
+	if tokens[1] == tokens[2] {
+		t.Error("token not refreshed before it expires")
+	}
+}
+
+func TestNewTokenClient_InvalidKey(t *testing.T) {
+	authKey, _ := testAuthKey(t)
+	authKey.Key = []byte("not a key")
+	if _, err := newTokenClient(authKey, nil); err == nil {
+		t.Error("expected an error for an invalid auth key")
+	}
+}
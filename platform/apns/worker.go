@@ -60,21 +60,15 @@ func (w *Worker) updatePushInfoFromTokenUpdate(ctx context.Context, message []by
 	if err := mdm.UnmarshalCheckinEvent(message, &ev); err != nil {
 		return errors.Wrap(err, "unmarshal pushinfo event")
 	}
+	// push info is stored by the same key as the queue, so that commands
+	// for a user channel push with the token and PushMagic of that
+	// channel.
 	info := PushInfo{
-		UDID:      ev.Command.UDID,
+		UDID:      ev.Command.ChannelID(),
 		Token:     ev.Command.Token.String(),
 		PushMagic: ev.Command.PushMagic,
 		MDMTopic:  ev.Command.Topic,
 	}
-	// UDID is the primary key for storing the APNS values.
-	// For MDM managed users, use the UserID instead,
-	// and for BYOD User Enrollment, use the EnrollmentID.
-	if ev.Command.UserID != "" {
-		info.UDID = ev.Command.UserID
-	}
-	if ev.Command.EnrollmentID != "" {
-		info.UDID = ev.Command.EnrollmentID
-	}
 	err := w.db.Save(ctx, &info)
 	return errors.Wrapf(err, "saving pushinfo for udid=%s", info.UDID)
 }
@@ -0,0 +1,43 @@
+package apns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/liuds832/micromdm/mdm"
+)
+
+type saveRecorder map[string]PushInfo
+
+func (s saveRecorder) Save(_ context.Context, info *PushInfo) error {
+	s[info.UDID] = *info
+	return nil
+}
+
+func TestWorker_UserChannels(t *testing.T) {
+	tokenUpdates := []mdm.CheckinCommand{
+		{UDID: "UDID"},
+		{UDID: "UDID", UserID: "USER"},
+		{EnrollmentID: "ENROLLMENT"},
+		{EnrollmentID: "ENROLLMENT", EnrollmentUserID: "ENROLLMENT-USER"},
+	}
+	store := make(saveRecorder)
+	w := NewWorker(store, nil, nil)
+	for _, cmd := range tokenUpdates {
+		cmd.MessageType = "TokenUpdate"
+		cmd.PushMagic = "magic-" + cmd.ChannelID()
+		data, err := mdm.MarshalCheckinEvent(&mdm.CheckinEvent{Command: cmd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.updatePushInfoFromTokenUpdate(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []string{"UDID", "USER", "ENROLLMENT", "ENROLLMENT-USER"} {
+		if have, want := store[id].PushMagic, "magic-"+id; have != want {
+			t.Errorf("have PushMagic %q for %s, want %q", have, id, want)
+		}
+	}
+}
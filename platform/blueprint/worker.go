@@ -136,7 +136,7 @@ func (w *Worker) handleTokenUpdateEvent(ctx context.Context, message []byte) err
 		_, err := w.cmdsvc.NewCommand(ctx, &mdm.CommandRequest{
 			Command: &mdm.Command{RequestType: "DeviceConfigured"},
 			UDID:    ev.Command.UDID,
-		})
+		}, command.DeliveryOptions{})
 		if err != nil {
 			return errors.Wrap(err, "send DeviceConfigured")
 		}
@@ -243,7 +243,7 @@ func (w *Worker) applyToDevice(ctx context.Context, bp Blueprint, udid string) e
 	}
 
 	for _, r := range requests {
-		if _, err := w.cmdsvc.NewCommand(ctx, r); err != nil {
+		if _, err := w.cmdsvc.NewCommand(ctx, r, command.DeliveryOptions{}); err != nil {
 			return errors.Wrap(err, "create new command from blueprint")
 		}
 	}
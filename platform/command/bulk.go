@@ -0,0 +1,185 @@
+package command
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/pkg/httputil"
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+const (
+	// bulkBatchSize is the number of commands handed to a BulkQueue at once.
+	bulkBatchSize = 500
+
+	// maxBulkTargets limits the number of devices in a single request.
+	maxBulkTargets = 50000
+)
+
+// BulkQueue is implemented by command queues which can add commands for
+// many devices at once. The queue is responsible for notifying the
+// devices at a controlled rate.
+type BulkQueue interface {
+	EnqueueBulk(ctx context.Context, events []*Event) error
+}
+
+// DeviceStore resolves the serial numbers of bulk command targets.
+type DeviceStore interface {
+	DeviceBySerial(ctx context.Context, serial string) (*device.Device, error)
+}
+
+// BulkCommandRequest sends the same command to every device in UDIDs and
+// Serials. Each device gets its own command UUID.
+type BulkCommandRequest struct {
+	UDIDs   []string     `json:"udids"`
+	Serials []string     `json:"serials"`
+	Command *mdm.Command `json:"command"`
+	DeliveryOptions
+}
+
+// BulkCommandResult is the outcome for a single target of a bulk command.
+type BulkCommandResult struct {
+	UDID         string `json:"udid,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	CommandUUID  string `json:"command_uuid,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+var (
+	errBulkNoTargets     = errors.New("bulk command request must contain udids or serials")
+	errBulkTooMany       = errors.Errorf("bulk command request is limited to %d devices", maxBulkTargets)
+	errBulkNoRequestType = errors.New("bulk command request must contain a command with a request_type")
+	errSerialUnsupported = errors.New("serial number targets require a device store")
+	errBulkDependsOn     = errors.New("bulk command request does not support depends_on")
+)
+
+func (svc *CommandService) NewBulkCommand(ctx context.Context, req *BulkCommandRequest) ([]BulkCommandResult, error) {
+	if req == nil || req.Command == nil || req.Command.RequestType == "" {
+		return nil, errBulkNoRequestType
+	}
+	targets := len(req.UDIDs) + len(req.Serials)
+	if targets == 0 {
+		return nil, errBulkNoTargets
+	}
+	if targets > maxBulkTargets {
+		return nil, errBulkTooMany
+	}
+	if len(req.Serials) > 0 && svc.devices == nil {
+		return nil, errSerialUnsupported
+	}
+	if len(req.DeliveryOptions.DependsOn) > 0 {
+		return nil, errBulkDependsOn
+	}
+	if err := req.DeliveryOptions.validate(); err != nil {
+		return nil, err
+	}
+	delivery := req.DeliveryOptions
+	if delivery.Priority == 0 {
+		delivery.Priority = defaultPriority(req.Command.RequestType)
+	}
+
+	results := make([]BulkCommandResult, 0, targets)
+	for _, udid := range req.UDIDs {
+		results = append(results, BulkCommandResult{UDID: udid})
+	}
+	for _, serial := range req.Serials {
+		result := BulkCommandResult{SerialNumber: serial}
+		dev, err := svc.devices.DeviceBySerial(ctx, serial)
+		if err != nil {
+			result.Error = errors.Wrap(err, "find device by serial").Error()
+		} else {
+			result.UDID = dev.UDID
+		}
+		results = append(results, result)
+	}
+
+	events := make([]*Event, 0, targets)
+	eventResults := make([]*BulkCommandResult, 0, targets)
+	for i := range results {
+		r := &results[i]
+		if r.Error != "" {
+			continue
+		}
+		if r.UDID == "" {
+			r.Error = errEmptyRequest.Error()
+			continue
+		}
+		payload, err := mdm.NewCommandPayload(&mdm.CommandRequest{UDID: r.UDID, Command: req.Command})
+		if err != nil {
+			r.Error = errors.Wrap(err, "creating mdm payload").Error()
+			continue
+		}
+		event := NewEvent(payload, r.UDID)
+		event.Delivery = delivery
+		r.CommandUUID = payload.CommandUUID
+		events = append(events, event)
+		eventResults = append(eventResults, r)
+	}
+
+	bq, bulk := svc.queue.(BulkQueue)
+	for start := 0; start < len(events); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		var err error
+		if bulk {
+			err = bq.EnqueueBulk(ctx, events[start:end])
+		} else {
+			err = svc.publishEvents(ctx, events[start:end])
+		}
+		if err != nil {
+			for _, r := range eventResults[start:end] {
+				r.CommandUUID = ""
+				r.Error = err.Error()
+			}
+		}
+	}
+	return results, nil
+}
+
+// publishEvents queues commands one at a time, for queues which don't
+// implement BulkQueue.
+func (svc *CommandService) publishEvents(ctx context.Context, events []*Event) error {
+	for _, event := range events {
+		msg, err := MarshalEvent(event)
+		if err != nil {
+			return errors.Wrap(err, "marshalling mdm command event")
+		}
+		if err := svc.publisher.Publish(ctx, CommandTopic, msg); err != nil {
+			return errors.Wrapf(err, "publish mdm command on topic: %s", CommandTopic)
+		}
+	}
+	return nil
+}
+
+type bulkCommandResponse struct {
+	Commands []BulkCommandResult `json:"commands,omitempty"`
+	Err      error               `json:"error,omitempty"`
+}
+
+func (r bulkCommandResponse) Failed() error   { return r.Err }
+func (r bulkCommandResponse) StatusCode() int { return http.StatusCreated }
+
+func decodeBulkCommandRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req BulkCommandRequest
+	err := httputil.DecodeJSONRequest(r, &req)
+	return req, err
+}
+
+// MakeBulkCommandEndpoint creates an endpoint which sends a command to
+// many devices.
+func MakeBulkCommandEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(BulkCommandRequest)
+		results, err := svc.NewBulkCommand(ctx, &req)
+		if err != nil {
+			return bulkCommandResponse{Err: err}, nil
+		}
+		return bulkCommandResponse{Commands: results}, nil
+	}
+}
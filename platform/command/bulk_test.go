@@ -0,0 +1,100 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/liuds832/micromdm/mdm"
+	mdmcmd "github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+type bulkQueue struct {
+	batches [][]*command.Event
+}
+
+func (q *bulkQueue) Clear(context.Context, mdm.CheckinEvent) error { return nil }
+func (q *bulkQueue) ViewQueue(context.Context, mdm.CheckinEvent) ([]*mdm.Command, error) {
+	return nil, nil
+}
+func (q *bulkQueue) EnqueueBulk(_ context.Context, events []*command.Event) error {
+	q.batches = append(q.batches, events)
+	return nil
+}
+
+type serialStore map[string]string
+
+func (s serialStore) DeviceBySerial(_ context.Context, serial string) (*device.Device, error) {
+	udid, ok := s[serial]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &device.Device{UDID: udid, SerialNumber: serial}, nil
+}
+
+func TestNewBulkCommand(t *testing.T) {
+	q := new(bulkQueue)
+	svc, err := command.New(nil, q, command.WithDeviceStore(serialStore{"C02ABC": "udid-3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := svc.NewBulkCommand(context.Background(), &command.BulkCommandRequest{
+		UDIDs:           []string{"udid-1", "udid-2"},
+		Serials:         []string{"C02ABC", "UNKNOWN"},
+		Command:         &mdmcmd.Command{RequestType: "DeviceInformation"},
+		DeliveryOptions: command.DeliveryOptions{MaxAttempts: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have, want := len(results), 4; have != want {
+		t.Fatalf("have %d results, want %d", have, want)
+	}
+	uuids := make(map[string]bool)
+	for _, r := range results[:3] {
+		if r.Error != "" || r.CommandUUID == "" {
+			t.Errorf("unexpected result %+v", r)
+		}
+		uuids[r.CommandUUID] = true
+	}
+	if len(uuids) != 3 {
+		t.Errorf("expected a command UUID per device, got %v", uuids)
+	}
+	if have, want := results[2].UDID, "udid-3"; have != want {
+		t.Errorf("have udid %s for serial, want %s", have, want)
+	}
+	if results[3].Error == "" {
+		t.Error("expected an error for an unknown serial")
+	}
+
+	if have, want := len(q.batches), 1; have != want {
+		t.Fatalf("have %d batches, want %d", have, want)
+	}
+	for _, ev := range q.batches[0] {
+		if ev.Delivery.MaxAttempts != 3 {
+			t.Errorf("expected delivery options on event %s", ev.Payload.CommandUUID)
+		}
+	}
+}
+
+func TestNewBulkCommand_Validation(t *testing.T) {
+	svc, err := command.New(nil, new(bulkQueue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	cmd := &mdmcmd.Command{RequestType: "DeviceInformation"}
+	for name, req := range map[string]*command.BulkCommandRequest{
+		"no targets":      {Command: cmd},
+		"no request type": {UDIDs: []string{"udid-1"}, Command: &mdmcmd.Command{}},
+		"serials":         {Serials: []string{"C02ABC"}, Command: cmd},
+	} {
+		if _, err := svc.NewBulkCommand(ctx, req); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuds832/micromdm/platform/command/internal/commandproto"
+)
+
+// CommandCanceledTopic is a PubSub topic that events are published to
+// when a queued command is canceled.
+const CommandCanceledTopic = "mdm.CommandCanceled"
+
+// CancelQueue is implemented by command queues which can remove a single
+// command.
+type CancelQueue interface {
+	CancelCommand(ctx context.Context, udid, uuid string) error
+}
+
+// ErrCommandSent is returned by a CancelQueue when the command was sent to
+// the device and is awaiting a response.
+var ErrCommandSent = errors.New("command was sent and is awaiting a response")
+
+type commandSentErr struct {
+	uuid string
+}
+
+func (e commandSentErr) Error() string {
+	return fmt.Sprintf("command %s was sent and is awaiting a response", e.uuid)
+}
+
+func (e commandSentErr) StatusCode() int { return http.StatusConflict }
+
+var errCancelUnsupported = errors.New("command queue does not support canceling commands")
+
+// CanceledEvent is published when a command is removed from a queue.
+type CanceledEvent struct {
+	ID          string
+	Time        time.Time
+	DeviceUDID  string
+	CommandUUID string
+}
+
+// MarshalCanceledEvent serializes a CanceledEvent to a protocol buffer
+// wire format.
+func MarshalCanceledEvent(e *CanceledEvent) ([]byte, error) {
+	return proto.Marshal(&commandproto.CanceledEvent{
+		Id:          e.ID,
+		Time:        e.Time.UnixNano(),
+		DeviceUdid:  e.DeviceUDID,
+		CommandUuid: e.CommandUUID,
+	})
+}
+
+// UnmarshalCanceledEvent parses a protocol buffer representation of data
+// into the CanceledEvent.
+func UnmarshalCanceledEvent(data []byte, e *CanceledEvent) error {
+	var pb commandproto.CanceledEvent
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return errors.Wrap(err, "unmarshal pb CanceledEvent")
+	}
+	e.ID = pb.Id
+	e.Time = time.Unix(0, pb.Time).UTC()
+	e.DeviceUDID = pb.DeviceUdid
+	e.CommandUUID = pb.CommandUuid
+	return nil
+}
+
+func (svc *CommandService) CancelCommand(ctx context.Context, udid, commandUUID string) error {
+	q, ok := svc.queue.(CancelQueue)
+	if !ok {
+		return errCancelUnsupported
+	}
+	err := q.CancelCommand(ctx, udid, commandUUID)
+	switch {
+	case isNotFound(err):
+		return commandNotFoundErr{uuid: commandUUID}
+	case errors.Cause(err) == ErrCommandSent:
+		return commandSentErr{uuid: commandUUID}
+	case err != nil:
+		return errors.Wrap(err, "cancel command")
+	}
+
+	msg, err := MarshalCanceledEvent(&CanceledEvent{
+		ID:          uuid.New().String(),
+		Time:        time.Now().UTC(),
+		DeviceUDID:  udid,
+		CommandUUID: commandUUID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshalling command canceled event")
+	}
+	if err := svc.publisher.Publish(ctx, CommandCanceledTopic, msg); err != nil {
+		return errors.Wrapf(err, "publish command canceled event on topic: %s", CommandCanceledTopic)
+	}
+	return nil
+}
+
+type cancelRequest struct {
+	UDID        string
+	CommandUUID string
+}
+
+type cancelResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r cancelResponse) Failed() error   { return r.Err }
+func (r cancelResponse) StatusCode() int { return http.StatusOK }
+
+func decodeCancelRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	return cancelRequest{UDID: vars["udid"], CommandUUID: vars["command_uuid"]}, nil
+}
+
+// MakeCancelCommandEndpoint creates an endpoint which removes a single
+// command from a device queue.
+func MakeCancelCommandEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(cancelRequest)
+		if req.UDID == "" {
+			return cancelResponse{Err: errEmptyRequest}, nil
+		}
+		if req.CommandUUID == "" {
+			return cancelResponse{Err: errEmptyCommandUUID}, nil
+		}
+		if err := svc.CancelCommand(ctx, req.UDID, req.CommandUUID); err != nil {
+			return cancelResponse{Err: err}, nil
+		}
+		return cancelResponse{}, nil
+	}
+}
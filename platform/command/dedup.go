@@ -0,0 +1,54 @@
+package command
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/groob/plist"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/mdm/mdm"
+)
+
+// DedupQueue is implemented by command queues which coalesce identical
+// pending commands. NewCommand hands its commands to such a queue
+// directly, so that looking for a duplicate and queueing the command
+// happen at once and the caller learns which command is queued. The event
+// of a queued command is published afterwards with Queued set. Only commands created with NewCommand carry a dedup key; raw
+// and bulk commands are always queued.
+type DedupQueue interface {
+	// EnqueueCoalesced queues the command of ev, unless coalescing is
+	// enabled and a command with the dedup key of ev is still pending for
+	// the device. It returns the UUID of that pending command, or an empty
+	// string if the command of ev was queued.
+	EnqueueCoalesced(ctx context.Context, ev *Event) (string, error)
+}
+
+// dedupKey identifies identical commands for a device. A caller supplied
+// idempotency key is used as is, and the command and delivery options of a
+// repeated request are discarded in favor of the pending command.
+// Otherwise the key is the request type and a hash of the command without
+// its UUID and of the delivery options, so that the same command scheduled
+// differently is not coalesced.
+func dedupKey(request *mdm.CommandRequest, payload *mdm.CommandPayload, opts DeliveryOptions) (string, error) {
+	if request.IdempotencyKey != "" {
+		return "key:" + request.IdempotencyKey, nil
+	}
+	body, err := plist.Marshal(payload.Command)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal command for dedup key")
+	}
+	opts.ExpiresAt = opts.ExpiresAt.UTC()
+	opts.NotBefore = opts.NotBefore.UTC()
+	opts.NotAfter = opts.NotAfter.UTC()
+	delivery, err := json.Marshal(opts)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal delivery options for dedup key")
+	}
+	h := sha256.New()
+	h.Write(body)
+	h.Write(delivery)
+	return payload.Command.RequestType + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
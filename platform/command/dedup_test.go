@@ -0,0 +1,140 @@
+package command_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/liuds832/micromdm/mdm"
+	mdmcmd "github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+)
+
+// dedupQueue queues commands with new keys and reports the command
+// queued first for keys it has seen.
+type dedupQueue map[string]string
+
+func (q dedupQueue) Clear(context.Context, mdm.CheckinEvent) error { return nil }
+func (q dedupQueue) ViewQueue(context.Context, mdm.CheckinEvent) ([]*mdm.Command, error) {
+	return nil, nil
+}
+func (q dedupQueue) EnqueueCoalesced(_ context.Context, ev *command.Event) (string, error) {
+	key := ev.DeviceUDID + "/" + ev.DedupKey
+	if existing, ok := q[key]; ok {
+		return existing, nil
+	}
+	q[key] = ev.Payload.CommandUUID
+	return "", nil
+}
+
+func TestNewCommand_Coalesced(t *testing.T) {
+	q := make(dedupQueue)
+	svc, err := command.New(inmem.NewPubSub(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	newRequest := func() *mdmcmd.CommandRequest {
+		return &mdmcmd.CommandRequest{
+			UDID:    "udid-1",
+			Command: &mdmcmd.Command{RequestType: "DeviceInformation"},
+		}
+	}
+	first, err := svc.NewCommand(ctx, newRequest(), command.DeliveryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Coalesced {
+		t.Fatal("expected the first command to be queued")
+	}
+	if len(q) != 1 {
+		t.Fatalf("expected the command to be queued with a dedup key, have %v", q)
+	}
+
+	second, err := svc.NewCommand(ctx, newRequest(), command.DeliveryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Coalesced {
+		t.Error("expected the identical command to be coalesced")
+	}
+	if have, want := second.Payload.CommandUUID, first.Payload.CommandUUID; have != want {
+		t.Errorf("have command uuid %s, want %s", have, want)
+	}
+
+	scheduled, err := svc.NewCommand(ctx, newRequest(), command.DeliveryOptions{NotBefore: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheduled.Coalesced {
+		t.Error("expected the command with other delivery options to be queued")
+	}
+
+	keyed := newRequest()
+	keyed.IdempotencyKey = "provision-1"
+	third, err := svc.NewCommand(ctx, keyed, command.DeliveryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Coalesced {
+		t.Error("expected a command with a new idempotency key to be queued")
+	}
+	keyed = newRequest()
+	keyed.IdempotencyKey = "provision-1"
+	fourth, err := svc.NewCommand(ctx, keyed, command.DeliveryOptions{Priority: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fourth.Coalesced || fourth.Payload.CommandUUID != third.Payload.CommandUUID {
+		t.Error("expected a repeated idempotency key to be coalesced whatever its delivery options")
+	}
+}
+
+func TestNewCommand_PublishesQueuedCommand(t *testing.T) {
+	ctx := context.Background()
+	ps := inmem.NewPubSub()
+	events, err := ps.Subscribe(ctx, "command-events", command.CommandTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := command.New(ps, make(dedupQueue))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &mdmcmd.CommandRequest{
+		UDID:    "udid-1",
+		Command: &mdmcmd.Command{RequestType: "DeviceInformation"},
+	}
+	result, err := svc.NewCommand(ctx, request, command.DeliveryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-events:
+		var ev command.Event
+		if err := command.UnmarshalEvent(msg.Message, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Payload.CommandUUID != result.Payload.CommandUUID || ev.DeviceUDID != "udid-1" {
+			t.Errorf("have event for command %s of %s", ev.Payload.CommandUUID, ev.DeviceUDID)
+		}
+		if !ev.Queued {
+			t.Error("expected the event to be marked queued")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the command event")
+	}
+
+	// a coalesced command queues nothing new.
+	if _, err := svc.NewCommand(ctx, request, command.DeliveryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-events:
+		t.Error("expected no event for a coalesced command")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
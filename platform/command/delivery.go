@@ -0,0 +1,132 @@
+package command
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DeliveryOptions control how the queue delivers a command. The zero value
+// retries a command until the device answers it.
+type DeliveryOptions struct {
+	// MaxAttempts is the number of times a command is sent to the device
+	// before it fails. Zero means no limit.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// ExpiresAt is the time after which a command which was not answered
+	// by the device fails. The zero time means the command does not expire.
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// NotBefore and NotAfter are a window in which the command may be
+	// sent. A command which wasn't sent by NotAfter fails like an expired
+	// command. Zero values mean no limit.
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+
+	// DependsOn holds the UUIDs of commands queued for the same device
+	// which must be acknowledged before the command is sent. The command
+	// fails if one of them fails, is canceled or is unknown.
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// Priority is the lane of the command in the device queue. Commands
+	// in a higher lane are sent first. Zero uses the default priority of
+	// the request type.
+	Priority int `json:"priority,omitempty"`
+}
+
+// Command priorities. Security critical commands default to
+// PriorityCritical so they don't wait behind long queues.
+const (
+	PriorityNormal   = 0
+	PriorityCritical = 100
+)
+
+// defaultPriority returns the priority of requestType for commands queued
+// without one.
+func defaultPriority(requestType string) int {
+	switch requestType {
+	case "DeviceLock", "EraseDevice", "EnableLostMode":
+		return PriorityCritical
+	default:
+		return PriorityNormal
+	}
+}
+
+func (o DeliveryOptions) validate() error {
+	if o.MaxAttempts < 0 {
+		return errors.New("max_attempts must not be negative")
+	}
+	if !o.NotBefore.IsZero() && !o.NotAfter.IsZero() && !o.NotAfter.After(o.NotBefore) {
+		return errors.New("not_after must be after not_before")
+	}
+	for _, dep := range o.DependsOn {
+		if dep == "" {
+			return errors.New("depends_on must not contain empty command UUIDs")
+		}
+	}
+	return nil
+}
+
+// validateFor checks the options of the command with commandUUID.
+func (o DeliveryOptions) validateFor(commandUUID string) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	for _, dep := range o.DependsOn {
+		if dep == commandUUID {
+			return errors.New("command must not depend on itself")
+		}
+	}
+	return nil
+}
+
+// decodeDeliveryQuery reads DeliveryOptions from the max_attempts and
+// priority query parameters, the expires_at, not_before and not_after (RFC 3339) query
+// parameters and the comma separated or repeated depends_on parameter.
+func decodeDeliveryQuery(q url.Values) (DeliveryOptions, error) {
+	var opts DeliveryOptions
+	if s := q.Get("max_attempts"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return opts, errors.Wrap(err, "parse max_attempts")
+		}
+		opts.MaxAttempts = n
+	}
+	if s := q.Get("priority"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return opts, errors.Wrap(err, "parse priority")
+		}
+		opts.Priority = n
+	}
+	times := []struct {
+		name string
+		t    *time.Time
+	}{
+		{"expires_at", &opts.ExpiresAt},
+		{"not_before", &opts.NotBefore},
+		{"not_after", &opts.NotAfter},
+	}
+	for _, qt := range times {
+		s := q.Get(qt.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return opts, errors.Wrapf(err, "parse %s", qt.name)
+		}
+		*qt.t = t.UTC()
+	}
+	for _, v := range q["depends_on"] {
+		for _, dep := range strings.Split(v, ",") {
+			if dep = strings.TrimSpace(dep); dep != "" {
+				opts.DependsOn = append(opts.DependsOn, dep)
+			}
+		}
+	}
+	return opts, nil
+}
@@ -16,6 +16,17 @@ type Event struct {
 	Time       time.Time
 	Payload    *mdm.CommandPayload
 	DeviceUDID string
+	Delivery   DeliveryOptions
+
+	// DedupKey identifies identical commands for queues which coalesce
+	// pending commands. It is empty for commands which are never
+	// coalesced.
+	DedupKey string
+
+	// Queued is set if the command was queued before the event was
+	// published. The event then only announces the command and the queues
+	// ignore it.
+	Queued bool
 }
 
 // NewEvent returns an Event with a unique ID and the current time.
@@ -40,6 +51,14 @@ func MarshalEvent(e *Event) ([]byte, error) {
 		Time:         e.Time.UnixNano(),
 		PayloadBytes: payloadBytes,
 		DeviceUdid:   e.DeviceUDID,
+		MaxAttempts:  int64(e.Delivery.MaxAttempts),
+		ExpiresAt:    timeToNano(e.Delivery.ExpiresAt),
+		NotBefore:    timeToNano(e.Delivery.NotBefore),
+		NotAfter:     timeToNano(e.Delivery.NotAfter),
+		DependsOn:    e.Delivery.DependsOn,
+		Priority:     int64(e.Delivery.Priority),
+		DedupKey:     e.DedupKey,
+		Queued:       e.Queued,
 	})
 
 }
@@ -59,6 +78,9 @@ func UnmarshalEvent(data []byte, e *Event) error {
 	e.DeviceUDID = pb.DeviceUdid
 	e.Time = time.Unix(0, pb.Time).UTC()
 	e.Payload = &payload
+	e.Delivery = deliveryFromProto(&pb)
+	e.DedupKey = pb.DedupKey
+	e.Queued = pb.Queued
 	return nil
 }
 
@@ -67,6 +89,7 @@ type RawEvent struct {
 	Time        time.Time
 	DeviceUDID  string
 	Payload     []byte
+	Delivery    DeliveryOptions
 }
 
 // NewRawEvent returns a RawEvent with the current time.
@@ -87,6 +110,12 @@ func MarshalRawEvent(e *RawEvent) ([]byte, error) {
 		Time:         e.Time.UnixNano(),
 		DeviceUdid:   e.DeviceUDID,
 		PayloadBytes: e.Payload,
+		MaxAttempts:  int64(e.Delivery.MaxAttempts),
+		ExpiresAt:    timeToNano(e.Delivery.ExpiresAt),
+		NotBefore:    timeToNano(e.Delivery.NotBefore),
+		NotAfter:     timeToNano(e.Delivery.NotAfter),
+		DependsOn:    e.Delivery.DependsOn,
+		Priority:     int64(e.Delivery.Priority),
 	})
 }
 
@@ -101,5 +130,32 @@ func UnmarshalRawEvent(data []byte, e *RawEvent) error {
 	e.Time = time.Unix(0, pb.Time).UTC()
 	e.DeviceUDID = pb.DeviceUdid
 	e.Payload = pb.PayloadBytes
+	e.Delivery = deliveryFromProto(&pb)
 	return nil
 }
+
+func deliveryFromProto(pb *commandproto.Event) DeliveryOptions {
+	return DeliveryOptions{
+		MaxAttempts: int(pb.MaxAttempts),
+		ExpiresAt:   timeFromNano(pb.ExpiresAt),
+		NotBefore:   timeFromNano(pb.NotBefore),
+		NotAfter:    timeFromNano(pb.NotAfter),
+		DependsOn:   pb.DependsOn,
+		Priority:    int(pb.Priority),
+	}
+}
+
+func timeFromNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// timeToNano returns 0 for the zero time, which would overflow UnixNano.
+func timeToNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -3,6 +3,7 @@ package command_test
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/liuds832/micromdm/platform/command"
 )
@@ -47,3 +48,36 @@ func TestRawEvent(t *testing.T) {
 		t.Error("expected events to be equal")
 	}
 }
+
+func TestRawEvent_Delivery(t *testing.T) {
+	now := time.Now().UTC()
+	ev := command.NewRawEvent(&command.RawCommand{
+		UDID:        "1234",
+		CommandUUID: "0001_ProfileList",
+		Raw:         []byte(testRawCmd),
+	})
+	ev.Delivery = command.DeliveryOptions{
+		MaxAttempts: 3,
+		NotBefore:   now.Add(time.Hour),
+		NotAfter:    now.Add(2 * time.Hour),
+	}
+
+	buf, err := command.MarshalRawEvent(ev)
+	if err != nil {
+		t.Fatalf("could not marshal event: %v", err)
+	}
+	ev2 := new(command.RawEvent)
+	if err = command.UnmarshalRawEvent(buf, ev2); err != nil {
+		t.Fatalf("could not unmarshal event: %v", err)
+	}
+
+	if !ev2.Delivery.NotBefore.Equal(ev.Delivery.NotBefore) {
+		t.Errorf("have not_before %s, want %s", ev2.Delivery.NotBefore, ev.Delivery.NotBefore)
+	}
+	if !ev2.Delivery.NotAfter.Equal(ev.Delivery.NotAfter) {
+		t.Errorf("have not_after %s, want %s", ev2.Delivery.NotAfter, ev.Delivery.NotAfter)
+	}
+	if !ev2.Delivery.ExpiresAt.IsZero() {
+		t.Errorf("expected zero expires_at, got %s", ev2.Delivery.ExpiresAt)
+	}
+}
@@ -0,0 +1,100 @@
+package command
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// HistoryQueue is implemented by command queues which keep a history of
+// completed and failed commands.
+type HistoryQueue interface {
+	History(ctx context.Context, udid string, opt HistoryOptions) (*HistoryPage, error)
+}
+
+// HistoryOptions selects a page of command history. Cursor is the
+// NextCursor of the previous page, or empty for the most recent entries.
+type HistoryOptions struct {
+	Limit  int
+	Cursor string
+}
+
+// HistoryEntry is a command which was answered by the device.
+type HistoryEntry struct {
+	UUID           string    `json:"uuid"`
+	Status         string    `json:"status"`
+	Time           time.Time `json:"time"`
+	Payload        []byte    `json:"payload"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastSentAt     time.Time `json:"last_sent_at"`
+	TimesSent      int       `json:"times_sent"`
+	FailureMessage string    `json:"failure_message,omitempty"`
+}
+
+// HistoryPage is a page of command history, newest entries first.
+type HistoryPage struct {
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+var errHistoryUnsupported = errors.New("command queue does not keep history")
+
+func (svc *CommandService) CommandHistory(ctx context.Context, udid string, opt HistoryOptions) (*HistoryPage, error) {
+	hq, ok := svc.queue.(HistoryQueue)
+	if !ok {
+		return nil, errHistoryUnsupported
+	}
+	page, err := hq.History(ctx, udid, opt)
+	if err != nil {
+		return nil, errors.Wrap(err, "get command history")
+	}
+	return page, nil
+}
+
+type historyRequest struct {
+	UDID string
+	Opts HistoryOptions
+}
+
+type historyResponse struct {
+	*HistoryPage
+	Err error `json:"error,omitempty"`
+}
+
+func (r historyResponse) Failed() error   { return r.Err }
+func (r historyResponse) StatusCode() int { return http.StatusOK }
+
+func decodeHistoryRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := historyRequest{UDID: mux.Vars(r)["udid"]}
+	q := r.URL.Query()
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse limit")
+		}
+		req.Opts.Limit = n
+	}
+	req.Opts.Cursor = q.Get("cursor")
+	return req, nil
+}
+
+// MakeHistoryEndpoint creates an endpoint which pages through the command
+// history of a device.
+func MakeHistoryEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(historyRequest)
+		if req.UDID == "" {
+			return historyResponse{Err: errEmptyRequest}, nil
+		}
+		page, err := svc.CommandHistory(ctx, req.UDID, req.Opts)
+		if err != nil {
+			return historyResponse{Err: err}, nil
+		}
+		return historyResponse{HistoryPage: page}, nil
+	}
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -24,15 +25,62 @@ const (
 	RawCommandTopic = "mdm.RawCommand"
 )
 
-func (svc *CommandService) NewCommand(ctx context.Context, request *mdm.CommandRequest) (*mdm.CommandPayload, error) {
+// CommandResult is the outcome of NewCommand.
+type CommandResult struct {
+	Payload *mdm.CommandPayload
+
+	// Coalesced is true if an identical command was still pending for the
+	// device. The payload then has the UUID of that command and nothing
+	// new was queued.
+	Coalesced bool
+}
+
+func (svc *CommandService) NewCommand(ctx context.Context, request *mdm.CommandRequest, opts DeliveryOptions) (*CommandResult, error) {
 	if request == nil {
 		return nil, errors.New("empty CommandRequest")
 	}
+	if opts.NotBefore.IsZero() {
+		opts.NotBefore = request.NotBefore
+	}
+	if opts.NotAfter.IsZero() {
+		opts.NotAfter = request.NotAfter
+	}
+	if opts.Priority == 0 {
+		opts.Priority = request.Priority
+	}
+	if opts.Priority == 0 && request.Command != nil {
+		opts.Priority = defaultPriority(request.RequestType)
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
 	payload, err := mdm.NewCommandPayload(request)
 	if err != nil {
 		return nil, errors.Wrap(err, "creating mdm payload")
 	}
+	if err := opts.validateFor(payload.CommandUUID); err != nil {
+		return nil, err
+	}
+	key, err := dedupKey(request, payload, opts)
+	if err != nil {
+		return nil, err
+	}
 	event := NewEvent(payload, request.UDID)
+	event.Delivery = opts
+	event.DedupKey = key
+	if q, ok := svc.queue.(DedupQueue); ok {
+		existing, err := q.EnqueueCoalesced(ctx, event)
+		if err != nil {
+			return nil, errors.Wrap(err, "enqueue mdm command")
+		}
+		if existing != "" {
+			payload.CommandUUID = existing
+			return &CommandResult{Payload: payload, Coalesced: true}, nil
+		}
+		// the command event is still published for the other
+		// subscribers of the topic.
+		event.Queued = true
+	}
 	msg, err := MarshalEvent(event)
 	if err != nil {
 		return nil, errors.Wrap(err, "marshalling mdm command event")
@@ -40,14 +88,21 @@ func (svc *CommandService) NewCommand(ctx context.Context, request *mdm.CommandR
 	if err := svc.publisher.Publish(context.TODO(), CommandTopic, msg); err != nil {
 		return nil, errors.Wrapf(err, "publish mdm command on topic: %s", CommandTopic)
 	}
-	return payload, nil
+	return &CommandResult{Payload: payload}, nil
 }
 
-func (svc *CommandService) NewRawCommand(ctx context.Context, cmd *RawCommand) error {
+func (svc *CommandService) NewRawCommand(ctx context.Context, cmd *RawCommand, opts DeliveryOptions) error {
 	if cmd == nil {
 		return errors.New("empty RawCommand")
 	}
+	if err := opts.validateFor(cmd.CommandUUID); err != nil {
+		return err
+	}
+	if opts.Priority == 0 {
+		opts.Priority = defaultPriority(cmd.Command.RequestType)
+	}
 	event := NewRawEvent(cmd)
+	event.Delivery = opts
 	msg, err := MarshalRawEvent(event)
 	if err != nil {
 		return errors.Wrap(err, "marshalling raw mdm command event")
@@ -60,15 +115,46 @@ func (svc *CommandService) NewRawCommand(ctx context.Context, cmd *RawCommand) e
 
 type newCommandRequest struct {
 	mdm.CommandRequest
+	Delivery DeliveryOptions
+}
+
+// UnmarshalJSON decodes the fields of the CommandRequest and the delivery
+// options, which are next to each other in the request body.
+func (r *newCommandRequest) UnmarshalJSON(data []byte) error {
+	var request struct {
+		UDID           string `json:"udid"`
+		CommandUUID    string `json:"command_uuid"`
+		IdempotencyKey string `json:"idempotency_key"`
+		DeliveryOptions
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return errors.Wrap(err, "decode command request")
+	}
+	r.UDID = request.UDID
+	r.CommandUUID = request.CommandUUID
+	r.NotBefore = request.NotBefore
+	r.NotAfter = request.NotAfter
+	r.IdempotencyKey = request.IdempotencyKey
+	r.Priority = request.Priority
+	r.Delivery = request.DeliveryOptions
+	r.Command = &mdm.Command{}
+	return r.Command.UnmarshalJSON(data)
 }
 
 type newCommandResponse struct {
-	Payload *mdm.CommandPayload `json:"payload,omitempty"`
-	Err     error               `json:"error,omitempty"`
+	Payload   *mdm.CommandPayload `json:"payload,omitempty"`
+	Coalesced bool                `json:"coalesced,omitempty"`
+	Err       error               `json:"error,omitempty"`
 }
 
-func (r newCommandResponse) Failed() error   { return r.Err }
-func (r newCommandResponse) StatusCode() int { return http.StatusCreated }
+func (r newCommandResponse) Failed() error { return r.Err }
+
+func (r newCommandResponse) StatusCode() int {
+	if r.Coalesced {
+		return http.StatusOK
+	}
+	return http.StatusCreated
+}
 
 func decodeNewCommandRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	var req newCommandRequest
@@ -85,11 +171,11 @@ func MakeNewCommandEndpoint(svc Service) endpoint.Endpoint {
 		if req.UDID == "" || req.RequestType == "" {
 			return newCommandResponse{Err: errEmptyRequest}, nil
 		}
-		payload, err := svc.NewCommand(ctx, &req.CommandRequest)
+		result, err := svc.NewCommand(ctx, &req.CommandRequest, req.Delivery)
 		if err != nil {
 			return newCommandResponse{Err: err}, nil
 		}
-		return newCommandResponse{Payload: payload}, nil
+		return newCommandResponse{Payload: result.Payload, Coalesced: result.Coalesced}, nil
 	}
 }
 
@@ -104,6 +190,7 @@ type RawCommand struct {
 
 type newRawCommandRequest struct {
 	RawCommand
+	Delivery DeliveryOptions
 }
 
 type newRawCommandResponse struct {
@@ -133,7 +220,8 @@ func decodeNewRawCommandRequest(ctx context.Context, r *http.Request) (interface
 
 	req.UDID = udid
 	req.Raw = payload
-	return req, nil
+	req.Delivery, err = decodeDeliveryQuery(r.URL.Query())
+	return req, err
 }
 
 var errMalformedRequest = errors.New("request is malformed")
@@ -148,7 +236,7 @@ func MakeNewRawCommandEndpoint(svc Service) endpoint.Endpoint {
 		if req.CommandUUID == "" || req.Command.RequestType == "" {
 			return newRawCommandResponse{Err: errMalformedRequest}, nil
 		}
-		if err := svc.NewRawCommand(ctx, &req.RawCommand); err != nil {
+		if err := svc.NewRawCommand(ctx, &req.RawCommand, req.Delivery); err != nil {
 			return newRawCommandResponse{Err: err}, nil
 		}
 		return newRawCommandResponse{Payload: &req.RawCommand}, nil
@@ -0,0 +1,79 @@
+package command
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+)
+
+func TestDecodeNewCommandRequest(t *testing.T) {
+	body := `{
+		"udid": "udid-1",
+		"request_type": "ProfileList",
+		"idempotency_key": "key-1",
+		"max_attempts": 3,
+		"not_before": "2021-06-01T10:00:00Z",
+		"depends_on": ["uuid-1"],
+		"priority": 50
+	}`
+	r := httptest.NewRequest("POST", "/v1/commands", strings.NewReader(body))
+	decoded, err := decodeNewCommandRequest(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := decoded.(newCommandRequest)
+	if req.UDID != "udid-1" || req.RequestType != "ProfileList" || req.IdempotencyKey != "key-1" {
+		t.Errorf("have request %+v", req.CommandRequest)
+	}
+	want := DeliveryOptions{
+		MaxAttempts: 3,
+		NotBefore:   time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC),
+		DependsOn:   []string{"uuid-1"},
+		Priority:    50,
+	}
+	if !reflect.DeepEqual(req.Delivery, want) {
+		t.Errorf("have delivery options %+v, want %+v", req.Delivery, want)
+	}
+}
+
+func TestNewCommand_DeliveryWindow(t *testing.T) {
+	pubsub := inmem.NewPubSub()
+	events, err := pubsub.Subscribe(context.Background(), "test", CommandTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := New(pubsub, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notBefore := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(time.Hour)
+	request := &mdm.CommandRequest{
+		UDID:      "udid-1",
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+		Command:   &mdm.Command{RequestType: "ProfileList"},
+	}
+	if _, err := svc.NewCommand(context.Background(), request, DeliveryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var ev Event
+	select {
+	case msg := <-events:
+		if err := UnmarshalEvent(msg.Message, &ev); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for command event")
+	}
+	if !ev.Delivery.NotBefore.Equal(notBefore) || !ev.Delivery.NotAfter.Equal(notAfter) {
+		t.Errorf("have window %s - %s, want %s - %s", ev.Delivery.NotBefore, ev.Delivery.NotAfter, notBefore, notAfter)
+	}
+}
@@ -0,0 +1,61 @@
+package command_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mdmcmd "github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+)
+
+func TestNewCommand_Priority(t *testing.T) {
+	pubsub := inmem.NewPubSub()
+	events, err := pubsub.Subscribe(context.Background(), "test", command.CommandTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := command.New(pubsub, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command         mdmcmd.Command
+		priority        int
+		requestPriority int
+		want            int
+	}{
+		{mdmcmd.Command{RequestType: "ProfileList"}, 0, 0, command.PriorityNormal},
+		{mdmcmd.Command{RequestType: "DeviceLock", DeviceLock: &mdmcmd.DeviceLock{}}, 0, 0, command.PriorityCritical},
+		{mdmcmd.Command{RequestType: "EraseDevice", EraseDevice: &mdmcmd.EraseDevice{}}, 0, 0, command.PriorityCritical},
+		{mdmcmd.Command{RequestType: "DeviceLock", DeviceLock: &mdmcmd.DeviceLock{}}, 5, 0, 5},
+		{mdmcmd.Command{RequestType: "ProfileList"}, 50, 0, 50},
+		{mdmcmd.Command{RequestType: "ProfileList"}, 0, 20, 20},
+		{mdmcmd.Command{RequestType: "ProfileList"}, 50, 20, 50},
+	}
+	for _, tt := range tests {
+		cmd := tt.command
+		request := &mdmcmd.CommandRequest{
+			UDID:     "udid-1",
+			Priority: tt.requestPriority,
+			Command:  &cmd,
+		}
+		if _, err := svc.NewCommand(context.Background(), request, command.DeliveryOptions{Priority: tt.priority}); err != nil {
+			t.Fatal(err)
+		}
+		var ev command.Event
+		select {
+		case msg := <-events:
+			if err := command.UnmarshalEvent(msg.Message, &ev); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for command event")
+		}
+		if have := ev.Delivery.Priority; have != tt.want {
+			t.Errorf("%s with priority %d: have %d, want %d", tt.command.RequestType, tt.priority, have, tt.want)
+		}
+	}
+}
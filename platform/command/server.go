@@ -12,6 +12,10 @@ type Endpoints struct {
 	NewRawCommandEndpoint endpoint.Endpoint
 	ClearQueueEndpoint    endpoint.Endpoint
 	ViewQueueEndpoint     endpoint.Endpoint
+	HistoryEndpoint       endpoint.Endpoint
+	StatusEndpoint        endpoint.Endpoint
+	CancelEndpoint        endpoint.Endpoint
+	BulkCommandEndpoint   endpoint.Endpoint
 }
 
 func MakeServerEndpoints(s Service, outer endpoint.Middleware, others ...endpoint.Middleware) Endpoints {
@@ -20,6 +24,10 @@ func MakeServerEndpoints(s Service, outer endpoint.Middleware, others ...endpoin
 		NewRawCommandEndpoint: endpoint.Chain(outer, others...)(MakeNewRawCommandEndpoint(s)),
 		ClearQueueEndpoint:    endpoint.Chain(outer, others...)(MakeClearQueueEndpoint(s)),
 		ViewQueueEndpoint:     endpoint.Chain(outer, others...)(MakeViewQueueEndpoint(s)),
+		HistoryEndpoint:       endpoint.Chain(outer, others...)(MakeHistoryEndpoint(s)),
+		StatusEndpoint:        endpoint.Chain(outer, others...)(MakeStatusEndpoint(s)),
+		CancelEndpoint:        endpoint.Chain(outer, others...)(MakeCancelCommandEndpoint(s)),
+		BulkCommandEndpoint:   endpoint.Chain(outer, others...)(MakeBulkCommandEndpoint(s)),
 	}
 }
 
@@ -32,6 +40,22 @@ func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.S
 		options...,
 	))
 
+	// GET /v1/commands/status/command_uuid		View the state of a single command.
+	r.Methods("GET").Path("/v1/commands/status/{command_uuid}").Handler(httptransport.NewServer(
+		e.StatusEndpoint,
+		decodeStatusRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
+
+	// GET /v1/commands/udid/history		View device command history.
+	r.Methods("GET").Path("/v1/commands/{udid}/history").Handler(httptransport.NewServer(
+		e.HistoryEndpoint,
+		decodeHistoryRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
+
 	// POST     /v1/commands		Add new MDM Command to device queue.
 	r.Methods("POST").Path("/v1/commands").Handler(httptransport.NewServer(
 		e.NewCommandEndpoint,
@@ -40,6 +64,14 @@ func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.S
 		options...,
 	))
 
+	// POST     /v1/commands/bulk		Add the same MDM Command to many device queues.
+	r.Methods("POST").Path("/v1/commands/bulk").Handler(httptransport.NewServer(
+		e.BulkCommandEndpoint,
+		decodeBulkCommandRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
+
 	// POST,PUT /v1/commands/udid		Add new MDM Command with raw plist to device queue.
 	r.Methods("POST", "PUT").Path("/v1/commands/{udid}").Handler(httptransport.NewServer(
 		e.NewRawCommandEndpoint,
@@ -55,4 +87,12 @@ func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.S
 		httputil.EncodeJSONResponse,
 		options...,
 	))
+
+	// DELETE     /v1/commands/udid/command_uuid		Cancel a single queued command.
+	r.Methods("DELETE").Path("/v1/commands/{udid}/{command_uuid}").Handler(httptransport.NewServer(
+		e.CancelEndpoint,
+		decodeCancelRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
 }
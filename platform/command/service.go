@@ -9,10 +9,14 @@ import (
 )
 
 type Service interface {
-	NewCommand(context.Context, *mdm.CommandRequest) (*mdm.CommandPayload, error)
-	NewRawCommand(context.Context, *RawCommand) error
+	NewCommand(context.Context, *mdm.CommandRequest, DeliveryOptions) (*CommandResult, error)
+	NewRawCommand(context.Context, *RawCommand, DeliveryOptions) error
+	NewBulkCommand(context.Context, *BulkCommandRequest) ([]BulkCommandResult, error)
 	ClearQueue(ctx context.Context, udid string) error
+	CancelCommand(ctx context.Context, udid, uuid string) error
 	ViewQueue(ctx context.Context, udid string) ([]*mdmsvc.Command, error)
+	CommandHistory(ctx context.Context, udid string, opt HistoryOptions) (*HistoryPage, error)
+	CommandStatus(ctx context.Context, uuid string) (*CommandStatus, error)
 }
 
 // Queue is an MDM Command Queue.
@@ -24,12 +28,25 @@ type Queue interface {
 type CommandService struct {
 	publisher pubsub.Publisher
 	queue     Queue
+	devices   DeviceStore
 }
 
-func New(pub pubsub.Publisher, queue Queue) (*CommandService, error) {
+type Option func(*CommandService)
+
+// WithDeviceStore allows bulk commands to target devices by serial number.
+func WithDeviceStore(devices DeviceStore) Option {
+	return func(svc *CommandService) {
+		svc.devices = devices
+	}
+}
+
+func New(pub pubsub.Publisher, queue Queue, opts ...Option) (*CommandService, error) {
 	svc := CommandService{
 		publisher: pub,
 		queue:     queue,
 	}
+	for _, fn := range opts {
+		fn(&svc)
+	}
 	return &svc, nil
 }
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gorilla/mux"
+	"github.com/groob/plist"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/mdm"
+)
+
+// Command states reported by CommandStatus.
+const (
+	StateQueued       = "queued"
+	StateSent         = "sent"
+	StateNotNow       = "notnow"
+	StateAcknowledged = "acknowledged"
+	StateError        = "error"
+	StateExpired      = "expired"
+	StateCanceled     = "canceled"
+
+	StateDependencyFailed = "dependency_failed"
+)
+
+// StatusQueue is implemented by command queues which can look up a
+// command by its UUID.
+type StatusQueue interface {
+	CommandStatus(ctx context.Context, uuid string) (*CommandStatus, error)
+}
+
+// CommandStatus is the delivery state of a single command.
+type CommandStatus struct {
+	CommandUUID string    `json:"command_uuid"`
+	UDID        string    `json:"udid"`
+	RequestType string    `json:"request_type,omitempty"`
+	State       string    `json:"state"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSentAt  time.Time `json:"last_sent_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	TimesSent   int       `json:"times_sent"`
+
+	// DependsOn holds the dependencies the command is still waiting for.
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	ErrorChain    []mdm.ErrorChainItem   `json:"error_chain,omitempty"`
+	FailureReason string                 `json:"failure_reason,omitempty"`
+	Response      map[string]interface{} `json:"response,omitempty"`
+
+	// Payload and RawResponse are the command and response plists. They
+	// are set by the queue and decoded by the service.
+	Payload     []byte `json:"-"`
+	RawResponse []byte `json:"-"`
+}
+
+type commandNotFoundErr struct {
+	uuid string
+}
+
+func (e commandNotFoundErr) Error() string {
+	return fmt.Sprintf("command %s not found", e.uuid)
+}
+
+func (e commandNotFoundErr) NotFound() bool  { return true }
+func (e commandNotFoundErr) StatusCode() int { return http.StatusNotFound }
+
+var errStatusUnsupported = errors.New("command queue does not support status lookups")
+
+func (svc *CommandService) CommandStatus(ctx context.Context, uuid string) (*CommandStatus, error) {
+	q, ok := svc.queue.(StatusQueue)
+	if !ok {
+		return nil, errStatusUnsupported
+	}
+	status, err := q.CommandStatus(ctx, uuid)
+	if isNotFound(err) {
+		return nil, commandNotFoundErr{uuid: uuid}
+	} else if err != nil {
+		return nil, errors.Wrap(err, "get command status")
+	}
+
+	if len(status.Payload) > 0 {
+		var payload struct {
+			Command struct {
+				RequestType string
+			}
+		}
+		if err := plist.Unmarshal(status.Payload, &payload); err == nil {
+			status.RequestType = payload.Command.RequestType
+		}
+	}
+	if len(status.RawResponse) > 0 {
+		var resp mdm.Response
+		if err := plist.NewDecoder(bytes.NewReader(status.RawResponse)).Decode(&resp); err == nil {
+			status.ErrorChain = resp.ErrorChain
+		}
+		if err := plist.Unmarshal(status.RawResponse, &status.Response); err != nil {
+			return nil, errors.Wrap(err, "decode command response plist")
+		}
+	}
+	return status, nil
+}
+
+func isNotFound(err error) bool {
+	type notFoundError interface {
+		error
+		NotFound() bool
+	}
+
+	e, ok := errors.Cause(err).(notFoundError)
+	return ok && e.NotFound()
+}
+
+type statusRequest struct {
+	CommandUUID string
+}
+
+type statusResponse struct {
+	*CommandStatus
+	Err error `json:"error,omitempty"`
+}
+
+func (r statusResponse) Failed() error   { return r.Err }
+func (r statusResponse) StatusCode() int { return http.StatusOK }
+
+func decodeStatusRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return statusRequest{CommandUUID: mux.Vars(r)["command_uuid"]}, nil
+}
+
+var errEmptyCommandUUID = errors.New("request must contain a command UUID")
+
+// MakeStatusEndpoint creates an endpoint which looks up the state of a
+// single command.
+func MakeStatusEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(statusRequest)
+		if req.CommandUUID == "" {
+			return statusResponse{Err: errEmptyCommandUUID}, nil
+		}
+		status, err := svc.CommandStatus(ctx, req.CommandUUID)
+		if err != nil {
+			return statusResponse{Err: err}, nil
+		}
+		return statusResponse{CommandStatus: status}, nil
+	}
+}
@@ -0,0 +1,71 @@
+package command_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/liuds832/micromdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+)
+
+const testErrorResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>CommandUUID</key>
+    <string>0001_ProfileList</string>
+    <key>ErrorChain</key>
+    <array>
+        <dict>
+            <key>ErrorCode</key>
+            <integer>12021</integer>
+            <key>ErrorDomain</key>
+            <string>MCMDMErrorDomain</string>
+        </dict>
+    </array>
+    <key>Status</key>
+    <string>Error</string>
+    <key>UDID</key>
+    <string>1234</string>
+</dict>
+</plist>`
+
+type statusQueue struct {
+	status *command.CommandStatus
+}
+
+func (q statusQueue) Clear(context.Context, mdm.CheckinEvent) error { return nil }
+func (q statusQueue) ViewQueue(context.Context, mdm.CheckinEvent) ([]*mdm.Command, error) {
+	return nil, nil
+}
+func (q statusQueue) CommandStatus(context.Context, string) (*command.CommandStatus, error) {
+	return q.status, nil
+}
+
+func TestCommandStatus(t *testing.T) {
+	q := statusQueue{status: &command.CommandStatus{
+		CommandUUID: "0001_ProfileList",
+		UDID:        "1234",
+		State:       command.StateError,
+		Payload:     []byte(testRawCmd),
+		RawResponse: []byte(testErrorResponse),
+	}}
+	svc, err := command.New(nil, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := svc.CommandStatus(context.Background(), "0001_ProfileList")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.RequestType, "ProfileList"; have != want {
+		t.Errorf("have request type %s, want %s", have, want)
+	}
+	if len(status.ErrorChain) != 1 || status.ErrorChain[0].ErrorCode != 12021 {
+		t.Errorf("unexpected error chain %+v", status.ErrorChain)
+	}
+	if have, want := status.Response["Status"], "Error"; have != want {
+		t.Errorf("have response status %v, want %v", have, want)
+	}
+}
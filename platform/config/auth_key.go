@@ -0,0 +1,78 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuds832/micromdm/platform/config/internal/configproto"
+)
+
+// APNSAuthKey is an APNs authentication key from the Apple Developer
+// portal. It signs provider tokens which are an alternative to the push
+// certificate.
+type APNSAuthKey struct {
+	// Key is the contents of the .p8 file, a PEM encoded PKCS #8 key.
+	Key    []byte `json:"key"`
+	KeyID  string `json:"key_id"`
+	TeamID string `json:"team_id"`
+	Topic  string `json:"topic"`
+}
+
+// PrivateKey parses the P-256 private key of k.
+func (k *APNSAuthKey) PrivateKey() (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(k.Key)
+	if block == nil {
+		return nil, errors.New("decode APNs auth key PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse APNs auth key")
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, errors.New("APNs auth key is not a P-256 key")
+	}
+	return ecKey, nil
+}
+
+// Validate checks that k can be used to sign provider tokens.
+func (k *APNSAuthKey) Validate() error {
+	switch {
+	case k.KeyID == "":
+		return errors.New("APNs auth key: missing key ID")
+	case k.TeamID == "":
+		return errors.New("APNs auth key: missing team ID")
+	case k.Topic == "":
+		return errors.New("APNs auth key: missing topic")
+	}
+	_, err := k.PrivateKey()
+	return err
+}
+
+func MarshalAPNSAuthKey(k *APNSAuthKey) ([]byte, error) {
+	pb := configproto.APNSAuthKey{
+		Key:    k.Key,
+		KeyId:  k.KeyID,
+		TeamId: k.TeamID,
+		Topic:  k.Topic,
+	}
+	data, err := proto.Marshal(&pb)
+	return data, errors.Wrap(err, "marshal APNs auth key to proto")
+}
+
+func UnmarshalAPNSAuthKey(data []byte, k *APNSAuthKey) error {
+	var pb configproto.APNSAuthKey
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return errors.Wrap(err, "unmarshal APNs auth key from proto")
+	}
+	k.Key = pb.GetKey()
+	k.KeyID = pb.GetKeyId()
+	k.TeamID = pb.GetTeamId()
+	k.Topic = pb.GetTopic()
+	return nil
+}
@@ -106,14 +106,9 @@ func (db *DB) PushCertificate() (*tls.Certificate, error) {
 	}
 
 	// load certificate
-	certBlock, _ := pem.Decode(conf.PushCertificate)
-	if certBlock == nil {
-		return nil, errors.New("decode push certificate PEM")
-	}
-
-	pushCert, err := x509.ParseCertificate(certBlock.Bytes)
+	pushCert, err := config.ParsePushCertificate(conf.PushCertificate)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse push certificate from server config")
+		return nil, errors.Wrap(err, "load push certificate from server config")
 	}
 
 	cert := tls.Certificate{
@@ -127,6 +122,10 @@ func (db *DB) PushCertificate() (*tls.Certificate, error) {
 func (db *DB) PushTopic() (string, error) {
 	cert, err := db.PushCertificate()
 	if err != nil {
+		// without a push certificate, the topic of the auth key is used.
+		if key, keyErr := db.APNSAuthKey(); keyErr == nil {
+			return key.Topic, nil
+		}
 		return "", errors.Wrap(err, "get push certificate for topic")
 	}
 	topic, err := crypto.TopicFromCert(cert.Leaf)
@@ -141,3 +140,7 @@ type notFound struct {
 func (e *notFound) Error() string {
 	return fmt.Sprintf("not found: %s %s", e.ResourceType, e.Message)
 }
+
+func (e *notFound) NotFound() bool {
+	return true
+}
@@ -0,0 +1,40 @@
+package builtin
+
+import (
+	"context"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/config"
+)
+
+const authKeyKey = "apns-auth-key"
+
+func (db *DB) SaveAPNSAuthKey(key *config.APNSAuthKey) error {
+	data, err := config.MarshalAPNSAuthKey(key)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(ConfigBucket))
+		return bkt.Put([]byte(authKeyKey), data)
+	})
+	if err != nil {
+		return errors.Wrap(err, "save APNs auth key in bolt")
+	}
+	return db.Publisher.Publish(context.TODO(), config.ConfigTopic, []byte("updated"))
+}
+
+func (db *DB) APNSAuthKey() (*config.APNSAuthKey, error) {
+	var key config.APNSAuthKey
+	err := db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(ConfigBucket))
+		data := bkt.Get([]byte(authKeyKey))
+		if data == nil {
+			return &notFound{"APNSAuthKey", "no APNs auth key found in boltdb"}
+		}
+		return config.UnmarshalAPNSAuthKey(data, &key)
+	})
+	return &key, errors.Wrap(err, "get APNs auth key from bolt")
+}
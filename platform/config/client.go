@@ -26,6 +26,28 @@ func NewHTTPClient(instance, token string, logger log.Logger, opts ...httptransp
 		).Endpoint()
 	}
 
+	var getStatusEndpoint endpoint.Endpoint
+	{
+		getStatusEndpoint = httptransport.NewClient(
+			"GET",
+			httputil.CopyURL(u, "/v1/config/status"),
+			httputil.EncodeRequestWithToken(token, httputil.EncodeEmptyRequest),
+			decodeGetStatusResponse,
+			opts...,
+		).Endpoint()
+	}
+
+	var saveAPNSAuthKeyEndpoint endpoint.Endpoint
+	{
+		saveAPNSAuthKeyEndpoint = httptransport.NewClient(
+			"PUT",
+			httputil.CopyURL(u, "/v1/config/apns-auth-key"),
+			httputil.EncodeRequestWithToken(token, httptransport.EncodeJSONRequest),
+			decodeSaveAPNSAuthKeyResponse,
+			opts...,
+		).Endpoint()
+	}
+
 	var applyDEPTokensEndpoint endpoint.Endpoint
 	{
 		applyDEPTokensEndpoint = httptransport.NewClient(
@@ -50,6 +72,8 @@ func NewHTTPClient(instance, token string, logger log.Logger, opts ...httptransp
 
 	return Endpoints{
 		SavePushCertificateEndpoint: saveEndpoint,
+		GetStatusEndpoint:           getStatusEndpoint,
+		SaveAPNSAuthKeyEndpoint:     saveAPNSAuthKeyEndpoint,
 		ApplyDEPTokensEndpoint:      applyDEPTokensEndpoint,
 		GetDEPTokensEndpoint:        getDEPTokensEndpoint,
 	}, nil
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuds832/micromdm/pkg/crypto"
+	"github.com/liuds832/micromdm/platform/config/internal/configproto"
+	"github.com/liuds832/micromdm/platform/pubsub"
+)
+
+// PushCertificateExpiryTopic is a PubSub topic that warnings about the
+// expiry of the push certificate are published to.
+const PushCertificateExpiryTopic = "mdm.PushCertificateExpiry"
+
+// DefaultExpiryWarnings are the times before the push certificate expires
+// at which the ExpiryMonitor warns.
+var DefaultExpiryWarnings = []time.Duration{
+	30 * 24 * time.Hour,
+	14 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+	24 * time.Hour,
+}
+
+const expiryCheckInterval = time.Hour
+
+// PushCertificateExpiry is a warning that the push certificate expires
+// soon or has expired.
+type PushCertificateExpiry struct {
+	Topic         string    `json:"topic"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	Expired       bool      `json:"expired"`
+}
+
+func MarshalPushCertificateExpiry(e *PushCertificateExpiry) ([]byte, error) {
+	return proto.Marshal(&configproto.PushCertificateExpiry{
+		Topic:         e.Topic,
+		NotAfter:      e.NotAfter.UnixNano(),
+		DaysRemaining: int32(e.DaysRemaining),
+		Expired:       e.Expired,
+	})
+}
+
+func UnmarshalPushCertificateExpiry(data []byte, e *PushCertificateExpiry) error {
+	var pb configproto.PushCertificateExpiry
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return errors.Wrap(err, "unmarshal proto to PushCertificateExpiry")
+	}
+	e.Topic = pb.GetTopic()
+	e.NotAfter = time.Unix(0, pb.GetNotAfter()).UTC()
+	e.DaysRemaining = int(pb.GetDaysRemaining())
+	e.Expired = pb.GetExpired()
+	return nil
+}
+
+// ExpiryMonitor warns when the push certificate is about to expire. A
+// warning is logged and published once for each of the warning times the
+// certificate passes, and once more when it expired. A renewed certificate
+// starts over.
+type ExpiryMonitor struct {
+	store    Store
+	pub      pubsub.Publisher
+	logger   log.Logger
+	warnings []time.Duration
+	now      func() time.Time
+
+	// the certificate and warning the last warning was for.
+	notAfter time.Time
+	warned   int
+}
+
+// NewExpiryMonitor creates an ExpiryMonitor which warns at the times
+// before the push certificate expires in warnings.
+func NewExpiryMonitor(store Store, pub pubsub.Publisher, logger log.Logger, warnings []time.Duration) *ExpiryMonitor {
+	sorted := append([]time.Duration(nil), warnings...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+	return &ExpiryMonitor{
+		store:    store,
+		pub:      pub,
+		logger:   logger,
+		warnings: sorted,
+		now:      time.Now,
+	}
+}
+
+// Run checks the push certificate until ctx is done.
+func (m *ExpiryMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.check(ctx); err != nil {
+			level.Info(m.logger).Log("msg", "check push certificate expiry", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check warns if the push certificate passed a warning time since the
+// last check.
+func (m *ExpiryMonitor) check(ctx context.Context) error {
+	cert, err := m.store.PushCertificate()
+	if err != nil {
+		// there is nothing to warn about without a push certificate.
+		return nil
+	}
+	notAfter := cert.Leaf.NotAfter
+	remaining := notAfter.Sub(m.now())
+
+	warning := -1
+	for i, before := range m.warnings {
+		if remaining <= before {
+			warning = i
+		}
+	}
+	if remaining <= 0 {
+		warning = len(m.warnings)
+	}
+	if warning < 0 || (notAfter.Equal(m.notAfter) && warning <= m.warned) {
+		return nil
+	}
+
+	topic, _ := crypto.TopicFromCert(cert.Leaf)
+	ev := &PushCertificateExpiry{
+		Topic:         topic,
+		NotAfter:      notAfter.UTC(),
+		DaysRemaining: int(remaining.Hours() / 24),
+		Expired:       remaining <= 0,
+	}
+	if ev.Expired {
+		ev.DaysRemaining = 0
+		level.Info(m.logger).Log("msg", "push certificate expired, devices can no longer be pushed to", "topic", topic, "not_after", ev.NotAfter)
+	} else {
+		level.Info(m.logger).Log("msg", "push certificate expires soon, renew it", "topic", topic, "not_after", ev.NotAfter, "days_remaining", ev.DaysRemaining)
+	}
+
+	data, err := MarshalPushCertificateExpiry(ev)
+	if err != nil {
+		return err
+	}
+	if err := m.pub.Publish(ctx, PushCertificateExpiryTopic, data); err != nil {
+		return errors.Wrap(err, "publish push certificate expiry")
+	}
+	m.notAfter, m.warned = notAfter, warning
+	return nil
+}
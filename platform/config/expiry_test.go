@@ -0,0 +1,252 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+)
+
+type certStore struct {
+	Store
+	cert     *tls.Certificate
+	key      *APNSAuthKey
+	topicErr error
+}
+
+type notFoundErr struct{}
+
+func (notFoundErr) Error() string  { return "no push certificate" }
+func (notFoundErr) NotFound() bool { return true }
+
+func (s *certStore) PushCertificate() (*tls.Certificate, error) {
+	if s.cert == nil {
+		return nil, notFoundErr{}
+	}
+	return s.cert, nil
+}
+
+func (s *certStore) PushTopic() (string, error) {
+	switch {
+	case s.topicErr != nil:
+		return "", s.topicErr
+	case s.cert != nil:
+		return topicOf(s.cert.Leaf), nil
+	case s.key != nil:
+		return s.key.Topic, nil
+	}
+	return "", errors.Wrap(notFoundErr{}, "get push certificate for topic")
+}
+
+func (s *certStore) SaveAPNSAuthKey(key *APNSAuthKey) error {
+	s.key = key
+	return nil
+}
+
+func (s *certStore) SavePushCertificate(cert, key []byte) error {
+	leaf, err := ParsePushCertificate(cert)
+	if err != nil {
+		return err
+	}
+	s.cert = &tls.Certificate{Certificate: [][]byte{leaf.Raw}, Leaf: leaf}
+	return nil
+}
+
+var oidUserID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+
+func topicOf(cert *x509.Certificate) string {
+	for _, name := range cert.Subject.Names {
+		if name.Type.Equal(oidUserID) {
+			return name.Value.(string)
+		}
+	}
+	return ""
+}
+
+func newPushCertificate(t *testing.T, topic string, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: oidUserID, Value: topic}},
+		},
+		NotBefore: notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:  notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestSavePushCertificate_TopicChange(t *testing.T) {
+	store := &certStore{}
+	svc := New(store)
+	ctx := context.Background()
+	notAfter := time.Now().Add(365 * 24 * time.Hour)
+
+	if err := svc.SavePushCertificate(ctx, newPushCertificate(t, "com.apple.mgmt.External.a", notAfter), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	// a renewal keeps the topic.
+	if err := svc.SavePushCertificate(ctx, newPushCertificate(t, "com.apple.mgmt.External.a", notAfter), nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newPushCertificate(t, "com.apple.mgmt.External.b", notAfter)
+	err := svc.SavePushCertificate(ctx, other, nil, false)
+	changed, ok := err.(*TopicChangedError)
+	if !ok {
+		t.Fatalf("have error %v, want a TopicChangedError", err)
+	}
+	if changed.StatusCode() != http.StatusConflict || changed.Current != "com.apple.mgmt.External.a" {
+		t.Errorf("have %+v", changed)
+	}
+	if have := topicOf(store.cert.Leaf); have != "com.apple.mgmt.External.a" {
+		t.Errorf("have topic %s saved after a refused change", have)
+	}
+
+	if err := svc.SavePushCertificate(ctx, other, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if have := topicOf(store.cert.Leaf); have != "com.apple.mgmt.External.b" {
+		t.Errorf("have topic %s after a forced change", have)
+	}
+}
+
+func TestSavePushCertificate_TopicUnknown(t *testing.T) {
+	store := &certStore{topicErr: errors.New("decode private key for push cert")}
+	svc := New(store)
+	ctx := context.Background()
+	cert := newPushCertificate(t, "com.apple.mgmt.External.a", time.Now().Add(365*24*time.Hour))
+
+	// a topic which can not be read is not treated as a missing one.
+	if err := svc.SavePushCertificate(ctx, cert, nil, false); err == nil {
+		t.Fatal("saved push certificate without the current topic")
+	}
+	if err := svc.SavePushCertificate(ctx, cert, nil, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveAPNSAuthKey_TopicChange(t *testing.T) {
+	store := &certStore{}
+	svc := New(store)
+	ctx := context.Background()
+
+	if err := svc.SaveAPNSAuthKey(ctx, newAuthKey(t, "com.apple.mgmt.External.a"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SaveAPNSAuthKey(ctx, newAuthKey(t, "com.apple.mgmt.External.a"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	err := svc.SaveAPNSAuthKey(ctx, newAuthKey(t, "com.apple.mgmt.External.b"), false)
+	if _, ok := err.(*TopicChangedError); !ok {
+		t.Fatalf("have error %v, want a TopicChangedError", err)
+	}
+	if store.key.Topic != "com.apple.mgmt.External.a" {
+		t.Errorf("have topic %s saved after a refused change", store.key.Topic)
+	}
+
+	if err := svc.SaveAPNSAuthKey(ctx, newAuthKey(t, "com.apple.mgmt.External.b"), true); err != nil {
+		t.Fatal(err)
+	}
+	if store.key.Topic != "com.apple.mgmt.External.b" {
+		t.Errorf("have topic %s after a forced change", store.key.Topic)
+	}
+}
+
+func newAuthKey(t *testing.T, topic string) APNSAuthKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return APNSAuthKey{
+		Key:    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		KeyID:  "KEYID",
+		TeamID: "TEAMID",
+		Topic:  topic,
+	}
+}
+
+func TestExpiryMonitor(t *testing.T) {
+	now := time.Now().UTC()
+	notAfter := now.Add(40 * 24 * time.Hour)
+	store := &certStore{}
+	if err := store.SavePushCertificate(newPushCertificate(t, "com.apple.mgmt.External.a", notAfter), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ps := inmem.NewPubSub()
+	events, err := ps.Subscribe(context.Background(), "test", PushCertificateExpiryTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewExpiryMonitor(store, ps, log.NewNopLogger(), []time.Duration{7 * 24 * time.Hour, 30 * 24 * time.Hour})
+
+	for _, tt := range []struct {
+		daysLeft int
+		warn     bool
+		expired  bool
+	}{
+		{daysLeft: 35, warn: false},
+		{daysLeft: 29, warn: true},
+		{daysLeft: 20, warn: false},
+		{daysLeft: 6, warn: true},
+		{daysLeft: 5, warn: false},
+		{daysLeft: -1, warn: true, expired: true},
+		{daysLeft: -2, warn: false},
+	} {
+		m.now = func() time.Time { return notAfter.Add(-time.Duration(tt.daysLeft)*24*time.Hour - time.Minute) }
+		if err := m.check(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ev := <-events:
+			if !tt.warn {
+				t.Errorf("%d days left: unexpected warning", tt.daysLeft)
+				continue
+			}
+			var expiry PushCertificateExpiry
+			if err := UnmarshalPushCertificateExpiry(ev.Message, &expiry); err != nil {
+				t.Fatal(err)
+			}
+			if expiry.Expired != tt.expired || expiry.Topic != "com.apple.mgmt.External.a" {
+				t.Errorf("%d days left: have %+v", tt.daysLeft, expiry)
+			}
+			if !tt.expired && expiry.DaysRemaining != tt.daysLeft {
+				t.Errorf("have %d days remaining, want %d", expiry.DaysRemaining, tt.daysLeft)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tt.warn {
+				t.Errorf("%d days left: no warning", tt.daysLeft)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/pkg/crypto"
+	"github.com/liuds832/micromdm/pkg/httputil"
+)
+
+// Status describes the push configuration of the server.
+type Status struct {
+	PushCertificate *PushCertificateStatus `json:"push_certificate,omitempty"`
+	APNSAuthKey     bool                   `json:"apns_auth_key"`
+}
+
+// PushCertificateStatus describes the validity of the push certificate.
+type PushCertificateStatus struct {
+	Topic         string    `json:"topic"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	Expired       bool      `json:"expired"`
+}
+
+func (svc *ConfigService) GetStatus(ctx context.Context) (*Status, error) {
+	var status Status
+	if _, err := svc.store.APNSAuthKey(); err == nil {
+		status.APNSAuthKey = true
+	}
+	cert, err := svc.store.PushCertificate()
+	if err != nil {
+		// without a push certificate, there is no expiry to report.
+		return &status, nil
+	}
+	topic, err := crypto.TopicFromCert(cert.Leaf)
+	if err != nil {
+		return nil, errors.Wrap(err, "get topic from push certificate")
+	}
+	remaining := time.Until(cert.Leaf.NotAfter)
+	status.PushCertificate = &PushCertificateStatus{
+		Topic:     topic,
+		NotBefore: cert.Leaf.NotBefore.UTC(),
+		NotAfter:  cert.Leaf.NotAfter.UTC(),
+		Expired:   remaining <= 0,
+	}
+	if remaining > 0 {
+		status.PushCertificate.DaysRemaining = int(remaining.Hours() / 24)
+	}
+	return &status, nil
+}
+
+type statusResponse struct {
+	*Status
+	Err error `json:"err,omitempty"`
+}
+
+func (r statusResponse) Failed() error { return r.Err }
+
+func decodeGetStatusRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeGetStatusResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp statusResponse
+	err := httputil.DecodeJSONResponse(r, &resp)
+	return resp, err
+}
+
+func MakeGetStatusEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		status, err := svc.GetStatus(ctx)
+		return statusResponse{Status: status, Err: err}, nil
+	}
+}
+
+func (e Endpoints) GetStatus(ctx context.Context) (*Status, error) {
+	response, err := e.GetStatusEndpoint(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.(statusResponse).Status, response.(statusResponse).Err
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/liuds832/micromdm/pkg/httputil"
+	"github.com/pkg/errors"
+)
+
+// SaveAPNSAuthKey saves an APNs auth key. Like a push certificate, a key
+// for another topic than the current one is refused with a
+// TopicChangedError unless force is set.
+func (svc *ConfigService) SaveAPNSAuthKey(ctx context.Context, key APNSAuthKey, force bool) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
+	if err := svc.checkTopic(key.Topic, force); err != nil {
+		return err
+	}
+	err := svc.store.SaveAPNSAuthKey(&key)
+	return errors.Wrap(err, "save APNs auth key")
+}
+
+type saveAuthKeyRequest struct {
+	APNSAuthKey
+	Force bool `json:"force,omitempty"`
+}
+
+type saveAuthKeyResponse struct {
+	Err error `json:"err,omitempty"`
+}
+
+func (r saveAuthKeyResponse) Failed() error { return r.Err }
+
+func decodeSaveAPNSAuthKeyRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req saveAuthKeyRequest
+	err := httputil.DecodeJSONRequest(r, &req)
+	return req, err
+}
+
+func decodeSaveAPNSAuthKeyResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp saveAuthKeyResponse
+	err := httputil.DecodeJSONResponse(r, &resp)
+	return resp, err
+}
+
+func MakeSaveAPNSAuthKeyEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(saveAuthKeyRequest)
+		err = svc.SaveAPNSAuthKey(ctx, req.APNSAuthKey, req.Force)
+		return saveAuthKeyResponse{Err: err}, nil
+	}
+}
+
+func (e Endpoints) SaveAPNSAuthKey(ctx context.Context, key APNSAuthKey, force bool) error {
+	response, err := e.SaveAPNSAuthKeyEndpoint(ctx, saveAuthKeyRequest{APNSAuthKey: key, Force: force})
+	if err != nil {
+		return err
+	}
+	return response.(saveAuthKeyResponse).Err
+}
@@ -2,21 +2,95 @@ package config
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"net/http"
 
 	"github.com/go-kit/kit/endpoint"
-	"github.com/liuds832/micromdm/pkg/httputil"
 	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/pkg/crypto"
+	"github.com/liuds832/micromdm/pkg/httputil"
 )
 
-func (svc *ConfigService) SavePushCertificate(ctx context.Context, cert, key []byte) error {
-	err := svc.store.SavePushCertificate(cert, key)
+// SavePushCertificate saves the PEM encoded push certificate and key.
+// Devices are enrolled with the topic of the push certificate, so a
+// certificate for another topic than the current one is refused with a
+// TopicChangedError unless force is set.
+func (svc *ConfigService) SavePushCertificate(ctx context.Context, cert, key []byte, force bool) error {
+	pushCert, err := ParsePushCertificate(cert)
+	if err != nil {
+		return err
+	}
+	topic, err := crypto.TopicFromCert(pushCert)
+	if err != nil {
+		return errors.Wrap(err, "get topic from push certificate")
+	}
+	if err := svc.checkTopic(topic, force); err != nil {
+		return err
+	}
+	err = svc.store.SavePushCertificate(cert, key)
 	return errors.Wrap(err, "save push certificate")
 }
 
+// checkTopic returns a TopicChangedError if topic is not the current push
+// topic, unless force is set. Without a current topic any topic is
+// accepted.
+func (svc *ConfigService) checkTopic(topic string, force bool) error {
+	current, err := svc.store.PushTopic()
+	switch {
+	case isNotFound(err):
+		return nil
+	case force:
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "get current push topic")
+	case current != topic:
+		return &TopicChangedError{Current: current, New: topic}
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	err = errors.Cause(err)
+	type notFoundErr interface {
+		error
+		NotFound() bool
+	}
+
+	e, ok := err.(notFoundErr)
+	return ok && e.NotFound()
+}
+
+// ParsePushCertificate parses a PEM encoded push certificate.
+func ParsePushCertificate(cert []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(cert)
+	if block == nil {
+		return nil, errors.New("decode push certificate PEM")
+	}
+	pushCert, err := x509.ParseCertificate(block.Bytes)
+	return pushCert, errors.Wrap(err, "parse push certificate")
+}
+
+// TopicChangedError is returned when a push certificate or APNs auth key
+// for a new topic would replace the current one. Enrolled devices can not be pushed to
+// with a different topic and have to enroll again.
+type TopicChangedError struct {
+	Current string
+	New     string
+}
+
+func (e *TopicChangedError) Error() string {
+	return fmt.Sprintf("push topic %s does not match current topic %s: enrolled devices would no longer receive pushes, force the upload to replace it", e.New, e.Current)
+}
+
+func (e *TopicChangedError) StatusCode() int { return http.StatusConflict }
+
 type saveRequest struct {
-	Cert []byte `json:"cert"`
-	Key  []byte `json:"key"`
+	Cert  []byte `json:"cert"`
+	Key   []byte `json:"key"`
+	Force bool   `json:"force,omitempty"`
 }
 
 type saveResponse struct {
@@ -40,15 +114,16 @@ func decodeSavePushCertificateResponse(_ context.Context, r *http.Response) (int
 func MakeSavePushCertificateEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(saveRequest)
-		err = svc.SavePushCertificate(ctx, req.Cert, req.Key)
+		err = svc.SavePushCertificate(ctx, req.Cert, req.Key, req.Force)
 		return saveResponse{Err: err}, nil
 	}
 }
 
-func (e Endpoints) SavePushCertificate(ctx context.Context, cert, key []byte) error {
+func (e Endpoints) SavePushCertificate(ctx context.Context, cert, key []byte, force bool) error {
 	request := saveRequest{
-		Cert: cert,
-		Key:  key,
+		Cert:  cert,
+		Key:   key,
+		Force: force,
 	}
 
 	response, err := e.SavePushCertificateEndpoint(ctx, request)
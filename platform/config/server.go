@@ -10,6 +10,8 @@ import (
 type Endpoints struct {
 	SavePushCertificateEndpoint endpoint.Endpoint
 	GetPushCertificateEndpoint  endpoint.Endpoint
+	GetStatusEndpoint           endpoint.Endpoint
+	SaveAPNSAuthKeyEndpoint     endpoint.Endpoint
 	ApplyDEPTokensEndpoint      endpoint.Endpoint
 	GetDEPTokensEndpoint        endpoint.Endpoint
 }
@@ -18,6 +20,8 @@ func MakeServerEndpoints(s Service, outer endpoint.Middleware, others ...endpoin
 	return Endpoints{
 		SavePushCertificateEndpoint: endpoint.Chain(outer, others...)(MakeSavePushCertificateEndpoint(s)),
 		GetPushCertificateEndpoint:  endpoint.Chain(outer, others...)(MakeGetPushCertificateEndpoint(s)),
+		GetStatusEndpoint:           endpoint.Chain(outer, others...)(MakeGetStatusEndpoint(s)),
+		SaveAPNSAuthKeyEndpoint:     endpoint.Chain(outer, others...)(MakeSaveAPNSAuthKeyEndpoint(s)),
 		ApplyDEPTokensEndpoint:      endpoint.Chain(outer, others...)(MakeApplyDEPTokensEndpoint(s)),
 		GetDEPTokensEndpoint:        endpoint.Chain(outer, others...)(MakeGetDEPTokensEndpoint(s)),
 	}
@@ -26,6 +30,8 @@ func MakeServerEndpoints(s Service, outer endpoint.Middleware, others ...endpoin
 func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.ServerOption) {
 	// PUT     /v1/config/certificate		create or replace the MDM Push Certificate
 	// GET     /v1/config/certificate		retrieve the MDM Push Certificate
+	// GET     /v1/config/status			retrieve the topic and expiry of the push configuration
+	// PUT     /v1/config/apns-auth-key		create or replace the APNs auth key
 	// PUT     /v1/dep-tokens				create or replace a DEP OAuth token
 	// GET     /v1/dep-tokens				get the OAuth Token used for the DEP client
 
@@ -43,6 +49,20 @@ func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.S
 		options...,
 	))
 
+	r.Methods("GET").Path("/v1/config/status").Handler(httptransport.NewServer(
+		e.GetStatusEndpoint,
+		decodeGetStatusRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
+
+	r.Methods("PUT").Path("/v1/config/apns-auth-key").Handler(httptransport.NewServer(
+		e.SaveAPNSAuthKeyEndpoint,
+		decodeSaveAPNSAuthKeyRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
+
 	r.Methods("PUT").Path("/v1/dep-tokens").Handler(httptransport.NewServer(
 		e.ApplyDEPTokensEndpoint,
 		decodeApplyDEPTokensRequest,
@@ -8,8 +8,10 @@ import (
 )
 
 type Service interface {
-	SavePushCertificate(ctx context.Context, cert, key []byte) error
+	SavePushCertificate(ctx context.Context, cert, key []byte, force bool) error
 	GetPushCertificate(ctx context.Context) ([]byte, error)
+	GetStatus(ctx context.Context) (*Status, error)
+	SaveAPNSAuthKey(ctx context.Context, key APNSAuthKey, force bool) error
 	ApplyDEPToken(ctx context.Context, P7MContent []byte) error
 	GetDEPTokens(ctx context.Context) ([]DEPToken, []byte, error)
 }
@@ -19,6 +21,8 @@ type Store interface {
 	GetPushCertificate() ([]byte, error)
 	PushCertificate() (*tls.Certificate, error)
 	PushTopic() (string, error)
+	SaveAPNSAuthKey(key *APNSAuthKey) error
+	APNSAuthKey() (*APNSAuthKey, error)
 	DEPKeypair() (key *rsa.PrivateKey, cert *x509.Certificate, err error)
 	AddToken(consumerKey string, json []byte) error
 	DEPTokens() ([]DEPToken, error)
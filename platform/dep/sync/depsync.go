@@ -14,6 +14,7 @@ import (
 
 	"github.com/liuds832/micromdm/dep"
 	conf "github.com/liuds832/micromdm/platform/config"
+	"github.com/liuds832/micromdm/platform/leader"
 	"github.com/liuds832/micromdm/platform/pubsub"
 )
 
@@ -39,6 +40,7 @@ type Watcher struct {
 
 	publisher pubsub.Publisher
 	db        WatcherDB
+	elector   leader.Elector
 	startSync chan bool
 	syncNow   chan bool
 
@@ -51,7 +53,7 @@ func NewWatcher(db WatcherDB, pub pubsub.PublishSubscriber, opts ...Option) (*Wa
 		db:        db,
 		publisher: pub,
 		startSync: make(chan bool),
-		syncNow:   make(chan bool),
+		syncNow:   make(chan bool, 1),
 	}
 	for _, optFn := range opts {
 		optFn(&w)
@@ -70,28 +72,49 @@ func NewWatcher(db WatcherDB, pub pubsub.PublishSubscriber, opts ...Option) (*Wa
 		return nil, err
 	}
 
+	if w.elector != nil {
+		w.elector.Go("dep-sync", w.watch)
+	} else {
+		go w.watch(context.Background())
+	}
+
+	return &w, nil
+}
+
+// watch runs the DEP sync until ctx is done.
+func (w *Watcher) watch(ctx context.Context) {
 	saveCursor := func() {
-		if err := db.SaveCursor(w.cursor); err != nil {
+		if err := w.db.SaveCursor(w.cursor); err != nil {
 			level.Info(w.logger).Log("err", err, "msg", "saving cursor")
 			return
 		}
 		level.Info(w.logger).Log("msg", "saved DEP config", "cursor", w.cursor.Value)
 	}
 
-	go func() {
-		defer saveCursor()
-		if w.client == nil {
-			// block until we have a DEP client to start sync process
-			level.Info(w.logger).Log("msg", "waiting for DEP token to be added before starting sync")
-			<-w.startSync
-		}
-		err := w.Run()
-		// the DEP sync should never end without an error, but log
-		// unconditionally anyway so we never silently stop watching
-		level.Info(w.logger).Log("err", err, "msg", "DEP watcher stopped")
-	}()
+	// another instance may have led the sync since the cursor was loaded.
+	if cursor, err := w.db.LoadCursor(); err != nil {
+		level.Info(w.logger).Log("err", err, "msg", "loading cursor")
+	} else if cursor.Valid() {
+		w.cursor = *cursor
+	}
 
-	return &w, nil
+	defer saveCursor()
+	w.mtx.RLock()
+	client := w.client
+	w.mtx.RUnlock()
+	if client == nil {
+		// block until we have a DEP client to start sync process
+		level.Info(w.logger).Log("msg", "waiting for DEP token to be added before starting sync")
+		select {
+		case <-w.startSync:
+		case <-ctx.Done():
+			return
+		}
+	}
+	err := w.Run(ctx)
+	// the DEP sync should never end without an error, but log
+	// unconditionally anyway so we never silently stop watching
+	level.Info(w.logger).Log("err", err, "msg", "DEP watcher stopped")
 }
 
 type Client interface {
@@ -114,6 +137,13 @@ func WithLogger(logger log.Logger) Option {
 	}
 }
 
+// WithElector runs the sync only while this instance leads "dep-sync".
+func WithElector(elector leader.Elector) Option {
+	return func(w *Watcher) {
+		w.elector = elector
+	}
+}
+
 func (w *Watcher) updateClient(pubsub pubsub.Subscriber) error {
 	tokenAdded, err := pubsub.Subscribe(context.TODO(), "token-events", conf.DEPTokenTopic)
 	if err != nil {
@@ -151,7 +181,13 @@ func (w *Watcher) SyncNow() {
 		level.Info(w.logger).Log("msg", "waiting for DEP token to be added before starting sync")
 		return
 	}
-	w.syncNow <- true
+	// a requested sync runs after the current one. A request made while
+	// this instance doesn't lead the sync is dropped when it starts
+	// leading, because it syncs right away then.
+	select {
+	case w.syncNow <- true:
+	default:
+	}
 }
 
 // TODO this needs to be a proper error in the micromdm/dep package.
@@ -270,7 +306,7 @@ func (w *Watcher) publishAndProcessDevices(devices []dep.Device) error {
 	return nil
 }
 
-func (w *Watcher) Run() error {
+func (w *Watcher) Run(ctx context.Context) error {
 	var (
 		err       error
 		resp      *dep.DeviceResponse
@@ -282,7 +318,14 @@ func (w *Watcher) Run() error {
 		}
 	)
 
-	ticker := time.NewTicker(syncDuration).C
+	// the first sync below covers any request made before it.
+	select {
+	case <-w.syncNow:
+	default:
+	}
+
+	ticker := time.NewTicker(syncDuration)
+	defer ticker.Stop()
 	for {
 		if fetchNext {
 			resp, err = w.client.FetchDevices(dep.Limit(100), dep.Cursor(w.cursor.Value))
@@ -349,9 +392,11 @@ func (w *Watcher) Run() error {
 		}
 
 		select {
-		case <-ticker:
+		case <-ticker.C:
 		case <-w.syncNow:
 			level.Info(w.logger).Log("msg", "explicit DEP sync requested")
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 }
@@ -0,0 +1,209 @@
+package device
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuds832/micromdm/platform/device/internal/deviceproto"
+)
+
+// AppInventory is the last InstalledApplicationList and
+// ManagedApplicationList reported by a device. Each response replaces the
+// previous list of its kind.
+type AppInventory struct {
+	UDID                       string         `json:"udid"`
+	InstalledApplicationListAt time.Time      `json:"installed_application_list_at"`
+	ManagedApplicationListAt   time.Time      `json:"managed_application_list_at"`
+	Apps                       []InstalledApp `json:"apps"`
+	ManagedApps                []ManagedApp   `json:"managed_apps"`
+}
+
+// InstalledApp is an application installed on a device.
+type InstalledApp struct {
+	BundleID     string `json:"bundle_id" db:"bundle_id"`
+	Version      string `json:"version" db:"version"`
+	ShortVersion string `json:"short_version" db:"short_version"`
+	Name         string `json:"name" db:"name"`
+	BundleSize   int64  `json:"bundle_size" db:"bundle_size"`
+
+	// Managed is set if the app is in the ManagedApplicationList of the
+	// device with the Managed status.
+	Managed       bool   `json:"managed" db:"managed"`
+	ManagedStatus string `json:"managed_status,omitempty" db:"managed_status"`
+}
+
+// ManagedApp is an application in the ManagedApplicationList of a device.
+type ManagedApp struct {
+	BundleID string `json:"bundle_id" db:"bundle_id"`
+	Status   string `json:"status" db:"status"`
+}
+
+// appsResponse holds the parts of a command response which update the
+// app inventory.
+type appsResponse struct {
+	InstalledApplicationList *[]struct {
+		Identifier   string
+		Version      string
+		ShortVersion string
+		Name         string
+		BundleSize   int64
+	}
+	ManagedApplicationList *map[string]struct {
+		Status string
+	}
+}
+
+// Update replaces the app lists of the inventory with the lists in an
+// Acknowledged command response. It reports whether the response was one
+// of the app list commands.
+func (inv *AppInventory) Update(response []byte, at time.Time) (bool, error) {
+	var resp appsResponse
+	if err := plist.Unmarshal(response, &resp); err != nil {
+		return false, errors.Wrap(err, "unmarshal app list response")
+	}
+
+	if resp.InstalledApplicationList != nil {
+		inv.InstalledApplicationListAt = at
+		inv.Apps = make([]InstalledApp, 0, len(*resp.InstalledApplicationList))
+		for _, app := range *resp.InstalledApplicationList {
+			inv.Apps = append(inv.Apps, InstalledApp{
+				BundleID:     app.Identifier,
+				Version:      app.Version,
+				ShortVersion: app.ShortVersion,
+				Name:         app.Name,
+				BundleSize:   app.BundleSize,
+			})
+		}
+	}
+
+	if resp.ManagedApplicationList != nil {
+		inv.ManagedApplicationListAt = at
+		inv.ManagedApps = make([]ManagedApp, 0, len(*resp.ManagedApplicationList))
+		for bundleID, app := range *resp.ManagedApplicationList {
+			inv.ManagedApps = append(inv.ManagedApps, ManagedApp{BundleID: bundleID, Status: app.Status})
+		}
+		sort.Slice(inv.ManagedApps, func(i, j int) bool {
+			return inv.ManagedApps[i].BundleID < inv.ManagedApps[j].BundleID
+		})
+	}
+
+	if resp.InstalledApplicationList == nil && resp.ManagedApplicationList == nil {
+		return false, nil
+	}
+	inv.setManaged()
+	return true, nil
+}
+
+// setManaged sets the managed state of the installed apps from the
+// managed apps.
+func (inv *AppInventory) setManaged() {
+	status := make(map[string]string, len(inv.ManagedApps))
+	for _, app := range inv.ManagedApps {
+		status[app.BundleID] = app.Status
+	}
+	for i, app := range inv.Apps {
+		inv.Apps[i].ManagedStatus = status[app.BundleID]
+		inv.Apps[i].Managed = status[app.BundleID] == "Managed"
+	}
+}
+
+func MarshalAppInventory(inv *AppInventory) ([]byte, error) {
+	pb := deviceproto.AppInventory{
+		Udid:                       inv.UDID,
+		InstalledApplicationListAt: timeToNano(inv.InstalledApplicationListAt),
+		ManagedApplicationListAt:   timeToNano(inv.ManagedApplicationListAt),
+	}
+	for _, app := range inv.Apps {
+		pb.Apps = append(pb.Apps, &deviceproto.InstalledApp{
+			BundleId:      app.BundleID,
+			Version:       app.Version,
+			ShortVersion:  app.ShortVersion,
+			Name:          app.Name,
+			BundleSize:    app.BundleSize,
+			Managed:       app.Managed,
+			ManagedStatus: app.ManagedStatus,
+		})
+	}
+	for _, app := range inv.ManagedApps {
+		pb.ManagedApps = append(pb.ManagedApps, &deviceproto.ManagedApp{
+			BundleId: app.BundleID,
+			Status:   app.Status,
+		})
+	}
+	return proto.Marshal(&pb)
+}
+
+func UnmarshalAppInventory(data []byte, inv *AppInventory) error {
+	var pb deviceproto.AppInventory
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return errors.Wrap(err, "unmarshal proto to app inventory")
+	}
+	inv.UDID = pb.GetUdid()
+	inv.InstalledApplicationListAt = timeFromNano(pb.GetInstalledApplicationListAt())
+	inv.ManagedApplicationListAt = timeFromNano(pb.GetManagedApplicationListAt())
+	inv.Apps = nil
+	for _, app := range pb.GetApps() {
+		inv.Apps = append(inv.Apps, InstalledApp{
+			BundleID:      app.GetBundleId(),
+			Version:       app.GetVersion(),
+			ShortVersion:  app.GetShortVersion(),
+			Name:          app.GetName(),
+			BundleSize:    app.GetBundleSize(),
+			Managed:       app.GetManaged(),
+			ManagedStatus: app.GetManagedStatus(),
+		})
+	}
+	inv.ManagedApps = nil
+	for _, app := range pb.GetManagedApps() {
+		inv.ManagedApps = append(inv.ManagedApps, ManagedApp{
+			BundleID: app.GetBundleId(),
+			Status:   app.GetStatus(),
+		})
+	}
+	return nil
+}
+
+// version returns the version of the app to compare, which is the
+// CFBundleShortVersionString if the app has one.
+func (app InstalledApp) version() string {
+	if app.ShortVersion != "" {
+		return app.ShortVersion
+	}
+	return app.Version
+}
+
+// compareVersions compares two version strings part by part, numerically
+// if both parts are numbers. Missing parts count as zero, so 1.2 and 1.2.0
+// are equal.
+func compareVersions(a, b string) int {
+	split := func(r rune) bool { return r == '.' || r == '-' || r == '_' || r == ' ' }
+	as, bs := strings.FieldsFunc(a, split), strings.FieldsFunc(b, split)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := "0", "0"
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xerr := strconv.ParseUint(x, 10, 64)
+		yn, yerr := strconv.ParseUint(y, 10, 64)
+		switch {
+		case xerr != nil || yerr != nil:
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		case xn < yn:
+			return -1
+		case xn > yn:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+package device
+
+import (
+	"testing"
+	"time"
+)
+
+const installedApplicationListResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>0001</string>
+	<key>InstalledApplicationList</key>
+	<array>
+		<dict>
+			<key>BundleSize</key>
+			<integer>1024</integer>
+			<key>Identifier</key>
+			<string>com.example.app</string>
+			<key>Name</key>
+			<string>Example</string>
+			<key>ShortVersion</key>
+			<string>1.2.10</string>
+			<key>Version</key>
+			<string>1210</string>
+		</dict>
+		<dict>
+			<key>Identifier</key>
+			<string>com.example.other</string>
+			<key>Name</key>
+			<string>Other</string>
+			<key>Version</key>
+			<string>3.0</string>
+		</dict>
+	</array>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>UDID-1</string>
+</dict>
+</plist>`
+
+const managedApplicationListResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>0002</string>
+	<key>ManagedApplicationList</key>
+	<dict>
+		<key>com.example.app</key>
+		<dict>
+			<key>Status</key>
+			<string>Managed</string>
+		</dict>
+		<key>com.example.queued</key>
+		<dict>
+			<key>Status</key>
+			<string>Queued</string>
+		</dict>
+	</dict>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>UDID-1</string>
+</dict>
+</plist>`
+
+func TestAppInventoryUpdate(t *testing.T) {
+	inv := &AppInventory{
+		UDID: "UDID-1",
+		Apps: []InstalledApp{{BundleID: "com.example.removed", Version: "1.0"}},
+	}
+	at := time.Now().UTC()
+
+	for _, response := range []string{managedApplicationListResponse, installedApplicationListResponse} {
+		updated, err := inv.Update([]byte(response), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !updated {
+			t.Errorf("response not recognized as an app list: %s", response)
+		}
+	}
+
+	// the installed apps replace the previous list.
+	if len(inv.Apps) != 2 {
+		t.Fatalf("have apps %+v, want 2", inv.Apps)
+	}
+	app := inv.Apps[0]
+	if app.BundleID != "com.example.app" || app.ShortVersion != "1.2.10" || app.BundleSize != 1024 {
+		t.Errorf("have app %+v", app)
+	}
+	if !app.Managed || app.ManagedStatus != "Managed" {
+		t.Errorf("have app %+v, want it managed", app)
+	}
+	if inv.Apps[1].Managed || inv.Apps[1].ManagedStatus != "" {
+		t.Errorf("have app %+v, want it unmanaged", inv.Apps[1])
+	}
+	if len(inv.ManagedApps) != 2 || inv.ManagedApps[1].Status != "Queued" {
+		t.Errorf("have managed apps %+v", inv.ManagedApps)
+	}
+
+	data, err := MarshalAppInventory(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have AppInventory
+	if err := UnmarshalAppInventory(data, &have); err != nil {
+		t.Fatal(err)
+	}
+	if !have.InstalledApplicationListAt.Equal(at) || len(have.Apps) != 2 || have.Apps[0] != app {
+		t.Errorf("have %+v after round trip, want %+v", have, inv)
+	}
+
+	updated, err := inv.Update([]byte(deviceInformationResponse), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated {
+		t.Error("DeviceInformation response recognized as an app list")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.10", "1.2.9", 1},
+		{"1.2", "1.2.0", 0},
+		{"10.0", "9.9.9", 1},
+		{"1.0", "1.0.1", -1},
+		{"2.0b1", "2.0b2", -1},
+		{"", "1.0", -1},
+	} {
+		if have := compareVersions(tt.a, tt.b); have != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, have, tt.want)
+		}
+	}
+}
+
+func TestListInstalledAppsOption_MatchesVersion(t *testing.T) {
+	opt := ListInstalledAppsOption{MinVersion: "1.2", BelowVersion: "1.3"}
+	for _, tt := range []struct {
+		app  InstalledApp
+		want bool
+	}{
+		{InstalledApp{ShortVersion: "1.2.10", Version: "9999"}, true},
+		{InstalledApp{Version: "1.2"}, true},
+		{InstalledApp{Version: "1.1.9"}, false},
+		{InstalledApp{Version: "1.3"}, false},
+	} {
+		if have := opt.matchesVersion(tt.app); have != tt.want {
+			t.Errorf("matchesVersion(%+v) = %v, want %v", tt.app, have, tt.want)
+		}
+	}
+}
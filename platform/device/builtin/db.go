@@ -20,6 +20,12 @@ const (
 	// The udidCertAuthBucket stores a simple mapping from UDID to
 	// sha256 hash of the device identity certificate for future validation
 	udidCertAuthBucket = "mdm.UDIDCertAuth"
+
+	// The inventoryBucket stores the device inventory by UDID.
+	inventoryBucket = "mdm.DeviceInventory"
+
+	// The appsBucket stores the app lists of a device by UDID.
+	appsBucket = "mdm.DeviceApps"
 )
 
 type DB struct {
@@ -37,7 +43,18 @@ func NewDB(db *bolt.DB) (*DB, error) {
 			return err
 		}
 		_, err = tx.CreateBucketIfNotExists([]byte(udidCertAuthBucket))
-		return err
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(inventoryBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(appsBucket))
+		if err != nil {
+			return err
+		}
+		return createListIndexes(tx)
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "creating %s bucket", DeviceBucket)
@@ -55,37 +72,12 @@ func (db *DB) GetBootstrapToken(ctx context.Context, udid string) ([]byte, error
 	return d.BootstrapToken, nil
 }
 
-func (db *DB) List(ctx context.Context, opt device.ListDevicesOption) ([]device.Device, error) {
-	var devices []device.Device
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(DeviceBucket))
-		// TODO optimize by implemting Seek() and bytes.HasPrefix() so we don't
-		// hit all keys in the database if we dont have to.
-		return b.ForEach(func(k, v []byte) error {
-			var dev device.Device
-			if err := device.UnmarshalDevice(v, &dev); err != nil {
-				return err
-			}
-			if len(opt.FilterSerial) == 0 {
-				devices = append(devices, dev)
-				return nil
-			}
-			for _, fs := range opt.FilterSerial {
-				if fs == dev.SerialNumber {
-					devices = append(devices, dev)
-				}
-			}
-			return nil
-		})
-	})
-	return devices, err
-}
-
 func (db *DB) Save(ctx context.Context, dev *device.Device) error {
 	tx, err := db.DB.Begin(true)
 	if err != nil {
 		return errors.Wrap(err, "begin transaction")
 	}
+	defer tx.Rollback()
 	bkt := tx.Bucket([]byte(DeviceBucket))
 	if bkt == nil {
 		return fmt.Errorf("bucket %q not found!", DeviceBucket)
@@ -95,6 +87,20 @@ func (db *DB) Save(ctx context.Context, dev *device.Device) error {
 		return errors.Wrap(err, "marshalling device")
 	}
 
+	// replace the list indexes of the previous version of the device.
+	if v := bkt.Get([]byte(dev.UUID)); v != nil {
+		var prev device.Device
+		if err := device.UnmarshalDevice(v, &prev); err != nil {
+			return errors.Wrap(err, "unmarshal previous device")
+		}
+		if err := deleteListIndexes(tx, &prev); err != nil {
+			return err
+		}
+	}
+	if err := putListIndexes(tx, dev); err != nil {
+		return err
+	}
+
 	// store an array of indices to reference the UUID, which will be the
 	// key used to store the actual device.
 	indexes := []string{dev.UDID, dev.SerialNumber}
@@ -137,11 +143,15 @@ func (db *DB) deleteByIndex(key string) error {
 	if err != nil {
 		return errors.Wrap(err, "begin transaction")
 	}
+	defer tx.Rollback()
 
 	bkt := tx.Bucket([]byte(DeviceBucket))
 	if err := bkt.Delete([]byte(device.UUID)); err != nil {
 		return errors.Wrapf(err, "delete device for key %s", key)
 	}
+	if err := deleteListIndexes(tx, device); err != nil {
+		return err
+	}
 
 	idxBucket := tx.Bucket([]byte(deviceIndexBucket))
 	if err := idxBucket.Delete([]byte(device.UDID)); err != nil {
@@ -151,6 +161,13 @@ func (db *DB) deleteByIndex(key string) error {
 		return errors.Wrapf(err, "delete device index for serial %s", device.SerialNumber)
 	}
 
+	if err := tx.Bucket([]byte(inventoryBucket)).Delete([]byte(device.UDID)); err != nil {
+		return errors.Wrapf(err, "delete inventory for UDID %s", device.UDID)
+	}
+	if err := tx.Bucket([]byte(appsBucket)).Delete([]byte(device.UDID)); err != nil {
+		return errors.Wrapf(err, "delete app inventory for UDID %s", device.UDID)
+	}
+
 	return tx.Commit()
 }
 
@@ -226,3 +243,122 @@ func (db *DB) GetUDIDCertHash(udid []byte) ([]byte, error) {
 	})
 	return certHash, err
 }
+
+func (db *DB) Inventory(ctx context.Context, udid string) (*device.Inventory, error) {
+	var inv device.Inventory
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(inventoryBucket)).Get([]byte(udid))
+		if v == nil {
+			return &notFound{"Inventory", fmt.Sprintf("udid %s", udid)}
+		}
+		return device.UnmarshalInventory(v, &inv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (db *DB) SaveInventory(ctx context.Context, inv *device.Inventory) error {
+	pb, err := device.MarshalInventory(inv)
+	if err != nil {
+		return errors.Wrap(err, "marshalling inventory")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(inventoryBucket)).Put([]byte(inv.UDID), pb)
+	})
+	return errors.Wrap(err, "put inventory to boltdb")
+}
+
+func (db *DB) AppInventory(ctx context.Context, udid string) (*device.AppInventory, error) {
+	var inv device.AppInventory
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(appsBucket)).Get([]byte(udid))
+		if v == nil {
+			return &notFound{"AppInventory", fmt.Sprintf("udid %s", udid)}
+		}
+		return device.UnmarshalAppInventory(v, &inv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (db *DB) SaveAppInventory(ctx context.Context, inv *device.AppInventory) error {
+	pb, err := device.MarshalAppInventory(inv)
+	if err != nil {
+		return errors.Wrap(err, "marshalling app inventory")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(appsBucket)).Put([]byte(inv.UDID), pb)
+	})
+	return errors.Wrap(err, "put app inventory to boltdb")
+}
+
+// ListInstalledApps returns the installed apps matching the bundle
+// identifier and UDID filters of opt.
+func (db *DB) ListInstalledApps(ctx context.Context, opt device.ListInstalledAppsOption) ([]device.DeviceApp, error) {
+	bundleIDs := make(map[string]bool, len(opt.FilterBundleID))
+	for _, id := range opt.FilterBundleID {
+		bundleIDs[id] = true
+	}
+
+	var apps []device.DeviceApp
+	err := db.View(func(tx *bolt.Tx) error {
+		var inventories []device.AppInventory
+		b := tx.Bucket([]byte(appsBucket))
+		if len(opt.FilterUDID) > 0 {
+			for _, udid := range opt.FilterUDID {
+				v := b.Get([]byte(udid))
+				if v == nil {
+					continue
+				}
+				var inv device.AppInventory
+				if err := device.UnmarshalAppInventory(v, &inv); err != nil {
+					return err
+				}
+				inventories = append(inventories, inv)
+			}
+		} else {
+			err := b.ForEach(func(k, v []byte) error {
+				var inv device.AppInventory
+				if err := device.UnmarshalAppInventory(v, &inv); err != nil {
+					return err
+				}
+				inventories = append(inventories, inv)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		devices := tx.Bucket([]byte(DeviceBucket))
+		idx := tx.Bucket([]byte(deviceIndexBucket))
+		for _, inv := range inventories {
+			var serial string
+			if uuid := idx.Get([]byte(inv.UDID)); uuid != nil {
+				var dev device.Device
+				if v := devices.Get(uuid); v != nil {
+					if err := device.UnmarshalDevice(v, &dev); err != nil {
+						return err
+					}
+				}
+				serial = dev.SerialNumber
+			}
+			for _, app := range inv.Apps {
+				if len(bundleIDs) > 0 && !bundleIDs[app.BundleID] {
+					continue
+				}
+				apps = append(apps, device.DeviceApp{
+					UDID:         inv.UDID,
+					SerialNumber: serial,
+					InstalledApp: app,
+				})
+			}
+		}
+		return nil
+	})
+	return apps, errors.Wrap(err, "list installed apps")
+}
@@ -5,7 +5,9 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
 
@@ -139,6 +141,108 @@ func TestDeleteBySerial(t *testing.T) {
 	}
 }
 
+func TestSaveInventory(t *testing.T) {
+	db := setupDB(t)
+	dev := &device.Device{
+		UUID:         "a-b-c-d",
+		UDID:         "UDID-FOO-BAR-BAZ",
+		SerialNumber: "foobarbaz",
+	}
+	ctx := context.Background()
+
+	if err := db.Save(ctx, dev); err != nil {
+		t.Fatalf("saving device in datastore: %s", err)
+	}
+	if _, err := db.Inventory(ctx, dev.UDID); !isNotFound(err) {
+		t.Fatalf("have err %v, want not found", err)
+	}
+
+	inv := &device.Inventory{
+		UDID:                dev.UDID,
+		DeviceInformationAt: time.Now().UTC(),
+		OSVersion:           "12.1",
+		BatteryLevel:        0.5,
+		FDEEnabled:          true,
+		Certificates:        []device.InventoryCertificate{{CommonName: "MDM Identity", IsIdentity: true}},
+	}
+	if err := db.SaveInventory(ctx, inv); err != nil {
+		t.Fatalf("saving inventory in datastore: %s", err)
+	}
+	have, err := db.Inventory(ctx, dev.UDID)
+	if err != nil {
+		t.Fatalf("getting inventory: %s", err)
+	}
+	if !reflect.DeepEqual(have, inv) {
+		t.Errorf("have %+v, want %+v", have, inv)
+	}
+
+	if err := db.DeleteByUDID(ctx, dev.UDID); err != nil {
+		t.Fatalf("deleting device in datastore: %s", err)
+	}
+	if _, err := db.Inventory(ctx, dev.UDID); !isNotFound(err) {
+		t.Errorf("have err %v, want inventory to be deleted with the device", err)
+	}
+}
+
+func TestListInstalledApps(t *testing.T) {
+	db := setupDB(t)
+	ctx := context.Background()
+
+	dev := &device.Device{UUID: "a-b-c-d", UDID: "UDID-1", SerialNumber: "C02ABC"}
+	if err := db.Save(ctx, dev); err != nil {
+		t.Fatalf("saving device in datastore: %s", err)
+	}
+	for _, inv := range []*device.AppInventory{
+		{UDID: "UDID-1", Apps: []device.InstalledApp{
+			{BundleID: "com.example.app", Version: "1.0"},
+			{BundleID: "com.example.other", Version: "2.0"},
+		}},
+		{UDID: "UDID-2", Apps: []device.InstalledApp{{BundleID: "com.example.app", Version: "1.1"}}},
+	} {
+		if err := db.SaveAppInventory(ctx, inv); err != nil {
+			t.Fatalf("saving app inventory: %s", err)
+		}
+	}
+
+	apps, err := db.ListInstalledApps(ctx, device.ListInstalledAppsOption{FilterBundleID: []string{"com.example.app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 {
+		t.Fatalf("have %d apps, want 2", len(apps))
+	}
+	for _, app := range apps {
+		if app.UDID == "UDID-1" && app.SerialNumber != dev.SerialNumber {
+			t.Errorf("have serial %q, want %q", app.SerialNumber, dev.SerialNumber)
+		}
+	}
+
+	apps, err = db.ListInstalledApps(ctx, device.ListInstalledAppsOption{FilterUDID: []string{"UDID-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 {
+		t.Errorf("have %d apps for UDID-1, want 2", len(apps))
+	}
+
+	// a new list replaces the previous one.
+	if err := db.SaveAppInventory(ctx, &device.AppInventory{UDID: "UDID-1"}); err != nil {
+		t.Fatalf("saving app inventory: %s", err)
+	}
+	apps, err = db.ListInstalledApps(ctx, device.ListInstalledAppsOption{FilterUDID: []string{"UDID-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 0 {
+		t.Errorf("have %d apps for UDID-1 after an empty list, want 0", len(apps))
+	}
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*notFound)
+	return ok && e.NotFound()
+}
+
 func setupDB(t *testing.T) *DB {
 	f, _ := ioutil.TempFile("", "bolt-")
 	f.Close()
@@ -0,0 +1,289 @@
+package builtin
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+// The deviceListIndexBucket holds a bucket for each of the listIndexes.
+// The keys of an index are the indexed value, a zero byte and the device
+// UUID, so that a cursor walks the devices sorted by the value.
+const deviceListIndexBucket = "mdm.DeviceListIdx"
+
+// listIndexes are the device fields which devices can be filtered and
+// sorted by, with the function that encodes a field for its index.
+var listIndexes = map[string]func(*device.Device) []byte{
+	"udid":               func(d *device.Device) []byte { return []byte(d.UDID) },
+	"serial_number":      func(d *device.Device) []byte { return []byte(d.SerialNumber) },
+	"os_version":         func(d *device.Device) []byte { return []byte(d.OSVersion) },
+	"model":              func(d *device.Device) []byte { return []byte(d.Model) },
+	"model_name":         func(d *device.Device) []byte { return []byte(d.ModelName) },
+	"product_name":       func(d *device.Device) []byte { return []byte(d.ProductName) },
+	"enrolled":           func(d *device.Device) []byte { return []byte(strconv.FormatBool(d.Enrolled)) },
+	"dep_profile_status": func(d *device.Device) []byte { return []byte(d.DEPProfileStatus) },
+	"last_seen":          func(d *device.Device) []byte { return encodeTime(d.LastSeen.UnixNano(), d.LastSeen.IsZero()) },
+}
+
+// encodeTime encodes nanoseconds so that the bytes sort like the times.
+func encodeTime(nano int64, zero bool) []byte {
+	b := make([]byte, 8)
+	if !zero {
+		binary.BigEndian.PutUint64(b, uint64(nano)^(1<<63))
+	}
+	return b
+}
+
+func indexKey(value []byte, uuid string) []byte {
+	key := make([]byte, 0, len(value)+1+len(uuid))
+	key = append(key, value...)
+	key = append(key, 0)
+	return append(key, uuid...)
+}
+
+func uuidFromIndexKey(key []byte) string {
+	return string(key[bytes.LastIndexByte(key, 0)+1:])
+}
+
+// createListIndexes creates the list indexes and indexes the saved devices
+// if they do not exist yet.
+func createListIndexes(tx *bolt.Tx) error {
+	if tx.Bucket([]byte(deviceListIndexBucket)) != nil {
+		return nil
+	}
+	idx, err := tx.CreateBucket([]byte(deviceListIndexBucket))
+	if err != nil {
+		return err
+	}
+	for name := range listIndexes {
+		if _, err := idx.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+
+	var devices []device.Device
+	err = tx.Bucket([]byte(DeviceBucket)).ForEach(func(k, v []byte) error {
+		var dev device.Device
+		if err := device.UnmarshalDevice(v, &dev); err != nil {
+			return errors.Wrapf(err, "unmarshal device %s", k)
+		}
+		devices = append(devices, dev)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := range devices {
+		if err := putListIndexes(tx, &devices[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putListIndexes(tx *bolt.Tx, dev *device.Device) error {
+	idx := tx.Bucket([]byte(deviceListIndexBucket))
+	for name, value := range listIndexes {
+		if err := idx.Bucket([]byte(name)).Put(indexKey(value(dev), dev.UUID), nil); err != nil {
+			return errors.Wrapf(err, "put device %s index", name)
+		}
+	}
+	return nil
+}
+
+func deleteListIndexes(tx *bolt.Tx, dev *device.Device) error {
+	idx := tx.Bucket([]byte(deviceListIndexBucket))
+	for name, value := range listIndexes {
+		if err := idx.Bucket([]byte(name)).Delete(indexKey(value(dev), dev.UUID)); err != nil {
+			return errors.Wrapf(err, "delete device %s index", name)
+		}
+	}
+	return nil
+}
+
+// List returns the page of devices matching the filters of opt. Only the
+// devices on the page are read, the filters and sorting use the list
+// indexes.
+func (db *DB) List(ctx context.Context, opt device.ListDevicesOption) ([]device.Device, error) {
+	var devices []device.Device
+	err := db.View(func(tx *bolt.Tx) error {
+		uuids, err := listUUIDs(tx, opt)
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket([]byte(DeviceBucket))
+		for _, uuid := range uuids {
+			v := b.Get([]byte(uuid))
+			if v == nil {
+				continue
+			}
+			var dev device.Device
+			if err := device.UnmarshalDevice(v, &dev); err != nil {
+				return errors.Wrapf(err, "unmarshal device %s", uuid)
+			}
+			devices = append(devices, dev)
+		}
+		return nil
+	})
+	return devices, errors.Wrap(err, "list devices")
+}
+
+// Count returns the number of devices matching the filters of opt.
+func (db *DB) Count(ctx context.Context, opt device.ListDevicesOption) (int, error) {
+	var count int
+	err := db.View(func(tx *bolt.Tx) error {
+		matched, filtered := matchingUUIDs(tx, opt)
+		if filtered {
+			count = len(matched)
+		} else {
+			count = tx.Bucket([]byte(DeviceBucket)).Stats().KeyN
+		}
+		return nil
+	})
+	return count, errors.Wrap(err, "count devices")
+}
+
+// listUUIDs returns the UUIDs of the page of devices matching opt, in the
+// requested order.
+func listUUIDs(tx *bolt.Tx, opt device.ListDevicesOption) ([]string, error) {
+	matched, filtered := matchingUUIDs(tx, opt)
+
+	var (
+		uuids []string
+		skip  = opt.Offset()
+	)
+	// add appends a UUID to the page and reports whether the page is full.
+	add := func(uuid string) bool {
+		if filtered && !matched[uuid] {
+			return false
+		}
+		if skip > 0 {
+			skip--
+			return false
+		}
+		uuids = append(uuids, uuid)
+		return opt.PerPage > 0 && len(uuids) == opt.PerPage
+	}
+
+	switch {
+	case opt.SortBy != "":
+		idx := tx.Bucket([]byte(deviceListIndexBucket)).Bucket([]byte(opt.SortBy))
+		if idx == nil {
+			return nil, errors.Errorf("devices can not be sorted by %s", opt.SortBy)
+		}
+		walk(idx.Cursor(), opt.SortDesc, func(k []byte) bool { return add(uuidFromIndexKey(k)) })
+	case filtered:
+		sorted := make([]string, 0, len(matched))
+		for uuid := range matched {
+			sorted = append(sorted, uuid)
+		}
+		if opt.SortDesc {
+			sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+		} else {
+			sort.Strings(sorted)
+		}
+		for _, uuid := range sorted {
+			if add(uuid) {
+				break
+			}
+		}
+	default:
+		walk(tx.Bucket([]byte(DeviceBucket)).Cursor(), opt.SortDesc, func(k []byte) bool { return add(string(k)) })
+	}
+	return uuids, nil
+}
+
+// walk calls fn with the keys of c in order, until fn returns true.
+func walk(c *bolt.Cursor, desc bool, fn func(k []byte) bool) {
+	first, next := c.First, c.Next
+	if desc {
+		first, next = c.Last, c.Prev
+	}
+	for k, _ := first(); k != nil; k, _ = next() {
+		if fn(k) {
+			return
+		}
+	}
+}
+
+// matchingUUIDs returns the UUIDs of the devices matching the filters of
+// opt. It reports false if opt has no filters.
+func matchingUUIDs(tx *bolt.Tx, opt device.ListDevicesOption) (map[string]bool, bool) {
+	idx := tx.Bucket([]byte(deviceListIndexBucket))
+
+	var (
+		matched  map[string]bool
+		filtered bool
+	)
+	intersect := func(uuids map[string]bool) {
+		if !filtered {
+			matched, filtered = uuids, true
+			return
+		}
+		for uuid := range matched {
+			if !uuids[uuid] {
+				delete(matched, uuid)
+			}
+		}
+	}
+
+	var dep []string
+	for _, status := range opt.FilterDEPProfileStatus {
+		dep = append(dep, string(status))
+	}
+	var enrolled []string
+	if opt.FilterEnrolled != nil {
+		enrolled = []string{strconv.FormatBool(*opt.FilterEnrolled)}
+	}
+	for _, filter := range []struct {
+		index  string
+		values []string
+	}{
+		{"udid", opt.FilterUDID},
+		{"serial_number", opt.FilterSerial},
+		{"os_version", opt.FilterOSVersion},
+		{"model", opt.FilterModel},
+		{"model_name", opt.FilterModelName},
+		{"product_name", opt.FilterProductName},
+		{"dep_profile_status", dep},
+		{"enrolled", enrolled},
+	} {
+		if len(filter.values) == 0 {
+			continue
+		}
+		uuids := make(map[string]bool)
+		c := idx.Bucket([]byte(filter.index)).Cursor()
+		for _, value := range filter.values {
+			prefix := append([]byte(value), 0)
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				uuids[uuidFromIndexKey(k)] = true
+			}
+		}
+		intersect(uuids)
+	}
+
+	if !opt.LastSeenAfter.IsZero() || !opt.LastSeenBefore.IsZero() {
+		uuids := make(map[string]bool)
+		c := idx.Bucket([]byte("last_seen")).Cursor()
+		start := []byte{}
+		if !opt.LastSeenAfter.IsZero() {
+			start = encodeTime(opt.LastSeenAfter.UnixNano(), false)
+		}
+		for k, _ := c.Seek(start); k != nil; k, _ = c.Next() {
+			if !opt.LastSeenBefore.IsZero() && bytes.Compare(k[:8], encodeTime(opt.LastSeenBefore.UnixNano(), false)) >= 0 {
+				break
+			}
+			uuids[uuidFromIndexKey(k)] = true
+		}
+		intersect(uuids)
+	}
+
+	return matched, filtered
+}
@@ -0,0 +1,122 @@
+package builtin
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+func TestList(t *testing.T) {
+	db := setupDB(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	for _, dev := range []*device.Device{
+		{UUID: "1", UDID: "UDID-1", SerialNumber: "C", OSVersion: "12.1", ProductName: "MacBookPro15,1", Enrolled: true, LastSeen: now.Add(-time.Hour)},
+		{UUID: "2", UDID: "UDID-2", SerialNumber: "A", OSVersion: "11.6", ProductName: "MacBookPro15,1", Enrolled: true, LastSeen: now.Add(-48 * time.Hour)},
+		{UUID: "3", UDID: "UDID-3", SerialNumber: "B", OSVersion: "12.1", ProductName: "iPhone10,3", DEPProfileStatus: device.ASSIGNED},
+		{UUID: "4", UDID: "UDID-4", SerialNumber: "D", OSVersion: "15.0", ProductName: "iPhone10,3", Enrolled: true, LastSeen: now},
+	} {
+		if err := db.Save(ctx, dev); err != nil {
+			t.Fatalf("saving device in datastore: %s", err)
+		}
+	}
+	// a changed device must not be found by its previous values.
+	dev, err := db.DeviceByUDID(ctx, "UDID-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.OSVersion = "15.1"
+	if err := db.Save(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+
+	enrolled, unenrolled := true, false
+	for _, tt := range []struct {
+		name  string
+		opt   device.ListDevicesOption
+		want  []string
+		total int
+	}{
+		{"all", device.ListDevicesOption{}, []string{"1", "2", "3", "4"}, 4},
+		{"page", device.ListDevicesOption{Page: 2, PerPage: 3}, []string{"4"}, 4},
+		{"desc", device.ListDevicesOption{SortDesc: true, PerPage: 2}, []string{"4", "3"}, 4},
+		{"serial", device.ListDevicesOption{FilterSerial: []string{"A", "D"}}, []string{"2", "4"}, 2},
+		{"udid", device.ListDevicesOption{FilterUDID: []string{"UDID-3"}}, []string{"3"}, 1},
+		{"os version", device.ListDevicesOption{FilterOSVersion: []string{"12.1"}}, []string{"1", "3"}, 2},
+		{"updated os version", device.ListDevicesOption{FilterOSVersion: []string{"15.0"}}, nil, 0},
+		{"product and enrolled", device.ListDevicesOption{FilterProductName: []string{"iPhone10,3"}, FilterEnrolled: &enrolled}, []string{"4"}, 1},
+		{"unenrolled", device.ListDevicesOption{FilterEnrolled: &unenrolled}, []string{"3"}, 1},
+		{"dep status", device.ListDevicesOption{FilterDEPProfileStatus: []device.DEPProfileStatus{device.ASSIGNED}}, []string{"3"}, 1},
+		{"seen after", device.ListDevicesOption{LastSeenAfter: now.Add(-2 * time.Hour)}, []string{"1", "4"}, 2},
+		{"seen between", device.ListDevicesOption{LastSeenAfter: now.Add(-72 * time.Hour), LastSeenBefore: now.Add(-time.Minute)}, []string{"1", "2"}, 2},
+		{"sort by serial", device.ListDevicesOption{SortBy: "serial_number"}, []string{"2", "3", "1", "4"}, 4},
+		{"sort by last seen desc", device.ListDevicesOption{SortBy: "last_seen", SortDesc: true}, []string{"4", "1", "2", "3"}, 4},
+		{"filtered page sorted", device.ListDevicesOption{FilterEnrolled: &enrolled, SortBy: "os_version", Page: 2, PerPage: 2}, []string{"4"}, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			devices, err := db.List(ctx, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var have []string
+			for _, d := range devices {
+				have = append(have, d.UUID)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("have devices %v, want %v", have, tt.want)
+			}
+			total, err := db.Count(ctx, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != tt.total {
+				t.Errorf("have total %d, want %d", total, tt.total)
+			}
+		})
+	}
+
+	if err := db.DeleteBySerial(ctx, "A"); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := db.List(ctx, device.ListDevicesOption{FilterOSVersion: []string{"11.6"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("have deleted devices %v", devices)
+	}
+}
+
+func TestListIndexesCreatedForSavedDevices(t *testing.T) {
+	db := setupDB(t)
+	ctx := context.Background()
+	if err := db.Save(ctx, &device.Device{UUID: "1", UDID: "UDID-1", OSVersion: "12.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the indexes, as in a database from before they existed.
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(deviceListIndexBucket))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewDB(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := reopened.List(ctx, device.ListDevicesOption{FilterOSVersion: []string{"12.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].UDID != "UDID-1" {
+		t.Errorf("have devices %v, want UDID-1", devices)
+	}
+}
@@ -38,9 +38,34 @@ func NewHTTPClient(instance, token string, logger log.Logger, opts ...httptransp
 		).Endpoint()
 	}
 
+	var getInventoryEndpoint endpoint.Endpoint
+	{
+		getInventoryEndpoint = httptransport.NewClient(
+			"GET",
+			httputil.CopyURL(u, "/v1/devices"),
+			httputil.EncodeRequestWithToken(token, encodeGetInventoryRequest),
+			decodeGetInventoryResponse,
+			opts...,
+		).Endpoint()
+	}
+
+	var listInstalledAppsEndpoint endpoint.Endpoint
+	{
+		listInstalledAppsEndpoint = httptransport.NewClient(
+			"POST",
+			httputil.CopyURL(u, "/v1/devices/apps"),
+			httputil.EncodeRequestWithToken(token, httptransport.EncodeJSONRequest),
+			decodeListInstalledAppsResponse,
+			opts...,
+		).Endpoint()
+	}
+
 	return Endpoints{
 		ListDevicesEndpoint:   listDevicesEndpoint,
 		RemoveDevicesEndpoint: removeDevicesEndpoint,
+		GetInventoryEndpoint:  getInventoryEndpoint,
+
+		ListInstalledAppsEndpoint: listInstalledAppsEndpoint,
 	}, nil
 
 }
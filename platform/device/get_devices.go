@@ -2,6 +2,7 @@ package device
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -11,39 +12,163 @@ import (
 )
 
 type ListDevicesOption struct {
+	// Page is the page of PerPage devices to list, starting at 1. All
+	// devices are listed if PerPage is zero.
 	Page    int `json:"page"`
 	PerPage int `json:"per_page"`
 
-	FilterSerial []string `json:"filter_serial"`
-	FilterUDID   []string `json:"filter_udid"`
+	FilterSerial           []string           `json:"filter_serial"`
+	FilterUDID             []string           `json:"filter_udid"`
+	FilterOSVersion        []string           `json:"filter_os_version,omitempty"`
+	FilterModel            []string           `json:"filter_model,omitempty"`
+	FilterModelName        []string           `json:"filter_model_name,omitempty"`
+	FilterProductName      []string           `json:"filter_product_name,omitempty"`
+	FilterDEPProfileStatus []DEPProfileStatus `json:"filter_dep_profile_status,omitempty"`
+	FilterEnrolled         *bool              `json:"filter_enrolled,omitempty"`
+
+	// LastSeenAfter and LastSeenBefore restrict the devices to the ones
+	// last seen at or after LastSeenAfter and before LastSeenBefore. Zero
+	// times are ignored.
+	LastSeenAfter  time.Time `json:"last_seen_after"`
+	LastSeenBefore time.Time `json:"last_seen_before"`
+
+	// SortBy is one of the SortFields. Devices are listed by UUID if it
+	// is empty.
+	SortBy   string `json:"sort_by,omitempty"`
+	SortDesc bool   `json:"sort_desc,omitempty"`
+}
+
+// SortFields are the fields devices can be sorted by.
+var SortFields = []string{
+	"udid",
+	"serial_number",
+	"os_version",
+	"model",
+	"model_name",
+	"product_name",
+	"enrolled",
+	"dep_profile_status",
+	"last_seen",
+}
+
+// validate checks the paging and sorting of opt.
+func (opt ListDevicesOption) validate() error {
+	if opt.Page < 0 || opt.PerPage < 0 {
+		return &invalidListOptionErr{"page and per_page must not be negative"}
+	}
+	if opt.SortBy == "" {
+		return nil
+	}
+	for _, field := range SortFields {
+		if field == opt.SortBy {
+			return nil
+		}
+	}
+	return &invalidListOptionErr{fmt.Sprintf("cannot sort devices by %q", opt.SortBy)}
+}
+
+// Offset returns the number of devices before the page of opt.
+func (opt ListDevicesOption) Offset() int {
+	if opt.PerPage == 0 || opt.Page <= 1 {
+		return 0
+	}
+	return (opt.Page - 1) * opt.PerPage
 }
 
+type invalidListOptionErr struct{ reason string }
+
+func (e *invalidListOptionErr) Error() string   { return "invalid list devices option: " + e.reason }
+func (e *invalidListOptionErr) StatusCode() int { return http.StatusBadRequest }
+
 type DeviceDTO struct {
-	SerialNumber     string           `json:"serial_number"`
-	UDID             string           `json:"udid"`
-	EnrollmentStatus bool             `json:"enrollment_status"`
-	LastSeen         time.Time        `json:"last_seen"`
-	DEPProfileStatus DEPProfileStatus `json:"dep_profile_status"`
+	SerialNumber          string           `json:"serial_number"`
+	UDID                  string           `json:"udid"`
+	EnrollmentStatus      bool             `json:"enrollment_status"`
+	LastSeen              time.Time        `json:"last_seen"`
+	DEPProfileStatus      DEPProfileStatus `json:"dep_profile_status"`
+	LastPush              *PushStatus      `json:"last_push,omitempty"`
+	OSVersion             string           `json:"os_version,omitempty"`
+	BuildVersion          string           `json:"build_version,omitempty"`
+	ProductName           string           `json:"product_name,omitempty"`
+	Model                 string           `json:"model,omitempty"`
+	ModelName             string           `json:"model_name,omitempty"`
+	DeviceName            string           `json:"device_name,omitempty"`
+	IMEI                  string           `json:"imei,omitempty"`
+	MEID                  string           `json:"meid,omitempty"`
+	Description           string           `json:"description,omitempty"`
+	Color                 string           `json:"color,omitempty"`
+	AssetTag              string           `json:"asset_tag,omitempty"`
+	AwaitingConfiguration bool             `json:"awaiting_configuration"`
+	DEPProfileUUID        string           `json:"dep_profile_uuid,omitempty"`
+	DEPProfileAssignedBy  string           `json:"dep_profile_assigned_by,omitempty"`
 }
 
-func (svc *DeviceService) ListDevices(ctx context.Context, opt ListDevicesOption) ([]DeviceDTO, error) {
+// PushStatus is the result of the last push notification to a device.
+type PushStatus struct {
+	PushedAt time.Time `json:"pushed_at"`
+	ID       string    `json:"push_notification_id,omitempty"`
+	// Status is the HTTP status of the APNs response, or zero if APNs
+	// could not be reached.
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// TokenInvalid is set once APNs reported the push token as
+	// unregistered or bad. The device is not pushed to until it sends a
+	// new token.
+	TokenInvalid bool `json:"token_invalid"`
+}
+
+// ListDevices returns the page of devices matching opt, and the total
+// number of matching devices.
+func (svc *DeviceService) ListDevices(ctx context.Context, opt ListDevicesOption) ([]DeviceDTO, int, error) {
+	if err := opt.validate(); err != nil {
+		return nil, 0, err
+	}
+	total, err := svc.store.Count(ctx, opt)
+	if err != nil {
+		return nil, 0, err
+	}
 	devices, err := svc.store.List(ctx, opt)
 	var dto []DeviceDTO
 	for _, d := range devices {
 		dto = append(dto, DeviceDTO{
-			SerialNumber:     d.SerialNumber,
-			UDID:             d.UDID,
-			EnrollmentStatus: d.Enrolled,
-			LastSeen:         d.LastSeen,
-			DEPProfileStatus: d.DEPProfileStatus,
+			SerialNumber:          d.SerialNumber,
+			UDID:                  d.UDID,
+			EnrollmentStatus:      d.Enrolled,
+			LastSeen:              d.LastSeen,
+			DEPProfileStatus:      d.DEPProfileStatus,
+			LastPush:              svc.lastPush(ctx, d.UDID),
+			OSVersion:             d.OSVersion,
+			BuildVersion:          d.BuildVersion,
+			ProductName:           d.ProductName,
+			Model:                 d.Model,
+			ModelName:             d.ModelName,
+			DeviceName:            d.DeviceName,
+			IMEI:                  d.IMEI,
+			MEID:                  d.MEID,
+			Description:           d.Description,
+			Color:                 d.Color,
+			AssetTag:              d.AssetTag,
+			AwaitingConfiguration: d.AwaitingConfiguration,
+			DEPProfileUUID:        d.DEPProfileUUID,
+			DEPProfileAssignedBy:  d.DEPProfileAssignedBy,
 		})
 	}
-	return dto, err
+	return dto, total, err
+}
+
+func (svc *DeviceService) lastPush(ctx context.Context, udid string) *PushStatus {
+	if svc.push == nil {
+		return nil
+	}
+	// devices which never had a push token have no status.
+	status, _ := svc.push.PushStatus(ctx, udid)
+	return status
 }
 
 type getDevicesRequest struct{ Opts ListDevicesOption }
 type getDevicesResponse struct {
 	Devices []DeviceDTO `json:"devices"`
+	Total   int         `json:"total"`
 	Err     error       `json:"err,omitempty"`
 }
 
@@ -67,19 +192,21 @@ func decodeListDevicesResponse(_ context.Context, r *http.Response) (interface{}
 func MakeListDevicesEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(getDevicesRequest)
-		dto, err := svc.ListDevices(ctx, req.Opts)
+		dto, total, err := svc.ListDevices(ctx, req.Opts)
 		return getDevicesResponse{
 			Devices: dto,
+			Total:   total,
 			Err:     err,
 		}, nil
 	}
 }
 
-func (e Endpoints) ListDevices(ctx context.Context, opts ListDevicesOption) ([]DeviceDTO, error) {
+func (e Endpoints) ListDevices(ctx context.Context, opts ListDevicesOption) ([]DeviceDTO, int, error) {
 	request := getDevicesRequest{opts}
 	response, err := e.ListDevicesEndpoint(ctx, request.Opts)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return response.(getDevicesResponse).Devices, response.(getDevicesResponse).Err
+	resp := response.(getDevicesResponse)
+	return resp.Devices, resp.Total, resp.Err
 }
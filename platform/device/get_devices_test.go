@@ -0,0 +1,53 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+type listStore struct {
+	Store
+	devices []Device
+}
+
+func (s listStore) List(context.Context, ListDevicesOption) ([]Device, error) { return s.devices, nil }
+func (s listStore) Count(context.Context, ListDevicesOption) (int, error)     { return 10, nil }
+
+func TestListDevices(t *testing.T) {
+	svc := New(listStore{devices: []Device{{UDID: "UDID-1", OSVersion: "12.1"}}})
+
+	devices, total, err := svc.ListDevices(context.Background(), ListDevicesOption{SortBy: "last_seen", PerPage: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 10 || len(devices) != 1 || devices[0].OSVersion != "12.1" {
+		t.Errorf("have %d of %d devices: %+v", len(devices), total, devices)
+	}
+
+	for _, opt := range []ListDevicesOption{{SortBy: "push_magic"}, {Page: -1}} {
+		_, _, err := svc.ListDevices(context.Background(), opt)
+		sc, ok := err.(httptransport.StatusCoder)
+		if !ok || sc.StatusCode() != http.StatusBadRequest {
+			t.Errorf("%+v: have err %v, want a bad request", opt, err)
+		}
+	}
+}
+
+func TestListDevicesOption_Offset(t *testing.T) {
+	for _, tt := range []struct {
+		opt  ListDevicesOption
+		want int
+	}{
+		{ListDevicesOption{}, 0},
+		{ListDevicesOption{Page: 3}, 0},
+		{ListDevicesOption{Page: 1, PerPage: 50}, 0},
+		{ListDevicesOption{Page: 3, PerPage: 50}, 100},
+	} {
+		if have := tt.opt.Offset(); have != tt.want {
+			t.Errorf("%+v: have offset %d, want %d", tt.opt, have, tt.want)
+		}
+	}
+}
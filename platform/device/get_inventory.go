@@ -0,0 +1,65 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/pkg/httputil"
+)
+
+func (svc *DeviceService) GetInventory(ctx context.Context, udid string) (*Inventory, error) {
+	inv, err := svc.store.Inventory(ctx, udid)
+	return inv, errors.Wrapf(err, "get inventory for udid %s", udid)
+}
+
+type getInventoryRequest struct {
+	UDID string
+}
+
+type getInventoryResponse struct {
+	Inventory *Inventory `json:"inventory,omitempty"`
+	Err       error      `json:"err,omitempty"`
+}
+
+func (r getInventoryResponse) Failed() error { return r.Err }
+
+func decodeGetInventoryRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	udid, ok := mux.Vars(r)["udid"]
+	if !ok {
+		return nil, errors.New("bad route")
+	}
+	return getInventoryRequest{UDID: udid}, nil
+}
+
+func encodeGetInventoryRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(getInventoryRequest)
+	r.Method, r.URL.Path = "GET", "/v1/devices/"+url.PathEscape(req.UDID)+"/inventory"
+	return nil
+}
+
+func decodeGetInventoryResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp getInventoryResponse
+	err := httputil.DecodeJSONResponse(r, &resp)
+	return resp, err
+}
+
+func MakeGetInventoryEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(getInventoryRequest)
+		inv, err := svc.GetInventory(ctx, req.UDID)
+		return getInventoryResponse{Inventory: inv, Err: err}, nil
+	}
+}
+
+func (e Endpoints) GetInventory(ctx context.Context, udid string) (*Inventory, error) {
+	response, err := e.GetInventoryEndpoint(ctx, getInventoryRequest{UDID: udid})
+	if err != nil {
+		return nil, err
+	}
+	return response.(getInventoryResponse).Inventory, response.(getInventoryResponse).Err
+}
@@ -0,0 +1,235 @@
+package device
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuds832/micromdm/platform/device/internal/deviceproto"
+)
+
+// Inventory is the hardware and security state of a device, as reported
+// in its responses to the DeviceInformation, SecurityInfo and
+// CertificateList commands.
+type Inventory struct {
+	UDID string `json:"udid" db:"udid"`
+
+	// The times the device last answered each of the commands. The fields
+	// reported by a command are only meaningful if it answered.
+	DeviceInformationAt time.Time `json:"device_information_at" db:"device_information_at"`
+	SecurityInfoAt      time.Time `json:"security_info_at" db:"security_info_at"`
+	CertificateListAt   time.Time `json:"certificate_list_at" db:"certificate_list_at"`
+
+	// DeviceInformation
+	OSVersion               string  `json:"os_version" db:"os_version"`
+	BuildVersion            string  `json:"build_version" db:"build_version"`
+	ProductName             string  `json:"product_name" db:"product_name"`
+	ModelName               string  `json:"model_name" db:"model_name"`
+	Model                   string  `json:"model" db:"model"`
+	DeviceName              string  `json:"device_name" db:"device_name"`
+	SerialNumber            string  `json:"serial_number" db:"serial_number"`
+	BatteryLevel            float64 `json:"battery_level" db:"battery_level"`
+	DeviceCapacity          float64 `json:"device_capacity" db:"device_capacity"`
+	AvailableDeviceCapacity float64 `json:"available_device_capacity" db:"available_device_capacity"`
+	IsSupervised            bool    `json:"is_supervised" db:"is_supervised"`
+	WiFiMAC                 string  `json:"wifi_mac" db:"wifi_mac"`
+	BluetoothMAC            string  `json:"bluetooth_mac" db:"bluetooth_mac"`
+
+	// SecurityInfo
+	FDEEnabled      bool `json:"fde_enabled" db:"fde_enabled"`
+	SIPEnabled      bool `json:"sip_enabled" db:"sip_enabled"`
+	FirewallEnabled bool `json:"firewall_enabled" db:"firewall_enabled"`
+	PasscodePresent bool `json:"passcode_present" db:"passcode_present"`
+
+	// CertificateList
+	Certificates []InventoryCertificate `json:"certificates" db:"-"`
+}
+
+// InventoryCertificate is a certificate installed on a device.
+type InventoryCertificate struct {
+	CommonName string    `json:"common_name"`
+	IsIdentity bool      `json:"is_identity"`
+	NotBefore  time.Time `json:"not_before"`
+	NotAfter   time.Time `json:"not_after"`
+}
+
+// inventoryResponse holds the parts of a command response which update the
+// inventory. The fields are pointers, because a DeviceInformation response
+// only holds the queries which were asked for.
+type inventoryResponse struct {
+	QueryResponses *struct {
+		OSVersion               *string
+		BuildVersion            *string
+		ProductName             *string
+		ModelName               *string
+		Model                   *string
+		DeviceName              *string
+		SerialNumber            *string
+		BatteryLevel            *float64
+		DeviceCapacity          *float64
+		AvailableDeviceCapacity *float64
+		IsSupervised            *bool
+		WiFiMAC                 *string
+		BluetoothMAC            *string
+	}
+	SecurityInfo *struct {
+		FDEEnabled                       *bool `plist:"FDE_Enabled"`
+		SystemIntegrityProtectionEnabled *bool
+		PasscodePresent                  *bool
+		FirewallSettings                 *struct {
+			FirewallEnabled *bool
+		}
+	}
+	CertificateList *[]struct {
+		CommonName string
+		IsIdentity bool
+		Data       []byte
+	}
+}
+
+// Update merges an Acknowledged command response into the inventory. It
+// reports whether the response was one of the inventory commands.
+func (inv *Inventory) Update(response []byte, at time.Time) (bool, error) {
+	var resp inventoryResponse
+	if err := plist.Unmarshal(response, &resp); err != nil {
+		return false, errors.Wrap(err, "unmarshal inventory response")
+	}
+
+	if q := resp.QueryResponses; q != nil {
+		inv.DeviceInformationAt = at
+		setString(&inv.OSVersion, q.OSVersion)
+		setString(&inv.BuildVersion, q.BuildVersion)
+		setString(&inv.ProductName, q.ProductName)
+		setString(&inv.ModelName, q.ModelName)
+		setString(&inv.Model, q.Model)
+		setString(&inv.DeviceName, q.DeviceName)
+		setString(&inv.SerialNumber, q.SerialNumber)
+		setFloat(&inv.BatteryLevel, q.BatteryLevel)
+		setFloat(&inv.DeviceCapacity, q.DeviceCapacity)
+		setFloat(&inv.AvailableDeviceCapacity, q.AvailableDeviceCapacity)
+		setBool(&inv.IsSupervised, q.IsSupervised)
+		setString(&inv.WiFiMAC, q.WiFiMAC)
+		setString(&inv.BluetoothMAC, q.BluetoothMAC)
+	}
+
+	if s := resp.SecurityInfo; s != nil {
+		inv.SecurityInfoAt = at
+		setBool(&inv.FDEEnabled, s.FDEEnabled)
+		setBool(&inv.SIPEnabled, s.SystemIntegrityProtectionEnabled)
+		setBool(&inv.PasscodePresent, s.PasscodePresent)
+		if s.FirewallSettings != nil {
+			setBool(&inv.FirewallEnabled, s.FirewallSettings.FirewallEnabled)
+		}
+	}
+
+	if resp.CertificateList != nil {
+		inv.CertificateListAt = at
+		inv.Certificates = make([]InventoryCertificate, 0, len(*resp.CertificateList))
+		for _, c := range *resp.CertificateList {
+			cert := InventoryCertificate{CommonName: c.CommonName, IsIdentity: c.IsIdentity}
+			// the validity is informational, keep certificates which fail to parse.
+			if parsed, err := x509.ParseCertificate(c.Data); err == nil {
+				cert.NotBefore = parsed.NotBefore.UTC()
+				cert.NotAfter = parsed.NotAfter.UTC()
+			}
+			inv.Certificates = append(inv.Certificates, cert)
+		}
+	}
+
+	updated := resp.QueryResponses != nil || resp.SecurityInfo != nil || resp.CertificateList != nil
+	return updated, nil
+}
+
+func setString(dst *string, src *string) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setFloat(dst *float64, src *float64) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setBool(dst *bool, src *bool) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func MarshalInventory(inv *Inventory) ([]byte, error) {
+	pb := deviceproto.Inventory{
+		Udid:                    inv.UDID,
+		DeviceInformationAt:     timeToNano(inv.DeviceInformationAt),
+		SecurityInfoAt:          timeToNano(inv.SecurityInfoAt),
+		CertificateListAt:       timeToNano(inv.CertificateListAt),
+		OsVersion:               inv.OSVersion,
+		BuildVersion:            inv.BuildVersion,
+		ProductName:             inv.ProductName,
+		ModelName:               inv.ModelName,
+		Model:                   inv.Model,
+		DeviceName:              inv.DeviceName,
+		SerialNumber:            inv.SerialNumber,
+		BatteryLevel:            inv.BatteryLevel,
+		DeviceCapacity:          inv.DeviceCapacity,
+		AvailableDeviceCapacity: inv.AvailableDeviceCapacity,
+		IsSupervised:            inv.IsSupervised,
+		WifiMac:                 inv.WiFiMAC,
+		BluetoothMac:            inv.BluetoothMAC,
+		FdeEnabled:              inv.FDEEnabled,
+		SipEnabled:              inv.SIPEnabled,
+		FirewallEnabled:         inv.FirewallEnabled,
+		PasscodePresent:         inv.PasscodePresent,
+	}
+	for _, c := range inv.Certificates {
+		pb.Certificates = append(pb.Certificates, &deviceproto.InventoryCertificate{
+			CommonName: c.CommonName,
+			IsIdentity: c.IsIdentity,
+			NotBefore:  timeToNano(c.NotBefore),
+			NotAfter:   timeToNano(c.NotAfter),
+		})
+	}
+	return proto.Marshal(&pb)
+}
+
+func UnmarshalInventory(data []byte, inv *Inventory) error {
+	var pb deviceproto.Inventory
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return errors.Wrap(err, "unmarshal proto to inventory")
+	}
+	inv.UDID = pb.GetUdid()
+	inv.DeviceInformationAt = timeFromNano(pb.GetDeviceInformationAt())
+	inv.SecurityInfoAt = timeFromNano(pb.GetSecurityInfoAt())
+	inv.CertificateListAt = timeFromNano(pb.GetCertificateListAt())
+	inv.OSVersion = pb.GetOsVersion()
+	inv.BuildVersion = pb.GetBuildVersion()
+	inv.ProductName = pb.GetProductName()
+	inv.ModelName = pb.GetModelName()
+	inv.Model = pb.GetModel()
+	inv.DeviceName = pb.GetDeviceName()
+	inv.SerialNumber = pb.GetSerialNumber()
+	inv.BatteryLevel = pb.GetBatteryLevel()
+	inv.DeviceCapacity = pb.GetDeviceCapacity()
+	inv.AvailableDeviceCapacity = pb.GetAvailableDeviceCapacity()
+	inv.IsSupervised = pb.GetIsSupervised()
+	inv.WiFiMAC = pb.GetWifiMac()
+	inv.BluetoothMAC = pb.GetBluetoothMac()
+	inv.FDEEnabled = pb.GetFdeEnabled()
+	inv.SIPEnabled = pb.GetSipEnabled()
+	inv.FirewallEnabled = pb.GetFirewallEnabled()
+	inv.PasscodePresent = pb.GetPasscodePresent()
+	inv.Certificates = nil
+	for _, c := range pb.GetCertificates() {
+		inv.Certificates = append(inv.Certificates, InventoryCertificate{
+			CommonName: c.GetCommonName(),
+			IsIdentity: c.GetIsIdentity(),
+			NotBefore:  timeFromNano(c.GetNotBefore()),
+			NotAfter:   timeFromNano(c.GetNotAfter()),
+		})
+	}
+	return nil
+}
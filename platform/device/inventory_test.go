@@ -0,0 +1,151 @@
+package device
+
+import (
+	"testing"
+	"time"
+)
+
+const deviceInformationResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>0001</string>
+	<key>QueryResponses</key>
+	<dict>
+		<key>AvailableDeviceCapacity</key>
+		<real>120.5</real>
+		<key>BatteryLevel</key>
+		<real>0.75</real>
+		<key>DeviceCapacity</key>
+		<real>250</real>
+		<key>IsSupervised</key>
+		<true/>
+		<key>OSVersion</key>
+		<string>12.1</string>
+		<key>WiFiMAC</key>
+		<string>00:11:22:33:44:55</string>
+	</dict>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>UDID-1</string>
+</dict>
+</plist>`
+
+const securityInfoResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>0002</string>
+	<key>SecurityInfo</key>
+	<dict>
+		<key>FDE_Enabled</key>
+		<true/>
+		<key>FirewallSettings</key>
+		<dict>
+			<key>FirewallEnabled</key>
+			<true/>
+		</dict>
+		<key>SystemIntegrityProtectionEnabled</key>
+		<true/>
+	</dict>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>UDID-1</string>
+</dict>
+</plist>`
+
+const certificateListResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CertificateList</key>
+	<array>
+		<dict>
+			<key>CommonName</key>
+			<string>MDM Identity</string>
+			<key>Data</key>
+			<data>bm90IGEgY2VydGlmaWNhdGU=</data>
+			<key>IsIdentity</key>
+			<true/>
+		</dict>
+	</array>
+	<key>CommandUUID</key>
+	<string>0003</string>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>UDID-1</string>
+</dict>
+</plist>`
+
+func TestInventoryUpdate(t *testing.T) {
+	inv := &Inventory{UDID: "UDID-1", OSVersion: "12.0", SerialNumber: "C02ABC"}
+	at := time.Now().UTC()
+
+	for _, response := range []string{deviceInformationResponse, securityInfoResponse, certificateListResponse} {
+		updated, err := inv.Update([]byte(response), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !updated {
+			t.Errorf("response not recognized as inventory: %s", response)
+		}
+	}
+
+	if inv.OSVersion != "12.1" || inv.BatteryLevel != 0.75 || inv.AvailableDeviceCapacity != 120.5 || !inv.IsSupervised {
+		t.Errorf("DeviceInformation not applied: %+v", inv)
+	}
+	// fields which were not queried keep their values.
+	if have, want := inv.SerialNumber, "C02ABC"; have != want {
+		t.Errorf("have serial %s, want %s", have, want)
+	}
+	if !inv.FDEEnabled || !inv.SIPEnabled || !inv.FirewallEnabled || inv.PasscodePresent {
+		t.Errorf("SecurityInfo not applied: %+v", inv)
+	}
+	if len(inv.Certificates) != 1 || inv.Certificates[0].CommonName != "MDM Identity" || !inv.Certificates[0].IsIdentity {
+		t.Errorf("have certificates %+v", inv.Certificates)
+	}
+	for _, reported := range []time.Time{inv.DeviceInformationAt, inv.SecurityInfoAt, inv.CertificateListAt} {
+		if !reported.Equal(at) {
+			t.Errorf("have report time %s, want %s", reported, at)
+		}
+	}
+
+	data, err := MarshalInventory(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var have Inventory
+	if err := UnmarshalInventory(data, &have); err != nil {
+		t.Fatal(err)
+	}
+	if have.WiFiMAC != inv.WiFiMAC || have.FDEEnabled != inv.FDEEnabled || len(have.Certificates) != 1 {
+		t.Errorf("have %+v after round trip, want %+v", have, inv)
+	}
+}
+
+func TestInventoryUpdate_OtherResponse(t *testing.T) {
+	response := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>0004</string>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>UDID-1</string>
+</dict>
+</plist>`
+	var inv Inventory
+	updated, err := inv.Update([]byte(response), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated || !inv.DeviceInformationAt.IsZero() {
+		t.Errorf("have inventory updated from a response without inventory: %+v", inv)
+	}
+}
@@ -0,0 +1,95 @@
+package device
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/liuds832/micromdm/pkg/httputil"
+)
+
+// ListInstalledAppsOption filters the apps installed across the devices.
+type ListInstalledAppsOption struct {
+	FilterBundleID []string `json:"filter_bundle_id"`
+	FilterUDID     []string `json:"filter_udid"`
+
+	// MinVersion and BelowVersion restrict the app versions to the range
+	// [MinVersion, BelowVersion). Either may be empty.
+	MinVersion   string `json:"min_version,omitempty"`
+	BelowVersion string `json:"below_version,omitempty"`
+}
+
+// DeviceApp is an app installed on a device.
+type DeviceApp struct {
+	UDID         string `json:"udid" db:"udid"`
+	SerialNumber string `json:"serial_number" db:"serial_number"`
+	InstalledApp
+}
+
+func (svc *DeviceService) ListInstalledApps(ctx context.Context, opt ListInstalledAppsOption) ([]DeviceApp, error) {
+	// the stores filter by bundle identifier and device, the version range
+	// is compared here.
+	apps, err := svc.store.ListInstalledApps(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []DeviceApp
+	for _, app := range apps {
+		if opt.matchesVersion(app.InstalledApp) {
+			filtered = append(filtered, app)
+		}
+	}
+	return filtered, nil
+}
+
+// matchesVersion reports whether the version of app is in the range of
+// opt.
+func (opt ListInstalledAppsOption) matchesVersion(app InstalledApp) bool {
+	if opt.MinVersion != "" && compareVersions(app.version(), opt.MinVersion) < 0 {
+		return false
+	}
+	if opt.BelowVersion != "" && compareVersions(app.version(), opt.BelowVersion) >= 0 {
+		return false
+	}
+	return true
+}
+
+type listInstalledAppsRequest struct{ Opts ListInstalledAppsOption }
+type listInstalledAppsResponse struct {
+	Apps []DeviceApp `json:"apps"`
+	Err  error       `json:"err,omitempty"`
+}
+
+func (r listInstalledAppsResponse) Failed() error { return r.Err }
+
+func decodeListInstalledAppsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var opts ListInstalledAppsOption
+	err := httputil.DecodeJSONRequest(r, &opts)
+	return listInstalledAppsRequest{Opts: opts}, err
+}
+
+func decodeListInstalledAppsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp listInstalledAppsResponse
+	err := httputil.DecodeJSONResponse(r, &resp)
+	return resp, err
+}
+
+func MakeListInstalledAppsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(listInstalledAppsRequest)
+		apps, err := svc.ListInstalledApps(ctx, req.Opts)
+		return listInstalledAppsResponse{
+			Apps: apps,
+			Err:  err,
+		}, nil
+	}
+}
+
+func (e Endpoints) ListInstalledApps(ctx context.Context, opts ListInstalledAppsOption) ([]DeviceApp, error) {
+	response, err := e.ListInstalledAppsEndpoint(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return response.(listInstalledAppsResponse).Apps, response.(listInstalledAppsResponse).Err
+}
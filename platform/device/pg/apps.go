@@ -0,0 +1,207 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	sq "gopkg.in/Masterminds/squirrel.v1"
+
+	"github.com/liuds832/micromdm/platform/device"
+)
+
+const (
+	appInventoryTableName  = "device_app_inventory"
+	installedAppsTableName = "device_installed_apps"
+	managedAppsTableName   = "device_managed_apps"
+
+	// appsPerInsert keeps the inserts of large app lists below the limit of
+	// parameters in a statement.
+	appsPerInsert = 1000
+)
+
+func installedAppColumns() []string {
+	return []string{
+		"udid",
+		"bundle_id",
+		"version",
+		"short_version",
+		"name",
+		"bundle_size",
+		"managed",
+		"managed_status",
+	}
+}
+
+// SaveAppInventory replaces the app lists of a device.
+func (d *Postgres) SaveAppInventory(ctx context.Context, inv *device.AppInventory) error {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Insert(appInventoryTableName).
+		Columns("udid", "installed_application_list_at", "managed_application_list_at").
+		Values(inv.UDID, inv.InstalledApplicationListAt, inv.ManagedApplicationListAt).
+		Suffix(`ON CONFLICT (udid) DO UPDATE SET
+			installed_application_list_at = EXCLUDED.installed_application_list_at,
+			managed_application_list_at = EXCLUDED.managed_application_list_at`).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building app inventory save query")
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrap(err, "exec app inventory save in pg")
+	}
+
+	if err := deleteApps(ctx, tx, sq.Eq{"udid": inv.UDID}); err != nil {
+		return err
+	}
+	for start := 0; start < len(inv.Apps); start += appsPerInsert {
+		insert := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Insert(installedAppsTableName).
+			Columns(installedAppColumns()...)
+		for _, app := range inv.Apps[start:min(start+appsPerInsert, len(inv.Apps))] {
+			insert = insert.Values(
+				inv.UDID,
+				app.BundleID,
+				app.Version,
+				app.ShortVersion,
+				app.Name,
+				app.BundleSize,
+				app.Managed,
+				app.ManagedStatus,
+			)
+		}
+		if err := execInsert(ctx, tx, insert); err != nil {
+			return errors.Wrap(err, "insert installed apps")
+		}
+	}
+	for start := 0; start < len(inv.ManagedApps); start += appsPerInsert {
+		insert := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Insert(managedAppsTableName).
+			Columns("udid", "bundle_id", "status")
+		for _, app := range inv.ManagedApps[start:min(start+appsPerInsert, len(inv.ManagedApps))] {
+			insert = insert.Values(inv.UDID, app.BundleID, app.Status)
+		}
+		if err := execInsert(ctx, tx, insert); err != nil {
+			return errors.Wrap(err, "insert managed apps")
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "commit app inventory")
+}
+
+func execInsert(ctx context.Context, tx *sqlx.Tx, insert sq.InsertBuilder) error {
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// deleteApps deletes the app lists of the devices matched by where.
+func deleteApps(ctx context.Context, tx *sqlx.Tx, where sq.Sqlizer) error {
+	for _, table := range []string{installedAppsTableName, managedAppsTableName} {
+		query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Delete(table).
+			Where(where).
+			ToSql()
+		if err != nil {
+			return errors.Wrap(err, "building sql")
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return errors.Wrapf(err, "delete from %s", table)
+		}
+	}
+	return nil
+}
+
+func (d *Postgres) AppInventory(ctx context.Context, udid string) (*device.AppInventory, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("udid", "installed_application_list_at", "managed_application_list_at").
+		From(appInventoryTableName).
+		Where(sq.Eq{"udid": udid}).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+	var inv device.AppInventory
+	err = d.db.QueryRowxContext(ctx, query, args...).Scan(&inv.UDID, &inv.InstalledApplicationListAt, &inv.ManagedApplicationListAt)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, appInventoryNotFoundErr{}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "finding app inventory by udid")
+	}
+
+	query, args, err = sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select(installedAppColumns()[1:]...).
+		From(installedAppsTableName).
+		Where(sq.Eq{"udid": udid}).
+		OrderBy("bundle_id").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+	if err := d.db.SelectContext(ctx, &inv.Apps, query, args...); err != nil {
+		return nil, errors.Wrap(err, "list installed apps by udid")
+	}
+
+	query, args, err = sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("bundle_id", "status").
+		From(managedAppsTableName).
+		Where(sq.Eq{"udid": udid}).
+		OrderBy("bundle_id").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+	err = d.db.SelectContext(ctx, &inv.ManagedApps, query, args...)
+	return &inv, errors.Wrap(err, "list managed apps by udid")
+}
+
+// ListInstalledApps returns the installed apps matching the bundle
+// identifier and UDID filters of opt.
+func (d *Postgres) ListInstalledApps(ctx context.Context, opt device.ListInstalledAppsOption) ([]device.DeviceApp, error) {
+	var columns []string
+	for _, col := range installedAppColumns() {
+		columns = append(columns, "a."+col)
+	}
+	columns = append(columns, "COALESCE(d.serial_number, '') AS serial_number")
+
+	sel := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select(columns...).
+		From(installedAppsTableName+" a").
+		LeftJoin(tableName+" d ON d.udid = a.udid").
+		OrderBy("a.udid", "a.bundle_id")
+	if len(opt.FilterBundleID) > 0 {
+		sel = sel.Where(sq.Eq{"a.bundle_id": opt.FilterBundleID})
+	}
+	if len(opt.FilterUDID) > 0 {
+		sel = sel.Where(sq.Eq{"a.udid": opt.FilterUDID})
+	}
+	query, args, err := sel.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+	var apps []device.DeviceApp
+	err = d.db.SelectContext(ctx, &apps, query, args...)
+	return apps, errors.Wrap(err, "list installed apps")
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type appInventoryNotFoundErr struct{}
+
+func (e appInventoryNotFoundErr) Error() string  { return "app inventory not found" }
+func (e appInventoryNotFoundErr) NotFound() bool { return true }
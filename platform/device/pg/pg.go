@@ -3,6 +3,7 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"strings"
 
 	"github.com/jmoiron/sqlx"
@@ -46,6 +47,7 @@ func columns() []string {
 		"dep_profile_assigned_date",
 		"dep_profile_assigned_by",
 		"last_seen",
+		"bootstrap_token",
 	}
 }
 
@@ -81,6 +83,7 @@ func (d *Postgres) Save(ctx context.Context, device *device.Device) error {
 		Set("dep_profile_assigned_date", device.DEPProfileAssignedDate).
 		Set("dep_profile_assigned_by", device.DEPProfileAssignedBy).
 		Set("last_seen", device.LastSeen).
+		Set("bootstrap_token", device.BootstrapToken).
 		ToSql()
 	if err != nil {
 		return errors.Wrap(err, "building update query for device save")
@@ -117,6 +120,7 @@ func (d *Postgres) Save(ctx context.Context, device *device.Device) error {
 			device.DEPProfileAssignedDate,
 			device.DEPProfileAssignedBy,
 			device.LastSeen,
+			device.BootstrapToken,
 		).
 		Suffix(updateQuery).
 		ToSql()
@@ -164,11 +168,64 @@ func (d *Postgres) DeviceBySerial(ctx context.Context, serial string) (*device.D
 	return &dev, errors.Wrap(err, "finding device by serial")
 }
 
-func (d *Postgres) ListDevices(ctx context.Context, opt device.ListDevicesOption) ([]device.Device, error) {
-	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+// filterDevices adds the filters of opt to a query of the devices table.
+func filterDevices(query sq.SelectBuilder, opt device.ListDevicesOption) sq.SelectBuilder {
+	for _, filter := range []struct {
+		column string
+		values []string
+	}{
+		{"udid", opt.FilterUDID},
+		{"serial_number", opt.FilterSerial},
+		{"os_version", opt.FilterOSVersion},
+		{"model", opt.FilterModel},
+		{"model_name", opt.FilterModelName},
+		{"product_name", opt.FilterProductName},
+	} {
+		if len(filter.values) > 0 {
+			query = query.Where(sq.Eq{filter.column: filter.values})
+		}
+	}
+	if len(opt.FilterDEPProfileStatus) > 0 {
+		var statuses []string
+		for _, status := range opt.FilterDEPProfileStatus {
+			statuses = append(statuses, string(status))
+		}
+		query = query.Where(sq.Eq{"dep_profile_status": statuses})
+	}
+	if opt.FilterEnrolled != nil {
+		query = query.Where(sq.Eq{"enrolled": *opt.FilterEnrolled})
+	}
+	if !opt.LastSeenAfter.IsZero() {
+		query = query.Where(sq.GtOrEq{"last_seen": opt.LastSeenAfter})
+	}
+	if !opt.LastSeenBefore.IsZero() {
+		query = query.Where(sq.Lt{"last_seen": opt.LastSeenBefore})
+	}
+	return query
+}
+
+func (d *Postgres) List(ctx context.Context, opt device.ListDevicesOption) ([]device.Device, error) {
+	order := "ASC"
+	if opt.SortDesc {
+		order = "DESC"
+	}
+	orderBy := []string{"uuid " + order}
+	if opt.SortBy != "" {
+		column, ok := sortColumns[opt.SortBy]
+		if !ok {
+			return nil, errors.Errorf("devices can not be sorted by %s", opt.SortBy)
+		}
+		orderBy = append([]string{column + " " + order}, orderBy...)
+	}
+
+	sel := filterDevices(sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
 		Select(columns()...).
-		From(tableName).
-		ToSql()
+		From(tableName), opt).
+		OrderBy(orderBy...)
+	if opt.PerPage > 0 {
+		sel = sel.Limit(uint64(opt.PerPage)).Offset(uint64(opt.Offset()))
+	}
+	query, args, err := sel.ToSql()
 	if err != nil {
 		return nil, errors.Wrap(err, "building sql")
 	}
@@ -177,7 +234,36 @@ func (d *Postgres) ListDevices(ctx context.Context, opt device.ListDevicesOption
 	return list, errors.Wrap(err, "list devices")
 }
 
+// sortColumns are the columns of the device.SortFields.
+var sortColumns = map[string]string{
+	"udid":               "udid",
+	"serial_number":      "serial_number",
+	"os_version":         "os_version",
+	"model":              "model",
+	"model_name":         "model_name",
+	"product_name":       "product_name",
+	"enrolled":           "enrolled",
+	"dep_profile_status": "dep_profile_status",
+	"last_seen":          "last_seen",
+}
+
+func (d *Postgres) Count(ctx context.Context, opt device.ListDevicesOption) (int, error) {
+	query, args, err := filterDevices(sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("COUNT(*)").
+		From(tableName), opt).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, "building sql")
+	}
+	var count int
+	err = d.db.QueryRowxContext(ctx, query, args...).Scan(&count)
+	return count, errors.Wrap(err, "count devices")
+}
+
 func (d *Postgres) DeleteByUDID(ctx context.Context, udid string) error {
+	if err := d.deleteInventory(ctx, sq.Eq{"udid": udid}); err != nil {
+		return err
+	}
 	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
 		Delete(tableName).
 		Where(sq.Eq{"udid": udid}).
@@ -190,6 +276,14 @@ func (d *Postgres) DeleteByUDID(ctx context.Context, udid string) error {
 }
 
 func (d *Postgres) DeleteBySerial(ctx context.Context, serial string) error {
+	devices := sq.Select("udid").From(tableName).Where(sq.Eq{"serial_number": serial})
+	inSQL, inArgs, err := devices.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	if err := d.deleteInventory(ctx, sq.Expr("udid IN ("+inSQL+")", inArgs...)); err != nil {
+		return err
+	}
 	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
 		Delete(tableName).
 		Where(sq.Eq{"serial_number": serial}).
@@ -201,6 +295,185 @@ func (d *Postgres) DeleteBySerial(ctx context.Context, serial string) error {
 	return errors.Wrap(err, "delete device by serial_number")
 }
 
+// deleteInventory deletes the inventory and app lists of the devices
+// matched by where.
+func (d *Postgres) deleteInventory(ctx context.Context, where sq.Sqlizer) error {
+	tables := []string{inventoryTableName, appInventoryTableName, installedAppsTableName, managedAppsTableName}
+	for _, table := range tables {
+		query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Delete(table).
+			Where(where).
+			ToSql()
+		if err != nil {
+			return errors.Wrap(err, "building sql")
+		}
+		if _, err := d.db.ExecContext(ctx, query, args...); err != nil {
+			return errors.Wrapf(err, "delete device inventory from %s", table)
+		}
+	}
+	return nil
+}
+
+// GetBootstrapToken returns the Bootstrap Token for the device by udid
+func (d *Postgres) GetBootstrapToken(ctx context.Context, udid string) ([]byte, error) {
+	dev, err := d.DeviceByUDID(ctx, udid)
+	if err != nil {
+		return nil, errors.Wrap(err, "lookup device by udid")
+	}
+	return dev.BootstrapToken, nil
+}
+
+const udidCertAuthTableName = "udid_cert_auth"
+
+func (d *Postgres) SaveUDIDCertHash(udid, certHash []byte) error {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Insert(udidCertAuthTableName).
+		Columns("udid", "cert_hash").
+		Values(string(udid), certHash).
+		Suffix("ON CONFLICT (udid) DO UPDATE SET cert_hash = EXCLUDED.cert_hash").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building udid cert hash save query")
+	}
+	_, err = d.db.ExecContext(context.TODO(), query, args...)
+	return errors.Wrap(err, "exec udid cert hash save in pg")
+}
+
+func (d *Postgres) GetUDIDCertHash(udid []byte) ([]byte, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("cert_hash").
+		From(udidCertAuthTableName).
+		Where(sq.Eq{"udid": string(udid)}).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+
+	var certHash []byte
+	err = d.db.QueryRowxContext(context.TODO(), query, args...).Scan(&certHash)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, udidCertHashNotFoundErr{}
+	}
+	return certHash, errors.Wrap(err, "finding udid cert hash by udid")
+}
+
+const inventoryTableName = "device_inventory"
+
+func inventoryColumns() []string {
+	return []string{
+		"udid",
+		"device_information_at",
+		"security_info_at",
+		"certificate_list_at",
+		"os_version",
+		"build_version",
+		"product_name",
+		"model_name",
+		"model",
+		"device_name",
+		"serial_number",
+		"battery_level",
+		"device_capacity",
+		"available_device_capacity",
+		"is_supervised",
+		"wifi_mac",
+		"bluetooth_mac",
+		"fde_enabled",
+		"sip_enabled",
+		"firewall_enabled",
+		"passcode_present",
+		"certificates",
+	}
+}
+
+// inventoryRow is an Inventory with the certificates encoded as JSON.
+type inventoryRow struct {
+	device.Inventory
+	Certificates []byte `db:"certificates"`
+}
+
+func (d *Postgres) SaveInventory(ctx context.Context, inv *device.Inventory) error {
+	certificates, err := json.Marshal(inv.Certificates)
+	if err != nil {
+		return errors.Wrap(err, "marshal inventory certificates")
+	}
+	values := []interface{}{
+		inv.UDID,
+		inv.DeviceInformationAt,
+		inv.SecurityInfoAt,
+		inv.CertificateListAt,
+		inv.OSVersion,
+		inv.BuildVersion,
+		inv.ProductName,
+		inv.ModelName,
+		inv.Model,
+		inv.DeviceName,
+		inv.SerialNumber,
+		inv.BatteryLevel,
+		inv.DeviceCapacity,
+		inv.AvailableDeviceCapacity,
+		inv.IsSupervised,
+		inv.WiFiMAC,
+		inv.BluetoothMAC,
+		inv.FDEEnabled,
+		inv.SIPEnabled,
+		inv.FirewallEnabled,
+		inv.PasscodePresent,
+		certificates,
+	}
+
+	var update []string
+	for _, col := range inventoryColumns()[1:] {
+		update = append(update, col+" = EXCLUDED."+col)
+	}
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Insert(inventoryTableName).
+		Columns(inventoryColumns()...).
+		Values(values...).
+		Suffix("ON CONFLICT (udid) DO UPDATE SET " + strings.Join(update, ", ")).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building inventory save query")
+	}
+	_, err = d.db.ExecContext(ctx, query, args...)
+	return errors.Wrap(err, "exec inventory save in pg")
+}
+
+func (d *Postgres) Inventory(ctx context.Context, udid string) (*device.Inventory, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select(inventoryColumns()...).
+		From(inventoryTableName).
+		Where(sq.Eq{"udid": udid}).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+
+	var row inventoryRow
+	err = d.db.QueryRowxContext(ctx, query, args...).StructScan(&row)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, inventoryNotFoundErr{}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "finding inventory by udid")
+	}
+	inv := row.Inventory
+	if err := json.Unmarshal(row.Certificates, &inv.Certificates); err != nil {
+		return nil, errors.Wrap(err, "unmarshal inventory certificates")
+	}
+	return &inv, nil
+}
+
+type inventoryNotFoundErr struct{}
+
+func (e inventoryNotFoundErr) Error() string  { return "inventory not found" }
+func (e inventoryNotFoundErr) NotFound() bool { return true }
+
+type udidCertHashNotFoundErr struct{}
+
+func (e udidCertHashNotFoundErr) Error() string  { return "udid cert hash not found" }
+func (e udidCertHashNotFoundErr) NotFound() bool { return true }
+
 type deviceNotFoundErr struct{}
 
 func (e deviceNotFoundErr) Error() string {
@@ -5,6 +5,7 @@ package pg
 
 import (
 	"context"
+	"reflect"
 	"testing"
 	"time"
 
@@ -48,7 +49,7 @@ func TestPGCrud(t *testing.T) {
 	}
 
 	// list
-	devices, err := db.ListDevices(ctx, device.ListDevicesOption{})
+	devices, err := db.List(ctx, device.ListDevicesOption{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,6 +62,170 @@ func TestPGCrud(t *testing.T) {
 	}
 }
 
+func TestSaveInventory(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	dev := &device.Device{UUID: "inventory", UDID: "inventory", SerialNumber: "inventory"}
+	if err := db.Save(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	inv := &device.Inventory{
+		UDID:                dev.UDID,
+		DeviceInformationAt: time.Now().UTC().Truncate(time.Microsecond),
+		OSVersion:           "12.1",
+		BatteryLevel:        0.5,
+		IsSupervised:        true,
+		FDEEnabled:          true,
+		Certificates: []device.InventoryCertificate{
+			{CommonName: "MDM Identity", IsIdentity: true, NotAfter: time.Now().UTC().Truncate(time.Second)},
+		},
+	}
+	if err := db.SaveInventory(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+	inv.OSVersion = "12.2"
+	if err := db.SaveInventory(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := db.Inventory(ctx, dev.UDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := found.OSVersion, inv.OSVersion; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := found.DeviceInformationAt, inv.DeviceInformationAt; !have.Equal(want) {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if len(found.Certificates) != 1 || !found.Certificates[0].NotAfter.Equal(inv.Certificates[0].NotAfter) {
+		t.Errorf("have certificates %+v, want %+v", found.Certificates, inv.Certificates)
+	}
+
+	if err := db.DeleteBySerial(ctx, dev.SerialNumber); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Inventory(ctx, dev.UDID); err == nil {
+		t.Error("expected inventory to be deleted with the device")
+	}
+}
+
+func TestSaveAppInventory(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	dev := &device.Device{UUID: "apps", UDID: "apps", SerialNumber: "apps"}
+	if err := db.Save(ctx, dev); err != nil {
+		t.Fatal(err)
+	}
+	inv := &device.AppInventory{
+		UDID:                       dev.UDID,
+		InstalledApplicationListAt: time.Now().UTC().Truncate(time.Microsecond),
+		Apps: []device.InstalledApp{
+			{BundleID: "com.example.app", Version: "1.0", Managed: true, ManagedStatus: "Managed"},
+			{BundleID: "com.example.other", Version: "2.0"},
+		},
+		ManagedApps: []device.ManagedApp{{BundleID: "com.example.app", Status: "Managed"}},
+	}
+	if err := db.SaveAppInventory(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := db.AppInventory(ctx, dev.UDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Apps) != 2 || found.Apps[0] != inv.Apps[0] || len(found.ManagedApps) != 1 {
+		t.Errorf("have %+v, want %+v", found, inv)
+	}
+
+	apps, err := db.ListInstalledApps(ctx, device.ListInstalledAppsOption{FilterBundleID: []string{"com.example.app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].SerialNumber != dev.SerialNumber {
+		t.Errorf("have apps %+v", apps)
+	}
+
+	// a new list replaces the previous one.
+	inv.Apps = inv.Apps[1:]
+	if err := db.SaveAppInventory(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+	apps, err = db.ListInstalledApps(ctx, device.ListInstalledAppsOption{FilterUDID: []string{dev.UDID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].BundleID != "com.example.other" {
+		t.Errorf("have apps %+v after replacing the list", apps)
+	}
+
+	if err := db.DeleteByUDID(ctx, dev.UDID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AppInventory(ctx, dev.UDID); err == nil {
+		t.Error("expected app inventory to be deleted with the device")
+	}
+}
+
+func TestList(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	devices := []*device.Device{
+		{UUID: "list-1", UDID: "list-1", SerialNumber: "list-C", OSVersion: "12.1", Enrolled: true, LastSeen: now.Add(-time.Hour)},
+		{UUID: "list-2", UDID: "list-2", SerialNumber: "list-A", OSVersion: "11.6", Enrolled: true, LastSeen: now.Add(-48 * time.Hour)},
+		{UUID: "list-3", UDID: "list-3", SerialNumber: "list-B", OSVersion: "12.1"},
+	}
+	for _, dev := range devices {
+		if err := db.Save(ctx, dev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for _, dev := range devices {
+			db.DeleteByUDID(ctx, dev.UDID)
+		}
+	}()
+
+	enrolled := true
+	udids := []string{"list-1", "list-2", "list-3"}
+	for _, tt := range []struct {
+		name  string
+		opt   device.ListDevicesOption
+		want  []string
+		total int
+	}{
+		{"page", device.ListDevicesOption{FilterUDID: udids, Page: 2, PerPage: 2}, []string{"list-3"}, 3},
+		{"os version", device.ListDevicesOption{FilterUDID: udids, FilterOSVersion: []string{"12.1"}}, []string{"list-1", "list-3"}, 2},
+		{"enrolled seen after", device.ListDevicesOption{FilterUDID: udids, FilterEnrolled: &enrolled, LastSeenAfter: now.Add(-2 * time.Hour)}, []string{"list-1"}, 1},
+		{"sort by serial desc", device.ListDevicesOption{FilterUDID: udids, SortBy: "serial_number", SortDesc: true}, []string{"list-1", "list-3", "list-2"}, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := db.List(ctx, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var have []string
+			for _, d := range list {
+				have = append(have, d.UUID)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("have devices %v, want %v", have, tt.want)
+			}
+			total, err := db.Count(ctx, tt.opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != tt.total {
+				t.Errorf("have total %d, want %d", total, tt.total)
+			}
+		})
+	}
+}
+
 func setup(t *testing.T) *Postgres {
 	db, err := dbutil.OpenDBX(
 		"postgres",
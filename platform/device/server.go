@@ -11,18 +11,26 @@ import (
 type Endpoints struct {
 	ListDevicesEndpoint   endpoint.Endpoint
 	RemoveDevicesEndpoint endpoint.Endpoint
+	GetInventoryEndpoint  endpoint.Endpoint
+
+	ListInstalledAppsEndpoint endpoint.Endpoint
 }
 
 func MakeServerEndpoints(s Service, outer endpoint.Middleware, others ...endpoint.Middleware) Endpoints {
 	return Endpoints{
 		ListDevicesEndpoint:   endpoint.Chain(outer, others...)(MakeListDevicesEndpoint(s)),
 		RemoveDevicesEndpoint: endpoint.Chain(outer, others...)(MakeRemoveDevicesEndpoint(s)),
+		GetInventoryEndpoint:  endpoint.Chain(outer, others...)(MakeGetInventoryEndpoint(s)),
+
+		ListInstalledAppsEndpoint: endpoint.Chain(outer, others...)(MakeListInstalledAppsEndpoint(s)),
 	}
 }
 
 func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.ServerOption) {
 	// POST     /v1/devices		get a list of devices managed by the server
 	// DELETE  /v1/devices		remove one or more devices from the server
+	// GET     /v1/devices/:udid/inventory	get the inventory reported by a device
+	// POST    /v1/devices/apps		get a list of the apps installed on the devices
 
 	r.Methods("POST").Path("/v1/devices").Handler(httptransport.NewServer(
 		e.ListDevicesEndpoint,
@@ -37,4 +45,18 @@ func RegisterHTTPHandlers(r *mux.Router, e Endpoints, options ...httptransport.S
 		httputil.EncodeJSONResponse,
 		options...,
 	))
+
+	r.Methods("GET").Path("/v1/devices/{udid}/inventory").Handler(httptransport.NewServer(
+		e.GetInventoryEndpoint,
+		decodeGetInventoryRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
+
+	r.Methods("POST").Path("/v1/devices/apps").Handler(httptransport.NewServer(
+		e.ListInstalledAppsEndpoint,
+		decodeListInstalledAppsRequest,
+		httputil.EncodeJSONResponse,
+		options...,
+	))
 }
@@ -10,20 +10,56 @@ type RemoveDevicesOptions struct {
 }
 
 type Service interface {
-	ListDevices(ctx context.Context, opt ListDevicesOption) ([]DeviceDTO, error)
+	ListDevices(ctx context.Context, opt ListDevicesOption) ([]DeviceDTO, int, error)
 	RemoveDevices(ctx context.Context, opt RemoveDevicesOptions) error
+	GetInventory(ctx context.Context, udid string) (*Inventory, error)
+	ListInstalledApps(ctx context.Context, opt ListInstalledAppsOption) ([]DeviceApp, error)
 }
 
 type Store interface {
 	List(ctx context.Context, opt ListDevicesOption) ([]Device, error)
+	Count(ctx context.Context, opt ListDevicesOption) (int, error)
 	DeleteByUDID(ctx context.Context, udid string) error
 	DeleteBySerial(ctx context.Context, serial string) error
+	Inventory(ctx context.Context, udid string) (*Inventory, error)
+	ListInstalledApps(ctx context.Context, opt ListInstalledAppsOption) ([]DeviceApp, error)
+}
+
+// InventoryStore stores the inventory reported by devices.
+type InventoryStore interface {
+	Inventory(ctx context.Context, udid string) (*Inventory, error)
+	SaveInventory(ctx context.Context, inv *Inventory) error
+}
+
+// AppInventoryStore stores the app lists reported by devices.
+type AppInventoryStore interface {
+	AppInventory(ctx context.Context, udid string) (*AppInventory, error)
+	SaveAppInventory(ctx context.Context, inv *AppInventory) error
+}
+
+// PushStatusStore provides the result of the last push to a device.
+type PushStatusStore interface {
+	PushStatus(ctx context.Context, udid string) (*PushStatus, error)
 }
 
 type DeviceService struct {
 	store Store
+	push  PushStatusStore
+}
+
+type Option func(*DeviceService)
+
+// WithPushStatus adds the result of the last push to the listed devices.
+func WithPushStatus(push PushStatusStore) Option {
+	return func(svc *DeviceService) {
+		svc.push = push
+	}
 }
 
-func New(store Store) *DeviceService {
-	return &DeviceService{store: store}
+func New(store Store, opts ...Option) *DeviceService {
+	svc := &DeviceService{store: store}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	return svc
 }
@@ -19,6 +19,8 @@ type DeviceWorkerStore interface {
 	DeviceByUDID(ctx context.Context, udid string) (*Device, error)
 	DeviceBySerial(ctx context.Context, serial string) (*Device, error)
 	DeleteBySerial(ctx context.Context, serial string) error
+	InventoryStore
+	AppInventoryStore
 }
 
 type Worker struct {
@@ -177,11 +179,83 @@ func (w *Worker) updateFromAcknowledge(ctx context.Context, message []byte) erro
 	}
 	dev.LastSeen = time.Now()
 
+	// responses on the user channel describe the user, not the device.
+	if ev.Response.Status == "Acknowledged" && ev.Response.UserID == nil && len(ev.Raw) > 0 {
+		if err := w.updateInventory(ctx, dev, ev.Raw); err != nil {
+			return err
+		}
+		if err := w.updateAppInventory(ctx, dev.UDID, ev.Raw); err != nil {
+			return err
+		}
+	}
+
 	err = w.db.Save(ctx, dev)
 	return errors.Wrapf(err, "saving updated device for acknowledge event")
 
 }
 
+// updateInventory saves the inventory reported in an acknowledged command
+// response, and copies the DeviceInformation fields which the device list
+// shows to dev.
+func (w *Worker) updateInventory(ctx context.Context, dev *Device, response []byte) error {
+	inv, err := w.db.Inventory(ctx, dev.UDID)
+	if isNotFound(err) {
+		inv, err = &Inventory{UDID: dev.UDID}, nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "retrieve inventory for udid %s", dev.UDID)
+	}
+
+	updated, err := inv.Update(response, time.Now().UTC())
+	if err != nil {
+		return errors.Wrapf(err, "update inventory for udid %s", dev.UDID)
+	}
+	if !updated {
+		return nil
+	}
+	if err := w.db.SaveInventory(ctx, inv); err != nil {
+		return errors.Wrapf(err, "save inventory for udid %s", dev.UDID)
+	}
+
+	if !inv.DeviceInformationAt.IsZero() {
+		setIfReported(&dev.OSVersion, inv.OSVersion)
+		setIfReported(&dev.BuildVersion, inv.BuildVersion)
+		setIfReported(&dev.ProductName, inv.ProductName)
+		setIfReported(&dev.Model, inv.Model)
+		setIfReported(&dev.ModelName, inv.ModelName)
+		setIfReported(&dev.DeviceName, inv.DeviceName)
+	}
+	return nil
+}
+
+// updateAppInventory replaces the app lists of a device with the lists in
+// an acknowledged command response.
+func (w *Worker) updateAppInventory(ctx context.Context, udid string, response []byte) error {
+	inv, err := w.db.AppInventory(ctx, udid)
+	if isNotFound(err) {
+		inv, err = &AppInventory{UDID: udid}, nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "retrieve app inventory for udid %s", udid)
+	}
+
+	updated, err := inv.Update(response, time.Now().UTC())
+	if err != nil {
+		return errors.Wrapf(err, "update app inventory for udid %s", udid)
+	}
+	if !updated {
+		return nil
+	}
+	err = w.db.SaveAppInventory(ctx, inv)
+	return errors.Wrapf(err, "save app inventory for udid %s", udid)
+}
+
+func setIfReported(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
 func (w *Worker) updateFromCheckout(ctx context.Context, message []byte) error {
 	var ev mdm.CheckinEvent
 	if err := mdm.UnmarshalCheckinEvent(message, &ev); err != nil {
@@ -251,7 +325,7 @@ func (w *Worker) updateFromTokenUpdate(ctx context.Context, message []byte) erro
 	}
 
 	// do not process managed user, or user enrollment checkin events while updating device records.
-	if ev.Command.UserID != "" || ev.Command.EnrollmentID != "" {
+	if ev.Command.ChannelID() != ev.Command.UDID {
 		return nil
 	}
 
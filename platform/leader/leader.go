@@ -0,0 +1,289 @@
+// Package leader elects one of several micromdm instances to run the
+// workers which must not run more than once at a time, like the DEP sync.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// Elector runs workers while this instance is their leader.
+type Elector interface {
+	// Go runs fn in a new goroutine once this instance leads name. The
+	// context of fn is canceled when the leadership is lost, and fn runs
+	// again if it is regained.
+	Go(name string, fn func(ctx context.Context))
+
+	// Stop cancels the running workers and hands their leadership over
+	// to another instance.
+	Stop()
+}
+
+// Local returns an Elector for a single instance, which always leads.
+func Local() Elector {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &local{ctx: ctx, cancel: cancel}
+}
+
+type local struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (l *local) Go(_ string, fn func(ctx context.Context)) { go fn(l.ctx) }
+func (l *local) Stop()                                     { l.cancel() }
+
+// LeaseStore stores leases in a database shared by all instances.
+type LeaseStore interface {
+	// AcquireLease takes the lease on name for holder, or renews it if
+	// holder has it already. The lease expires after ttl. It reports
+	// whether holder has the lease.
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+
+	// ReleaseLease gives up the lease on name if holder has it.
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// DefaultTTL is the time after which the lease of an instance which
+// stopped renewing it expires.
+const DefaultTTL = 15 * time.Second
+
+// Lease is an Elector which leads while it holds a lease in a LeaseStore.
+type Lease struct {
+	store  LeaseStore
+	holder string
+	ttl    time.Duration
+	logger log.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type Option func(*Lease)
+
+func WithLogger(logger log.Logger) Option {
+	return func(l *Lease) {
+		l.logger = logger
+	}
+}
+
+// WithHolder sets the name which identifies this instance in the leases.
+// The default is the hostname and a random suffix.
+func WithHolder(holder string) Option {
+	return func(l *Lease) {
+		l.holder = holder
+	}
+}
+
+// WithTTL sets how long a lease lasts without being renewed. Leases are
+// renewed three times per ttl.
+func WithTTL(ttl time.Duration) Option {
+	return func(l *Lease) {
+		l.ttl = ttl
+	}
+}
+
+func NewLease(store LeaseStore, opts ...Option) *Lease {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Lease{
+		store:  store,
+		holder: defaultHolder(),
+		ttl:    DefaultTTL,
+		logger: log.NewNopLogger(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for _, fn := range opts {
+		fn(l)
+	}
+	return l
+}
+
+func defaultHolder() string {
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// Holder returns the name of this instance in the leases.
+func (l *Lease) Holder() string { return l.holder }
+
+func (l *Lease) Go(name string, fn func(ctx context.Context)) {
+	l.wg.Add(1)
+	go l.run(name, fn)
+}
+
+// ErrHeld is returned by Hold when another instance keeps the lease.
+var ErrHeld = errors.New("lease is held by another instance")
+
+// Hold takes the lease on name and keeps it until Stop, so that only one
+// instance runs. It waits at most wait for another instance to give the
+// lease up, and returns ErrHeld if it didn't.
+func (l *Lease) Hold(ctx context.Context, name string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := l.store.AcquireLease(ctx, name, l.holder, l.ttl)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			return ErrHeld
+		}
+		select {
+		case <-time.After(l.ttl / 3):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	l.wg.Add(1)
+	go l.hold(name)
+	return nil
+}
+
+// hold renews the lease on name until Stop.
+func (l *Lease) hold(name string) {
+	defer l.wg.Done()
+	logger := log.With(l.logger, "lease", name, "holder", l.holder)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ok, err := l.store.AcquireLease(l.ctx, name, l.holder, l.ttl)
+			if err != nil && l.ctx.Err() == nil {
+				level.Info(logger).Log("msg", "renew lease", "err", err)
+			} else if !ok && err == nil {
+				level.Info(logger).Log("msg", "lease was taken by another instance")
+			}
+		case <-l.ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+			if err := l.store.ReleaseLease(ctx, name, l.holder); err != nil {
+				level.Info(logger).Log("msg", "release lease", "err", err)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+// Stop cancels the running workers and waits for them to return before
+// their leases are released.
+func (l *Lease) Stop() {
+	l.cancel()
+	l.wg.Wait()
+}
+
+// start runs fn until the returned cancel function is called. done is
+// closed when fn returns.
+func (l *Lease) start(fn func(ctx context.Context)) (cancel context.CancelFunc, done chan struct{}) {
+	ctx, cancel := context.WithCancel(l.ctx)
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+	return cancel, done
+}
+
+func (l *Lease) run(name string, fn func(ctx context.Context)) {
+	defer l.wg.Done()
+	logger := log.With(l.logger, "lease", name, "holder", l.holder)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	var (
+		cancel  context.CancelFunc
+		done    chan struct{}
+		renewed time.Time
+		// stopping is set while a worker which did not stop after the
+		// leadership was lost is still running. The lease is not taken
+		// again until it returned.
+		stopping bool
+	)
+	// stop cancels the worker and reports whether it returned before the
+	// lease expired. Otherwise the lease is left to expire.
+	stop := func() bool {
+		cancel()
+		select {
+		case <-done:
+			return true
+		case <-time.After(l.ttl):
+			level.Info(logger).Log("msg", "worker did not stop, leaving lease to expire")
+			return false
+		}
+	}
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl)
+		defer cancel()
+		if err := l.store.ReleaseLease(ctx, name, l.holder); err != nil {
+			level.Info(logger).Log("msg", "release lease", "err", err)
+		}
+	}
+
+	for {
+		if !stopping {
+			leader, err := l.store.AcquireLease(l.ctx, name, l.holder, l.ttl)
+			if err != nil && l.ctx.Err() == nil {
+				level.Info(logger).Log("msg", "acquire lease", "err", err)
+				// keep leading until the lease could have expired.
+				leader = done != nil && time.Since(renewed) < l.ttl
+			} else if leader {
+				renewed = time.Now()
+			}
+
+			switch {
+			case leader && done == nil && l.ctx.Err() == nil:
+				level.Info(logger).Log("msg", "acquired leadership")
+				cancel, done = l.start(fn)
+			case !leader && done != nil && l.ctx.Err() == nil:
+				level.Info(logger).Log("msg", "lost leadership")
+				if stop() {
+					done = nil
+				} else {
+					stopping = true
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			cancel()
+			done = nil
+			if stopping {
+				stopping = false
+				level.Info(logger).Log("msg", "worker stopped after leadership was lost")
+				if l.ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+			release()
+			if l.ctx.Err() != nil {
+				level.Info(logger).Log("msg", "released leadership")
+				return
+			}
+			// the worker returned by itself. Another instance may try.
+			level.Info(logger).Log("msg", "worker stopped, released leadership")
+		case <-l.ctx.Done():
+			if done != nil && !stopping && stop() {
+				release()
+				level.Info(logger).Log("msg", "released leadership")
+			}
+			return
+		}
+	}
+}
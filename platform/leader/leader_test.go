@@ -0,0 +1,186 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lease struct {
+	holder  string
+	expires time.Time
+}
+
+type memStore struct {
+	mtx    sync.Mutex
+	leases map[string]lease
+}
+
+func (s *memStore) AcquireLease(_ context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := time.Now()
+	if l, ok := s.leases[name]; ok && l.holder != holder && l.expires.After(now) {
+		return false, nil
+	}
+	s.leases[name] = lease{holder: holder, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *memStore) ReleaseLease(_ context.Context, name, holder string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.leases[name].holder == holder {
+		delete(s.leases, name)
+	}
+	return nil
+}
+
+// worker records which holder runs it.
+type worker struct {
+	mtx     sync.Mutex
+	running map[string]bool
+	started chan string
+}
+
+func (w *worker) run(holder string) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		w.mtx.Lock()
+		w.running[holder] = true
+		n := len(w.running)
+		w.mtx.Unlock()
+		if n > 1 {
+			panic("worker runs in more than one instance")
+		}
+		w.started <- holder
+
+		<-ctx.Done()
+		w.mtx.Lock()
+		delete(w.running, holder)
+		w.mtx.Unlock()
+	}
+}
+
+func TestLease_Handover(t *testing.T) {
+	store := &memStore{leases: make(map[string]lease)}
+	w := &worker{running: make(map[string]bool), started: make(chan string, 2)}
+	ttl := 200 * time.Millisecond
+
+	a := NewLease(store, WithHolder("a"), WithTTL(ttl))
+	a.Go("worker", w.run("a"))
+	if have := <-w.started; have != "a" {
+		t.Fatalf("have leader %s, want a", have)
+	}
+
+	b := NewLease(store, WithHolder("b"), WithTTL(ttl))
+	defer b.Stop()
+	b.Go("worker", w.run("b"))
+	select {
+	case holder := <-w.started:
+		t.Fatalf("worker started in %s while a leads", holder)
+	case <-time.After(2 * ttl):
+	}
+
+	a.Stop()
+	select {
+	case holder := <-w.started:
+		if holder != "b" {
+			t.Fatalf("have leader %s, want b", holder)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for handover")
+	}
+}
+
+func TestLease_Expired(t *testing.T) {
+	store := &memStore{leases: map[string]lease{
+		// a lease held by an instance which exited without releasing it.
+		"worker": {holder: "gone", expires: time.Now().Add(50 * time.Millisecond)},
+	}}
+	w := &worker{running: make(map[string]bool), started: make(chan string, 1)}
+
+	b := NewLease(store, WithHolder("b"), WithTTL(30*time.Millisecond))
+	defer b.Stop()
+	b.Go("worker", w.run("b"))
+	select {
+	case <-w.started:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the expired lease")
+	}
+}
+
+func TestLease_StuckWorker(t *testing.T) {
+	store := &memStore{leases: make(map[string]lease)}
+	ttl := 30 * time.Millisecond
+	started := make(chan struct{}, 2)
+	unblock := make(chan struct{})
+	var running int
+	var mtx sync.Mutex
+	fn := func(ctx context.Context) {
+		mtx.Lock()
+		running++
+		n := running
+		mtx.Unlock()
+		if n > 1 {
+			t.Error("worker started while the previous one was still running")
+		}
+		started <- struct{}{}
+		<-ctx.Done()
+		// the worker ignores the cancel until it is unblocked.
+		<-unblock
+		mtx.Lock()
+		running--
+		mtx.Unlock()
+	}
+
+	a := NewLease(store, WithHolder("a"), WithTTL(ttl))
+	defer a.Stop()
+	a.Go("worker", fn)
+	<-started
+
+	// another instance takes the lease, then gives it up again while the
+	// worker of a is stuck.
+	store.mtx.Lock()
+	store.leases["worker"] = lease{holder: "other", expires: time.Now().Add(ttl)}
+	store.mtx.Unlock()
+	select {
+	case <-started:
+		t.Fatal("worker restarted while the previous one was stuck")
+	case <-time.After(10 * ttl):
+	}
+
+	close(unblock)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the worker to restart")
+	}
+}
+
+func TestLease_Hold(t *testing.T) {
+	store := &memStore{leases: make(map[string]lease)}
+	ttl := 60 * time.Millisecond
+	ctx := context.Background()
+
+	a := NewLease(store, WithHolder("a"), WithTTL(ttl))
+	if err := a.Hold(ctx, "instance", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// a renews the lease while it runs.
+	b := NewLease(store, WithHolder("b"), WithTTL(ttl))
+	defer b.Stop()
+	if err := b.Hold(ctx, "instance", 3*ttl); err != ErrHeld {
+		t.Fatalf("have %v, want ErrHeld", err)
+	}
+
+	// b takes the lease once a stopped.
+	go func() {
+		time.Sleep(ttl)
+		a.Stop()
+	}()
+	if err := b.Hold(ctx, "instance", 3*ttl); err != nil {
+		t.Fatalf("expected b to take the released lease, got %v", err)
+	}
+}
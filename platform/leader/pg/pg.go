@@ -0,0 +1,46 @@
+// Package pg stores leader leases in Postgres.
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+type Postgres struct{ db *sqlx.DB }
+
+func New(db *sqlx.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// AcquireLease takes the lease if it is free or expired, and renews it if
+// holder has it. Expiry uses the database clock, so the clocks of the
+// instances don't need to agree.
+func (d *Postgres) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	var current string
+	err := d.db.GetContext(ctx, &current, `
+		INSERT INTO leader_leases (name, holder, expires_at)
+		VALUES ($1, $2, (now() at time zone 'utc') + $3 * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE
+		SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE leader_leases.holder = EXCLUDED.holder
+			OR leader_leases.expires_at < (now() at time zone 'utc')
+		RETURNING holder`,
+		name, holder, ttl.Milliseconds())
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "acquire lease %s", name)
+	}
+	return current == holder, nil
+}
+
+func (d *Postgres) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := d.db.ExecContext(ctx,
+		`DELETE FROM leader_leases WHERE name = $1 AND holder = $2`, name, holder)
+	return errors.Wrapf(err, "release lease %s", name)
+}
@@ -0,0 +1,68 @@
+//go:build pg
+// +build pg
+
+package pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/kit/dbutil"
+	_ "github.com/lib/pq"
+)
+
+func TestAcquireLease(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	ttl := 200 * time.Millisecond
+
+	for _, tt := range []struct {
+		holder string
+		want   bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"a", true},
+	} {
+		ok, err := db.AcquireLease(ctx, "worker", tt.holder, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want {
+			t.Errorf("holder %s: have %v, want %v", tt.holder, ok, tt.want)
+		}
+	}
+
+	// b takes the lease once it expired.
+	time.Sleep(ttl)
+	if ok, err := db.AcquireLease(ctx, "worker", "b", ttl); err != nil || !ok {
+		t.Fatalf("expected b to take the expired lease, got %v, %v", ok, err)
+	}
+
+	// a takes the lease once b released it.
+	if err := db.ReleaseLease(ctx, "worker", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.AcquireLease(ctx, "worker", "a", ttl); err != nil || !ok {
+		t.Fatalf("expected a to take the released lease, got %v, %v", ok, err)
+	}
+}
+
+func setup(t *testing.T) *Postgres {
+	db, err := dbutil.OpenDBX(
+		"postgres",
+		"host=localhost port=5432 user=micromdm dbname=micromdm_test password=micromdm sslmode=disable",
+		dbutil.WithLogger(log.NewNopLogger()),
+		dbutil.WithMaxAttempts(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`DELETE FROM leader_leases`); err != nil {
+		t.Fatal(err)
+	}
+	return New(db)
+}
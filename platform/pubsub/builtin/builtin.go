@@ -0,0 +1,481 @@
+// Package builtin implements a durable pubsub.PublishSubscriber backed by
+// BoltDB. Events are stored per topic and each named subscription keeps
+// the offset of the last event it processed, so events which were
+// published but not processed before a restart are delivered after it.
+//
+// A subscriber processed an event once it receives the next one, so the
+// event received last before a restart is delivered again after it.
+package builtin
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/pubsub"
+)
+
+const (
+	// EventBucket holds a nested bucket of events for each topic. Keys are
+	// big endian sequence numbers, values are the big endian publish time
+	// followed by the message.
+	EventBucket = "mdm.PubSubEvents"
+
+	// OffsetBucket maps the topic and name of a subscription, separated by
+	// a zero byte, to the sequence number of the last received event.
+	OffsetBucket = "mdm.PubSubOffsets"
+)
+
+const (
+	// DefaultMaxAge is the age after which events are removed even if a
+	// subscription did not receive them.
+	DefaultMaxAge = 7 * 24 * time.Hour
+
+	// DefaultOffsetGracePeriod is how long the PubSub runs before Prune
+	// removes the offsets of subscriptions which were not made again.
+	DefaultOffsetGracePeriod = 24 * time.Hour
+
+	pruneInterval = time.Hour
+	readBatchSize = 100
+	retryInterval = time.Second
+
+	// commitInterval is the longest time the offset of processed events
+	// stays uncommitted while events are delivered.
+	commitInterval = 250 * time.Millisecond
+)
+
+type PubSub struct {
+	db          *bolt.DB
+	logger      log.Logger
+	maxAge      time.Duration
+	offsetGrace time.Duration
+	started     time.Time
+
+	mtx           sync.Mutex
+	subscriptions map[string]*subscription
+	// subscribed holds the keys of every subscription made since the
+	// PubSub was created, including the ended ones.
+	subscribed map[string]bool
+}
+
+type subscription struct {
+	name      string
+	topic     string
+	offset    uint64
+	events    chan pubsub.Event
+	wake      chan struct{}
+	delivered uint64
+
+	// canceled is the Done channel of the subscription context, stopped
+	// is closed once the subscription was removed.
+	canceled <-chan struct{}
+	stopped  chan struct{}
+}
+
+type Option func(*PubSub)
+
+func WithLogger(logger log.Logger) Option {
+	return func(p *PubSub) {
+		p.logger = logger
+	}
+}
+
+// WithMaxAge removes events older than maxAge whether or not they were
+// received by all subscriptions. A zero value keeps events until they are
+// received.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(p *PubSub) {
+		p.maxAge = maxAge
+	}
+}
+
+// WithOffsetGracePeriod sets how long the PubSub runs before Prune removes
+// the offsets of subscriptions which were not made since it was created.
+// Until then, the events of these subscriptions are kept for workers which
+// subscribe late, or run again after a rolling restart.
+func WithOffsetGracePeriod(grace time.Duration) Option {
+	return func(p *PubSub) {
+		p.offsetGrace = grace
+	}
+}
+
+// NewPubSub creates a durable PubSub which stores events in db.
+func NewPubSub(db *bolt.DB, opts ...Option) (*PubSub, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(EventBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(OffsetBucket))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating %s bucket", EventBucket)
+	}
+
+	p := &PubSub{
+		db:            db,
+		logger:        log.NewNopLogger(),
+		maxAge:        DefaultMaxAge,
+		offsetGrace:   DefaultOffsetGracePeriod,
+		started:       time.Now(),
+		subscriptions: make(map[string]*subscription),
+		subscribed:    make(map[string]bool),
+	}
+	for _, fn := range opts {
+		fn(p)
+	}
+	go p.runPruner()
+	return p, nil
+}
+
+func subscriptionKey(topic, name string) string {
+	return topic + "\x00" + name
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// Publish stores the event before it returns.
+func (p *PubSub) Publish(_ context.Context, topic string, msg []byte) error {
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.Bucket([]byte(EventBucket)).CreateBucketIfNotExists([]byte(topic))
+		if err != nil {
+			return err
+		}
+		seq, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		value := make([]byte, 8, 8+len(msg))
+		binary.BigEndian.PutUint64(value, uint64(time.Now().UnixNano()))
+		return bkt.Put(seqKey(seq), append(value, msg...))
+	})
+	if err != nil {
+		return errors.Wrapf(err, "store event on topic: %s", topic)
+	}
+
+	p.mtx.Lock()
+	for _, sub := range p.subscriptions {
+		if sub.topic != topic {
+			continue
+		}
+		select {
+		case sub.wake <- struct{}{}:
+		default:
+		}
+	}
+	p.mtx.Unlock()
+	return nil
+}
+
+// Subscribe delivers the events of topic which the subscription name did
+// not receive yet. A new subscription starts with the next published
+// event. The subscription ends when ctx is done, after which name can
+// subscribe again.
+func (p *PubSub) Subscribe(ctx context.Context, name, topic string) (<-chan pubsub.Event, error) {
+	key := subscriptionKey(topic, name)
+	p.mtx.Lock()
+	for {
+		old, ok := p.subscriptions[key]
+		if !ok {
+			break
+		}
+		p.mtx.Unlock()
+		select {
+		case <-old.canceled:
+		default:
+			return nil, fmt.Errorf("subscription %s on topic %s already exists", name, topic)
+		}
+		// wait until the ended subscription committed its last offset.
+		<-old.stopped
+		p.mtx.Lock()
+	}
+	defer p.mtx.Unlock()
+
+	var offset uint64
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		offsets := tx.Bucket([]byte(OffsetBucket))
+		if v := offsets.Get([]byte(key)); v != nil {
+			offset = binary.BigEndian.Uint64(v)
+			return nil
+		}
+		if bkt := tx.Bucket([]byte(EventBucket)).Bucket([]byte(topic)); bkt != nil {
+			offset = bkt.Sequence()
+		}
+		return offsets.Put([]byte(key), seqKey(offset))
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "load offset of subscription %s on topic %s", name, topic)
+	}
+
+	sub := &subscription{
+		name:   name,
+		topic:  topic,
+		offset: offset,
+		events: make(chan pubsub.Event),
+		wake:   make(chan struct{}, 1),
+
+		canceled: ctx.Done(),
+		stopped:  make(chan struct{}),
+	}
+	p.subscriptions[key] = sub
+	p.subscribed[key] = true
+	go p.deliver(ctx, sub)
+	return sub.events, nil
+}
+
+type storedEvent struct {
+	seq     uint64
+	message []byte
+}
+
+// read returns up to readBatchSize events of topic after offset.
+func (p *PubSub) read(topic string, offset uint64) ([]storedEvent, error) {
+	var events []storedEvent
+	err := p.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(EventBucket)).Bucket([]byte(topic))
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.Seek(seqKey(offset + 1)); k != nil && len(events) < readBatchSize; k, v = c.Next() {
+			if len(v) < 8 {
+				continue
+			}
+			msg := make([]byte, len(v)-8)
+			copy(msg, v[8:])
+			events = append(events, storedEvent{seq: binary.BigEndian.Uint64(k), message: msg})
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (p *PubSub) commit(sub *subscription, seq uint64) error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(OffsetBucket)).Put([]byte(subscriptionKey(sub.topic, sub.name)), seqKey(seq))
+	})
+}
+
+// deliver sends the stored events to the subscription in order. The
+// subscriber processed an event once it receives the next one, or once it
+// receives it if the subscription ends. The offset of the processed events
+// is committed before the subscription waits for new events, and at least
+// every commitInterval while it delivers them.
+func (p *PubSub) deliver(ctx context.Context, sub *subscription) {
+	processed, committed := sub.offset, sub.offset
+	commit := func() {
+		if processed == committed {
+			return
+		}
+		if err := p.commit(sub, processed); err != nil {
+			level.Info(p.logger).Log("msg", "commit pubsub offset", "topic", sub.topic, "subscription", sub.name, "err", err)
+			return
+		}
+		committed = processed
+	}
+	defer func() {
+		commit()
+		p.mtx.Lock()
+		delete(p.subscriptions, subscriptionKey(sub.topic, sub.name))
+		p.mtx.Unlock()
+		close(sub.stopped)
+	}()
+
+	ticker := time.NewTicker(commitInterval)
+	defer ticker.Stop()
+	for {
+		events, err := p.read(sub.topic, sub.offset)
+		if err != nil {
+			level.Info(p.logger).Log("msg", "read pubsub events", "topic", sub.topic, "subscription", sub.name, "err", err)
+			select {
+			case <-time.After(retryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if len(events) == 0 {
+			commit()
+			select {
+			case <-sub.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		for _, ev := range events {
+			for sent := false; !sent; {
+				select {
+				case sub.events <- pubsub.Event{Topic: sub.topic, Message: ev.message}:
+					sent = true
+				case <-ticker.C:
+					commit()
+				case <-ctx.Done():
+					return
+				}
+			}
+			atomic.AddUint64(&sub.delivered, 1)
+			processed, sub.offset = sub.offset, ev.seq
+		}
+	}
+}
+
+// Prune removes the events which were received by every subscription of
+// their topic, and the events older than the maximum age. It returns the
+// number of removed events.
+//
+// Once the PubSub ran for the offset grace period, the offsets of
+// subscriptions which were not made since it was created are removed
+// first. These belong to subscriptions which no longer exist and would
+// otherwise keep the events of their topic forever.
+func (p *PubSub) Prune() (int, error) {
+	pruneOffsets := time.Since(p.started) >= p.offsetGrace
+	p.mtx.Lock()
+	subscribed := make(map[string]bool, len(p.subscribed))
+	for key := range p.subscribed {
+		subscribed[key] = true
+	}
+	p.mtx.Unlock()
+
+	var removed int
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		offsets := tx.Bucket([]byte(OffsetBucket))
+		var stale [][]byte
+		err := offsets.ForEach(func(k, v []byte) error {
+			if pruneOffsets && !subscribed[string(k)] {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := offsets.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		received := make(map[string]uint64)
+		err = offsets.ForEach(func(k, v []byte) error {
+			var topic string
+			for i, b := range k {
+				if b == 0 {
+					topic = string(k[:i])
+					break
+				}
+			}
+			offset := binary.BigEndian.Uint64(v)
+			if min, ok := received[topic]; !ok || offset < min {
+				received[topic] = offset
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var cutoff uint64
+		if p.maxAge > 0 {
+			cutoff = uint64(time.Now().Add(-p.maxAge).UnixNano())
+		}
+		events := tx.Bucket([]byte(EventBucket))
+		return events.ForEach(func(topic, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			bkt := events.Bucket(topic)
+			offset, ok := received[string(topic)]
+			if !ok {
+				offset = bkt.Sequence()
+			}
+			var keys [][]byte
+			c := bkt.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if binary.BigEndian.Uint64(k) <= offset {
+					keys = append(keys, k)
+					continue
+				}
+				if len(v) >= 8 && binary.BigEndian.Uint64(v[:8]) < cutoff {
+					keys = append(keys, k)
+					continue
+				}
+				break
+			}
+			for _, k := range keys {
+				if err := bkt.Delete(k); err != nil {
+					return err
+				}
+			}
+			removed += len(keys)
+			return nil
+		})
+	})
+	return removed, errors.Wrap(err, "prune pubsub events")
+}
+
+func (p *PubSub) runPruner() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := p.Prune()
+		if err != nil {
+			level.Info(p.logger).Log("msg", "prune pubsub events", "err", err)
+		} else if n > 0 {
+			level.Debug(p.logger).Log("msg", "pruned pubsub events", "deleted", n)
+		}
+	}
+}
+
+// Stats reports the backlog of the subscriptions of this process.
+func (p *PubSub) Stats() []pubsub.SubscriptionStats {
+	p.mtx.Lock()
+	subs := make([]subscription, 0, len(p.subscriptions))
+	for _, sub := range p.subscriptions {
+		subs = append(subs, subscription{
+			name:      sub.name,
+			topic:     sub.topic,
+			delivered: atomic.LoadUint64(&sub.delivered),
+		})
+	}
+	p.mtx.Unlock()
+
+	now := time.Now()
+	stats := make([]pubsub.SubscriptionStats, 0, len(subs))
+	p.db.View(func(tx *bolt.Tx) error {
+		for _, sub := range subs {
+			s := pubsub.SubscriptionStats{Name: sub.name, Topic: sub.topic, Delivered: sub.delivered}
+			bkt := tx.Bucket([]byte(EventBucket)).Bucket([]byte(sub.topic))
+			v := tx.Bucket([]byte(OffsetBucket)).Get([]byte(subscriptionKey(sub.topic, sub.name)))
+			if bkt != nil && v != nil {
+				offset := binary.BigEndian.Uint64(v)
+				s.Depth = int(bkt.Sequence() - offset)
+				if _, ev := bkt.Cursor().Seek(seqKey(offset + 1)); len(ev) >= 8 {
+					s.Lag = now.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(ev[:8]))))
+				}
+			}
+			stats = append(stats, s)
+		}
+		return nil
+	})
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Topic != stats[j].Topic {
+			return stats[i].Topic < stats[j].Topic
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
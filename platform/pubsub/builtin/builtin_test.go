@@ -0,0 +1,298 @@
+package builtin
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/liuds832/micromdm/platform/pubsub"
+)
+
+func setupDB(t *testing.T) (*bolt.DB, func()) {
+	f, err := ioutil.TempFile("", "bolt-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0777, nil)
+	if err != nil {
+		t.Fatalf("couldn't open bolt, err %s\n", err)
+	}
+	return db, func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func receive(t *testing.T, events <-chan pubsub.Event) string {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return string(ev.Message)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return ""
+}
+
+// waitOffset waits until the subscription committed the offset want.
+func waitOffset(t *testing.T, db *bolt.DB, topic, name string, want uint64) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		var offset uint64
+		db.View(func(tx *bolt.Tx) error {
+			if v := tx.Bucket([]byte(OffsetBucket)).Get([]byte(subscriptionKey(topic, name))); v != nil {
+				offset = binary.BigEndian.Uint64(v)
+			}
+			return nil
+		})
+		if offset == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for offset %d of subscription %s", want, name)
+}
+
+func TestPubSub(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	ps, err := NewPubSub(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := ps.Publish(ctx, "a", []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	subA, err := ps.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subB, err := ps.Subscribe(ctx, "bsub", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Subscribe(ctx, "asub", "a"); err == nil {
+		t.Error("expected error for a duplicate subscription")
+	}
+
+	for _, msg := range []string{"a1", "a2"} {
+		if err := ps.Publish(ctx, "a", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.Publish(ctx, "b", []byte("b1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new subscription starts after the events published before it.
+	for _, want := range []string{"a1", "a2"} {
+		if have := receive(t, subA); have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	}
+	if have, want := receive(t, subB), "b1"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+func TestSubscribe_Replay(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	ps, err := NewPubSub(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := ps.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a1", "a2", "a3"} {
+		if err := ps.Publish(ctx, "a", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"a1", "a2"} {
+		if have := receive(t, sub); have != want {
+			t.Fatalf("have %s, want %s", have, want)
+		}
+	}
+	waitOffset(t, db, "a", "asub", 1)
+	cancel()
+
+	// a new PubSub on the same database replays the events which the
+	// subscription did not process. a2 may still be processed when the
+	// subscription ends, so it is delivered again.
+	ps, err = NewPubSub(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = context.Background()
+	sub, err = ps.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a2", "a3"} {
+		if have := receive(t, sub); have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	ps, err := NewPubSub(db, WithMaxAge(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sub, err := ps.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a1", "a2", "a3"} {
+		if err := ps.Publish(ctx, "a", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// no subscription receives events of topic b.
+	if err := ps.Publish(ctx, "b", []byte("b1")); err != nil {
+		t.Fatal(err)
+	}
+	// a1 was processed once a2 is received.
+	receive(t, sub)
+	receive(t, sub)
+	waitOffset(t, db, "a", "asub", 1)
+
+	removed, err := ps.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := removed, 2; have != want {
+		t.Fatalf("have %d removed events, want %d", have, want)
+	}
+	if have, want := receive(t, sub), "a3"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+func TestSubscribe_AfterCancel(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	ps, err := NewPubSub(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := ps.Subscribe(ctx, "asub", "a"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// the ended subscription is replaced without waiting for its removal.
+	sub, err := ps.Subscribe(context.Background(), "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish(context.Background(), "a", []byte("a1")); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := receive(t, sub), "a1"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+func TestPrune_RemovedSubscription(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	ps, err := NewPubSub(db, WithMaxAge(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := ps.Subscribe(ctx, "removed", "a"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// after a restart only asub subscribes to the topic.
+	ps, err = NewPubSub(db, WithMaxAge(0), WithOffsetGracePeriod(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := ps.Subscribe(context.Background(), "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a1", "a2"} {
+		if err := ps.Publish(context.Background(), "a", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive(t, sub)
+	receive(t, sub)
+	waitOffset(t, db, "a", "asub", 1)
+
+	removed, err := ps.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := removed, 1; have != want {
+		t.Errorf("have %d removed events, want %d", have, want)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(OffsetBucket)).Get([]byte(subscriptionKey("a", "removed"))); v != nil {
+			t.Error("offset of the removed subscription was kept")
+		}
+		return nil
+	})
+}
+
+func TestPrune_OffsetGracePeriod(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+	ps, err := NewPubSub(db, WithMaxAge(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := ps.Subscribe(ctx, "late", "a"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := ps.Publish(context.Background(), "a", []byte("a1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart the subscription is made again only after a prune.
+	ps, err = NewPubSub(db, WithMaxAge(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := ps.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 0 {
+		t.Errorf("have %d removed events, want none within the grace period", removed)
+	}
+	sub, err := ps.Subscribe(context.Background(), "late", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := receive(t, sub), "a1"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
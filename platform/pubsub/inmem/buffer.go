@@ -0,0 +1,385 @@
+package inmem
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/pubsub"
+)
+
+// OverflowPolicy decides what happens to an event which is published
+// while the buffer of a subscription is full.
+type OverflowPolicy string
+
+const (
+	// Block makes Publish wait until the subscriber catches up, the
+	// publish context is done or the publish timeout passed. The event is
+	// dropped for the subscription if it did not catch up.
+	Block OverflowPolicy = "block"
+
+	// DropOldest discards the oldest buffered event.
+	DropOldest OverflowPolicy = "drop-oldest"
+
+	// Spill writes the event to a file until the subscriber catches up.
+	// Once the file reaches the spill limit, the oldest events are
+	// dropped like with DropOldest.
+	Spill OverflowPolicy = "spill"
+)
+
+// ParseOverflowPolicy returns the OverflowPolicy named s.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case Block, DropOldest, Spill:
+		return p, nil
+	default:
+		return "", errors.Errorf("invalid pubsub overflow policy: %s", s)
+	}
+}
+
+// errClosed is returned by pop once the subscription ended.
+var errClosed = errors.New("pubsub subscription ended")
+
+type queuedEvent struct {
+	event     pubsub.Event
+	published time.Time
+}
+
+// buffer holds the events of a subscription which were published but not
+// received yet, in publish order.
+type buffer struct {
+	size       int
+	policy     OverflowPolicy
+	spillDir   string
+	spillLimit int64
+	name       string
+
+	mtx      sync.Mutex
+	notEmpty *sync.Cond
+	// space is closed and replaced whenever an event leaves the buffer.
+	space  chan struct{}
+	closed bool
+
+	events   []queuedEvent
+	inflight *queuedEvent
+	spill    *spillFile
+
+	delivered uint64
+	dropped   uint64
+}
+
+func newBuffer(name string, size int, policy OverflowPolicy, spillDir string, spillLimit int64) *buffer {
+	b := &buffer{
+		size:       size,
+		policy:     policy,
+		spillDir:   spillDir,
+		spillLimit: spillLimit,
+		name:       name,
+		space:      make(chan struct{}),
+	}
+	b.notEmpty = sync.NewCond(&b.mtx)
+	return b
+}
+
+// push adds an event to the buffer. With the Block policy it waits at most
+// timeout for the subscriber to make room, or until ctx is done. A zero
+// timeout waits as long as ctx allows.
+func (b *buffer) push(ctx context.Context, ev pubsub.Event, timeout time.Duration) error {
+	qe := queuedEvent{event: ev, published: time.Now()}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed {
+		return nil
+	}
+	switch b.policy {
+	case DropOldest:
+		if len(b.events) >= b.size {
+			b.events = b.events[1:]
+			b.dropped++
+		}
+	case Spill:
+		// once events spilled, newer events follow them to keep the order.
+		if len(b.events) >= b.size || b.spill.len() > 0 {
+			if b.spill == nil {
+				spill, err := newSpillFile(b.spillDir, b.name)
+				if err != nil {
+					return err
+				}
+				b.spill = spill
+			}
+			for b.spill.len() > 0 && b.spill.size()+recordSize(qe) > b.spillLimit {
+				if err := b.dropOldest(); err != nil {
+					return err
+				}
+			}
+			if err := b.spill.write(qe); err != nil {
+				return err
+			}
+			b.notEmpty.Signal()
+			return nil
+		}
+	default:
+		var expired <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		for len(b.events) >= b.size && !b.closed {
+			space := b.space
+			b.mtx.Unlock()
+			var err error
+			select {
+			case <-space:
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-expired:
+				err = errors.Errorf("subscriber did not receive events for %s", timeout)
+			}
+			b.mtx.Lock()
+			if err != nil {
+				b.dropped++
+				return errors.Wrap(err, "drop event for full buffer")
+			}
+		}
+		if b.closed {
+			return nil
+		}
+	}
+	b.events = append(b.events, qe)
+	b.notEmpty.Signal()
+	return nil
+}
+
+// dropOldest discards the oldest waiting event to make room in the spill
+// file. The oldest spilled event moves to memory in its place.
+func (b *buffer) dropOldest() error {
+	b.dropped++
+	if len(b.events) == 0 {
+		_, err := b.readSpill()
+		return err
+	}
+	b.events = b.events[1:]
+	qe, err := b.readSpill()
+	if err != nil {
+		return err
+	}
+	b.events = append(b.events, qe)
+	return nil
+}
+
+// readSpill reads the oldest spilled event. The spilled events are dropped
+// if the file can't be read.
+func (b *buffer) readSpill() (queuedEvent, error) {
+	qe, err := b.spill.read()
+	if err != nil {
+		b.dropped += uint64(b.spill.len())
+		b.spill.reset()
+	}
+	return qe, err
+}
+
+// pop waits for the next event and marks it in flight until done is
+// called.
+func (b *buffer) pop() (queuedEvent, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for len(b.events) == 0 && b.spill.len() == 0 && !b.closed {
+		b.notEmpty.Wait()
+	}
+	var qe queuedEvent
+	if b.closed {
+		return qe, errClosed
+	}
+	if len(b.events) > 0 {
+		qe = b.events[0]
+		b.events = b.events[1:]
+		close(b.space)
+		b.space = make(chan struct{})
+	} else {
+		var err error
+		if qe, err = b.readSpill(); err != nil {
+			return qe, err
+		}
+	}
+	b.inflight = &qe
+	return qe, nil
+}
+
+// close ends the subscription. It wakes the subscription and waiting
+// publishers and releases the buffered events.
+func (b *buffer) close() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.events = nil
+	if b.spill != nil {
+		b.spill.f.Close()
+		b.spill = nil
+	}
+	b.notEmpty.Broadcast()
+	close(b.space)
+}
+
+func (b *buffer) done() {
+	b.mtx.Lock()
+	b.inflight = nil
+	b.delivered++
+	b.mtx.Unlock()
+}
+
+func (b *buffer) stats(now time.Time) pubsub.SubscriptionStats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	stats := pubsub.SubscriptionStats{
+		Depth:     len(b.events) + b.spill.len(),
+		Delivered: b.delivered,
+		Dropped:   b.dropped,
+	}
+	var oldest time.Time
+	switch {
+	case b.inflight != nil:
+		oldest = b.inflight.published
+		stats.Depth++
+	case len(b.events) > 0:
+		oldest = b.events[0].published
+	case b.spill.len() > 0:
+		oldest = b.spill.oldest()
+	}
+	if !oldest.IsZero() {
+		stats.Lag = now.Sub(oldest)
+	}
+	return stats
+}
+
+// spillFile stores events in a file as the publish time, the length of
+// the topic, the length of the message, the topic and the message.
+type spillFile struct {
+	f       *os.File
+	readAt  int64
+	writeAt int64
+	count   int
+	head    time.Time
+}
+
+func newSpillFile(dir, name string) (*spillFile, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrap(err, "create pubsub spill directory")
+		}
+	}
+	f, err := ioutil.TempFile(dir, "pubsub-"+name+"-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create pubsub spill file")
+	}
+	// the file is only used while the process runs.
+	os.Remove(f.Name())
+	return &spillFile{f: f}, nil
+}
+
+const spillHeaderSize = 16
+
+// spillCompactSize is the size of the read part of a spill file from which
+// on it is compacted, once it is larger than the unread part.
+const spillCompactSize = 1 << 20
+
+func recordSize(qe queuedEvent) int64 {
+	return int64(spillHeaderSize + len(qe.event.Topic) + len(qe.event.Message))
+}
+
+func (s *spillFile) len() int {
+	if s == nil {
+		return 0
+	}
+	return s.count
+}
+
+// size returns the number of bytes of the unread events.
+func (s *spillFile) size() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.writeAt - s.readAt
+}
+
+func (s *spillFile) oldest() time.Time {
+	return s.head
+}
+
+func (s *spillFile) write(qe queuedEvent) error {
+	rec := make([]byte, spillHeaderSize, spillHeaderSize+len(qe.event.Topic)+len(qe.event.Message))
+	binary.BigEndian.PutUint64(rec[0:8], uint64(qe.published.UnixNano()))
+	binary.BigEndian.PutUint32(rec[8:12], uint32(len(qe.event.Topic)))
+	binary.BigEndian.PutUint32(rec[12:16], uint32(len(qe.event.Message)))
+	rec = append(rec, qe.event.Topic...)
+	rec = append(rec, qe.event.Message...)
+	if _, err := s.f.WriteAt(rec, s.writeAt); err != nil {
+		return errors.Wrap(err, "write pubsub spill file")
+	}
+	if s.count == 0 {
+		s.head = qe.published
+	}
+	s.writeAt += int64(len(rec))
+	s.count++
+	return nil
+}
+
+func (s *spillFile) reset() {
+	s.readAt, s.writeAt, s.count = 0, 0, 0
+	s.head = time.Time{}
+	s.f.Truncate(0)
+}
+
+func (s *spillFile) read() (queuedEvent, error) {
+	var qe queuedEvent
+	hdr := make([]byte, spillHeaderSize)
+	if _, err := s.f.ReadAt(hdr, s.readAt); err != nil {
+		return qe, errors.Wrap(err, "read pubsub spill file")
+	}
+	topicLen := int(binary.BigEndian.Uint32(hdr[8:12]))
+	msgLen := int(binary.BigEndian.Uint32(hdr[12:16]))
+	body := make([]byte, topicLen+msgLen)
+	if _, err := s.f.ReadAt(body, s.readAt+spillHeaderSize); err != nil {
+		return qe, errors.Wrap(err, "read pubsub spill file")
+	}
+	qe.published = time.Unix(0, int64(binary.BigEndian.Uint64(hdr[0:8])))
+	qe.event = pubsub.Event{Topic: string(body[:topicLen]), Message: body[topicLen:]}
+	s.readAt += int64(spillHeaderSize + len(body))
+	s.count--
+
+	if s.count == 0 {
+		s.reset()
+		return qe, nil
+	}
+	if s.readAt >= spillCompactSize && s.readAt >= s.size() {
+		s.compact()
+	}
+	if _, err := s.f.ReadAt(hdr[:8], s.readAt); err == nil {
+		s.head = time.Unix(0, int64(binary.BigEndian.Uint64(hdr[0:8])))
+	}
+	return qe, nil
+}
+
+// compact moves the unread events to the start of the file, so that the
+// file doesn't grow while the subscriber never fully catches up. The read
+// part is at least as large as the unread part, so the copy doesn't
+// overwrite unread events. The file is left as it is if the copy fails.
+func (s *spillFile) compact() {
+	unread := make([]byte, s.size())
+	if _, err := s.f.ReadAt(unread, s.readAt); err != nil {
+		return
+	}
+	if _, err := s.f.WriteAt(unread, 0); err != nil {
+		return
+	}
+	s.readAt, s.writeAt = 0, int64(len(unread))
+	s.f.Truncate(s.writeAt)
+}
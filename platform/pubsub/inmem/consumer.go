@@ -3,32 +3,71 @@ package inmem
 import (
 	"context"
 
+	"github.com/go-kit/kit/log/level"
+
 	"github.com/liuds832/micromdm/platform/pubsub"
 )
 
-func (p *Inmem) Subscribe(_ context.Context, name, topic string) (<-chan pubsub.Event, error) {
+// Subscribe delivers the events published to topic from now on. The
+// subscription and its buffered events are removed and the channel is
+// closed when ctx is done.
+func (p *Inmem) Subscribe(ctx context.Context, name, topic string) (<-chan pubsub.Event, error) {
 	events := make(chan pubsub.Event)
-	sub := subscription{
+	sub := &subscription{
 		name:      name,
 		topic:     topic,
 		eventChan: events,
+		buf:       newBuffer(name, p.bufferSize, p.policy, p.spillDir, p.spillLimit),
 	}
 	p.mtx.Lock()
 	p.subscriptions[topic] = append(p.subscriptions[topic], sub)
 	p.mtx.Unlock()
 
+	if done := ctx.Done(); done != nil {
+		go func() {
+			<-done
+			sub.buf.close()
+		}()
+	}
+	go p.deliver(ctx, sub)
 	return events, nil
 }
 
-func (p *Inmem) dispatch() {
+// deliver sends the buffered events of a subscription in order until the
+// subscription ends.
+func (p *Inmem) deliver(ctx context.Context, sub *subscription) {
+	defer close(sub.eventChan)
+	defer p.unsubscribe(sub)
 	for {
+		qe, err := sub.buf.pop()
+		if err == errClosed {
+			return
+		}
+		if err != nil {
+			level.Info(p.logger).Log("msg", "read pubsub event", "topic", sub.topic, "subscription", sub.name, "err", err)
+			continue
+		}
 		select {
-		case ev := <-p.publish:
-			p.mtx.Lock()
-			for _, sub := range p.subscriptions[ev.Topic] {
-				go func(s subscription) { s.eventChan <- ev }(sub)
-			}
-			p.mtx.Unlock()
+		case sub.eventChan <- qe.event:
+		case <-ctx.Done():
+			return
+		}
+		sub.buf.done()
+	}
+}
+
+func (p *Inmem) unsubscribe(sub *subscription) {
+	sub.buf.close()
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	subs := p.subscriptions[sub.topic]
+	for i, s := range subs {
+		if s == sub {
+			p.subscriptions[sub.topic] = append(subs[:i:i], subs[i+1:]...)
+			break
 		}
 	}
+	if len(p.subscriptions[sub.topic]) == 0 {
+		delete(p.subscriptions, sub.topic)
+	}
 }
@@ -3,8 +3,13 @@ package inmem
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/liuds832/micromdm/platform/pubsub"
 )
 
 func TestPubSub(t *testing.T) {
@@ -47,3 +52,264 @@ func TestPubSub(t *testing.T) {
 		}
 	}
 }
+
+func receive(t *testing.T, events <-chan pubsub.Event) string {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return string(ev.Message)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return ""
+}
+
+func TestPubSub_Order(t *testing.T) {
+	ctx := context.Background()
+	inmem := NewPubSub(WithBufferSize(4), WithOverflowPolicy(Block))
+	sub, err := inmem.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for i := 0; i < 100; i++ {
+			inmem.Publish(ctx, "a", []byte(strconv.Itoa(i)))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if have, want := receive(t, sub), strconv.Itoa(i); have != want {
+			t.Fatalf("have %s, want %s", have, want)
+		}
+	}
+}
+
+func TestPubSub_DropOldest(t *testing.T) {
+	ctx := context.Background()
+	inmem := NewPubSub(WithBufferSize(2), WithOverflowPolicy(DropOldest))
+	sub, err := inmem.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first event is in flight once the subscription picked it up.
+	inmem.Publish(ctx, "a", []byte("0"))
+	buf := inmem.subscriptions["a"][0].buf
+	for i := 0; i < 100; i++ {
+		buf.mtx.Lock()
+		inflight := buf.inflight != nil
+		buf.mtx.Unlock()
+		if inflight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, msg := range []string{"1", "2", "3"} {
+		inmem.Publish(ctx, "a", []byte(msg))
+	}
+
+	stats := inmem.Stats()
+	if have, want := stats[0].Dropped, uint64(1); have != want {
+		t.Errorf("have %d dropped events, want %d", have, want)
+	}
+	for _, want := range []string{"0", "2", "3"} {
+		if have := receive(t, sub); have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	}
+}
+
+func TestPubSub_Spill(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pubsub-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inmem := NewPubSub(WithBufferSize(2), WithOverflowPolicy(Spill), WithSpillDir(dir))
+	sub, err := inmem.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		inmem.Publish(ctx, "a", []byte(strconv.Itoa(i)))
+	}
+	waitDepth(t, inmem, 10)
+	if lag := inmem.Stats()[0].Lag; lag <= 0 {
+		t.Errorf("expected lag for waiting events, got %s", lag)
+	}
+
+	for i := 0; i < 10; i++ {
+		if have, want := receive(t, sub), strconv.Itoa(i); have != want {
+			t.Fatalf("have %s, want %s", have, want)
+		}
+	}
+	waitDepth(t, inmem, 0)
+	if have, want := inmem.Stats()[0].Dropped, uint64(0); have != want {
+		t.Errorf("have %d dropped events, want %d", have, want)
+	}
+}
+
+func TestPubSub_SpillLimit(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "pubsub-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// every event takes 18 bytes in the spill file.
+	inmem := NewPubSub(WithBufferSize(2), WithOverflowPolicy(Spill), WithSpillDir(dir), WithSpillLimit(36))
+	sub, err := inmem.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmem.Publish(ctx, "a", []byte("0"))
+	waitDepth(t, inmem, 1)
+	buf := inmem.subscriptions["a"][0].buf
+	for i := 0; i < 100; i++ {
+		buf.mtx.Lock()
+		inflight := buf.inflight != nil
+		buf.mtx.Unlock()
+		if inflight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < 10; i++ {
+		inmem.Publish(ctx, "a", []byte(strconv.Itoa(i)))
+	}
+
+	if have, want := inmem.Stats()[0].Dropped, uint64(5); have != want {
+		t.Errorf("have %d dropped events, want %d", have, want)
+	}
+	for _, want := range []string{"0", "6", "7", "8", "9"} {
+		if have := receive(t, sub); have != want {
+			t.Fatalf("have %s, want %s", have, want)
+		}
+	}
+}
+
+func TestSpillFile_Compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spill, err := newSpillFile(dir, "asub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spill.f.Close()
+
+	msg := make([]byte, 64<<10)
+	write := func(i int) {
+		ev := pubsub.Event{Topic: strconv.Itoa(i), Message: msg}
+		if err := spill.write(queuedEvent{event: ev, published: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		write(i)
+	}
+	// the subscriber never catches up fully.
+	for i := 0; i < 100; i++ {
+		write(i + 10)
+		qe, err := spill.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := qe.event.Topic, strconv.Itoa(i); have != want {
+			t.Fatalf("have %s, want %s", have, want)
+		}
+	}
+
+	fi, err := spill.f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max := int64(3 * spillCompactSize); fi.Size() > max {
+		t.Errorf("have spill file of %d bytes, want at most %d", fi.Size(), max)
+	}
+}
+
+func TestPubSub_BlockTimeout(t *testing.T) {
+	ctx := context.Background()
+	inmem := NewPubSub(WithBufferSize(1), WithOverflowPolicy(Block), WithPublishTimeout(10*time.Millisecond))
+	// nobody reads the subscription.
+	if _, err := inmem.Subscribe(ctx, "asub", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 4; i++ {
+			inmem.Publish(ctx, "a", []byte(strconv.Itoa(i)))
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked by a subscriber which does not receive")
+	}
+	// one event is in flight and one is buffered.
+	if have, want := inmem.Stats()[0].Dropped, uint64(2); have != want {
+		t.Errorf("have %d dropped events, want %d", have, want)
+	}
+}
+
+func TestPubSub_SubscriptionCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	inmem := NewPubSub(WithBufferSize(1), WithOverflowPolicy(Block), WithPublishTimeout(0))
+	sub, err := inmem.Subscribe(ctx, "asub", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		inmem.Publish(context.Background(), "a", []byte(strconv.Itoa(i)))
+	}
+
+	// a publisher waiting for the subscriber is released by the cancel.
+	published := make(chan struct{})
+	go func() {
+		inmem.Publish(context.Background(), "a", []byte("2"))
+		close(published)
+	}()
+	cancel()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked by a canceled subscription")
+	}
+
+	timeout := time.After(time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-sub:
+			closed = !ok
+		case <-timeout:
+			t.Fatal("subscription channel was not closed after cancel")
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if len(inmem.Stats()) == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("have subscriptions %+v after cancel, want none", inmem.Stats())
+}
+
+// waitDepth waits until the only subscription has want events waiting.
+func waitDepth(t *testing.T, inmem *Inmem, want int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if inmem.Stats()[0].Depth == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("have depth %d, want %d", inmem.Stats()[0].Depth, want)
+}
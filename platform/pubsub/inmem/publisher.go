@@ -2,37 +2,147 @@ package inmem
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 
 	"github.com/liuds832/micromdm/platform/pubsub"
 )
 
-func NewPubSub() *Inmem {
-	publish := make(chan pubsub.Event)
-	subscriptions := make(map[string][]subscription)
+// DefaultBufferSize is the number of events buffered for each
+// subscription before the overflow policy applies.
+const DefaultBufferSize = 1024
+
+// DefaultSpillLimit is the largest size in bytes of the spill file of a
+// subscription with the Spill policy.
+const DefaultSpillLimit = 64 << 20
+
+// DefaultPublishTimeout is the longest time Publish waits for a
+// subscriber with the Block policy.
+const DefaultPublishTimeout = 10 * time.Second
+
+type Option func(*Inmem)
+
+func WithLogger(logger log.Logger) Option {
+	return func(p *Inmem) {
+		p.logger = logger
+	}
+}
+
+// WithBufferSize sets the number of events buffered in memory for each
+// subscription.
+func WithBufferSize(size int) Option {
+	return func(p *Inmem) {
+		if size > 0 {
+			p.bufferSize = size
+		}
+	}
+}
+
+// WithOverflowPolicy sets what happens when an event is published while
+// the buffer of a subscription is full. The default is Spill.
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(p *Inmem) {
+		p.policy = policy
+	}
+}
+
+// WithPublishTimeout sets the longest time Publish waits for a subscriber
+// with the Block policy. Zero waits until the publish context is done.
+func WithPublishTimeout(timeout time.Duration) Option {
+	return func(p *Inmem) {
+		p.publishTimeout = timeout
+	}
+}
+
+// WithSpillDir sets the directory of the files used by the Spill policy.
+// The default is the system temporary directory.
+func WithSpillDir(dir string) Option {
+	return func(p *Inmem) {
+		p.spillDir = dir
+	}
+}
+
+// WithSpillLimit sets the largest size in bytes of the spill file of each
+// subscription. Beyond it the oldest events are dropped.
+func WithSpillLimit(limit int64) Option {
+	return func(p *Inmem) {
+		if limit > 0 {
+			p.spillLimit = limit
+		}
+	}
+}
+
+func NewPubSub(opts ...Option) *Inmem {
 	inmem := &Inmem{
-		publish:       publish,
-		subscriptions: subscriptions,
+		logger:         log.NewNopLogger(),
+		bufferSize:     DefaultBufferSize,
+		policy:         Spill,
+		publishTimeout: DefaultPublishTimeout,
+		spillLimit:     DefaultSpillLimit,
+		subscriptions:  make(map[string][]*subscription),
+	}
+	for _, fn := range opts {
+		fn(inmem)
 	}
-	go inmem.dispatch()
 	return inmem
 }
 
 type Inmem struct {
-	mtx           sync.RWMutex
-	subscriptions map[string][]subscription
+	logger         log.Logger
+	bufferSize     int
+	policy         OverflowPolicy
+	publishTimeout time.Duration
+	spillDir       string
+	spillLimit     int64
 
-	publish chan pubsub.Event
+	mtx           sync.RWMutex
+	subscriptions map[string][]*subscription
 }
 
 type subscription struct {
 	name      string
 	topic     string
 	eventChan chan<- pubsub.Event
+	buf       *buffer
 }
 
-func (p *Inmem) Publish(_ context.Context, topic string, msg []byte) error {
+// Publish adds the event to the buffer of every subscription of topic.
+// Events reach each subscription in the order they were published.
+func (p *Inmem) Publish(ctx context.Context, topic string, msg []byte) error {
 	event := pubsub.Event{Topic: topic, Message: msg}
-	go func() { p.publish <- event }()
+	p.mtx.RLock()
+	subs := p.subscriptions[topic]
+	p.mtx.RUnlock()
+	for _, sub := range subs {
+		if err := sub.buf.push(ctx, event, p.publishTimeout); err != nil {
+			level.Info(p.logger).Log("msg", "buffer pubsub event", "topic", topic, "subscription", sub.name, "err", err)
+		}
+	}
 	return nil
 }
+
+// Stats reports the backlog of every subscription.
+func (p *Inmem) Stats() []pubsub.SubscriptionStats {
+	now := time.Now()
+	var stats []pubsub.SubscriptionStats
+	p.mtx.RLock()
+	for _, subs := range p.subscriptions {
+		for _, sub := range subs {
+			s := sub.buf.stats(now)
+			s.Name, s.Topic = sub.name, sub.topic
+			stats = append(stats, s)
+		}
+	}
+	p.mtx.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Topic != stats[j].Topic {
+			return stats[i].Topic < stats[j].Topic
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
@@ -0,0 +1,489 @@
+// Package pg implements a Postgres backed pubsub.PublishSubscriber which
+// delivers events across multiple micromdm instances.
+//
+// Events are stored in the pubsub_events table and a NOTIFY on every
+// publish wakes the subscribers of all instances. By default the
+// subscriptions of all instances which use the same name share a stored
+// offset and compete for events, so each event is received by one of
+// them. An instance claims a batch of events, hands them to its subscriber
+// and then moves the offset past the received events, so an event is
+// received again if its instance stops before storing the offset. Broadcast
+// subscriptions receive every event published after they subscribed in
+// each instance.
+package pg
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	sq "gopkg.in/Masterminds/squirrel.v1"
+
+	"github.com/liuds832/micromdm/platform/pubsub"
+)
+
+const (
+	eventsTable        = "pubsub_events"
+	subscriptionsTable = "pubsub_subscriptions"
+
+	// notifyChannel is the channel of the NOTIFY sent for every event.
+	// The payload is the topic.
+	notifyChannel = "micromdm_pubsub"
+)
+
+const (
+	// DefaultRetention is the age after which events are deleted.
+	DefaultRetention = 24 * time.Hour
+
+	// pollInterval wakes all subscriptions in case a notification was
+	// missed while the listener reconnected.
+	pollInterval  = 5 * time.Second
+	pruneInterval = 10 * time.Minute
+	readBatchSize = 100
+	retryInterval = time.Second
+
+	// claimTimeout is the time after which the events claimed by an
+	// instance which did not release them are claimed again.
+	claimTimeout = time.Minute
+)
+
+type PubSub struct {
+	db        *sqlx.DB
+	logger    log.Logger
+	retention time.Duration
+	broadcast map[string]bool
+	listener  *pq.Listener
+
+	mtx           sync.Mutex
+	subscriptions []*subscription
+}
+
+type subscription struct {
+	name      string
+	topic     string
+	broadcast bool
+	events    chan pubsub.Event
+	wake      chan struct{}
+
+	// offset is the last received event of a broadcast subscription.
+	offset    int64
+	delivered uint64
+}
+
+type Option func(*PubSub)
+
+func WithLogger(logger log.Logger) Option {
+	return func(p *PubSub) {
+		p.logger = logger
+	}
+}
+
+// WithRetention deletes events older than retention.
+func WithRetention(retention time.Duration) Option {
+	return func(p *PubSub) {
+		p.retention = retention
+	}
+}
+
+// WithBroadcast makes the subscriptions with the given names receive
+// every event in each instance, instead of competing for events with the
+// subscriptions of the same name in other instances. Use it for
+// subscriptions which keep state of the instance current.
+func WithBroadcast(names ...string) Option {
+	return func(p *PubSub) {
+		for _, name := range names {
+			p.broadcast[name] = true
+		}
+	}
+}
+
+// NewPubSub creates a PubSub which stores events in db. The dsn of db is
+// used to LISTEN for new events on a dedicated connection.
+func NewPubSub(db *sqlx.DB, dsn string, opts ...Option) (*PubSub, error) {
+	p := &PubSub{
+		db:        db,
+		logger:    log.NewNopLogger(),
+		retention: DefaultRetention,
+		broadcast: make(map[string]bool),
+	}
+	for _, fn := range opts {
+		fn(p)
+	}
+
+	p.listener = pq.NewListener(dsn, retryInterval, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			level.Info(p.logger).Log("msg", "pubsub listener", "err", err)
+		}
+	})
+	if err := p.listener.Listen(notifyChannel); err != nil {
+		p.listener.Close()
+		return nil, errors.Wrapf(err, "listen on channel %s", notifyChannel)
+	}
+	go p.listen()
+	go p.runPruner()
+	return p, nil
+}
+
+// Close stops listening for new events.
+func (p *PubSub) Close() error {
+	return p.listener.Close()
+}
+
+// Publish stores the event and notifies the subscribers of all instances.
+func (p *PubSub) Publish(ctx context.Context, topic string, msg []byte) error {
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	// events of a topic commit in the order of their ids, so readers
+	// never skip an event which commits late.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "pubsub:"+topic); err != nil {
+		return errors.Wrapf(err, "lock topic %s", topic)
+	}
+
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Insert(eventsTable).
+		Columns("topic", "message").
+		Values(topic, msg).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrapf(err, "store event on topic: %s", topic)
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, topic); err != nil {
+		return errors.Wrapf(err, "notify event on topic: %s", topic)
+	}
+	return errors.Wrap(tx.Commit(), "commit event")
+}
+
+// Subscribe delivers the events of topic published after the first
+// subscription with name subscribed. The subscription ends when ctx is
+// done.
+func (p *PubSub) Subscribe(ctx context.Context, name, topic string) (<-chan pubsub.Event, error) {
+	sub := &subscription{
+		name:      name,
+		topic:     topic,
+		broadcast: p.broadcast[name],
+		events:    make(chan pubsub.Event),
+		wake:      make(chan struct{}, 1),
+	}
+
+	if sub.broadcast {
+		err := p.db.GetContext(ctx, &sub.offset,
+			`SELECT COALESCE(MAX(id), 0) FROM pubsub_events WHERE topic = $1`, topic)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get last event on topic %s", topic)
+		}
+	} else {
+		_, err := p.db.ExecContext(ctx, `
+			INSERT INTO pubsub_subscriptions (topic, name, last_id)
+			SELECT $1, $2, COALESCE(MAX(id), 0) FROM pubsub_events WHERE topic = $1
+			ON CONFLICT (topic, name) DO NOTHING`, topic, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "create subscription %s on topic %s", name, topic)
+		}
+	}
+
+	p.mtx.Lock()
+	p.subscriptions = append(p.subscriptions, sub)
+	p.mtx.Unlock()
+
+	go p.deliver(ctx, sub)
+	return sub.events, nil
+}
+
+type storedEvent struct {
+	ID      int64  `db:"id"`
+	Message []byte `db:"message"`
+}
+
+// claim returns the next events of a competing subscription and claims
+// them for this instance until the returned time. The subscriptions of
+// other instances skip the claimed events until they are released or the
+// claim expired. It returns no events if another instance holds a claim.
+func (p *PubSub) claim(ctx context.Context, sub *subscription) ([]storedEvent, time.Time, error) {
+	var until time.Time
+	tx, err := p.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, until, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	var state struct {
+		LastID  int64 `db:"last_id"`
+		Claimed bool  `db:"claimed"`
+	}
+	err = tx.GetContext(ctx, &state, `
+		SELECT last_id, COALESCE(claimed_until > (now() at time zone 'utc'), false) AS claimed
+		FROM pubsub_subscriptions WHERE topic = $1 AND name = $2 FOR UPDATE`,
+		sub.topic, sub.name)
+	if err != nil {
+		return nil, until, errors.Wrapf(err, "lock subscription %s on topic %s", sub.name, sub.topic)
+	}
+	if state.Claimed {
+		return nil, until, nil
+	}
+
+	var events []storedEvent
+	err = tx.SelectContext(ctx, &events,
+		`SELECT id, message FROM pubsub_events WHERE topic = $1 AND id > $2 ORDER BY id LIMIT $3`,
+		sub.topic, state.LastID, readBatchSize)
+	if err != nil {
+		return nil, until, errors.Wrapf(err, "get events on topic %s", sub.topic)
+	}
+	if len(events) == 0 {
+		return nil, until, nil
+	}
+
+	err = tx.GetContext(ctx, &until, `
+		UPDATE pubsub_subscriptions
+		SET claimed_until = (now() at time zone 'utc') + $3 * interval '1 second'
+		WHERE topic = $1 AND name = $2
+		RETURNING claimed_until`,
+		sub.topic, sub.name, claimTimeout.Seconds())
+	if err != nil {
+		return nil, until, errors.Wrapf(err, "claim events of subscription %s on topic %s", sub.name, sub.topic)
+	}
+	return events, until, errors.Wrap(tx.Commit(), "commit claim")
+}
+
+// release moves the offset of a competing subscription to lastID and
+// releases the claim which expires at until, unless the claim expired and
+// another instance claimed the events since.
+func (p *PubSub) release(sub *subscription, lastID int64, until time.Time) error {
+	// the offset of the received events is stored after ctx is done.
+	_, err := p.db.Exec(`
+		UPDATE pubsub_subscriptions
+		SET last_id = GREATEST(last_id, $3),
+			claimed_until = CASE WHEN claimed_until = $4 THEN NULL ELSE claimed_until END
+		WHERE topic = $1 AND name = $2`,
+		sub.topic, sub.name, lastID, until)
+	return errors.Wrapf(err, "update subscription %s on topic %s", sub.name, sub.topic)
+}
+
+// deliverClaimed claims the next events of a competing subscription, hands
+// them to the subscriber and then moves the offset past the received
+// events. The events which were not received, because ctx is done or the
+// instance stopped, are delivered again. It returns the number of received
+// events, which is zero if another instance is delivering the events.
+func (p *PubSub) deliverClaimed(ctx context.Context, sub *subscription) (int, error) {
+	events, until, err := p.claim(ctx, sub)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	var (
+		received int
+		lastID   int64
+	)
+	for _, ev := range events {
+		if !p.handOff(ctx, sub, ev) {
+			break
+		}
+		lastID = ev.ID
+		received++
+	}
+	return received, p.release(sub, lastID, until)
+}
+
+// deliverRead hands the next events of a broadcast subscription to the
+// subscriber. It returns the number of received events.
+func (p *PubSub) deliverRead(ctx context.Context, sub *subscription) (int, error) {
+	var events []storedEvent
+	err := p.db.SelectContext(ctx, &events,
+		`SELECT id, message FROM pubsub_events WHERE topic = $1 AND id > $2 ORDER BY id LIMIT $3`,
+		sub.topic, atomic.LoadInt64(&sub.offset), readBatchSize)
+	if err != nil {
+		return 0, errors.Wrapf(err, "get events on topic %s", sub.topic)
+	}
+	var received int
+	for _, ev := range events {
+		if !p.handOff(ctx, sub, ev) {
+			break
+		}
+		atomic.StoreInt64(&sub.offset, ev.ID)
+		received++
+	}
+	return received, nil
+}
+
+// handOff sends an event to the subscriber. It reports false if ctx is
+// done first.
+func (p *PubSub) handOff(ctx context.Context, sub *subscription, ev storedEvent) bool {
+	select {
+	case sub.events <- pubsub.Event{Topic: sub.topic, Message: ev.Message}:
+		atomic.AddUint64(&sub.delivered, 1)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *PubSub) deliver(ctx context.Context, sub *subscription) {
+	defer p.remove(sub)
+	for {
+		var (
+			received int
+			err      error
+		)
+		if sub.broadcast {
+			received, err = p.deliverRead(ctx, sub)
+		} else {
+			received, err = p.deliverClaimed(ctx, sub)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			level.Info(p.logger).Log("msg", "read pubsub events", "topic", sub.topic, "subscription", sub.name, "err", err)
+			select {
+			case <-time.After(retryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if received == 0 {
+			select {
+			case <-sub.wake:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (p *PubSub) remove(sub *subscription) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for i, s := range p.subscriptions {
+		if s == sub {
+			p.subscriptions = append(p.subscriptions[:i], p.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// wake wakes the subscriptions of topic, or all subscriptions if topic is
+// empty.
+func (p *PubSub) wake(topic string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, sub := range p.subscriptions {
+		if topic != "" && sub.topic != topic {
+			continue
+		}
+		select {
+		case sub.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *PubSub) listen() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			// a nil notification follows a reconnect.
+			if n == nil {
+				p.wake("")
+				continue
+			}
+			p.wake(n.Extra)
+		case <-ticker.C:
+			p.wake("")
+		}
+	}
+}
+
+// Prune deletes the events older than the retention. It returns the
+// number of deleted events.
+func (p *PubSub) Prune(ctx context.Context) (int64, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Delete(eventsTable).
+		Where(sq.Lt{"created_at": time.Now().UTC().Add(-p.retention)}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, "building sql")
+	}
+	res, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "prune pubsub events")
+	}
+	return res.RowsAffected()
+}
+
+func (p *PubSub) runPruner() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := p.Prune(context.Background())
+		if err != nil {
+			level.Info(p.logger).Log("msg", "prune pubsub events", "err", err)
+		} else if n > 0 {
+			level.Debug(p.logger).Log("msg", "pruned pubsub events", "deleted", n)
+		}
+	}
+}
+
+// Stats reports the backlog of the subscriptions of this instance.
+func (p *PubSub) Stats() []pubsub.SubscriptionStats {
+	p.mtx.Lock()
+	subs := make([]*subscription, len(p.subscriptions))
+	copy(subs, p.subscriptions)
+	p.mtx.Unlock()
+
+	ctx := context.Background()
+	stats := make([]pubsub.SubscriptionStats, 0, len(subs))
+	for _, sub := range subs {
+		s := pubsub.SubscriptionStats{
+			Name:      sub.name,
+			Topic:     sub.topic,
+			Delivered: atomic.LoadUint64(&sub.delivered),
+		}
+		offset := atomic.LoadInt64(&sub.offset)
+		if !sub.broadcast {
+			if err := p.db.GetContext(ctx, &offset,
+				`SELECT last_id FROM pubsub_subscriptions WHERE topic = $1 AND name = $2`,
+				sub.topic, sub.name); err != nil {
+				level.Info(p.logger).Log("msg", "get pubsub subscription", "subscription", sub.name, "err", err)
+			}
+		}
+		var backlog struct {
+			Depth int     `db:"depth"`
+			Lag   float64 `db:"lag"`
+		}
+		err := p.db.GetContext(ctx, &backlog, `
+			SELECT COUNT(*) AS depth,
+				COALESCE(EXTRACT(EPOCH FROM (now() at time zone 'utc') - MIN(created_at)), 0) AS lag
+			FROM pubsub_events WHERE topic = $1 AND id > $2`, sub.topic, offset)
+		if err != nil {
+			level.Info(p.logger).Log("msg", "get pubsub backlog", "subscription", sub.name, "err", err)
+		}
+		s.Depth = backlog.Depth
+		s.Lag = time.Duration(backlog.Lag * float64(time.Second))
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Topic != stats[j].Topic {
+			return stats[i].Topic < stats[j].Topic
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
@@ -0,0 +1,150 @@
+//go:build pg
+// +build pg
+
+package pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/kit/dbutil"
+	_ "github.com/lib/pq"
+
+	"github.com/liuds832/micromdm/platform/pubsub"
+)
+
+const testDSN = "host=localhost port=5432 user=micromdm dbname=micromdm_test password=micromdm sslmode=disable"
+
+func receive(t *testing.T, events <-chan pubsub.Event) (string, bool) {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return string(ev.Message), true
+	case <-time.After(time.Second):
+		return "", false
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	a, b := setup(t, WithBroadcast("configs")), setup(t, WithBroadcast("configs"))
+	ctx := context.Background()
+
+	subA, err := a.Subscribe(ctx, "configs", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subB, err := b.Subscribe(ctx, "configs", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Publish(ctx, "config", []byte("c1")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []<-chan pubsub.Event{subA, subB} {
+		if have, _ := receive(t, sub); have != "c1" {
+			t.Errorf("have %q, want c1", have)
+		}
+	}
+}
+
+func TestCompetingConsumers(t *testing.T) {
+	a, b := setup(t), setup(t)
+	ctx := context.Background()
+
+	subA, err := a.Subscribe(ctx, "worker", "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subB, err := b.Subscribe(ctx, "worker", "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"w1": true, "w2": true, "w3": true, "w4": true}
+	for msg := range want {
+		if err := b.Publish(ctx, "work", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := make(map[string]int)
+	for i := 0; i < len(want); i++ {
+		select {
+		case ev := <-subA:
+			received[string(ev.Message)]++
+		case ev := <-subB:
+			received[string(ev.Message)]++
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after %d events", i)
+		}
+	}
+	for msg := range want {
+		if received[msg] != 1 {
+			t.Errorf("event %s received %d times, want once", msg, received[msg])
+		}
+	}
+	if msg, ok := receive(t, subA); ok {
+		t.Errorf("unexpected event %s", msg)
+	}
+}
+
+func TestSubscribe_Resume(t *testing.T) {
+	a := setup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := a.Subscribe(ctx, "worker", "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	// wait for the subscription to end.
+	for len(a.Stats()) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := a.Publish(context.Background(), "work", []byte("w1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a competing subscription receives events published while no
+	// instance was subscribed.
+	b, err := NewPubSub(a.db, testDSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	sub, err = b.Subscribe(context.Background(), "worker", "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := receive(t, sub); have != "w1" {
+		t.Errorf("have %q, want w1", have)
+	}
+}
+
+func setup(t *testing.T, opts ...Option) *PubSub {
+	db, err := dbutil.OpenDBX(
+		"postgres",
+		testDSN,
+		dbutil.WithLogger(log.NewNopLogger()),
+		dbutil.WithMaxAttempts(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`DELETE FROM pubsub_events`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`DELETE FROM pubsub_subscriptions`); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPubSub(db, testDSN, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
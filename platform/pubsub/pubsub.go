@@ -1,6 +1,9 @@
 package pubsub
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Event struct {
 	Topic   string
@@ -19,3 +22,25 @@ type PublishSubscriber interface {
 	Publisher
 	Subscriber
 }
+
+// SubscriptionStats describes the backlog of a named subscription.
+type SubscriptionStats struct {
+	Name  string `json:"name"`
+	Topic string `json:"topic"`
+
+	// Depth is the number of events waiting to be received.
+	Depth int `json:"depth"`
+
+	// Lag is the time the oldest waiting event has been waiting, in
+	// nanoseconds when encoded.
+	Lag time.Duration `json:"lag_ns"`
+
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// StatsReporter is implemented by a PublishSubscriber which reports the
+// backlog of its subscriptions.
+type StatsReporter interface {
+	Stats() []SubscriptionStats
+}
@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/groob/plist"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/command"
+)
+
+// WithBulkPushRate limits the CommandQueuedTopic events published for
+// commands queued in bulk to perSecond events per second.
+func WithBulkPushRate(perSecond int) Option {
+	return func(s *Store) {
+		s.bulkPushRate = perSecond
+	}
+}
+
+// CommandFromEvent creates the queued Command for a command event.
+func CommandFromEvent(ev *command.Event) (Command, error) {
+	payload, err := plist.Marshal(ev.Payload)
+	if err != nil {
+		return Command{}, errors.Wrap(err, "marshal event payload")
+	}
+	return Command{
+		UUID:        ev.Payload.CommandUUID,
+		Payload:     payload,
+		CreatedAt:   ev.Time,
+		MaxAttempts: ev.Delivery.MaxAttempts,
+		ExpiresAt:   ev.Delivery.ExpiresAt,
+		NotBefore:   ev.Delivery.NotBefore,
+		NotAfter:    ev.Delivery.NotAfter,
+		DependsOn:   ev.Delivery.DependsOn,
+		DedupKey:    ev.DedupKey,
+		Priority:    ev.Delivery.Priority,
+	}, nil
+}
+
+// EnqueueBulk adds the commands of events to their device queues in a
+// single transaction. Devices are notified at the bulk push rate.
+func (db *Store) EnqueueBulk(ctx context.Context, events []*command.Event) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(DeviceCommandBucket))
+		devices := make(map[string]*DeviceCommand)
+		var order []string
+		for _, ev := range events {
+			cmd, err := CommandFromEvent(ev)
+			if err != nil {
+				return err
+			}
+			dc, ok := devices[ev.DeviceUDID]
+			if !ok {
+				dc = &DeviceCommand{DeviceUDID: ev.DeviceUDID}
+				if v := bkt.Get([]byte(ev.DeviceUDID)); v != nil {
+					if err := UnmarshalDeviceCommand(v, dc); err != nil {
+						return err
+					}
+				}
+				devices[ev.DeviceUDID] = dc
+				order = append(order, ev.DeviceUDID)
+			}
+			dc.Commands = append(dc.Commands, cmd)
+		}
+		for _, udid := range order {
+			if err := db.save(tx, devices[udid]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "enqueue bulk commands")
+	}
+
+	now := time.Now()
+	for _, ev := range events {
+		if !ev.Delivery.NotBefore.IsZero() && now.Before(ev.Delivery.NotBefore) {
+			// the scheduler notifies the device later.
+			continue
+		}
+		db.fanout.Add(ev.DeviceUDID, ev.Payload.CommandUUID)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	mdmcmd "github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+)
+
+func TestEnqueueBulk(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	pubsub := inmem.NewPubSub()
+	queued, err := pubsub.Subscribe(context.Background(), "test", CommandQueuedTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.fanout = NewFanout(pubsub, 1000, log.NewNopLogger())
+
+	existing := &DeviceCommand{DeviceUDID: "dev1"}
+	existing.Commands = append(existing.Commands, Command{UUID: "existingCmd"})
+	if err := store.Save(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []*command.Event
+	for _, udid := range []string{"dev1", "dev2", "dev2"} {
+		payload, err := mdmcmd.NewCommandPayload(&mdmcmd.CommandRequest{
+			UDID:    udid,
+			Command: &mdmcmd.Command{RequestType: "DeviceInformation"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, command.NewEvent(payload, udid))
+	}
+	if err := store.EnqueueBulk(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	for udid, want := range map[string]int{"dev1": 2, "dev2": 2} {
+		dc, err := store.DeviceCommand(udid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := len(dc.Commands); have != want {
+			t.Errorf("%s: have %d commands, want %d", udid, have, want)
+		}
+	}
+
+	// dev2 has two commands but is only notified once.
+	notified := make(map[string]int)
+	timeout := time.After(time.Second)
+	for len(notified) < 2 {
+		select {
+		case ev := <-queued:
+			cq, err := UnmarshalQueuedCommand(ev.Message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notified[cq.DeviceUDID]++
+		case <-timeout:
+			t.Fatalf("timed out waiting for queued events, got %v", notified)
+		}
+	}
+	if store.fanout.Len() != 0 {
+		t.Errorf("expected no pending notifications, have %d", store.fanout.Len())
+	}
+	for udid, n := range notified {
+		if n != 1 {
+			t.Errorf("%s notified %d times", udid, n)
+		}
+	}
+}
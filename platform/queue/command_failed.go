@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/liuds832/micromdm/platform/pubsub"
+	"github.com/liuds832/micromdm/platform/queue/internal/commandqueuedproto"
+)
+
+// CommandFailedTopic is a PubSub topic that events are published to when
+// the queue gives up on delivering a command.
+const CommandFailedTopic = "mdm.CommandFailed"
+
+// QueueCommandFailed describes a command which was moved to the failed
+// commands because it was past its delivery limits.
+type QueueCommandFailed struct {
+	ID          string
+	Time        time.Time
+	DeviceUDID  string
+	CommandUUID string
+	Reason      string
+}
+
+func MarshalFailedCommand(cf *QueueCommandFailed) ([]byte, error) {
+	if cf == nil {
+		return nil, errors.New("marshalling nil QueueCommandFailed")
+	}
+	return proto.Marshal(&commandqueued.CommandFailed{
+		Id:          cf.ID,
+		Time:        cf.Time.UnixNano(),
+		DeviceUdid:  cf.DeviceUDID,
+		CommandUuid: cf.CommandUUID,
+		Reason:      cf.Reason,
+	})
+}
+
+func UnmarshalFailedCommand(data []byte) (*QueueCommandFailed, error) {
+	var pb commandqueued.CommandFailed
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return nil, err
+	}
+	return &QueueCommandFailed{
+		ID:          pb.Id,
+		Time:        time.Unix(0, pb.Time).UTC(),
+		DeviceUDID:  pb.DeviceUdid,
+		CommandUUID: pb.CommandUuid,
+		Reason:      pb.Reason,
+	}, nil
+}
+
+func PublishCommandFailed(pub pubsub.Publisher, udid, commandUUID, reason string) error {
+	msgBytes, err := MarshalFailedCommand(&QueueCommandFailed{
+		ID:          uuid.New().String(),
+		Time:        time.Now().UTC(),
+		DeviceUDID:  udid,
+		CommandUUID: commandUUID,
+		Reason:      reason,
+	})
+	if err != nil {
+		return err
+	}
+	return pub.Publish(context.TODO(), CommandFailedTopic, msgBytes)
+}
+
+// DeliveryFailure returns why cmd may not be sent again, or an empty string
+// if it is still within its delivery limits.
+func (cmd *Command) DeliveryFailure(now time.Time) string {
+	if !cmd.ExpiresAt.IsZero() && now.After(cmd.ExpiresAt) {
+		return fmt.Sprintf("command expired at %s", cmd.ExpiresAt.Format(time.RFC3339))
+	}
+	if !cmd.NotAfter.IsZero() && now.After(cmd.NotAfter) {
+		return fmt.Sprintf("command was not sent before %s", cmd.NotAfter.Format(time.RFC3339))
+	}
+	if cmd.MaxAttempts > 0 && cmd.TimesSent >= cmd.MaxAttempts {
+		return fmt.Sprintf("command was sent %d times without being acknowledged", cmd.TimesSent)
+	}
+	return ""
+}
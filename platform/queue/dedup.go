@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/command"
+)
+
+// WithCoalescing makes the queue drop commands which are identical to a
+// command still pending for the same device.
+func WithCoalescing() Option {
+	return func(s *Store) {
+		s.coalesce = true
+	}
+}
+
+// EnqueueCoalesced adds the command of ev to the device queue, unless
+// coalescing is enabled and a command with the dedup key of ev is pending.
+// The pending command is looked up and the command is added in a single
+// transaction. It returns the UUID of the pending command if there is one.
+func (db *Store) EnqueueCoalesced(ctx context.Context, ev *command.Event) (string, error) {
+	cmd, err := CommandFromEvent(ev)
+	if err != nil {
+		return "", err
+	}
+	var existing string
+	err = db.Update(func(tx *bolt.Tx) error {
+		dc := &DeviceCommand{DeviceUDID: ev.DeviceUDID}
+		if v := tx.Bucket([]byte(DeviceCommandBucket)).Get([]byte(ev.DeviceUDID)); v != nil {
+			if err := UnmarshalDeviceCommand(v, dc); err != nil {
+				return err
+			}
+		}
+		if db.coalesce {
+			if existing = dc.pendingDuplicate(cmd.DedupKey); existing != "" {
+				return nil
+			}
+		}
+		dc.Commands = append(dc.Commands, cmd)
+		return db.save(tx, dc)
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "enqueue command")
+	}
+	if existing != "" {
+		level.Info(db.logger).Log(
+			"msg", "coalesced command with pending duplicate",
+			"device_udid", ev.DeviceUDID,
+			"command_uuid", cmd.UUID,
+			"pending_command_uuid", existing,
+		)
+		return existing, nil
+	}
+
+	level.Info(db.logger).Log(
+		"msg", "queued event for device",
+		"device_udid", ev.DeviceUDID,
+		"command_uuid", ev.Payload.CommandUUID,
+		"request_type", ev.Payload.Command.RequestType,
+	)
+	if !cmd.Eligible(time.Now()) {
+		// the scheduler notifies the device later.
+		return "", nil
+	}
+	if err := PublishCommandQueued(db.publisher, ev.DeviceUDID, ev.Payload.CommandUUID); err != nil {
+		level.Info(db.logger).Log(
+			"msg", "publish command to queued topic",
+			"err", err,
+		)
+	}
+	return "", nil
+}
+
+// pendingDuplicate returns the UUID of a queued or NotNow command with
+// the dedup key.
+func (dc *DeviceCommand) pendingDuplicate(key string) string {
+	if key == "" {
+		return ""
+	}
+	for _, l := range [][]Command{dc.Commands, dc.NotNow} {
+		for _, cmd := range l {
+			if cmd.DedupKey == key {
+				return cmd.UUID
+			}
+		}
+	}
+	return ""
+}
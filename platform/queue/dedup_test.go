@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+)
+
+func TestEnqueueCoalesced(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+	store.publisher = inmem.NewPubSub()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd", DedupKey: "DeviceInformation:abc"})
+	dc.NotNow = append(dc.NotNow, Command{UUID: "yCmd", DedupKey: "key:retry"})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	newEvent := func(udid, uuid, key string) *command.Event {
+		ev := command.NewEvent(&mdm.CommandPayload{
+			CommandUUID: uuid,
+			Command:     &mdm.Command{RequestType: "DeviceInformation"},
+		}, udid)
+		ev.DedupKey = key
+		return ev
+	}
+
+	ctx := context.Background()
+	existing, err := store.EnqueueCoalesced(ctx, newEvent("TestDevice", "aCmd", "DeviceInformation:abc"))
+	if err != nil || existing != "" {
+		t.Fatalf("expected the command to be queued without coalescing, got %q, %v", existing, err)
+	}
+
+	store.coalesce = true
+	tests := []struct {
+		udid, uuid, key, want string
+	}{
+		{"TestDevice", "bCmd", "DeviceInformation:abc", "xCmd"},
+		{"TestDevice", "cCmd", "key:retry", "yCmd"},
+		{"TestDevice", "dCmd", "ProfileList:abc", ""},
+		{"TestDevice", "eCmd", "ProfileList:abc", "dCmd"},
+		{"TestDevice", "fCmd", "", ""},
+		{"OtherDevice", "gCmd", "DeviceInformation:abc", ""},
+	}
+	for _, tt := range tests {
+		existing, err := store.EnqueueCoalesced(ctx, newEvent(tt.udid, tt.uuid, tt.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if existing != tt.want {
+			t.Errorf("%s %s: have %q, want %q", tt.udid, tt.key, existing, tt.want)
+		}
+	}
+
+	dc, err = store.DeviceCommand("TestDevice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queued []string
+	for _, cmd := range dc.Commands {
+		queued = append(queued, cmd.UUID)
+	}
+	if have, want := len(queued), 4; have != want {
+		t.Errorf("have queued commands %v, want xCmd, aCmd, dCmd and fCmd", queued)
+	}
+}
@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// resolveDependencies removes the acknowledged dependencies of the queued
+// commands in dc. Commands with a dependency which failed, was canceled
+// or is unknown are failed, which in turn fails their own dependents.
+// It reports whether any command changed.
+func (db *Store) resolveDependencies(dc *DeviceCommand, fail func(x *Command, status, reason string)) (bool, error) {
+	var changed bool
+	for {
+		queued := make(map[string]bool)
+		for _, l := range [][]Command{dc.Commands, dc.NotNow} {
+			for _, cmd := range l {
+				queued[cmd.UUID] = true
+			}
+		}
+
+		var failedAny bool
+		for _, l := range []*[]Command{&dc.Commands, &dc.NotNow} {
+			kept := make([]Command, 0, len(*l))
+			for _, cmd := range *l {
+				if len(cmd.DependsOn) == 0 {
+					kept = append(kept, cmd)
+					continue
+				}
+				var waiting []string
+				var reason string
+				for _, dep := range cmd.DependsOn {
+					if queued[dep] {
+						waiting = append(waiting, dep)
+						continue
+					}
+					ok, err := db.acknowledged(dc, dep)
+					if err != nil {
+						return changed, err
+					}
+					if !ok {
+						reason = fmt.Sprintf("dependency %s was not acknowledged", dep)
+						break
+					}
+				}
+				if reason != "" {
+					x := cmd
+					fail(&x, StatusDependencyFailed, reason)
+					changed, failedAny = true, true
+					continue
+				}
+				if len(waiting) != len(cmd.DependsOn) {
+					cmd.DependsOn = waiting
+					changed = true
+				}
+				kept = append(kept, cmd)
+			}
+			*l = kept
+		}
+		if !failedAny {
+			return changed, nil
+		}
+	}
+}
+
+// acknowledged reports whether the device of dc acknowledged the command
+// uuid, which is no longer queued.
+func (db *Store) acknowledged(dc *DeviceCommand, uuid string) (bool, error) {
+	for _, cmd := range dc.Completed {
+		if cmd.UUID == uuid {
+			return true, nil
+		}
+	}
+	for _, cmd := range dc.Failed {
+		if cmd.UUID == uuid {
+			return false, nil
+		}
+	}
+	var ok bool
+	err := db.View(func(tx *bolt.Tx) error {
+		if db.withoutHistory {
+			ok = findAcknowledged(tx, dc.DeviceUDID, uuid)
+			return nil
+		}
+		cmd, err := findHistory(tx, dc.DeviceUDID, uuid)
+		ok = cmd != nil && cmd.LastStatus == "Acknowledged"
+		return err
+	})
+	return ok, err
+}
+
+// findAcknowledged reports whether the command uuid of udid is remembered
+// as acknowledged without history.
+func findAcknowledged(tx *bolt.Tx, udid, uuid string) bool {
+	prefix := historyPrefix(udid)
+	c := tx.Bucket([]byte(AcknowledgedBucket)).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if string(k[len(prefix)+8:]) == uuid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/liuds832/micromdm/mdm"
+)
+
+func TestNext_DependsOn(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands,
+		Command{UUID: "appCmd", DependsOn: []string{"wifiCmd"}},
+		Command{UUID: "wifiCmd", DependsOn: []string{"caCmd"}},
+		Command{UUID: "caCmd"},
+	)
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	resp := mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}
+	for _, want := range []string{"caCmd", "wifiCmd", "appCmd"} {
+		cmd, err := store.nextCommand(ctx, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil || cmd.UUID != want {
+			t.Fatalf("expected %s, got %v", want, cmd)
+		}
+		resp = mdm.Response{UDID: dc.DeviceUDID, CommandUUID: cmd.UUID, Status: "Acknowledged"}
+	}
+	cmd, err := store.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != nil {
+		t.Errorf("expected empty queue, got %s", cmd.UUID)
+	}
+}
+
+func TestNext_DependsOnAcknowledgedWithoutHistory(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+	store.withoutHistory = true
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "caCmd"})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}); err != nil {
+		t.Fatal(err)
+	}
+	resp := mdm.Response{UDID: dc.DeviceUDID, CommandUUID: "caCmd", Status: "Acknowledged"}
+	if _, err := store.nextCommand(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// the dependent is queued after its dependency was acknowledged.
+	dc, err := store.DeviceCommand(dc.DeviceUDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.Commands = append(dc.Commands, Command{UUID: "wifiCmd", DependsOn: []string{"caCmd"}})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.UUID != "wifiCmd" {
+		t.Fatalf("expected wifiCmd, got %v", cmd)
+	}
+
+	n, err := store.PruneAcknowledged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("have %d pruned commands, want the recently acknowledged command kept", n)
+	}
+}
+
+func TestNext_DependencyFailed(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands,
+		Command{UUID: "caCmd"},
+		Command{UUID: "wifiCmd", DependsOn: []string{"caCmd"}},
+		Command{UUID: "appCmd", DependsOn: []string{"wifiCmd"}},
+		Command{UUID: "otherCmd"},
+	)
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}); err != nil {
+		t.Fatal(err)
+	}
+	resp := mdm.Response{UDID: dc.DeviceUDID, CommandUUID: "caCmd", Status: "Error"}
+	cmd, err := store.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.UUID != "otherCmd" {
+		t.Fatalf("expected otherCmd, got %v", cmd)
+	}
+
+	for _, uuid := range []string{"wifiCmd", "appCmd"} {
+		status, err := store.CommandStatus(ctx, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := status.State, "dependency_failed"; have != want {
+			t.Errorf("%s: have state %s, want %s", uuid, have, want)
+		}
+		if status.FailureReason == "" {
+			t.Errorf("%s: expected a failure reason", uuid)
+		}
+	}
+}
+
+func TestNext_UnknownDependency(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd", DependsOn: []string{"missingCmd"}})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := store.nextCommand(context.Background(), mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != nil {
+		t.Fatalf("expected no command, got %s", cmd.UUID)
+	}
+	dc, err = store.DeviceCommand(dc.DeviceUDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dc.Commands) != 0 {
+		t.Errorf("expected xCmd to be failed, got %v", dc.Commands)
+	}
+}
@@ -21,82 +21,165 @@ type Command struct {
 
 	LastStatus     string
 	FailureMessage []byte
+
+	// MaxAttempts and ExpiresAt limit how long the queue tries to deliver
+	// the command. Zero values mean no limit.
+	MaxAttempts int
+	ExpiresAt   time.Time
+
+	// Response is the raw plist the device answered the command with.
+	Response []byte
+
+	// NotBefore and NotAfter are a window in which the command may be
+	// sent. Zero values mean no limit.
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// DependsOn holds the UUIDs of the commands which must be
+	// acknowledged before the command is sent. Dependencies are removed
+	// once they are acknowledged.
+	DependsOn []string
+
+	// DedupKey identifies identical commands when the queue coalesces
+	// pending commands.
+	DedupKey string
+
+	// Priority is the lane of the command. Commands in a higher lane are
+	// sent first.
+	Priority int
 }
 
+// Eligible reports whether the command may be sent at now.
+func (c *Command) Eligible(now time.Time) bool {
+	return c.NotBefore.IsZero() || !now.Before(c.NotBefore)
+}
+
+// StatusExpired is the LastStatus of commands which were failed by the
+// queue because they were past their delivery limits.
+const StatusExpired = "Expired"
+
+// StatusDependencyFailed is the LastStatus of commands which were failed
+// by the queue because one of their dependencies was not acknowledged.
+const StatusDependencyFailed = "DependencyFailed"
+
+// StatusCanceled is the LastStatus of commands which were canceled before
+// they were sent.
+const StatusCanceled = "Canceled"
+
 type DeviceCommand struct {
 	DeviceUDID string
 	Commands   []Command
 
-	// These are going to scale great. We'll have to see.
+	// Completed and Failed commands are moved to the history bucket when
+	// the DeviceCommand is saved. Records written by older versions may
+	// still contain them.
 	Completed []Command
 	Failed    []Command
 	NotNow    []Command
 }
 
+func commandToProto(command Command) *devicecommandproto.Command {
+	return &devicecommandproto.Command{
+		Uuid:         command.UUID,
+		Payload:      command.Payload,
+		CreatedAt:    timeToNano(command.CreatedAt),
+		LastSentAt:   timeToNano(command.LastSentAt),
+		Acknowledged: timeToNano(command.Acknowledged),
+
+		TimesSent: int64(command.TimesSent),
+
+		LastStatus:     command.LastStatus,
+		FailureMessage: command.FailureMessage,
+
+		MaxAttempts: int64(command.MaxAttempts),
+		ExpiresAt:   timeToNano(command.ExpiresAt),
+
+		Response: command.Response,
+
+		NotBefore: timeToNano(command.NotBefore),
+		NotAfter:  timeToNano(command.NotAfter),
+		DependsOn: command.DependsOn,
+		DedupKey:  command.DedupKey,
+		Priority:  int64(command.Priority),
+	}
+}
+
+func commandFromProto(command *devicecommandproto.Command) Command {
+	return Command{
+		UUID:         command.GetUuid(),
+		Payload:      command.GetPayload(),
+		CreatedAt:    timeFromNano(command.GetCreatedAt()),
+		LastSentAt:   timeFromNano(command.GetLastSentAt()),
+		Acknowledged: timeFromNano(command.GetAcknowledged()),
+
+		TimesSent: int(command.TimesSent),
+
+		LastStatus:     command.LastStatus,
+		FailureMessage: command.FailureMessage,
+
+		MaxAttempts: int(command.GetMaxAttempts()),
+		ExpiresAt:   timeFromNano(command.GetExpiresAt()),
+
+		Response: command.GetResponse(),
+
+		NotBefore: timeFromNano(command.GetNotBefore()),
+		NotAfter:  timeFromNano(command.GetNotAfter()),
+		DependsOn: command.GetDependsOn(),
+		DedupKey:  command.GetDedupKey(),
+		Priority:  int(command.GetPriority()),
+	}
+}
+
+// zeroNano is what older versions stored for unset times, because
+// UnixNano overflows for the zero time.
+var zeroNano = time.Time{}.UnixNano()
+
+func timeToNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func timeFromNano(n int64) time.Time {
+	if n == 0 || n == zeroNano {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+func MarshalCommand(c *Command) ([]byte, error) {
+	return proto.Marshal(commandToProto(*c))
+}
+
+func UnmarshalCommand(data []byte, c *Command) error {
+	var pb devicecommandproto.Command
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return errors.Wrap(err, "unmarshal proto to Command")
+	}
+	*c = commandFromProto(&pb)
+	return nil
+}
+
 func MarshalDeviceCommand(c *DeviceCommand) ([]byte, error) {
 	protoc := devicecommandproto.DeviceCommand{
 		DeviceUdid: c.DeviceUDID,
 	}
 
-	// TODO add helper here to reduce copy/pasted boilerplate.
 	for _, command := range c.Commands {
-		protoc.Commands = append(protoc.Commands, &devicecommandproto.Command{
-			Uuid:         command.UUID,
-			Payload:      command.Payload,
-			CreatedAt:    command.CreatedAt.UnixNano(),
-			LastSentAt:   command.LastSentAt.UnixNano(),
-			Acknowledged: command.Acknowledged.UnixNano(),
-
-			TimesSent: int64(command.TimesSent),
-
-			LastStatus:     command.LastStatus,
-			FailureMessage: command.FailureMessage,
-		})
+		protoc.Commands = append(protoc.Commands, commandToProto(command))
 	}
 
 	for _, command := range c.Completed {
-		protoc.Completed = append(protoc.Completed, &devicecommandproto.Command{
-			Uuid:         command.UUID,
-			Payload:      command.Payload,
-			CreatedAt:    command.CreatedAt.UnixNano(),
-			LastSentAt:   command.LastSentAt.UnixNano(),
-			Acknowledged: command.Acknowledged.UnixNano(),
-
-			TimesSent: int64(command.TimesSent),
-
-			LastStatus:     command.LastStatus,
-			FailureMessage: command.FailureMessage,
-		})
+		protoc.Completed = append(protoc.Completed, commandToProto(command))
 	}
 
 	for _, command := range c.Failed {
-		protoc.Failed = append(protoc.Failed, &devicecommandproto.Command{
-			Uuid:         command.UUID,
-			Payload:      command.Payload,
-			CreatedAt:    command.CreatedAt.UnixNano(),
-			LastSentAt:   command.LastSentAt.UnixNano(),
-			Acknowledged: command.Acknowledged.UnixNano(),
-
-			TimesSent: int64(command.TimesSent),
-
-			LastStatus:     command.LastStatus,
-			FailureMessage: command.FailureMessage,
-		})
+		protoc.Failed = append(protoc.Failed, commandToProto(command))
 	}
 
 	for _, command := range c.NotNow {
-		protoc.NotNow = append(protoc.NotNow, &devicecommandproto.Command{
-			Uuid:         command.UUID,
-			Payload:      command.Payload,
-			CreatedAt:    command.CreatedAt.UnixNano(),
-			LastSentAt:   command.LastSentAt.UnixNano(),
-			Acknowledged: command.Acknowledged.UnixNano(),
-
-			TimesSent: int64(command.TimesSent),
-
-			LastStatus:     command.LastStatus,
-			FailureMessage: command.FailureMessage,
-		})
+		protoc.NotNow = append(protoc.NotNow, commandToProto(command))
 	}
 	return proto.Marshal(&protoc)
 }
@@ -107,68 +190,20 @@ func UnmarshalDeviceCommand(data []byte, c *DeviceCommand) error {
 		return errors.Wrap(err, "unmarshal proto to DeviceCommand")
 	}
 	c.DeviceUDID = pb.GetDeviceUdid()
-	protoCommands := pb.GetCommands()
-	protoCommandsCompleted := pb.GetCompleted()
-	protoCommandsFailed := pb.GetFailed()
-	protoCommandsNotNow := pb.GetNotNow()
-	for _, command := range protoCommands {
-		c.Commands = append(c.Commands, Command{
-			UUID:         command.GetUuid(),
-			Payload:      command.GetPayload(),
-			CreatedAt:    time.Unix(0, command.GetCreatedAt()).UTC(),
-			LastSentAt:   time.Unix(0, command.GetLastSentAt()).UTC(),
-			Acknowledged: time.Unix(0, command.GetAcknowledged()).UTC(),
-
-			TimesSent: int(command.TimesSent),
-
-			LastStatus:     command.LastStatus,
-			FailureMessage: command.FailureMessage,
-		})
+	for _, command := range pb.GetCommands() {
+		c.Commands = append(c.Commands, commandFromProto(command))
 	}
 
-	for _, command := range protoCommandsCompleted {
-		c.Completed = append(c.Completed, Command{
-			UUID:         command.GetUuid(),
-			Payload:      command.GetPayload(),
-			CreatedAt:    time.Unix(0, command.GetCreatedAt()).UTC(),
-			LastSentAt:   time.Unix(0, command.GetLastSentAt()).UTC(),
-			Acknowledged: time.Unix(0, command.GetAcknowledged()).UTC(),
-
-			TimesSent: int(command.TimesSent),
-
-			LastStatus:     command.LastStatus,
-			FailureMessage: command.FailureMessage,
-		})
+	for _, command := range pb.GetCompleted() {
+		c.Completed = append(c.Completed, commandFromProto(command))
 	}
 
-	for _, command := range protoCommandsFailed {
-		c.Failed = append(c.Failed, Command{
-			UUID:         command.GetUuid(),
-			Payload:      command.GetPayload(),
-			CreatedAt:    time.Unix(0, command.GetCreatedAt()).UTC(),
-			LastSentAt:   time.Unix(0, command.GetLastSentAt()).UTC(),
-			Acknowledged: time.Unix(0, command.GetAcknowledged()).UTC(),
-
-			TimesSent: int(command.TimesSent),
-
-			LastStatus:     command.LastStatus,
-			FailureMessage: command.FailureMessage,
-		})
+	for _, command := range pb.GetFailed() {
+		c.Failed = append(c.Failed, commandFromProto(command))
 	}
 
-	for _, command := range protoCommandsNotNow {
-		c.NotNow = append(c.NotNow, Command{
-			UUID:         command.GetUuid(),
-			Payload:      command.GetPayload(),
-			CreatedAt:    time.Unix(0, command.GetCreatedAt()).UTC(),
-			LastSentAt:   time.Unix(0, command.GetLastSentAt()).UTC(),
-			Acknowledged: time.Unix(0, command.GetAcknowledged()).UTC(),
-
-			TimesSent: int(command.TimesSent),
-
-			LastStatus:     command.LastStatus,
-			FailureMessage: command.FailureMessage,
-		})
+	for _, command := range pb.GetNotNow() {
+		c.NotNow = append(c.NotNow, commandFromProto(command))
 	}
 	return nil
 }
@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/liuds832/micromdm/platform/pubsub"
+)
+
+// DefaultBulkPushRate is the number of CommandQueuedTopic events per
+// second published for commands queued in bulk.
+const DefaultBulkPushRate = 50
+
+// Fanout publishes CommandQueuedTopic events at a limited rate, so that
+// queueing a command for many devices does not flood the APNs worker.
+// Events for a device which is still waiting for its event are coalesced,
+// because a single push makes the device fetch all of its commands.
+type Fanout struct {
+	pub      pubsub.Publisher
+	interval time.Duration
+	logger   log.Logger
+
+	mu      sync.Mutex
+	pending []QueueCommandQueued
+	waiting map[string]bool
+	wake    chan struct{}
+}
+
+// NewFanout creates a Fanout which publishes at most perSecond events per
+// second. A rate of zero or less uses DefaultBulkPushRate.
+func NewFanout(pub pubsub.Publisher, perSecond int, logger log.Logger) *Fanout {
+	if perSecond <= 0 {
+		perSecond = DefaultBulkPushRate
+	}
+	f := &Fanout{
+		pub:      pub,
+		interval: time.Second / time.Duration(perSecond),
+		logger:   logger,
+		waiting:  make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+	go f.run()
+	return f
+}
+
+// Add schedules a CommandQueuedTopic event for a command.
+func (f *Fanout) Add(udid, uuid string) {
+	f.mu.Lock()
+	if !f.waiting[udid] {
+		f.waiting[udid] = true
+		f.pending = append(f.pending, QueueCommandQueued{DeviceUDID: udid, CommandUUID: uuid})
+	}
+	f.mu.Unlock()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Len returns the number of events waiting to be published.
+func (f *Fanout) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pending)
+}
+
+func (f *Fanout) next() (QueueCommandQueued, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.pending) == 0 {
+		return QueueCommandQueued{}, false
+	}
+	ev := f.pending[0]
+	f.pending[0] = QueueCommandQueued{}
+	f.pending = f.pending[1:]
+	delete(f.waiting, ev.DeviceUDID)
+	return ev, true
+}
+
+func (f *Fanout) run() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		ev, ok := f.next()
+		if !ok {
+			<-f.wake
+			continue
+		}
+		if err := PublishCommandQueued(f.pub, ev.DeviceUDID, ev.CommandUUID); err != nil {
+			level.Info(f.logger).Log(
+				"msg", "publish command to queued topic",
+				"err", err,
+			)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,293 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/command"
+)
+
+// CommandHistoryBucket stores completed and failed commands. Keys are the
+// UDID, a zero byte, the big endian time the command finished and the
+// command UUID, so the entries of a device are sorted oldest first.
+const CommandHistoryBucket = "mdm.CommandHistory"
+
+// AcknowledgedBucket remembers the acknowledged commands when the history
+// is disabled, so that commands can still depend on them. Keys are laid out
+// like the keys of the CommandHistoryBucket, values are empty.
+const AcknowledgedBucket = "mdm.AcknowledgedCommands"
+
+// AcknowledgedRetention is how long acknowledged commands are remembered
+// for their dependents when the history is disabled.
+const AcknowledgedRetention = 24 * time.Hour
+
+const (
+	historyPruneInterval = time.Hour
+
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// WithHistoryRetention limits the command history kept for each device.
+// Entries older than maxAge, and the oldest entries beyond maxEntries, are
+// removed by a background pruner. A zero value disables the limit.
+func WithHistoryRetention(maxAge time.Duration, maxEntries int) Option {
+	return func(s *Store) {
+		s.historyMaxAge = maxAge
+		s.historyMaxEntries = maxEntries
+	}
+}
+
+func historyPrefix(udid string) []byte {
+	return append([]byte(udid), 0)
+}
+
+func historyKey(udid string, t time.Time, uuid string) []byte {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(t.UnixNano()))
+	key := append(historyPrefix(udid), ts...)
+	return append(key, uuid...)
+}
+
+// historyKeyTime returns the time component of a history key.
+func historyKeyTime(key []byte) (time.Time, error) {
+	i := bytes.IndexByte(key, 0)
+	if i < 0 || len(key) < i+9 {
+		return time.Time{}, fmt.Errorf("malformed history key %q", key)
+	}
+	nano := binary.BigEndian.Uint64(key[i+1 : i+9])
+	return time.Unix(0, int64(nano)).UTC(), nil
+}
+
+// putHistory moves the finished commands of dc into the history bucket.
+func (db *Store) putHistory(tx *bolt.Tx, dc *DeviceCommand) error {
+	if db.withoutHistory {
+		acknowledged := tx.Bucket([]byte(AcknowledgedBucket))
+		for _, cmd := range dc.Completed {
+			if err := acknowledged.Put(historyKey(dc.DeviceUDID, cmd.Acknowledged, cmd.UUID), nil); err != nil {
+				return errors.Wrap(err, "put acknowledged command to boltdb")
+			}
+		}
+		err := unindexCommands(tx, append(dc.Completed, dc.Failed...))
+		dc.Completed, dc.Failed = nil, nil
+		return err
+	}
+	bkt := tx.Bucket([]byte(CommandHistoryBucket))
+	if bkt == nil {
+		return fmt.Errorf("bucket %q not found!", CommandHistoryBucket)
+	}
+
+	lists := []struct {
+		status   string
+		commands []Command
+	}{
+		{"Acknowledged", dc.Completed},
+		{"Error", dc.Failed},
+	}
+	now := time.Now().UTC()
+	for _, l := range lists {
+		for _, cmd := range l.commands {
+			if cmd.LastStatus == "" {
+				cmd.LastStatus = l.status
+			}
+			finished := cmd.Acknowledged
+			if finished.IsZero() {
+				finished = now
+			}
+			v, err := MarshalCommand(&cmd)
+			if err != nil {
+				return errors.Wrap(err, "marshalling history Command")
+			}
+			if err := bkt.Put(historyKey(dc.DeviceUDID, finished, cmd.UUID), v); err != nil {
+				return errors.Wrap(err, "put command history to boltdb")
+			}
+		}
+	}
+	dc.Completed, dc.Failed = nil, nil
+	return nil
+}
+
+// History returns a page of the command history for udid, newest first.
+func (db *Store) History(ctx context.Context, udid string, opt command.HistoryOptions) (*command.HistoryPage, error) {
+	limit := opt.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	prefix := historyPrefix(udid)
+	start := append([]byte(udid), 1) // first key after all entries for udid
+	if opt.Cursor != "" {
+		suffix, err := base64.RawURLEncoding.DecodeString(opt.Cursor)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode history cursor")
+		}
+		start = append(historyPrefix(udid), suffix...)
+	}
+
+	page := &command.HistoryPage{Entries: []command.HistoryEntry{}}
+	err := db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(CommandHistoryBucket))
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		k, v := c.Seek(start)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		var last []byte
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Prev() {
+			if len(page.Entries) == limit {
+				page.NextCursor = base64.RawURLEncoding.EncodeToString(last)
+				return nil
+			}
+			var cmd Command
+			if err := UnmarshalCommand(v, &cmd); err != nil {
+				return err
+			}
+			finished, err := historyKeyTime(k)
+			if err != nil {
+				return err
+			}
+			page.Entries = append(page.Entries, command.HistoryEntry{
+				UUID:           cmd.UUID,
+				Status:         cmd.LastStatus,
+				Time:           finished,
+				Payload:        cmd.Payload,
+				CreatedAt:      cmd.CreatedAt,
+				LastSentAt:     cmd.LastSentAt,
+				TimesSent:      cmd.TimesSent,
+				FailureMessage: string(cmd.FailureMessage),
+			})
+			last = k[len(prefix):]
+		}
+		return nil
+	})
+	return page, errors.Wrapf(err, "get command history, udid: %s", udid)
+}
+
+// PruneHistory removes history entries which are past the retention
+// limits and returns the number of removed entries.
+func (db *Store) PruneHistory() (int, error) {
+	if db.historyMaxAge <= 0 && db.historyMaxEntries <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().UTC().Add(-db.historyMaxAge)
+
+	var deleted int
+	err := db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(CommandHistoryBucket))
+		if bkt == nil {
+			return nil
+		}
+
+		var (
+			expired [][]byte
+			group   [][]byte // keys of the current device, oldest first
+			prefix  []byte
+			older   int
+		)
+		flush := func() {
+			n := older
+			if db.historyMaxEntries > 0 && len(group)-db.historyMaxEntries > n {
+				n = len(group) - db.historyMaxEntries
+			}
+			expired = append(expired, group[:n]...)
+			group, older = group[:0], 0
+		}
+
+		c := bkt.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			i := bytes.IndexByte(k, 0)
+			if i < 0 {
+				continue
+			}
+			if !bytes.Equal(k[:i+1], prefix) {
+				flush()
+				prefix = append(prefix[:0], k[:i+1]...)
+			}
+			group = append(group, append([]byte(nil), k...))
+			if db.historyMaxAge <= 0 {
+				continue
+			}
+			if finished, err := historyKeyTime(k); err == nil && finished.Before(cutoff) {
+				older++
+			}
+		}
+		flush()
+
+		index := tx.Bucket([]byte(CommandIndexBucket))
+		for _, k := range expired {
+			if err := bkt.Delete(k); err != nil {
+				return errors.Wrap(err, "delete command history")
+			}
+			if index == nil {
+				continue
+			}
+			uuid := k[bytes.IndexByte(k, 0)+9:]
+			if err := index.Delete(uuid); err != nil {
+				return errors.Wrap(err, "delete command index")
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	return deleted, errors.Wrap(err, "prune command history")
+}
+
+// PruneAcknowledged removes the acknowledged commands remembered without
+// history for longer than the AcknowledgedRetention, and returns the number
+// of removed entries.
+func (db *Store) PruneAcknowledged() (int, error) {
+	cutoff := time.Now().UTC().Add(-AcknowledgedRetention)
+	var deleted int
+	err := db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(AcknowledgedBucket))
+		var expired [][]byte
+		c := bkt.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if finished, err := historyKeyTime(k); err != nil || finished.Before(cutoff) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range expired {
+			if err := bkt.Delete(k); err != nil {
+				return errors.Wrap(err, "delete acknowledged command")
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	return deleted, errors.Wrap(err, "prune acknowledged commands")
+}
+
+func (db *Store) runHistoryPruner() {
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		prune := db.PruneHistory
+		if db.withoutHistory {
+			prune = db.PruneAcknowledged
+		}
+		n, err := prune()
+		if err != nil {
+			level.Info(db.logger).Log("msg", "prune command history", "err", err)
+		} else if n > 0 {
+			level.Debug(db.logger).Log("msg", "pruned command history", "deleted", n)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/liuds832/micromdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+)
+
+func TestHistory_Acknowledged(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd"}, Command{UUID: "yCmd"})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	resp := mdm.Response{UDID: dc.DeviceUDID, CommandUUID: "xCmd", Status: "Acknowledged"}
+	if _, err := store.nextCommand(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := store.DeviceCommand(dc.DeviceUDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Completed) != 0 {
+		t.Errorf("expected completed commands to move to history, have %d", len(saved.Completed))
+	}
+
+	page, err := store.History(ctx, dc.DeviceUDID, command.HistoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(page.Entries), 1; have != want {
+		t.Fatalf("have %d entries, want %d", have, want)
+	}
+	if have, want := page.Entries[0].UUID, "xCmd"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := page.Entries[0].Status, "Acknowledged"; have != want {
+		t.Errorf("have status %s, want %s", have, want)
+	}
+}
+
+func TestHistory_Pagination(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	now := time.Now().UTC()
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	uuids := []string{"aCmd", "bCmd", "cCmd", "dCmd", "eCmd"}
+	for i, uuid := range uuids {
+		dc.Completed = append(dc.Completed, Command{
+			UUID:         uuid,
+			Acknowledged: now.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+	// another device should not show up in the results.
+	other := &DeviceCommand{DeviceUDID: "TestDevice2"}
+	other.Completed = append(other.Completed, Command{UUID: "otherCmd", Acknowledged: now})
+	if err := store.Save(other); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var (
+		have []string
+		opt  = command.HistoryOptions{Limit: 2}
+	)
+	for i := 0; i < len(uuids); i++ {
+		page, err := store.History(ctx, dc.DeviceUDID, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range page.Entries {
+			have = append(have, e.UUID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opt.Cursor = page.NextCursor
+	}
+
+	want := []string{"eCmd", "dCmd", "cCmd", "bCmd", "aCmd"}
+	if len(have) != len(want) {
+		t.Fatalf("have %v, want %v", have, want)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("have %v, want %v", have, want)
+			break
+		}
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	now := time.Now().UTC()
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Failed = append(dc.Failed, Command{UUID: "oldCmd", Acknowledged: now.Add(-48 * time.Hour)})
+	for _, uuid := range []string{"aCmd", "bCmd", "cCmd"} {
+		now = now.Add(time.Second)
+		dc.Completed = append(dc.Completed, Command{UUID: uuid, Acknowledged: now})
+	}
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	store.historyMaxAge = 24 * time.Hour
+	store.historyMaxEntries = 2
+	deleted, err := store.PruneHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := deleted, 2; have != want {
+		t.Errorf("have %d deleted, want %d", have, want)
+	}
+
+	page, err := store.History(context.Background(), dc.DeviceUDID, command.HistoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 2 || page.Entries[0].UUID != "cCmd" || page.Entries[1].UUID != "bCmd" {
+		t.Errorf("unexpected history after prune: %+v", page.Entries)
+	}
+}
@@ -3,6 +3,9 @@ package inmem
 import (
 	"container/list"
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/liuds832/micromdm/mdm"
 	"github.com/liuds832/micromdm/platform/command"
@@ -14,23 +17,68 @@ import (
 	"github.com/groob/plist"
 )
 
+// maxFinishedStatuses is the number of answered commands whose status is
+// kept in memory.
+const maxFinishedStatuses = 10000
+
 // QueueInMem represents an in-memory command queue
 type QueueInMem struct {
 	logger log.Logger
-	queue  map[string]*list.List
+
+	mu       sync.Mutex
+	queue    map[string]*list.List
+	statuses map[string]*command.CommandStatus
+	finished *list.List // UUIDs of answered commands, oldest first
+
+	publisher pubsub.Publisher
+	fanout    *boltqueue.Fanout
+	coalesce  bool
+}
+
+// Option configures a QueueInMem.
+type Option func(*QueueInMem)
+
+// WithCoalescing makes the queue drop commands which are identical to a
+// command still pending for the same device.
+func WithCoalescing() Option {
+	return func(q *QueueInMem) {
+		q.coalesce = true
+	}
 }
 
 type queuedCommand struct {
-	uuid    string
-	payload []byte
-	notNow  bool
+	uuid        string
+	payload     []byte
+	notNow      bool
+	notBefore   time.Time
+	notAfter    time.Time
+	expiresAt   time.Time
+	maxAttempts int
+	dependsOn   []string
+	dedupKey    string
+	priority    int
+}
+
+// failedCommand is a command which was removed from the queue without
+// being answered.
+type failedCommand struct {
+	udid   string
+	uuid   string
+	reason string
 }
 
 // New creates a new in-memory command queue
-func New(pubsub pubsub.PublishSubscriber, logger log.Logger) *QueueInMem {
+func New(pubsub pubsub.PublishSubscriber, logger log.Logger, opts ...Option) *QueueInMem {
 	q := &QueueInMem{
-		logger: logger,
-		queue:  make(map[string]*list.List),
+		logger:    logger,
+		queue:     make(map[string]*list.List),
+		statuses:  make(map[string]*command.CommandStatus),
+		finished:  list.New(),
+		publisher: pubsub,
+		fanout:    boltqueue.NewFanout(pubsub, boltqueue.DefaultBulkPushRate, logger),
+	}
+	for _, fn := range opts {
+		fn(q)
 	}
 	q.startPolling(pubsub)
 	q.startRawPolling(pubsub)
@@ -49,11 +97,87 @@ func (q *QueueInMem) getList(udid string) *list.List {
 	return q.queue[udid]
 }
 
-func (q *QueueInMem) enqueue(l *list.List, uuid string, payload []byte) {
-	l.PushBack(&queuedCommand{
-		uuid:    uuid,
-		payload: payload,
-	})
+func (q *QueueInMem) enqueue(l *list.List, uuid string, payload []byte, opts command.DeliveryOptions) *queuedCommand {
+	qCmd := &queuedCommand{
+		uuid:        uuid,
+		payload:     payload,
+		notBefore:   opts.NotBefore,
+		notAfter:    opts.NotAfter,
+		expiresAt:   opts.ExpiresAt,
+		maxAttempts: opts.MaxAttempts,
+		dependsOn:   opts.DependsOn,
+		priority:    opts.Priority,
+	}
+	l.PushBack(qCmd)
+	return qCmd
+}
+
+// pendingDuplicate returns the UUID of a command in l with the dedup key.
+func (q *QueueInMem) pendingDuplicate(l *list.List, key string) string {
+	if !q.coalesce || key == "" {
+		return ""
+	}
+	for e := l.Front(); e != nil; e = e.Next() {
+		if qCmd := e.Value.(*queuedCommand); qCmd.dedupKey == key {
+			return qCmd.uuid
+		}
+	}
+	return ""
+}
+
+// EnqueueCoalesced adds the command of ev to the device queue, unless
+// coalescing is enabled and a command with the dedup key of ev is pending.
+// It returns the UUID of the pending command if there is one.
+func (q *QueueInMem) EnqueueCoalesced(_ context.Context, ev *command.Event) (string, error) {
+	payload, err := plist.Marshal(ev.Payload)
+	if err != nil {
+		return "", fmt.Errorf("marshal command plist: %w", err)
+	}
+
+	q.mu.Lock()
+	l := q.getList(ev.DeviceUDID)
+	if existing := q.pendingDuplicate(l, ev.DedupKey); existing != "" {
+		q.mu.Unlock()
+		level.Info(q.logger).Log(
+			"msg", "coalesced command with pending duplicate",
+			"device_udid", ev.DeviceUDID,
+			"command_uuid", ev.Payload.CommandUUID,
+			"pending_command_uuid", existing,
+		)
+		return existing, nil
+	}
+	qCmd := q.enqueue(l, ev.Payload.CommandUUID, payload, ev.Delivery)
+	qCmd.dedupKey = ev.DedupKey
+	q.track(ev.DeviceUDID, ev.Payload.CommandUUID, payload, ev.Time)
+	q.mu.Unlock()
+
+	level.Info(q.logger).Log(
+		"msg", "queued command for device",
+		"device_udid", ev.DeviceUDID,
+		"command_uuid", ev.Payload.CommandUUID,
+		"request_type", ev.Payload.Command.RequestType,
+	)
+	if q.schedule(ev.DeviceUDID, ev.Payload.CommandUUID, ev.Delivery.NotBefore) {
+		return "", nil
+	}
+	if err := boltqueue.PublishCommandQueued(q.publisher, ev.DeviceUDID, ev.Payload.CommandUUID); err != nil {
+		level.Info(q.logger).Log(
+			"msg", "publish command to queued topic",
+			"err", err,
+		)
+	}
+	return "", nil
+}
+
+// schedule notifies the device once a command with a future notBefore
+// becomes eligible. It reports whether the notification was deferred.
+func (q *QueueInMem) schedule(udid, uuid string, notBefore time.Time) bool {
+	wait := time.Until(notBefore)
+	if notBefore.IsZero() || wait <= 0 {
+		return false
+	}
+	time.AfterFunc(wait, func() { q.fanout.Add(udid, uuid) })
+	return true
 }
 
 func (q *QueueInMem) findCommandByUUID(l *list.List, uuid string) (*queuedCommand, *list.Element) {
@@ -66,32 +190,161 @@ func (q *QueueInMem) findCommandByUUID(l *list.List, uuid string) (*queuedComman
 	return nil, nil
 }
 
-func (q *QueueInMem) nextCommandPayload(l *list.List, skipNotNow bool) []byte {
+// nextCommand returns the first command of the highest priority which may
+// be sent.
+func (q *QueueInMem) nextCommand(l *list.List, skipNotNow bool) *queuedCommand {
+	now := time.Now()
+	var next *queuedCommand
 	for e := l.Front(); e != nil; e = e.Next() {
 		qCmd := e.Value.(*queuedCommand)
-		if !(skipNotNow && qCmd.notNow) {
-			return qCmd.payload
+		if skipNotNow && qCmd.notNow {
+			continue
+		}
+		if now.Before(qCmd.notBefore) || len(qCmd.dependsOn) > 0 {
+			continue
+		}
+		if next == nil || qCmd.priority > next.priority {
+			next = qCmd
 		}
 	}
-	return nil
+	return next
 }
 
-// Next delivers the next command from the command queue for the enrollment in resp
-func (q *QueueInMem) Next(_ context.Context, resp mdm.Response) ([]byte, error) {
-	udid := resp.UDID
-	if resp.UserID != nil {
-		udid = *resp.UserID
+// deliveryFailure returns why qCmd may not be sent again, or an empty
+// string if it is still within its delivery limits.
+func (q *QueueInMem) deliveryFailure(qCmd *queuedCommand, now time.Time) string {
+	cmd := boltqueue.Command{
+		NotAfter:    qCmd.notAfter,
+		ExpiresAt:   qCmd.expiresAt,
+		MaxAttempts: qCmd.maxAttempts,
 	}
-	if resp.EnrollmentID != nil {
-		udid = *resp.EnrollmentID
+	if status, ok := q.statuses[qCmd.uuid]; ok {
+		cmd.TimesSent = status.TimesSent
 	}
+	return cmd.DeliveryFailure(now)
+}
+
+// fail removes the element e of the queue of udid and records why its
+// command failed.
+func (q *QueueInMem) fail(udid string, l *list.List, e *list.Element, state, reason string) failedCommand {
+	qCmd := l.Remove(e).(*queuedCommand)
+	q.finish(qCmd.uuid, state, nil)
+	if status, ok := q.statuses[qCmd.uuid]; ok {
+		status.FailureReason = reason
+	}
+	return failedCommand{udid: udid, uuid: qCmd.uuid, reason: reason}
+}
+
+// track starts recording the status of a queued command.
+func (q *QueueInMem) track(udid, uuid string, payload []byte, created time.Time) {
+	q.statuses[uuid] = &command.CommandStatus{
+		CommandUUID: uuid,
+		UDID:        udid,
+		State:       command.StateQueued,
+		CreatedAt:   created,
+		Payload:     payload,
+	}
+}
+
+// finish records the answer to a command. Only the most recent answered
+// commands are kept.
+func (q *QueueInMem) finish(uuid, state string, raw []byte) {
+	status, ok := q.statuses[uuid]
+	if !ok {
+		return
+	}
+	status.State = state
+	status.CompletedAt = time.Now().UTC()
+	status.RawResponse = raw
 
+	q.finished.PushBack(uuid)
+	for q.finished.Len() > maxFinishedStatuses {
+		oldest := q.finished.Remove(q.finished.Front()).(string)
+		delete(q.statuses, oldest)
+	}
+}
+
+// resolveDependencies removes the acknowledged dependencies of the
+// commands in the queue l of udid. Commands with a dependency which failed,
+// was canceled or is unknown are removed from the queue and returned.
+func (q *QueueInMem) resolveDependencies(udid string, l *list.List) []failedCommand {
+	var failures []failedCommand
+	for {
+		queued := make(map[string]bool)
+		for e := l.Front(); e != nil; e = e.Next() {
+			queued[e.Value.(*queuedCommand).uuid] = true
+		}
+
+		var failed bool
+		for e := l.Front(); e != nil; {
+			next := e.Next()
+			qCmd := e.Value.(*queuedCommand)
+			var waiting []string
+			for _, dep := range qCmd.dependsOn {
+				if queued[dep] {
+					waiting = append(waiting, dep)
+					continue
+				}
+				if status, ok := q.statuses[dep]; ok && status.State == command.StateAcknowledged {
+					continue
+				}
+				reason := fmt.Sprintf("dependency %s was not acknowledged", dep)
+				failures = append(failures, q.fail(udid, l, e, command.StateDependencyFailed, reason))
+				failed = true
+				waiting = nil
+				break
+			}
+			qCmd.dependsOn = waiting
+			e = next
+		}
+		if !failed {
+			return failures
+		}
+	}
+}
+
+// Next delivers the next command from the command queue for the enrollment in resp.
+// Commands past their delivery limits are failed instead of sent, and
+// published to the CommandFailedTopic.
+func (q *QueueInMem) Next(_ context.Context, resp mdm.Response) ([]byte, error) {
+	q.mu.Lock()
+	payload, failures := q.next(resp)
+	q.mu.Unlock()
+
+	for _, f := range failures {
+		level.Info(q.logger).Log(
+			"msg", "command failed delivery limits",
+			"device_udid", f.udid,
+			"command_uuid", f.uuid,
+			"reason", f.reason,
+		)
+		if err := boltqueue.PublishCommandFailed(q.publisher, f.udid, f.uuid, f.reason); err != nil {
+			level.Info(q.logger).Log("msg", "publish command to failed topic", "err", err)
+		}
+	}
+	return payload, nil
+}
+
+func (q *QueueInMem) next(resp mdm.Response) ([]byte, []failedCommand) {
+	udid := resp.ChannelID()
+	now := time.Now()
 	l := q.getList(udid)
 
+	var failures []failedCommand
 	switch resp.Status {
 	case "NotNow":
-		qCmd, _ := q.findCommandByUUID(l, resp.CommandUUID)
+		qCmd, e := q.findCommandByUUID(l, resp.CommandUUID)
+		if qCmd == nil {
+			break
+		}
+		if reason := q.deliveryFailure(qCmd, now); reason != "" {
+			failures = append(failures, q.fail(udid, l, e, command.StateExpired, reason))
+			break
+		}
 		qCmd.notNow = true
+		if status, ok := q.statuses[qCmd.uuid]; ok {
+			status.State = command.StateNotNow
+		}
 	case "Acknowledged", "Error", "CommandFormatError":
 		_, e := q.findCommandByUUID(l, resp.CommandUUID)
 		if e != nil {
@@ -99,37 +352,133 @@ func (q *QueueInMem) Next(_ context.Context, resp mdm.Response) ([]byte, error)
 			if l.Len() == 0 {
 				q.clearList(udid)
 			}
+			state := command.StateAcknowledged
+			if resp.Status != "Acknowledged" {
+				state = command.StateError
+			}
+			q.finish(resp.CommandUUID, state, resp.Raw)
 		}
 	}
 
-	cmdBytes := q.nextCommandPayload(l, resp.Status == "NotNow")
+	failures = append(failures, q.resolveDependencies(udid, l)...)
 
-	return cmdBytes, nil
+	for {
+		qCmd := q.nextCommand(l, resp.Status == "NotNow")
+		if qCmd == nil {
+			if l.Len() == 0 {
+				q.clearList(udid)
+			}
+			return nil, failures
+		}
+		if reason := q.deliveryFailure(qCmd, now); reason != "" {
+			_, e := q.findCommandByUUID(l, qCmd.uuid)
+			failures = append(failures, q.fail(udid, l, e, command.StateExpired, reason))
+			continue
+		}
+		if status, ok := q.statuses[qCmd.uuid]; ok {
+			status.State = command.StateSent
+			status.LastSentAt = now.UTC()
+			status.TimesSent++
+		}
+		return qCmd.payload, failures
+	}
 }
 
-// Clear clears a command queue for the enrollment in event
-func (q *QueueInMem) Clear(_ context.Context, event mdm.CheckinEvent) error {
-	udid := event.Command.UDID
-	if event.Command.UserID != "" {
-		udid = event.Command.UserID
+// CancelCommand removes a command which was not sent, or was refused
+// with NotNow, from the queue of udid. Its status is kept as canceled.
+func (q *QueueInMem) CancelCommand(_ context.Context, udid, uuid string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l, ok := q.queue[udid]
+	if !ok {
+		return notFound{uuid: uuid}
+	}
+	qCmd, e := q.findCommandByUUID(l, uuid)
+	if qCmd == nil {
+		return notFound{uuid: uuid}
+	}
+	if status, ok := q.statuses[uuid]; ok && status.State == command.StateSent {
+		return command.ErrCommandSent
 	}
-	if event.Command.EnrollmentID != "" {
-		udid = event.Command.EnrollmentID
+	l.Remove(e)
+	if l.Len() == 0 {
+		q.clearList(udid)
 	}
+	q.finish(uuid, command.StateCanceled, nil)
+	return nil
+}
+
+// EnqueueBulk adds the commands of events to their device queues.
+// Devices are notified at the bulk push rate.
+func (q *QueueInMem) EnqueueBulk(_ context.Context, events []*command.Event) error {
+	cmds := make([]boltqueue.Command, len(events))
+	for i, ev := range events {
+		cmd, err := boltqueue.CommandFromEvent(ev)
+		if err != nil {
+			return err
+		}
+		cmds[i] = cmd
+	}
+
+	q.mu.Lock()
+	for i, ev := range events {
+		q.enqueue(q.getList(ev.DeviceUDID), cmds[i].UUID, cmds[i].Payload, ev.Delivery)
+		q.track(ev.DeviceUDID, cmds[i].UUID, cmds[i].Payload, ev.Time)
+	}
+	q.mu.Unlock()
+
+	for _, ev := range events {
+		if q.schedule(ev.DeviceUDID, ev.Payload.CommandUUID, ev.Delivery.NotBefore) {
+			continue
+		}
+		q.fanout.Add(ev.DeviceUDID, ev.Payload.CommandUUID)
+	}
+	return nil
+}
+
+type notFound struct {
+	uuid string
+}
+
+func (e notFound) Error() string  { return fmt.Sprintf("not found: command %s", e.uuid) }
+func (e notFound) NotFound() bool { return true }
+
+// CommandStatus returns the status of a queued or recently answered command.
+func (q *QueueInMem) CommandStatus(_ context.Context, uuid string) (*command.CommandStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status, ok := q.statuses[uuid]
+	if !ok {
+		return nil, notFound{uuid: uuid}
+	}
+	s := *status
+	return &s, nil
+}
+
+// Clear clears a command queue for the enrollment in event
+func (q *QueueInMem) Clear(_ context.Context, event mdm.CheckinEvent) error {
+	udid := event.Command.ChannelID()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
+	if l, ok := q.queue[udid]; ok {
+		for e := l.Front(); e != nil; e = e.Next() {
+			delete(q.statuses, e.Value.(*queuedCommand).uuid)
+		}
+	}
 	q.clearList(udid)
 	return nil
 }
 
 // View returns the command queue for the device in event
 func (q *QueueInMem) ViewQueue(_ context.Context, event mdm.CheckinEvent) ([]*mdm.Command, error) {
-	udid := event.Command.UDID
-	if event.Command.UserID != "" {
-		udid = event.Command.UserID
-	}
-	if event.Command.EnrollmentID != "" {
-		udid = event.Command.EnrollmentID
-	}
+	udid := event.Command.ChannelID()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
 	l := q.getList(udid)
 
@@ -145,6 +494,46 @@ func (q *QueueInMem) ViewQueue(_ context.Context, event mdm.CheckinEvent) ([]*md
 	return cmds, nil
 }
 
+// StaleQueues returns the queues with unsent commands which became
+// eligible at or before before.
+func (q *QueueInMem) StaleQueues(_ context.Context, before time.Time) ([]boltqueue.StaleQueue, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var stale []boltqueue.StaleQueue
+	now := time.Now()
+	for udid, l := range q.queue {
+		var since time.Time
+		for e := l.Front(); e != nil; e = e.Next() {
+			qCmd := e.Value.(*queuedCommand)
+			status, ok := q.statuses[qCmd.uuid]
+			if !ok || qCmd.notNow {
+				continue
+			}
+			cmd := boltqueue.Command{
+				CreatedAt:   status.CreatedAt,
+				TimesSent:   status.TimesSent,
+				NotBefore:   qCmd.notBefore,
+				NotAfter:    qCmd.notAfter,
+				ExpiresAt:   qCmd.expiresAt,
+				MaxAttempts: qCmd.maxAttempts,
+				DependsOn:   qCmd.dependsOn,
+			}
+			eligible := cmd.StalePending(now)
+			if eligible.IsZero() || eligible.After(before) {
+				continue
+			}
+			if since.IsZero() || eligible.Before(since) {
+				since = eligible
+			}
+		}
+		if !since.IsZero() {
+			stale = append(stale, boltqueue.StaleQueue{DeviceUDID: udid, Since: since})
+		}
+	}
+	return stale, nil
+}
+
 func (q *QueueInMem) startPolling(pubsub pubsub.PublishSubscriber) error {
 	events, err := pubsub.Subscribe(context.TODO(), "command-queue", command.CommandTopic)
 	if err != nil {
@@ -162,30 +551,13 @@ func (q *QueueInMem) startPolling(pubsub pubsub.PublishSubscriber) error {
 					)
 					continue
 				}
-				rawCmdPlist, err := plist.Marshal(cmdEvent.Payload)
-				if err != nil {
-					level.Info(q.logger).Log(
-						"msg", "marshal command plist",
-						"err", err,
-					)
+				if cmdEvent.Queued {
+					// NewCommand queued the command already.
 					continue
 				}
-				q.enqueue(
-					q.getList(cmdEvent.DeviceUDID),
-					cmdEvent.Payload.CommandUUID,
-					rawCmdPlist,
-				)
-				level.Info(q.logger).Log(
-					"msg", "queued command for device",
-					"device_udid", cmdEvent.DeviceUDID,
-					"command_uuid", cmdEvent.Payload.CommandUUID,
-					"request_type", cmdEvent.Payload.Command.RequestType,
-				)
-
-				err = boltqueue.PublishCommandQueued(pubsub, cmdEvent.DeviceUDID, cmdEvent.Payload.CommandUUID)
-				if err != nil {
+				if _, err := q.EnqueueCoalesced(context.TODO(), &cmdEvent); err != nil {
 					level.Info(q.logger).Log(
-						"msg", "publish command to queued topic",
+						"msg", "enqueue command",
 						"err", err,
 					)
 				}
@@ -212,16 +584,23 @@ func (q *QueueInMem) startRawPolling(pubsub pubsub.PublishSubscriber) error {
 					)
 					continue
 				}
+				q.mu.Lock()
 				q.enqueue(
 					q.getList(cmdEvent.DeviceUDID),
 					cmdEvent.CommandUUID,
 					cmdEvent.Payload,
+					cmdEvent.Delivery,
 				)
+				q.track(cmdEvent.DeviceUDID, cmdEvent.CommandUUID, cmdEvent.Payload, cmdEvent.Time)
+				q.mu.Unlock()
 				level.Info(q.logger).Log(
 					"msg", "queued raw command for device",
 					"device_udid", cmdEvent.DeviceUDID,
 					"command_uuid", cmdEvent.CommandUUID,
 				)
+				if q.schedule(cmdEvent.DeviceUDID, cmdEvent.CommandUUID, cmdEvent.Delivery.NotBefore) {
+					continue
+				}
 
 				err = boltqueue.PublishCommandQueued(pubsub, cmdEvent.DeviceUDID, cmdEvent.CommandUUID)
 				if err != nil {
@@ -1,12 +1,17 @@
 package inmem
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/liuds832/micromdm/mdm"
+	mdmcmd "github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
 	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+	boltqueue "github.com/liuds832/micromdm/platform/queue"
 )
 
 func TestQueue(t *testing.T) {
@@ -14,9 +19,9 @@ func TestQueue(t *testing.T) {
 	udid := "ABCD-EFGH"
 	l := q.getList(udid)
 
-	q.enqueue(l, "CMD-001", []byte("CMD-001"))
-	q.enqueue(l, "CMD-002", []byte("CMD-002"))
-	q.enqueue(l, "CMD-003", []byte("CMD-003"))
+	q.enqueue(l, "CMD-001", []byte("CMD-001"), command.DeliveryOptions{})
+	q.enqueue(l, "CMD-002", []byte("CMD-002"), command.DeliveryOptions{})
+	q.enqueue(l, "CMD-003", []byte("CMD-003"), command.DeliveryOptions{})
 
 	for i, test := range []struct {
 		nextUUID        string
@@ -51,3 +56,249 @@ func TestQueue(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandStatus(t *testing.T) {
+	q := New(inmem.NewPubSub(), log.NewNopLogger())
+	udid := "ABCD-EFGH"
+	q.enqueue(q.getList(udid), "CMD-001", []byte("CMD-001"), command.DeliveryOptions{})
+	q.track(udid, "CMD-001", []byte("CMD-001"), time.Now())
+
+	ctx := context.Background()
+	if _, err := q.Next(ctx, mdm.Response{UDID: udid, Status: "Idle"}); err != nil {
+		t.Fatal(err)
+	}
+	status, err := q.CommandStatus(ctx, "CMD-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.State, command.StateSent; have != want {
+		t.Errorf("have state %s, want %s", have, want)
+	}
+
+	resp := mdm.Response{UDID: udid, CommandUUID: "CMD-001", Status: "Error", Raw: []byte("raw")}
+	if _, err := q.Next(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+	status, err = q.CommandStatus(ctx, "CMD-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.State, command.StateError; have != want {
+		t.Errorf("have state %s, want %s", have, want)
+	}
+	if have, want := string(status.RawResponse), "raw"; have != want {
+		t.Errorf("have response %q, want %q", have, want)
+	}
+
+	if _, err := q.CommandStatus(ctx, "CMD-002"); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
+
+func TestCancelCommand(t *testing.T) {
+	q := New(inmem.NewPubSub(), log.NewNopLogger())
+	udid := "ABCD-EFGH"
+	for _, uuid := range []string{"CMD-001", "CMD-002"} {
+		q.enqueue(q.getList(udid), uuid, []byte(uuid), command.DeliveryOptions{})
+		q.track(udid, uuid, []byte(uuid), time.Now())
+	}
+
+	ctx := context.Background()
+	if _, err := q.Next(ctx, mdm.Response{UDID: udid, Status: "Idle"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.CancelCommand(ctx, udid, "CMD-001"); err != command.ErrCommandSent {
+		t.Errorf("expected ErrCommandSent for a sent command, got %v", err)
+	}
+	if err := q.CancelCommand(ctx, udid, "CMD-002"); err != nil {
+		t.Errorf("cancel pending command: %s", err)
+	}
+	if err := q.CancelCommand(ctx, udid, "CMD-002"); err == nil {
+		t.Error("expected error for a canceled command")
+	}
+	if have, want := q.getList(udid).Len(), 1; have != want {
+		t.Errorf("have queue length %d, want %d", have, want)
+	}
+	status, err := q.CommandStatus(ctx, "CMD-002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.State, command.StateCanceled; have != want {
+		t.Errorf("have state %s, want %s", have, want)
+	}
+}
+
+func TestDependsOn(t *testing.T) {
+	q := New(inmem.NewPubSub(), log.NewNopLogger())
+	udid := "ABCD-EFGH"
+	cmds := []struct {
+		uuid      string
+		dependsOn []string
+	}{
+		{"CMD-002", []string{"CMD-001"}},
+		{"CMD-003", []string{"CMD-002"}},
+		{"CMD-001", nil},
+	}
+	for _, c := range cmds {
+		q.enqueue(q.getList(udid), c.uuid, []byte(c.uuid), command.DeliveryOptions{DependsOn: c.dependsOn})
+		q.track(udid, c.uuid, []byte(c.uuid), time.Now())
+	}
+
+	ctx := context.Background()
+	payload, err := q.Next(ctx, mdm.Response{UDID: udid, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(payload), "CMD-001"; have != want {
+		t.Fatalf("have %s, want %s", have, want)
+	}
+
+	resp := mdm.Response{UDID: udid, CommandUUID: "CMD-001", Status: "Acknowledged"}
+	payload, err = q.Next(ctx, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(payload), "CMD-002"; have != want {
+		t.Fatalf("have %s, want %s", have, want)
+	}
+
+	// CMD-003 fails with its dependency.
+	resp = mdm.Response{UDID: udid, CommandUUID: "CMD-002", Status: "Error"}
+	payload, err = q.Next(ctx, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload != nil {
+		t.Fatalf("expected no command, got %s", payload)
+	}
+	status, err := q.CommandStatus(ctx, "CMD-003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.State, command.StateDependencyFailed; have != want {
+		t.Errorf("have state %s, want %s", have, want)
+	}
+}
+
+func TestDeliveryLimits(t *testing.T) {
+	ps := inmem.NewPubSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failed, err := ps.Subscribe(ctx, "test", boltqueue.CommandFailedTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := New(ps, log.NewNopLogger())
+	udid := "ABCD-EFGH"
+	for _, c := range []struct {
+		uuid string
+		opts command.DeliveryOptions
+	}{
+		{"CMD-001", command.DeliveryOptions{MaxAttempts: 1}},
+		{"CMD-002", command.DeliveryOptions{ExpiresAt: time.Now().Add(-time.Minute)}},
+		{"CMD-003", command.DeliveryOptions{NotAfter: time.Now().Add(-time.Minute)}},
+		{"CMD-004", command.DeliveryOptions{}},
+	} {
+		q.enqueue(q.getList(udid), c.uuid, []byte(c.uuid), c.opts)
+		q.track(udid, c.uuid, []byte(c.uuid), time.Now())
+	}
+
+	payload, err := q.Next(ctx, mdm.Response{UDID: udid, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(payload), "CMD-001"; have != want {
+		t.Fatalf("have %s, want %s", have, want)
+	}
+
+	// CMD-001 was sent once already, CMD-002 expired and CMD-003 was
+	// not sent in time.
+	resp := mdm.Response{UDID: udid, CommandUUID: "CMD-001", Status: "NotNow"}
+	payload, err = q.Next(ctx, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(payload), "CMD-004"; have != want {
+		t.Fatalf("have %s, want %s", have, want)
+	}
+
+	for _, want := range []string{"CMD-001", "CMD-002", "CMD-003"} {
+		status, err := q.CommandStatus(ctx, want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := status.State, command.StateExpired; have != want {
+			t.Errorf("have state %s, want %s", have, want)
+		}
+		select {
+		case ev := <-failed:
+			cf, err := boltqueue.UnmarshalFailedCommand(ev.Message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cf.CommandUUID != want {
+				t.Errorf("have failed command %s, want %s", cf.CommandUUID, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the failure of %s", want)
+		}
+	}
+}
+
+func TestEnqueueCoalesced(t *testing.T) {
+	q := New(inmem.NewPubSub(), log.NewNopLogger(), WithCoalescing())
+	udid := "ABCD-EFGH"
+	newEvent := func(uuid string) *command.Event {
+		ev := command.NewEvent(&mdmcmd.CommandPayload{
+			CommandUUID: uuid,
+			Command:     &mdmcmd.Command{RequestType: "DeviceInformation"},
+		}, udid)
+		ev.DedupKey = "DeviceInformation:abc"
+		return ev
+	}
+
+	ctx := context.Background()
+	for _, tt := range []struct{ uuid, want string }{
+		{"CMD-001", ""},
+		{"CMD-002", "CMD-001"},
+	} {
+		existing, err := q.EnqueueCoalesced(ctx, newEvent(tt.uuid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if existing != tt.want {
+			t.Errorf("%s: have %q, want %q", tt.uuid, existing, tt.want)
+		}
+	}
+
+	resp := mdm.Response{UDID: udid, CommandUUID: "CMD-001", Status: "Acknowledged"}
+	if _, err := q.Next(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+	if existing, _ := q.EnqueueCoalesced(ctx, newEvent("CMD-003")); existing != "" {
+		t.Errorf("expected no duplicate once acknowledged, got %q", existing)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	q := New(inmem.NewPubSub(), log.NewNopLogger())
+	udid := "ABCD-EFGH"
+	l := q.getList(udid)
+	q.enqueue(l, "CMD-001", []byte("CMD-001"), command.DeliveryOptions{})
+	q.enqueue(l, "CMD-002", []byte("CMD-002"), command.DeliveryOptions{Priority: command.PriorityCritical})
+	q.enqueue(l, "CMD-003", []byte("CMD-003"), command.DeliveryOptions{})
+
+	ctx := context.Background()
+	resp := mdm.Response{UDID: udid, Status: "Idle"}
+	for _, want := range []string{"CMD-002", "CMD-001", "CMD-003"} {
+		payload, err := q.Next(ctx, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := string(payload); have != want {
+			t.Fatalf("have %s, want %s", have, want)
+		}
+		resp = mdm.Response{UDID: udid, CommandUUID: want, Status: "Acknowledged"}
+	}
+}
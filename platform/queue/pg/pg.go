@@ -0,0 +1,1090 @@
+// Package pg implements a Postgres backed queue for MDM Commands.
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	sq "gopkg.in/Masterminds/squirrel.v1"
+
+	"github.com/liuds832/micromdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/leader"
+	"github.com/liuds832/micromdm/platform/pubsub"
+	"github.com/liuds832/micromdm/platform/queue"
+)
+
+const tableName = "device_commands"
+
+const scheduleInterval = 15 * time.Second
+
+// Command states. A pending command is in the regular queue. Commands
+// refused with NotNow are retried once the regular queue is empty.
+const (
+	statePending   = "pending"
+	stateNotNow    = "notnow"
+	stateCompleted = "completed"
+	stateFailed    = "failed"
+)
+
+type Postgres struct {
+	db             *sqlx.DB
+	logger         log.Logger
+	publisher      pubsub.Publisher
+	withoutHistory bool
+
+	bulkPushRate int
+	fanout       *queue.Fanout
+
+	coalesce bool
+	elector  leader.Elector
+}
+
+type Option func(*Postgres)
+
+func WithLogger(logger log.Logger) Option {
+	return func(d *Postgres) {
+		d.logger = logger
+	}
+}
+
+func WithoutHistory() Option {
+	return func(d *Postgres) {
+		d.withoutHistory = true
+	}
+}
+
+// WithBulkPushRate limits the CommandQueuedTopic events published for
+// commands queued in bulk to perSecond events per second.
+func WithBulkPushRate(perSecond int) Option {
+	return func(d *Postgres) {
+		d.bulkPushRate = perSecond
+	}
+}
+
+// WithCoalescing makes the queue drop commands which are identical to a
+// command still pending for the same device.
+func WithCoalescing() Option {
+	return func(d *Postgres) {
+		d.coalesce = true
+	}
+}
+
+// WithElector runs the scheduler of deferred commands only while this
+// instance leads "command-scheduler".
+func WithElector(elector leader.Elector) Option {
+	return func(d *Postgres) {
+		d.elector = elector
+	}
+}
+
+func NewQueue(db *sqlx.DB, pubsub pubsub.PublishSubscriber, opts ...Option) (*Postgres, error) {
+	d := &Postgres{db: db, logger: log.NewNopLogger(), publisher: pubsub}
+	for _, fn := range opts {
+		fn(d)
+	}
+	d.fanout = queue.NewFanout(pubsub, d.bulkPushRate, d.logger)
+	if d.elector != nil {
+		d.elector.Go("command-scheduler", d.runScheduler)
+	} else {
+		go d.runScheduler(context.Background())
+	}
+
+	if err := d.pollCommands(pubsub); err != nil {
+		return nil, err
+	}
+
+	if err := d.pollRawCommands(pubsub); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *Postgres) Next(ctx context.Context, resp mdm.Response) ([]byte, error) {
+	cmd, err := d.nextCommand(ctx, resp)
+	if err != nil {
+		return nil, err
+	}
+	if cmd == nil {
+		return nil, nil
+	}
+	return cmd.Payload, nil
+}
+
+func (d *Postgres) ViewQueue(ctx context.Context, event mdm.CheckinEvent) ([]*mdm.Command, error) {
+	udid := event.Command.ChannelID()
+
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("uuid", "payload").
+		From(tableName).
+		Where(sq.Eq{"device_udid": udid, "state": statePending}).
+		OrderBy("position").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+
+	var cmds []*mdm.Command
+	err = d.db.SelectContext(ctx, &cmds, query, args...)
+	return cmds, errors.Wrapf(err, "get device commands, udid: %s", udid)
+}
+
+func (d *Postgres) Clear(ctx context.Context, event mdm.CheckinEvent) error {
+	udid := event.Command.ChannelID()
+
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Delete(tableName).
+		Where(sq.Eq{"device_udid": udid, "state": []string{statePending, stateNotNow}}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	_, err = d.db.ExecContext(ctx, query, args...)
+	return errors.Wrapf(err, "clear queue, udid: %s", udid)
+}
+
+// CancelCommand removes a pending or NotNow command from the queue of udid.
+// Commands which were sent and are awaiting a response are not removed.
+func (d *Postgres) CancelCommand(ctx context.Context, udid, uuid string) error {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, udid); err != nil {
+		return errors.Wrapf(err, "lock device command queue, udid: %s", udid)
+	}
+
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("state", "times_sent").
+		From(tableName).
+		Where(sq.Eq{"uuid": uuid, "device_udid": udid, "state": []string{statePending, stateNotNow}}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	var (
+		state     string
+		timesSent int
+	)
+	err = tx.QueryRowxContext(ctx, query, args...).Scan(&state, &timesSent)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return commandNotFoundErr{uuid: uuid}
+	} else if err != nil {
+		return errors.Wrapf(err, "get command %s, udid: %s", uuid, udid)
+	}
+	if state == statePending && timesSent > 0 {
+		return command.ErrCommandSent
+	}
+
+	// the canceled command moves to the failed commands, unless history
+	// is disabled.
+	var stmt sq.Sqlizer = sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Update(tableName).
+		Set("state", stateFailed).
+		Set("acknowledged", time.Now().UTC()).
+		Set("last_status", queue.StatusCanceled).
+		Where(sq.Eq{"uuid": uuid})
+	if d.withoutHistory {
+		stmt = sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Delete(tableName).
+			Where(sq.Eq{"uuid": uuid})
+	}
+	query, args, err = stmt.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrapf(err, "cancel command %s, udid: %s", uuid, udid)
+	}
+	return errors.Wrap(tx.Commit(), "commit transaction")
+}
+
+// nextCommand mirrors the semantics of the builtin queue: the command
+// reported in resp is moved out of the regular queue according to its
+// status, then the first pending command is rotated to the back of the
+// queue and returned. If no pending commands remain, a command which was
+// previously refused with NotNow is retried, unless the device is still
+// responding with NotNow.
+func (d *Postgres) nextCommand(ctx context.Context, resp mdm.Response) (*queue.Command, error) {
+	// The queue is keyed by the enrollment channel: the UDID, UserID,
+	// EnrollmentID or EnrollmentUserID.
+	udid := resp.ChannelID()
+
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	// serialize concurrent connections from the same enrollment.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, udid); err != nil {
+		return nil, errors.Wrapf(err, "lock device command queue, udid: %s", udid)
+	}
+
+	now := time.Now().UTC()
+	var failed []queue.Command
+
+	switch resp.Status {
+	case "NotNow":
+		// We will try this command later when the device is not
+		// responding with NotNow, unless it is past its delivery limits.
+		var x *queue.Command
+		x, err = d.pendingCommand(ctx, tx, udid, resp.CommandUUID)
+		if err != nil || x == nil {
+			break
+		}
+		if reason := x.DeliveryFailure(now); reason != "" {
+			x.FailureMessage = []byte(reason)
+			failed = append(failed, *x)
+			err = d.failCommand(ctx, tx, udid, x.UUID, queue.StatusExpired, x.FailureMessage, nil)
+			break
+		}
+		err = d.moveCommand(ctx, tx, udid, resp.CommandUUID, map[string]interface{}{
+			"state":       stateNotNow,
+			"last_status": resp.Status,
+		}, true)
+
+	case "Acknowledged":
+		// move to completed, send next
+		if d.withoutHistory {
+			// keep the acknowledged command for the commands which depend
+			// on it, until pruneCompleted deletes it.
+			err = d.moveCommand(ctx, tx, udid, resp.CommandUUID, map[string]interface{}{
+				"state":        stateCompleted,
+				"acknowledged": now,
+				"last_status":  resp.Status,
+			}, false)
+			break
+		}
+		err = d.moveCommand(ctx, tx, udid, resp.CommandUUID, map[string]interface{}{
+			"state":        stateCompleted,
+			"acknowledged": now,
+			"last_status":  resp.Status,
+			"response":     resp.Raw,
+		}, false)
+
+	case "Error", "CommandFormatError":
+		// move to failed, send next
+		err = d.failCommand(ctx, tx, udid, resp.CommandUUID, resp.Status, errorChainMessage(resp.ErrorChain), resp.Raw)
+
+	case "Idle":
+
+		// will send next command below
+
+	default:
+		return nil, fmt.Errorf("unknown response status: %s", resp.Status)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "update command %s, udid: %s", resp.CommandUUID, udid)
+	}
+
+	dependents, err := d.failDependents(ctx, tx, udid)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail commands with failed dependencies, udid: %s", udid)
+	}
+	failed = append(failed, dependents...)
+
+	// pop the first eligible command of the highest priority lane and
+	// add it to the end of the queue. Within a lane, a command that got
+	// refused with NotNow before is only sent once the regular queue is
+	// empty. Commands past their delivery limits are failed instead of
+	// sent.
+	states := []string{statePending, stateNotNow}
+	if resp.Status == "NotNow" {
+		states = []string{statePending}
+	}
+	var cmd *queue.Command
+	for {
+		cmd, err = d.first(ctx, tx, udid, states, now)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get next command from queue, udid: %s", udid)
+		}
+		if cmd == nil {
+			break
+		}
+		if reason := cmd.DeliveryFailure(now); reason != "" {
+			cmd.FailureMessage = []byte(reason)
+			failed = append(failed, *cmd)
+			if err := d.failCommand(ctx, tx, udid, cmd.UUID, queue.StatusExpired, cmd.FailureMessage, nil); err != nil {
+				return nil, errors.Wrapf(err, "fail command %s, udid: %s", cmd.UUID, udid)
+			}
+			cmd = nil
+			continue
+		}
+		if err := d.markSent(ctx, tx, cmd.UUID, now); err != nil {
+			return nil, errors.Wrapf(err, "mark command %s sent, udid: %s", cmd.UUID, udid)
+		}
+		break
+	}
+
+	if d.withoutHistory {
+		if err := d.pruneCompleted(ctx, tx, udid, now); err != nil {
+			return nil, errors.Wrapf(err, "delete acknowledged commands, udid: %s", udid)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit transaction")
+	}
+
+	for _, x := range failed {
+		level.Info(d.logger).Log(
+			"msg", "command failed delivery limits",
+			"device_udid", udid,
+			"command_uuid", x.UUID,
+			"reason", string(x.FailureMessage),
+		)
+		if d.publisher == nil {
+			continue
+		}
+		if err := queue.PublishCommandFailed(d.publisher, udid, x.UUID, string(x.FailureMessage)); err != nil {
+			level.Info(d.logger).Log("msg", "publish command to failed topic", "err", err)
+		}
+	}
+	return cmd, nil
+}
+
+// errorChainMessage encodes the ErrorChain of a response as the
+// FailureMessage of a command.
+func errorChainMessage(chain []mdm.ErrorChainItem) []byte {
+	if len(chain) == 0 {
+		return nil
+	}
+	msg, err := json.Marshal(chain)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// failCommand moves a pending command to the failed commands, or deletes
+// it if history is disabled.
+func (d *Postgres) failCommand(ctx context.Context, tx *sqlx.Tx, udid, uuid, status string, msg, response []byte) error {
+	if d.withoutHistory {
+		return d.deleteCommand(ctx, tx, udid, uuid)
+	}
+	return d.moveCommand(ctx, tx, udid, uuid, map[string]interface{}{
+		"state":           stateFailed,
+		"acknowledged":    time.Now().UTC(),
+		"last_status":     status,
+		"failure_message": msg,
+		"response":        response,
+	}, false)
+}
+
+// moveCommand updates a pending command with the values in set. If
+// requeue is true, the command is moved to the back of its queue.
+func (d *Postgres) moveCommand(ctx context.Context, tx *sqlx.Tx, udid, uuid string, set map[string]interface{}, requeue bool) error {
+	update := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Update(tableName).
+		SetMap(set).
+		Where(sq.Eq{"uuid": uuid, "device_udid": udid, "state": statePending})
+	if requeue {
+		update = update.Set("position", sq.Expr("nextval('device_commands_position_seq')"))
+	}
+	query, args, err := update.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (d *Postgres) deleteCommand(ctx context.Context, tx *sqlx.Tx, udid, uuid string) error {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Delete(tableName).
+		Where(sq.Eq{"uuid": uuid, "device_udid": udid, "state": statePending}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// pruneCompleted deletes the acknowledged commands of udid which are older
+// than the queue.AcknowledgedRetention and which no queued command depends
+// on. Without history, acknowledged commands are only kept to release the
+// commands which depend on them, including the ones queued later.
+func (d *Postgres) pruneCompleted(ctx context.Context, tx *sqlx.Tx, udid string, now time.Time) error {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Delete(tableName + " c").
+		Where(sq.Eq{"c.device_udid": udid, "c.state": stateCompleted}).
+		Where(sq.Lt{"c.acknowledged": now.Add(-queue.AcknowledgedRetention)}).
+		Where(sq.Expr(`NOT EXISTS (
+			SELECT 1 FROM device_commands d WHERE d.state IN (?, ?) AND c.uuid = ANY(d.depends_on)
+		)`, statePending, stateNotNow)).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// failDependents fails the queued commands of udid with a dependency
+// which failed, was canceled or is unknown, until no such command is left.
+func (d *Postgres) failDependents(ctx context.Context, tx *sqlx.Tx, udid string) ([]queue.Command, error) {
+	var failed []queue.Command
+	for {
+		query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+			Select("DISTINCT ON (c.uuid) c.uuid", "dep").
+			From(tableName + " c, unnest(c.depends_on) AS dep").
+			Where(sq.Eq{"c.device_udid": udid, "c.state": []string{statePending, stateNotNow}}).
+			Where(sq.Expr(`NOT EXISTS (
+				SELECT 1 FROM device_commands d WHERE d.uuid = dep AND d.state IN (?, ?, ?)
+			)`, statePending, stateNotNow, stateCompleted)).
+			ToSql()
+		if err != nil {
+			return nil, errors.Wrap(err, "building sql")
+		}
+		var rows []struct {
+			UUID string `db:"uuid"`
+			Dep  string `db:"dep"`
+		}
+		if err := tx.SelectContext(ctx, &rows, query, args...); err != nil {
+			return nil, errors.Wrap(err, "select commands with failed dependencies")
+		}
+		if len(rows) == 0 {
+			return failed, nil
+		}
+		for _, r := range rows {
+			cmd := queue.Command{
+				UUID:           r.UUID,
+				FailureMessage: []byte(fmt.Sprintf("dependency %s was not acknowledged", r.Dep)),
+			}
+			var stmt sq.Sqlizer = sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+				Update(tableName).
+				SetMap(map[string]interface{}{
+					"state":           stateFailed,
+					"acknowledged":    time.Now().UTC(),
+					"last_status":     queue.StatusDependencyFailed,
+					"failure_message": cmd.FailureMessage,
+				}).
+				Where(sq.Eq{"uuid": r.UUID})
+			if d.withoutHistory {
+				stmt = sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+					Delete(tableName).
+					Where(sq.Eq{"uuid": r.UUID})
+			}
+			query, args, err := stmt.ToSql()
+			if err != nil {
+				return nil, errors.Wrap(err, "building sql")
+			}
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return nil, errors.Wrapf(err, "fail command %s", r.UUID)
+			}
+			failed = append(failed, cmd)
+		}
+	}
+}
+
+const commandColumns = "uuid, payload, times_sent, max_attempts, expires_at, not_before, not_after, priority"
+
+func scanCommand(row *sqlx.Row) (*queue.Command, error) {
+	var (
+		cmd                            queue.Command
+		expiresAt, notBefore, notAfter sql.NullTime
+	)
+	err := row.Scan(&cmd.UUID, &cmd.Payload, &cmd.TimesSent, &cmd.MaxAttempts, &expiresAt, &notBefore, &notAfter, &cmd.Priority)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cmd.ExpiresAt = validTime(expiresAt)
+	cmd.NotBefore = validTime(notBefore)
+	cmd.NotAfter = validTime(notAfter)
+	return &cmd, nil
+}
+
+// first selects and locks the next command in one of states which is
+// eligible at now and whose dependencies were all acknowledged. Commands
+// are ordered by priority, then pending before NotNow commands, then by
+// their queue position.
+func (d *Postgres) first(ctx context.Context, tx *sqlx.Tx, udid string, states []string, now time.Time) (*queue.Command, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select(commandColumns).
+		From(tableName).
+		Where(sq.Eq{"device_udid": udid, "state": states}).
+		Where(sq.Or{sq.Eq{"not_before": nil}, sq.Expr("not_before <= ?", now)}).
+		Where(sq.Expr(`NOT EXISTS (
+			SELECT 1 FROM unnest(depends_on) AS dep
+			WHERE NOT EXISTS (SELECT 1 FROM device_commands d WHERE d.uuid = dep AND d.state = ?)
+		)`, stateCompleted)).
+		OrderBy("priority DESC", "state = '"+stateNotNow+"'", "position").
+		Limit(1).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+	return scanCommand(tx.QueryRowxContext(ctx, query, args...))
+}
+
+// pendingCommand selects and locks a command in the regular queue.
+func (d *Postgres) pendingCommand(ctx context.Context, tx *sqlx.Tx, udid, uuid string) (*queue.Command, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select(commandColumns).
+		From(tableName).
+		Where(sq.Eq{"uuid": uuid, "device_udid": udid, "state": statePending}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+	return scanCommand(tx.QueryRowxContext(ctx, query, args...))
+}
+
+// markSent moves a command to the back of the regular queue and records
+// the delivery attempt.
+func (d *Postgres) markSent(ctx context.Context, tx *sqlx.Tx, uuid string, now time.Time) error {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Update(tableName).
+		Set("state", statePending).
+		Set("position", sq.Expr("nextval('device_commands_position_seq')")).
+		Set("times_sent", sq.Expr("times_sent + 1")).
+		Set("last_sent_at", now).
+		Where(sq.Eq{"uuid": uuid}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+type commandStatusRow struct {
+	DeviceUDID     string         `db:"device_udid"`
+	Payload        []byte         `db:"payload"`
+	State          string         `db:"state"`
+	CreatedAt      sql.NullTime   `db:"created_at"`
+	LastSentAt     sql.NullTime   `db:"last_sent_at"`
+	Acknowledged   sql.NullTime   `db:"acknowledged"`
+	TimesSent      int            `db:"times_sent"`
+	LastStatus     sql.NullString `db:"last_status"`
+	FailureMessage []byte         `db:"failure_message"`
+	Response       []byte         `db:"response"`
+	Waiting        pq.StringArray `db:"waiting"`
+}
+
+type commandNotFoundErr struct {
+	uuid string
+}
+
+func (e commandNotFoundErr) Error() string  { return fmt.Sprintf("command %s not found", e.uuid) }
+func (e commandNotFoundErr) NotFound() bool { return true }
+
+// CommandStatus looks up a queued or finished command by its UUID.
+func (d *Postgres) CommandStatus(ctx context.Context, uuid string) (*command.CommandStatus, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select(
+			"device_udid",
+			"payload",
+			"state",
+			"created_at",
+			"last_sent_at",
+			"acknowledged",
+			"times_sent",
+			"last_status",
+			"failure_message",
+			"response",
+		).
+		Column(sq.Expr(`ARRAY(
+			SELECT dep FROM unnest(depends_on) AS dep
+			WHERE NOT EXISTS (SELECT 1 FROM device_commands d WHERE d.uuid = dep AND d.state = ?)
+		) AS waiting`, stateCompleted)).
+		From(tableName).
+		Where(sq.Eq{"uuid": uuid}).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+	var row commandStatusRow
+	err = d.db.QueryRowxContext(ctx, query, args...).StructScan(&row)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return nil, commandNotFoundErr{uuid: uuid}
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "get command status, uuid: %s", uuid)
+	}
+
+	status := &command.CommandStatus{
+		CommandUUID: uuid,
+		UDID:        row.DeviceUDID,
+		CreatedAt:   validTime(row.CreatedAt),
+		LastSentAt:  validTime(row.LastSentAt),
+		CompletedAt: validTime(row.Acknowledged),
+		TimesSent:   row.TimesSent,
+		Payload:     row.Payload,
+		RawResponse: row.Response,
+		DependsOn:   []string(row.Waiting),
+	}
+	switch row.State {
+	case statePending:
+		status.State = command.StateQueued
+		if row.TimesSent > 0 {
+			status.State = command.StateSent
+		}
+	case stateNotNow:
+		status.State = command.StateNotNow
+	case stateCompleted:
+		status.State = command.StateAcknowledged
+	case stateFailed:
+		status.State = command.StateError
+		switch row.LastStatus.String {
+		case queue.StatusExpired:
+			status.State = command.StateExpired
+			status.FailureReason = string(row.FailureMessage)
+		case queue.StatusDependencyFailed:
+			status.State = command.StateDependencyFailed
+			status.FailureReason = string(row.FailureMessage)
+		case queue.StatusCanceled:
+			status.State = command.StateCanceled
+		}
+	}
+	return status, nil
+}
+
+// validTime returns the zero time for NULL and for the epoch defaults of
+// the timestamp columns.
+func validTime(t sql.NullTime) time.Time {
+	if !t.Valid || t.Time.Unix() <= 0 {
+		return time.Time{}
+	}
+	return t.Time.UTC()
+}
+
+func (d *Postgres) enqueue(ctx context.Context, udid, uuid string, payload []byte, opts command.DeliveryOptions) error {
+	return d.insert(ctx, d.db, udid, queue.Command{
+		UUID:        uuid,
+		Payload:     payload,
+		CreatedAt:   time.Now().UTC(),
+		MaxAttempts: opts.MaxAttempts,
+		ExpiresAt:   opts.ExpiresAt,
+		NotBefore:   opts.NotBefore,
+		NotAfter:    opts.NotAfter,
+		DependsOn:   opts.DependsOn,
+		Priority:    opts.Priority,
+	})
+}
+
+func (d *Postgres) insert(ctx context.Context, db sqlx.ExecerContext, udid string, cmd queue.Command) error {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Insert(tableName).
+		Columns("uuid", "device_udid", "payload", "state", "created_at", "max_attempts", "expires_at", "not_before", "not_after", "depends_on", "dedup_key", "priority").
+		Values(
+			cmd.UUID,
+			udid,
+			cmd.Payload,
+			statePending,
+			cmd.CreatedAt,
+			cmd.MaxAttempts,
+			nullTime(cmd.ExpiresAt),
+			nullTime(cmd.NotBefore),
+			nullTime(cmd.NotAfter),
+			pq.Array(cmd.DependsOn),
+			nullString(cmd.DedupKey),
+			cmd.Priority,
+		).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	_, err = db.ExecContext(ctx, query, args...)
+	return errors.Wrap(err, "exec command insert in pg")
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// EnqueueCoalesced adds the command of ev to the device queue, unless
+// coalescing is enabled and a command with the dedup key of ev is pending.
+// The queue of the device is locked while the pending command is looked up
+// and the command is added. It returns the UUID of the pending command if
+// there is one.
+func (d *Postgres) EnqueueCoalesced(ctx context.Context, ev *command.Event) (string, error) {
+	cmd, err := queue.CommandFromEvent(ev)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	if d.coalesce && cmd.DedupKey != "" {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, ev.DeviceUDID); err != nil {
+			return "", errors.Wrapf(err, "lock device command queue, udid: %s", ev.DeviceUDID)
+		}
+		existing, err := pendingDuplicate(ctx, tx, ev.DeviceUDID, cmd.DedupKey)
+		if err != nil {
+			return "", err
+		}
+		if existing != "" {
+			level.Info(d.logger).Log(
+				"msg", "coalesced command with pending duplicate",
+				"device_udid", ev.DeviceUDID,
+				"command_uuid", cmd.UUID,
+				"pending_command_uuid", existing,
+			)
+			return existing, nil
+		}
+	}
+	if err := d.insert(ctx, tx, ev.DeviceUDID, cmd); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", errors.Wrap(err, "commit command insert")
+	}
+
+	level.Info(d.logger).Log(
+		"msg", "queued event for device",
+		"device_udid", ev.DeviceUDID,
+		"command_uuid", ev.Payload.CommandUUID,
+		"request_type", ev.Payload.Command.RequestType,
+	)
+	if !cmd.Eligible(time.Now()) {
+		// the scheduler notifies the device later.
+		return "", nil
+	}
+	if err := queue.PublishCommandQueued(d.publisher, ev.DeviceUDID, ev.Payload.CommandUUID); err != nil {
+		level.Info(d.logger).Log(
+			"msg", "publish command to queued topic",
+			"err", err,
+		)
+	}
+	return "", nil
+}
+
+// pendingDuplicate returns the UUID of a command with the dedup key which
+// is still pending for udid.
+func pendingDuplicate(ctx context.Context, db sqlx.QueryerContext, udid, key string) (string, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("uuid").
+		From(tableName).
+		Where(sq.Eq{"device_udid": udid, "dedup_key": key, "state": []string{statePending, stateNotNow}}).
+		OrderBy("position").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return "", errors.Wrap(err, "building sql")
+	}
+	var uuid string
+	err = db.QueryRowxContext(ctx, query, args...).Scan(&uuid)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return "", nil
+	}
+	return uuid, errors.Wrap(err, "select pending duplicate command")
+}
+
+// EnqueueBulk adds the commands of events to their device queues in a
+// single statement. Devices are notified at the bulk push rate.
+func (d *Postgres) EnqueueBulk(ctx context.Context, events []*command.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	insert := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Insert(tableName).
+		Columns("uuid", "device_udid", "payload", "state", "created_at", "max_attempts", "expires_at", "not_before", "not_after", "depends_on", "priority")
+	for _, ev := range events {
+		cmd, err := queue.CommandFromEvent(ev)
+		if err != nil {
+			return err
+		}
+		insert = insert.Values(cmd.UUID, ev.DeviceUDID, cmd.Payload, statePending, cmd.CreatedAt, cmd.MaxAttempts, nullTime(cmd.ExpiresAt), nullTime(cmd.NotBefore), nullTime(cmd.NotAfter), pq.Array(cmd.DependsOn), cmd.Priority)
+	}
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return errors.Wrap(err, "building sql")
+	}
+	if _, err := d.db.ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrap(err, "exec bulk command insert in pg")
+	}
+
+	now := time.Now()
+	for _, ev := range events {
+		if !ev.Delivery.NotBefore.IsZero() && now.Before(ev.Delivery.NotBefore) {
+			// the scheduler notifies the device later.
+			continue
+		}
+		d.fanout.Add(ev.DeviceUDID, ev.Payload.CommandUUID)
+	}
+	return nil
+}
+
+// Import copies the commands of a builtin queue DeviceCommand record into
+// Postgres, keeping the queue order and the state of each command.
+// Commands which already exist are overwritten, so Import may be repeated.
+func Import(ctx context.Context, db *sqlx.DB, dc *queue.DeviceCommand) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	lists := []struct {
+		state    string
+		commands []queue.Command
+	}{
+		{statePending, dc.Commands},
+		{stateNotNow, dc.NotNow},
+		{stateCompleted, dc.Completed},
+		{stateFailed, dc.Failed},
+	}
+	for _, l := range lists {
+		for _, cmd := range l.commands {
+			query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+				Insert(tableName).
+				Columns(
+					"uuid",
+					"device_udid",
+					"payload",
+					"state",
+					"created_at",
+					"last_sent_at",
+					"acknowledged",
+					"times_sent",
+					"last_status",
+					"failure_message",
+					"max_attempts",
+					"expires_at",
+					"response",
+					"not_before",
+					"not_after",
+					"depends_on",
+					"dedup_key",
+					"priority",
+				).
+				Values(
+					cmd.UUID,
+					dc.DeviceUDID,
+					cmd.Payload,
+					l.state,
+					cmd.CreatedAt,
+					cmd.LastSentAt,
+					cmd.Acknowledged,
+					cmd.TimesSent,
+					cmd.LastStatus,
+					cmd.FailureMessage,
+					cmd.MaxAttempts,
+					nullTime(cmd.ExpiresAt),
+					cmd.Response,
+					nullTime(cmd.NotBefore),
+					nullTime(cmd.NotAfter),
+					pq.Array(cmd.DependsOn),
+					nullString(cmd.DedupKey),
+					cmd.Priority,
+				).
+				Suffix(`ON CONFLICT (uuid) DO UPDATE SET
+					device_udid = EXCLUDED.device_udid,
+					payload = EXCLUDED.payload,
+					state = EXCLUDED.state,
+					position = EXCLUDED.position,
+					created_at = EXCLUDED.created_at,
+					last_sent_at = EXCLUDED.last_sent_at,
+					acknowledged = EXCLUDED.acknowledged,
+					times_sent = EXCLUDED.times_sent,
+					last_status = EXCLUDED.last_status,
+					failure_message = EXCLUDED.failure_message,
+					max_attempts = EXCLUDED.max_attempts,
+					expires_at = EXCLUDED.expires_at,
+					response = EXCLUDED.response,
+					not_before = EXCLUDED.not_before,
+					not_after = EXCLUDED.not_after,
+					depends_on = EXCLUDED.depends_on,
+					dedup_key = EXCLUDED.dedup_key,
+					priority = EXCLUDED.priority`).
+				ToSql()
+			if err != nil {
+				return errors.Wrap(err, "building command import query")
+			}
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return errors.Wrapf(err, "import command %s, udid: %s", cmd.UUID, dc.DeviceUDID)
+			}
+		}
+	}
+	return errors.Wrap(tx.Commit(), "commit transaction")
+}
+
+func (d *Postgres) pollCommands(pubsub pubsub.PublishSubscriber) error {
+	commandEvents, err := pubsub.Subscribe(context.TODO(), "command-queue", command.CommandTopic)
+	if err != nil {
+		return errors.Wrapf(err,
+			"subscribing push to %s topic", command.CommandTopic)
+	}
+	go func() {
+		for {
+			select {
+			case event := <-commandEvents:
+				var ev command.Event
+				if err := command.UnmarshalEvent(event.Message, &ev); err != nil {
+					level.Info(d.logger).Log("msg", "unmarshal command event in queue", "err", err)
+					continue
+				}
+				if ev.Queued {
+					// NewCommand queued the command already.
+					continue
+				}
+				if _, err := d.EnqueueCoalesced(context.TODO(), &ev); err != nil {
+					level.Info(d.logger).Log("msg", "save command in db", "err", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (d *Postgres) pollRawCommands(pubsub pubsub.PublishSubscriber) error {
+	commandEvents, err := pubsub.Subscribe(context.TODO(), "command-queue", command.RawCommandTopic)
+	if err != nil {
+		return errors.Wrapf(err,
+			"subscribing push to %s topic", command.RawCommandTopic)
+	}
+	go func() {
+		for {
+			select {
+			case event := <-commandEvents:
+				var ev command.RawEvent
+				if err := command.UnmarshalRawEvent(event.Message, &ev); err != nil {
+					level.Info(d.logger).Log("msg", "unmarshal raw command event in queue", "err", err)
+					continue
+				}
+
+				if err := d.enqueue(context.TODO(), ev.DeviceUDID, ev.CommandUUID, ev.Payload, ev.Delivery); err != nil {
+					level.Info(d.logger).Log("msg", "save command in db", "err", err)
+					continue
+				}
+				level.Info(d.logger).Log(
+					"msg", "queued raw event for device",
+					"device_udid", ev.DeviceUDID,
+					"command_uuid", ev.CommandUUID,
+				)
+				if !ev.Delivery.NotBefore.IsZero() && time.Now().Before(ev.Delivery.NotBefore) {
+					// the scheduler notifies the device later.
+					continue
+				}
+
+				err = queue.PublishCommandQueued(pubsub, ev.DeviceUDID, ev.CommandUUID)
+				if err != nil {
+					level.Info(d.logger).Log(
+						"msg", "publish command to queued topic",
+						"err", err,
+					)
+					continue
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// notifyScheduled notifies the devices of unsent commands which became
+// eligible after since and at or before now.
+func (d *Postgres) notifyScheduled(ctx context.Context, since, now time.Time) (int, error) {
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("uuid", "device_udid").
+		From(tableName).
+		Where(sq.Eq{"state": []string{statePending, stateNotNow}, "times_sent": 0}).
+		Where(sq.Gt{"not_before": since}).
+		Where(sq.Expr("not_before <= ?", now)).
+		OrderBy("not_before").
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, "building sql")
+	}
+	var due []struct {
+		UUID       string `db:"uuid"`
+		DeviceUDID string `db:"device_udid"`
+	}
+	if err := d.db.SelectContext(ctx, &due, query, args...); err != nil {
+		return 0, errors.Wrap(err, "select scheduled commands")
+	}
+	for _, c := range due {
+		d.fanout.Add(c.DeviceUDID, c.UUID)
+	}
+	return len(due), nil
+}
+
+// StaleQueues returns the queues with unsent commands which became
+// eligible at or before before. Commands refused with NotNow or waiting
+// for other commands are not stale.
+func (d *Postgres) StaleQueues(ctx context.Context, before time.Time) ([]queue.StaleQueue, error) {
+	const eligible = "MIN(GREATEST(created_at, not_before))"
+	now := time.Now().UTC()
+	query, args, err := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
+		Select("device_udid", eligible+" AS since").
+		From(tableName).
+		Where(sq.Eq{"state": statePending, "times_sent": 0}).
+		// commands past their delivery limits can't be delivered anymore.
+		Where(sq.Expr("(expires_at IS NULL OR expires_at >= ?)", now)).
+		Where(sq.Expr("(not_after IS NULL OR not_after >= ?)", now)).
+		Where(sq.Expr("(max_attempts = 0 OR times_sent < max_attempts)")).
+		Where(sq.Expr(`NOT EXISTS (
+			SELECT 1 FROM unnest(depends_on) AS dep
+			WHERE NOT EXISTS (SELECT 1 FROM device_commands d WHERE d.uuid = dep AND d.state = ?)
+		)`, stateCompleted)).
+		GroupBy("device_udid").
+		Having(eligible+" <= ?", before).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "building sql")
+	}
+	var rows []struct {
+		DeviceUDID string    `db:"device_udid"`
+		Since      time.Time `db:"since"`
+	}
+	if err := d.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, errors.Wrap(err, "select stale queues")
+	}
+	stale := make([]queue.StaleQueue, len(rows))
+	for i, r := range rows {
+		stale[i] = queue.StaleQueue{DeviceUDID: r.DeviceUDID, Since: r.Since.UTC()}
+	}
+	return stale, nil
+}
+
+// runScheduler notifies devices when their deferred commands become
+// eligible. Commands which became eligible while the server was stopped
+// are notified on start. It runs until ctx is done.
+func (d *Postgres) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	var since time.Time
+	for {
+		now := time.Now().UTC()
+		n, err := d.notifyScheduled(ctx, since, now)
+		if err != nil {
+			level.Info(d.logger).Log("msg", "notify scheduled commands", "err", err)
+		} else {
+			since = now
+			if n > 0 {
+				level.Debug(d.logger).Log("msg", "notified scheduled commands", "count", n)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
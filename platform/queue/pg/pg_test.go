@@ -0,0 +1,469 @@
+//go:build pg
+// +build pg
+
+package pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/kit/dbutil"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/mdm"
+	mdmcmd "github.com/liuds832/micromdm/mdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+	"github.com/liuds832/micromdm/platform/queue"
+)
+
+func TestNext_Error(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	for _, uuid := range []string{"xCmd", "yCmd", "zCmd"} {
+		if err := db.enqueue(ctx, "TestDevice", uuid, []byte(uuid), command.DeliveryOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := mdm.Response{
+		UDID:        "TestDevice",
+		CommandUUID: "xCmd",
+		Status:      "Error",
+	}
+	for i := 0; i < 3; i++ {
+		cmd, err := db.nextCommand(ctx, resp)
+		if err != nil {
+			t.Fatalf("expected nil, but got err: %s", err)
+		}
+		if cmd == nil {
+			t.Fatal("expected cmd but got nil")
+		}
+
+		if have, errd := cmd.UUID, resp.CommandUUID; have == errd {
+			t.Error("got back command which previously failed")
+		}
+	}
+}
+
+func TestNext_NotNow(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	for _, uuid := range []string{"xCmd", "yCmd"} {
+		if err := db.enqueue(ctx, "TestDevice", uuid, []byte(uuid), command.DeliveryOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := mdm.Response{
+		UDID:        "TestDevice",
+		CommandUUID: "yCmd",
+		Status:      "NotNow",
+	}
+	cmd, err := db.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+
+	resp = mdm.Response{
+		UDID:        "TestDevice",
+		CommandUUID: cmd.UUID,
+		Status:      "NotNow",
+	}
+	cmd, err = db.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd != nil {
+		t.Error("Got back a notnowed command.")
+	}
+
+	// once the device is idle, NotNow commands are retried.
+	cmd, err = db.nextCommand(ctx, mdm.Response{UDID: "TestDevice", Status: "Idle"})
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd == nil {
+		t.Error("expected NotNow command to be retried")
+	}
+}
+
+func TestNext_Idle(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	uuids := []string{"xCmd", "yCmd", "zCmd"}
+	for _, uuid := range uuids {
+		if err := db.enqueue(ctx, "TestDevice", uuid, []byte(uuid), command.DeliveryOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := mdm.Response{
+		UDID:        "TestDevice",
+		CommandUUID: "xCmd",
+		Status:      "Idle",
+	}
+	for i := range uuids {
+		cmd, err := db.nextCommand(ctx, resp)
+		if err != nil {
+			t.Fatalf("expected nil, but got err: %s", err)
+		}
+		if cmd == nil {
+			t.Fatal("expected cmd but got nil")
+		}
+
+		if have, want := cmd.UUID, uuids[i]; have != want {
+			t.Errorf("have %s, want %s, index %d", have, want, i)
+		}
+	}
+}
+
+func TestNext_MaxAttempts(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	if err := db.enqueue(ctx, "TestDevice", "xCmd", []byte("xCmd"), command.DeliveryOptions{MaxAttempts: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := db.nextCommand(ctx, mdm.Response{UDID: "TestDevice", Status: "Idle"})
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd == nil || cmd.UUID != "xCmd" {
+		t.Fatal("expected xCmd to be sent")
+	}
+
+	resp := mdm.Response{UDID: "TestDevice", CommandUUID: "xCmd", Status: "NotNow"}
+	if _, err := db.nextCommand(ctx, resp); err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+
+	var state string
+	if err := db.db.Get(&state, `SELECT state FROM device_commands WHERE uuid = 'xCmd'`); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := state, stateFailed; have != want {
+		t.Errorf("have state %s, want %s", have, want)
+	}
+}
+
+func TestNext_NotBefore(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	later := command.DeliveryOptions{NotBefore: time.Now().Add(time.Hour)}
+	if err := db.enqueue(ctx, "TestDevice", "laterCmd", []byte("laterCmd"), later); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.enqueue(ctx, "TestDevice", "nowCmd", []byte("nowCmd"), command.DeliveryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := db.nextCommand(ctx, mdm.Response{UDID: "TestDevice", Status: "Idle"})
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd == nil || cmd.UUID != "nowCmd" {
+		t.Fatal("expected nowCmd to be sent")
+	}
+
+	resp := mdm.Response{UDID: "TestDevice", CommandUUID: "nowCmd", Status: "Acknowledged"}
+	cmd, err = db.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd != nil {
+		t.Fatalf("expected no eligible command, got %s", cmd.UUID)
+	}
+
+	n, err := db.notifyScheduled(ctx, time.Now(), later.NotBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 scheduled command, got %d", n)
+	}
+}
+
+func TestNext_DependsOn(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	wifi := command.DeliveryOptions{DependsOn: []string{"caCmd"}}
+	if err := db.enqueue(ctx, "TestDevice", "wifiCmd", []byte("wifiCmd"), wifi); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.enqueue(ctx, "TestDevice", "caCmd", []byte("caCmd"), command.DeliveryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := db.nextCommand(ctx, mdm.Response{UDID: "TestDevice", Status: "Idle"})
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd == nil || cmd.UUID != "caCmd" {
+		t.Fatal("expected caCmd to be sent first")
+	}
+
+	resp := mdm.Response{UDID: "TestDevice", CommandUUID: "caCmd", Status: "Acknowledged"}
+	cmd, err = db.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd == nil || cmd.UUID != "wifiCmd" {
+		t.Fatal("expected wifiCmd once caCmd is acknowledged")
+	}
+}
+
+func TestNext_DependsOnWithoutHistory(t *testing.T) {
+	db := setup(t, WithoutHistory())
+	ctx := context.Background()
+
+	wifi := command.DeliveryOptions{DependsOn: []string{"caCmd"}}
+	if err := db.enqueue(ctx, "TestDevice", "wifiCmd", []byte("wifiCmd"), wifi); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.enqueue(ctx, "TestDevice", "caCmd", []byte("caCmd"), command.DeliveryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.nextCommand(ctx, mdm.Response{UDID: "TestDevice", Status: "Idle"}); err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	resp := mdm.Response{UDID: "TestDevice", CommandUUID: "caCmd", Status: "Acknowledged"}
+	cmd, err := db.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd == nil || cmd.UUID != "wifiCmd" {
+		t.Fatal("expected wifiCmd once caCmd is acknowledged")
+	}
+
+	// a command queued after its dependency was acknowledged is sent.
+	resp = mdm.Response{UDID: "TestDevice", CommandUUID: "wifiCmd", Status: "Acknowledged"}
+	if _, err := db.nextCommand(ctx, resp); err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	app := command.DeliveryOptions{DependsOn: []string{"wifiCmd"}}
+	if err := db.enqueue(ctx, "TestDevice", "appCmd", []byte("appCmd"), app); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err = db.nextCommand(ctx, mdm.Response{UDID: "TestDevice", Status: "Idle"})
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd == nil || cmd.UUID != "appCmd" {
+		t.Fatal("expected appCmd after wifiCmd was acknowledged")
+	}
+
+	// the acknowledged commands are deleted after the retention once
+	// nothing depends on them.
+	old := time.Now().UTC().Add(-queue.AcknowledgedRetention - time.Hour)
+	if _, err := db.db.Exec(`UPDATE device_commands SET acknowledged = $1 WHERE state = 'completed'`, old); err != nil {
+		t.Fatal(err)
+	}
+	resp = mdm.Response{UDID: "TestDevice", CommandUUID: "appCmd", Status: "Acknowledged"}
+	if _, err := db.nextCommand(ctx, resp); err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	for _, uuid := range []string{"caCmd", "wifiCmd"} {
+		if _, err := db.CommandStatus(ctx, uuid); !isNotFound(err) {
+			t.Errorf("have %v for %s, want not found", err, uuid)
+		}
+	}
+	if _, err := db.CommandStatus(ctx, "appCmd"); err != nil {
+		t.Errorf("have %v for the recently acknowledged appCmd, want it kept", err)
+	}
+}
+
+func isNotFound(err error) bool {
+	e, ok := errors.Cause(err).(interface{ NotFound() bool })
+	return ok && e.NotFound()
+}
+
+func TestNext_DependencyFailed(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	if err := db.enqueue(ctx, "TestDevice", "caCmd", []byte("caCmd"), command.DeliveryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	wifi := command.DeliveryOptions{DependsOn: []string{"caCmd"}}
+	if err := db.enqueue(ctx, "TestDevice", "wifiCmd", []byte("wifiCmd"), wifi); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.nextCommand(ctx, mdm.Response{UDID: "TestDevice", Status: "Idle"}); err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	resp := mdm.Response{UDID: "TestDevice", CommandUUID: "caCmd", Status: "Error"}
+	cmd, err := db.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if cmd != nil {
+		t.Fatalf("expected no command, got %s", cmd.UUID)
+	}
+
+	status, err := db.CommandStatus(ctx, "wifiCmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.State, command.StateDependencyFailed; have != want {
+		t.Errorf("have state %s, want %s", have, want)
+	}
+}
+
+func TestCancelCommand(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	for _, uuid := range []string{"xCmd", "yCmd"} {
+		if err := db.enqueue(ctx, "TestDevice", uuid, []byte(uuid), command.DeliveryOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.nextCommand(ctx, mdm.Response{UDID: "TestDevice", Status: "Idle"}); err != nil {
+		t.Fatalf("expected nil, but got err: %s", err)
+	}
+	if err := db.CancelCommand(ctx, "TestDevice", "xCmd"); err != command.ErrCommandSent {
+		t.Errorf("expected ErrCommandSent for a sent command, got %v", err)
+	}
+	if err := db.CancelCommand(ctx, "TestDevice", "yCmd"); err != nil {
+		t.Fatalf("cancel pending command: %s", err)
+	}
+	if err := db.CancelCommand(ctx, "TestDevice", "yCmd"); !isNotFound(err) {
+		t.Errorf("expected not found for a canceled command, got %v", err)
+	}
+
+	status, err := db.CommandStatus(ctx, "yCmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.State, command.StateCanceled; have != want {
+		t.Errorf("have state %s, want %s", have, want)
+	}
+}
+
+func TestNext_Priority(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	if err := db.enqueue(ctx, "TestDevice", "appCmd", []byte("appCmd"), command.DeliveryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	lock := command.DeliveryOptions{Priority: command.PriorityCritical}
+	if err := db.enqueue(ctx, "TestDevice", "lockCmd", []byte("lockCmd"), lock); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := mdm.Response{UDID: "TestDevice", Status: "Idle"}
+	for _, want := range []string{"lockCmd", "appCmd"} {
+		cmd, err := db.nextCommand(ctx, resp)
+		if err != nil {
+			t.Fatalf("expected nil, but got err: %s", err)
+		}
+		if cmd == nil || cmd.UUID != want {
+			t.Fatalf("expected %s, got %v", want, cmd)
+		}
+		resp = mdm.Response{UDID: "TestDevice", CommandUUID: cmd.UUID, Status: "Acknowledged"}
+	}
+}
+
+func TestStaleQueues(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	old := now.Add(-time.Hour)
+	for udid, cmd := range map[string]queue.Command{
+		"stale":     {UUID: "oldCmd", CreatedAt: old},
+		"recent":    {UUID: "recentCmd", CreatedAt: now},
+		"waiting":   {UUID: "depCmd", CreatedAt: old, DependsOn: []string{"recentCmd"}},
+		"scheduled": {UUID: "laterCmd", CreatedAt: old, NotBefore: now.Add(time.Hour)},
+		"expired":   {UUID: "expiredCmd", CreatedAt: old, ExpiresAt: now.Add(-time.Minute)},
+		"late":      {UUID: "lateCmd", CreatedAt: old, NotAfter: now.Add(-time.Minute)},
+	} {
+		if err := db.insert(ctx, db.db, udid, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stale, err := db.StaleQueues(ctx, now.Add(-15*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0].DeviceUDID != "stale" || !stale[0].Since.Equal(old) {
+		t.Errorf("have stale queues %v, want only the queue of stale since %s", stale, old)
+	}
+}
+
+func TestEnqueueCoalesced(t *testing.T) {
+	db := setup(t)
+	db.coalesce = true
+	ctx := context.Background()
+
+	newEvent := func(uuid, key string) *command.Event {
+		ev := command.NewEvent(&mdmcmd.CommandPayload{
+			CommandUUID: uuid,
+			Command:     &mdmcmd.Command{RequestType: "DeviceInformation"},
+		}, "TestDevice")
+		ev.DedupKey = key
+		return ev
+	}
+	for _, tt := range []struct {
+		uuid, key, want string
+	}{
+		{"xCmd", "DeviceInformation:abc", ""},
+		{"yCmd", "DeviceInformation:abc", "xCmd"},
+		{"zCmd", "ProfileList:abc", ""},
+		{"aCmd", "", ""},
+	} {
+		existing, err := db.EnqueueCoalesced(ctx, newEvent(tt.uuid, tt.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if existing != tt.want {
+			t.Errorf("%s: have %q, want %q", tt.uuid, existing, tt.want)
+		}
+	}
+
+	// an answered command is no longer a duplicate.
+	resp := mdm.Response{UDID: "TestDevice", CommandUUID: "xCmd", Status: "Acknowledged"}
+	if _, err := db.nextCommand(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+	existing, err := db.EnqueueCoalesced(ctx, newEvent("bCmd", "DeviceInformation:abc"))
+	if err != nil || existing != "" {
+		t.Errorf("have %q, %v, want the command queued", existing, err)
+	}
+}
+
+func setup(t *testing.T, opts ...Option) *Postgres {
+	db, err := dbutil.OpenDBX(
+		"postgres",
+		"host=localhost port=5432 user=micromdm dbname=micromdm_test password=micromdm sslmode=disable",
+		dbutil.WithLogger(log.NewNopLogger()),
+		dbutil.WithMaxAttempts(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`DELETE FROM device_commands`); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewQueue(db, inmem.NewPubSub(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/liuds832/micromdm/mdm"
+)
+
+func TestNext_Priority(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands,
+		Command{UUID: "app1"},
+		Command{UUID: "app2"},
+		Command{UUID: "lock", Priority: 100},
+	)
+	dc.NotNow = append(dc.NotNow,
+		Command{UUID: "erase", Priority: 100},
+		Command{UUID: "profile"},
+	)
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	// the critical lane is served first, the regular queue before NotNow
+	// commands within a lane.
+	ctx := context.Background()
+	resp := mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}
+	for _, want := range []string{"lock", "erase", "app1", "app2", "profile"} {
+		cmd, err := store.nextCommand(ctx, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil || cmd.UUID != want {
+			t.Fatalf("expected %s, got %v", want, cmd)
+		}
+		resp = mdm.Response{UDID: dc.DeviceUDID, CommandUUID: cmd.UUID, Status: "Acknowledged"}
+	}
+}
+
+func TestNext_PriorityNotNow(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands,
+		Command{UUID: "lock1", Priority: 100},
+		Command{UUID: "lock2", Priority: 100},
+		Command{UUID: "app"},
+	)
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	// NotNow keeps the retry order within the critical lane.
+	ctx := context.Background()
+	resp := mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}
+	for _, want := range []string{"lock1", "lock2", "app"} {
+		cmd, err := store.nextCommand(ctx, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil || cmd.UUID != want {
+			t.Fatalf("expected %s, got %v", want, cmd)
+		}
+		resp = mdm.Response{UDID: dc.DeviceUDID, CommandUUID: cmd.UUID, Status: "NotNow"}
+	}
+
+	resp = mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}
+	for _, want := range []string{"lock1", "lock2", "app"} {
+		cmd, err := store.nextCommand(ctx, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil || cmd.UUID != want {
+			t.Fatalf("retry: expected %s, got %v", want, cmd)
+		}
+		resp = mdm.Response{UDID: dc.DeviceUDID, CommandUUID: cmd.UUID, Status: "Acknowledged"}
+	}
+}
@@ -3,13 +3,13 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"github.com/groob/plist"
 	"github.com/pkg/errors"
 
 	"github.com/liuds832/micromdm/mdm"
@@ -20,13 +20,25 @@ import (
 const (
 	DeviceCommandBucket = "mdm.DeviceCommands"
 
+	// CommandIndexBucket maps command UUIDs to the queue they belong to.
+	CommandIndexBucket = "mdm.CommandIndex"
+
 	CommandQueuedTopic = "mdm.CommandQueued"
 )
 
 type Store struct {
 	*bolt.DB
 	logger         log.Logger
+	publisher      pubsub.Publisher
 	withoutHistory bool
+
+	historyMaxAge     time.Duration
+	historyMaxEntries int
+
+	bulkPushRate int
+	fanout       *Fanout
+
+	coalesce bool
 }
 
 type Option func(*Store)
@@ -55,13 +67,7 @@ func (db *Store) Next(ctx context.Context, resp mdm.Response) ([]byte, error) {
 }
 
 func (db *Store) ViewQueue(ctx context.Context, event mdm.CheckinEvent) ([]*mdm.Command, error) {
-	udid := event.Command.UDID
-	if event.Command.UserID != "" {
-		udid = event.Command.UserID
-	}
-	if event.Command.EnrollmentID != "" {
-		udid = event.Command.EnrollmentID
-	}
+	udid := event.Command.ChannelID()
 
 	dc, err := db.DeviceCommand(udid)
 	if isNotFound(err) {
@@ -82,13 +88,7 @@ func (db *Store) ViewQueue(ctx context.Context, event mdm.CheckinEvent) ([]*mdm.
 }
 
 func (db *Store) Clear(ctx context.Context, event mdm.CheckinEvent) error {
-	udid := event.Command.UDID
-	if event.Command.UserID != "" {
-		udid = event.Command.UserID
-	}
-	if event.Command.EnrollmentID != "" {
-		udid = event.Command.EnrollmentID
-	}
+	udid := event.Command.ChannelID()
 
 	dc, err := db.DeviceCommand(udid)
 	if isNotFound(err) {
@@ -97,24 +97,54 @@ func (db *Store) Clear(ctx context.Context, event mdm.CheckinEvent) error {
 		return errors.Wrapf(err, "get device to clear queue, udid: %s", udid)
 	}
 
+	cleared := append(dc.Commands, dc.NotNow...)
 	dc.Commands = nil
 	dc.NotNow = nil
 
-	return db.Save(dc)
+	if err := db.Save(dc); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return unindexCommands(tx, cleared)
+	})
 }
 
-func (db *Store) nextCommand(ctx context.Context, resp mdm.Response) (*Command, error) {
-	// The UDID is the primary key for the queue.
-	// Depending on the enrollment type, replace the UDID with a different ID type.
-	// UserID for managed user channel
-	// EnrollmentID for BYOD User Enrollment.
-	udid := resp.UDID
-	if resp.UserID != nil {
-		udid = *resp.UserID
+// CancelCommand removes a pending or NotNow command from the queue of udid.
+// Commands which were sent and are awaiting a response are not removed.
+func (db *Store) CancelCommand(ctx context.Context, udid, uuid string) error {
+	dc, err := db.DeviceCommand(udid)
+	if isNotFound(err) {
+		return &notFound{"Command", fmt.Sprintf("uuid %s", uuid)}
+	} else if err != nil {
+		return errors.Wrapf(err, "get device commands, udid: %s", udid)
 	}
-	if resp.EnrollmentID != nil {
-		udid = *resp.EnrollmentID
+
+	x, notNow := cut(dc.NotNow, uuid)
+	if x != nil {
+		dc.NotNow = notNow
+	} else {
+		for _, cmd := range dc.Commands {
+			if cmd.UUID == uuid && cmd.TimesSent > 0 {
+				return command.ErrCommandSent
+			}
+		}
+		x, dc.Commands = cut(dc.Commands, uuid)
 	}
+	if x == nil {
+		return &notFound{"Command", fmt.Sprintf("uuid %s", uuid)}
+	}
+
+	// the canceled command moves to the history like a failed command.
+	x.LastStatus = StatusCanceled
+	x.Acknowledged = time.Now().UTC()
+	dc.Failed = append(dc.Failed, *x)
+	return db.Save(dc)
+}
+
+func (db *Store) nextCommand(ctx context.Context, resp mdm.Response) (*Command, error) {
+	// The queue is keyed by the enrollment channel: the UDID, UserID,
+	// EnrollmentID or EnrollmentUserID.
+	udid := resp.ChannelID()
 
 	dc, err := db.DeviceCommand(udid)
 	if err != nil {
@@ -124,6 +154,15 @@ func (db *Store) nextCommand(ctx context.Context, resp mdm.Response) (*Command,
 		return nil, errors.Wrapf(err, "get device command from queue, udid: %s", resp.UDID)
 	}
 
+	now := time.Now().UTC()
+	var failed []Command
+	fail := func(x *Command, status, reason string) {
+		x.LastStatus = status
+		x.FailureMessage = []byte(reason)
+		failed = append(failed, *x)
+		dc.Failed = append(dc.Failed, *x)
+	}
+
 	var cmd *Command
 	switch resp.Status {
 	case "NotNow":
@@ -134,6 +173,11 @@ func (db *Store) nextCommand(ctx context.Context, resp mdm.Response) (*Command,
 		if x == nil {
 			break
 		}
+		x.LastStatus = resp.Status
+		if reason := x.DeliveryFailure(now); reason != "" {
+			fail(x, StatusExpired, reason)
+			break
+		}
 		dc.NotNow = append(dc.NotNow, *x)
 
 	case "Acknowledged":
@@ -143,32 +187,23 @@ func (db *Store) nextCommand(ctx context.Context, resp mdm.Response) (*Command,
 		if x == nil {
 			break
 		}
-		if !db.withoutHistory {
-			x.Acknowledged = time.Now().UTC()
-			dc.Completed = append(dc.Completed, *x)
-		}
+		x.Acknowledged = now
+		x.LastStatus = resp.Status
+		x.Response = resp.Raw
+		dc.Completed = append(dc.Completed, *x)
 
-	case "Error":
+	case "Error", "CommandFormatError":
 		// move to failed, send next
 		x, a := cut(dc.Commands, resp.CommandUUID)
 		dc.Commands = a
 		if x == nil { // must've already bin ackd
 			break
 		}
-		if !db.withoutHistory {
-			dc.Failed = append(dc.Failed, *x)
-		}
-
-	case "CommandFormatError":
-		// move to failed
-		x, a := cut(dc.Commands, resp.CommandUUID)
-		dc.Commands = a
-		if x == nil {
-			break
-		}
-		if !db.withoutHistory {
-			dc.Failed = append(dc.Failed, *x)
-		}
+		x.Acknowledged = now
+		x.LastStatus = resp.Status
+		x.FailureMessage = errorChainMessage(resp.ErrorChain)
+		x.Response = resp.Raw
+		dc.Failed = append(dc.Failed, *x)
 
 	case "Idle":
 
@@ -178,37 +213,113 @@ func (db *Store) nextCommand(ctx context.Context, resp mdm.Response) (*Command,
 		return nil, fmt.Errorf("unknown response status: %s", resp.Status)
 	}
 
-	// pop the first command from the queue and add it to the end.
-	// If the regular queue is empty, send a command that got
-	// refused with NotNow before.
-	cmd, dc.Commands = popFirst(dc.Commands)
-	if cmd != nil {
-		dc.Commands = append(dc.Commands, *cmd)
-	} else if resp.Status != "NotNow" {
-		cmd, dc.NotNow = popFirst(dc.NotNow)
-		if cmd != nil {
-			dc.Commands = append(dc.Commands, *cmd)
+	resolved, err := db.resolveDependencies(dc, fail)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve command dependencies, udid: %s", udid)
+	}
+
+	// pop the first eligible command of the highest priority lane and
+	// add it to the end of the queue. Within a lane, a command that got
+	// refused with NotNow before is only sent once the regular queue is
+	// empty. Commands past their delivery limits are failed instead of
+	// sent.
+	for {
+		cmd = popNext(dc, now, resp.Status != "NotNow")
+		if cmd == nil {
+			break
 		}
+		if reason := cmd.DeliveryFailure(now); reason != "" {
+			fail(cmd, StatusExpired, reason)
+			cmd = nil
+			continue
+		}
+		cmd.LastSentAt = now
+		cmd.TimesSent++
+		dc.Commands = append(dc.Commands, *cmd)
+		break
 	}
 
 	// we only need to Save if there are command queue changes such as
 	// NowNow and Acknowledged responses or a new popped command.
-	if resp.Status != "Idle" || cmd != nil {
+	if resp.Status != "Idle" || cmd != nil || len(failed) > 0 || resolved {
 		if err := db.Save(dc); err != nil {
 			return nil, err
 		}
 	}
 
+	for _, x := range failed {
+		level.Info(db.logger).Log(
+			"msg", "command failed delivery limits",
+			"device_udid", udid,
+			"command_uuid", x.UUID,
+			"reason", string(x.FailureMessage),
+		)
+		if db.publisher == nil {
+			continue
+		}
+		if err := PublishCommandFailed(db.publisher, udid, x.UUID, string(x.FailureMessage)); err != nil {
+			level.Info(db.logger).Log("msg", "publish command to failed topic", "err", err)
+		}
+	}
+
 	return cmd, nil
 }
 
-func popFirst(all []Command) (*Command, []Command) {
-	if len(all) == 0 {
-		return nil, all
+// errorChainMessage encodes the ErrorChain of a response as the
+// FailureMessage of a command.
+func errorChainMessage(chain []mdm.ErrorChainItem) []byte {
+	if len(chain) == 0 {
+		return nil
+	}
+	msg, err := json.Marshal(chain)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// popNext removes the next command to send from the queue of dc. NotNow
+// commands are only considered if withNotNow is true, and win over the
+// regular queue only if their priority is higher.
+func popNext(dc *DeviceCommand, now time.Time, withNotNow bool) *Command {
+	i := firstReady(dc.Commands, now)
+	j := -1
+	if withNotNow {
+		j = firstReady(dc.NotNow, now)
+	}
+	if j >= 0 && (i < 0 || dc.NotNow[j].Priority > dc.Commands[i].Priority) {
+		var cmd *Command
+		cmd, dc.NotNow = popAt(dc.NotNow, j)
+		return cmd
+	}
+	if i < 0 {
+		return nil
+	}
+	var cmd *Command
+	cmd, dc.Commands = popAt(dc.Commands, i)
+	return cmd
+}
+
+// firstReady returns the index of the first command of the highest
+// priority which is eligible at now and has no unacknowledged
+// dependencies, or -1.
+func firstReady(all []Command, now time.Time) int {
+	best := -1
+	for i, cmd := range all {
+		if !cmd.Eligible(now) || len(cmd.DependsOn) > 0 {
+			continue
+		}
+		if best < 0 || cmd.Priority > all[best].Priority {
+			best = i
+		}
 	}
-	first := all[0]
-	all = append(all[:0], all[1:]...)
-	return &first, all
+	return best
+}
+
+func popAt(all []Command, i int) (*Command, []Command) {
+	cmd := all[i]
+	all = append(all[:i], all[i+1:]...)
+	return &cmd, all
 }
 
 func cut(all []Command, uuid string) (*Command, []Command) {
@@ -224,17 +335,40 @@ func cut(all []Command, uuid string) (*Command, []Command) {
 func NewQueue(db *bolt.DB, pubsub pubsub.PublishSubscriber, opts ...Option) (*Store, error) {
 	err := db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(DeviceCommandBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(CommandHistoryBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(CommandIndexBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(ScheduledCommandBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(AcknowledgedBucket))
 		return err
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "creating %s bucket", DeviceCommandBucket)
 	}
 
-	datastore := &Store{DB: db, logger: log.NewNopLogger()}
+	datastore := &Store{DB: db, logger: log.NewNopLogger(), publisher: pubsub}
 	for _, fn := range opts {
 		fn(datastore)
 	}
 
+	datastore.fanout = NewFanout(pubsub, datastore.bulkPushRate, datastore.logger)
+
+	if datastore.withoutHistory || datastore.historyMaxAge > 0 || datastore.historyMaxEntries > 0 {
+		go datastore.runHistoryPruner()
+	}
+	go datastore.runScheduler()
+
 	if err := datastore.pollCommands(pubsub); err != nil {
 		return nil, err
 	}
@@ -251,19 +385,34 @@ func (db *Store) Save(cmd *DeviceCommand) error {
 	if err != nil {
 		return errors.Wrap(err, "begin transaction")
 	}
+	defer tx.Rollback()
+	if err := db.save(tx, cmd); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Store) save(tx *bolt.Tx, cmd *DeviceCommand) error {
 	bkt := tx.Bucket([]byte(DeviceCommandBucket))
 	if bkt == nil {
 		return fmt.Errorf("bucket %q not found!", DeviceCommandBucket)
 	}
+	// keep only the pending commands in the per device record.
+	if err := db.putHistory(tx, cmd); err != nil {
+		return err
+	}
+	if err := indexCommands(tx, cmd.DeviceUDID, cmd.Commands, cmd.NotNow); err != nil {
+		return err
+	}
+	if err := scheduleCommands(tx, cmd.DeviceUDID, time.Now().UTC(), cmd.Commands, cmd.NotNow); err != nil {
+		return err
+	}
 	devproto, err := MarshalDeviceCommand(cmd)
 	if err != nil {
 		return errors.Wrap(err, "marshalling DeviceCommand")
 	}
 	key := []byte(cmd.DeviceUDID)
-	if err := bkt.Put(key, devproto); err != nil {
-		return errors.Wrap(err, "put DeviceCommand to boltdb")
-	}
-	return tx.Commit()
+	return errors.Wrap(bkt.Put(key, devproto), "put DeviceCommand to boltdb")
 }
 
 func (db *Store) DeviceCommand(udid string) (*DeviceCommand, error) {
@@ -291,6 +440,10 @@ func (e *notFound) Error() string {
 	return fmt.Sprintf("not found: %s %s", e.ResourceType, e.Message)
 }
 
+func (e *notFound) NotFound() bool {
+	return true
+}
+
 func (db *Store) pollCommands(pubsub pubsub.PublishSubscriber) error {
 	commandEvents, err := pubsub.Subscribe(context.TODO(), "command-queue", command.CommandTopic)
 	if err != nil {
@@ -306,41 +459,12 @@ func (db *Store) pollCommands(pubsub pubsub.PublishSubscriber) error {
 					level.Info(db.logger).Log("msg", "unmarshal command event in queue", "err", err)
 					continue
 				}
-
-				cmd := new(DeviceCommand)
-				cmd.DeviceUDID = ev.DeviceUDID
-				byUDID, err := db.DeviceCommand(ev.DeviceUDID)
-				if err == nil && byUDID != nil {
-					cmd = byUDID
-				}
-				newPayload, err := plist.Marshal(ev.Payload)
-				if err != nil {
-					level.Info(db.logger).Log("msg", "marshal event payload", "err", err)
+				if ev.Queued {
+					// NewCommand queued the command already.
 					continue
 				}
-				newCmd := Command{
-					UUID:    ev.Payload.CommandUUID,
-					Payload: newPayload,
-				}
-				cmd.Commands = append(cmd.Commands, newCmd)
-				if err := db.Save(cmd); err != nil {
+				if _, err := db.EnqueueCoalesced(context.TODO(), &ev); err != nil {
 					level.Info(db.logger).Log("msg", "save command in db", "err", err)
-					continue
-				}
-				level.Info(db.logger).Log(
-					"msg", "queued event for device",
-					"device_udid", ev.DeviceUDID,
-					"command_uuid", ev.Payload.CommandUUID,
-					"request_type", ev.Payload.Command.RequestType,
-				)
-
-				err = PublishCommandQueued(pubsub, ev.DeviceUDID, ev.Payload.CommandUUID)
-				if err != nil {
-					level.Info(db.logger).Log(
-						"msg", "publish command to queued topic",
-						"err", err,
-					)
-					continue
 				}
 			}
 		}
@@ -372,8 +496,15 @@ func (db *Store) pollRawCommands(pubsub pubsub.PublishSubscriber) error {
 					cmd = byUDID
 				}
 				newCmd := Command{
-					UUID:    ev.CommandUUID,
-					Payload: ev.Payload,
+					UUID:        ev.CommandUUID,
+					Payload:     ev.Payload,
+					CreatedAt:   ev.Time,
+					MaxAttempts: ev.Delivery.MaxAttempts,
+					ExpiresAt:   ev.Delivery.ExpiresAt,
+					NotBefore:   ev.Delivery.NotBefore,
+					NotAfter:    ev.Delivery.NotAfter,
+					DependsOn:   ev.Delivery.DependsOn,
+					Priority:    ev.Delivery.Priority,
 				}
 				cmd.Commands = append(cmd.Commands, newCmd)
 				if err := db.Save(cmd); err != nil {
@@ -385,6 +516,10 @@ func (db *Store) pollRawCommands(pubsub pubsub.PublishSubscriber) error {
 					"device_udid", ev.DeviceUDID,
 					"command_uuid", ev.CommandUUID,
 				)
+				if !newCmd.Eligible(time.Now()) {
+					// the scheduler notifies the device later.
+					continue
+				}
 
 				err = PublishCommandQueued(pubsub, ev.DeviceUDID, ev.CommandUUID)
 				if err != nil {
@@ -4,11 +4,15 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/go-kit/kit/log"
 	"github.com/liuds832/micromdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
 )
 
 func TestNext_Error(t *testing.T) {
@@ -155,6 +159,144 @@ func TestNext_zeroCommands(t *testing.T) {
 
 }
 
+func TestNext_DeliveryMetadata(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd"})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	resp := mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}
+	if _, err := store.nextCommand(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+	resp = mdm.Response{
+		UDID:        dc.DeviceUDID,
+		CommandUUID: "xCmd",
+		Status:      "Error",
+		ErrorChain:  []mdm.ErrorChainItem{{ErrorCode: 12021, ErrorDomain: "MCMDMErrorDomain"}},
+	}
+	if _, err := store.nextCommand(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := store.History(ctx, dc.DeviceUDID, command.HistoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 {
+		t.Fatalf("expected one history entry, got %d", len(page.Entries))
+	}
+	entry := page.Entries[0]
+	if have, want := entry.TimesSent, 1; have != want {
+		t.Errorf("have TimesSent %d, want %d", have, want)
+	}
+	if entry.LastSentAt.IsZero() {
+		t.Error("expected LastSentAt to be set")
+	}
+	if have, want := entry.Status, "Error"; have != want {
+		t.Errorf("have status %s, want %s", have, want)
+	}
+	if !strings.Contains(entry.FailureMessage, "12021") {
+		t.Errorf("expected ErrorChain in failure message, got %q", entry.FailureMessage)
+	}
+}
+
+func TestNext_MaxAttempts(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	pubsub := inmem.NewPubSub()
+	failed, err := pubsub.Subscribe(context.Background(), "test", CommandFailedTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.publisher = pubsub
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd", MaxAttempts: 2})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		cmd, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil {
+			t.Fatalf("expected command on attempt %d", i+1)
+		}
+		resp := mdm.Response{UDID: dc.DeviceUDID, CommandUUID: cmd.UUID, Status: "NotNow"}
+		if _, err := store.nextCommand(ctx, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != nil {
+		t.Errorf("expected no command after max attempts, got %s", cmd.UUID)
+	}
+
+	select {
+	case ev := <-failed:
+		cf, err := UnmarshalFailedCommand(ev.Message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := cf.CommandUUID, "xCmd"; have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+		if cf.ID == "" || cf.Time.IsZero() {
+			t.Errorf("expected an event ID and time, got %q and %s", cf.ID, cf.Time)
+		}
+	case <-time.After(time.Second):
+		t.Error("timed out waiting for command failed event")
+	}
+}
+
+func TestNext_Expired(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands,
+		Command{UUID: "xCmd", ExpiresAt: time.Now().Add(-time.Minute)},
+		Command{UUID: "yCmd"},
+	)
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cmd, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.UUID != "yCmd" {
+		t.Fatalf("expected expired command to be skipped, got %v", cmd)
+	}
+
+	page, err := store.History(ctx, dc.DeviceUDID, command.HistoryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].UUID != "xCmd" {
+		t.Fatalf("expected expired command in history, got %+v", page.Entries)
+	}
+	if !strings.Contains(page.Entries[0].FailureMessage, "expired") {
+		t.Errorf("unexpected failure message %q", page.Entries[0].FailureMessage)
+	}
+}
+
 func setupDB(t *testing.T) (*Store, func()) {
 	f, _ := ioutil.TempFile("", "bolt-")
 	teardown := func() {
@@ -168,6 +310,22 @@ func setupDB(t *testing.T) (*Store, func()) {
 	}
 	err = db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(DeviceCommandBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(CommandHistoryBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(CommandIndexBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(ScheduledCommandBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(AcknowledgedBucket))
 		return err
 	})
 	if err != nil {
@@ -176,3 +334,52 @@ func setupDB(t *testing.T) (*Store, func()) {
 	store := &Store{DB: db, logger: log.NewNopLogger()}
 	return store, teardown
 }
+
+func TestCancelCommand(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd"}, Command{UUID: "yCmd"}, Command{UUID: "zCmd"})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	// xCmd is sent and refused with NotNow, yCmd is sent next.
+	if _, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}); err != nil {
+		t.Fatal(err)
+	}
+	resp := mdm.Response{UDID: dc.DeviceUDID, CommandUUID: "xCmd", Status: "NotNow"}
+	if _, err := store.nextCommand(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.CancelCommand(ctx, dc.DeviceUDID, "yCmd"); err != command.ErrCommandSent {
+		t.Errorf("expected ErrCommandSent for a sent command, got %v", err)
+	}
+	if err := store.CancelCommand(ctx, dc.DeviceUDID, "xCmd"); err != nil {
+		t.Errorf("cancel NotNow command: %s", err)
+	}
+	if err := store.CancelCommand(ctx, dc.DeviceUDID, "zCmd"); err != nil {
+		t.Errorf("cancel pending command: %s", err)
+	}
+	if err := store.CancelCommand(ctx, dc.DeviceUDID, "zCmd"); !isNotFound(err) {
+		t.Errorf("expected not found for a canceled command, got %v", err)
+	}
+
+	saved, err := store.DeviceCommand(dc.DeviceUDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Commands) != 1 || saved.Commands[0].UUID != "yCmd" || len(saved.NotNow) != 0 {
+		t.Errorf("unexpected queue after cancel: %+v, NotNow: %+v", saved.Commands, saved.NotNow)
+	}
+	status, err := store.CommandStatus(ctx, "xCmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.State, command.StateCanceled; have != want {
+		t.Errorf("have state %s, want %s", have, want)
+	}
+}
@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// ScheduledCommandBucket stores the commands which may not be sent yet.
+// Keys are the big endian NotBefore time and the command UUID, values are
+// the UDID of the queue, so the bucket is sorted by the time a device must
+// be notified.
+const ScheduledCommandBucket = "mdm.ScheduledCommands"
+
+const scheduleInterval = 15 * time.Second
+
+func scheduleKey(t time.Time, uuid string) []byte {
+	key := make([]byte, 8, 8+len(uuid))
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return append(key, uuid...)
+}
+
+// scheduleCommands records the commands of a queue which become eligible
+// after now.
+func scheduleCommands(tx *bolt.Tx, udid string, now time.Time, lists ...[]Command) error {
+	bkt := tx.Bucket([]byte(ScheduledCommandBucket))
+	if bkt == nil {
+		return fmt.Errorf("bucket %q not found!", ScheduledCommandBucket)
+	}
+	for _, l := range lists {
+		for _, cmd := range l {
+			if cmd.Eligible(now) {
+				continue
+			}
+			if err := bkt.Put(scheduleKey(cmd.NotBefore, cmd.UUID), []byte(udid)); err != nil {
+				return errors.Wrap(err, "put scheduled command")
+			}
+		}
+	}
+	return nil
+}
+
+// NotifyScheduled notifies the devices of commands which became eligible
+// at or before now and returns the number of commands.
+func (db *Store) NotifyScheduled(now time.Time) (int, error) {
+	type due struct{ udid, uuid string }
+	var ready []due
+	err := db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(ScheduledCommandBucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", ScheduledCommandBucket)
+		}
+		var keys [][]byte
+		end := uint64(now.UnixNano())
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil && len(k) >= 8; k, v = c.Next() {
+			if binary.BigEndian.Uint64(k[:8]) > end {
+				break
+			}
+			ready = append(ready, due{udid: string(v), uuid: string(k[8:])})
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := bkt.Delete(k); err != nil {
+				return errors.Wrap(err, "delete scheduled command")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range ready {
+		db.fanout.Add(d.udid, d.uuid)
+	}
+	return len(ready), nil
+}
+
+func (db *Store) runScheduler() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		n, err := db.NotifyScheduled(time.Now().UTC())
+		if err != nil {
+			level.Info(db.logger).Log("msg", "notify scheduled commands", "err", err)
+		} else if n > 0 {
+			level.Debug(db.logger).Log("msg", "notified scheduled commands", "count", n)
+		}
+		<-ticker.C
+	}
+}
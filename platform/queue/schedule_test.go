@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/liuds832/micromdm/mdm"
+	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+)
+
+func TestNext_NotBefore(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands,
+		Command{UUID: "laterCmd", NotBefore: time.Now().Add(time.Hour)},
+		Command{UUID: "nowCmd"},
+	)
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cmd, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.UUID != "nowCmd" {
+		t.Fatalf("expected nowCmd, got %v", cmd)
+	}
+
+	resp := mdm.Response{UDID: dc.DeviceUDID, CommandUUID: "nowCmd", Status: "Acknowledged"}
+	cmd, err = store.nextCommand(ctx, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != nil {
+		t.Fatalf("expected no eligible command, got %s", cmd.UUID)
+	}
+
+	dc, err = store.DeviceCommand(dc.DeviceUDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dc.Commands) != 1 || dc.Commands[0].TimesSent != 0 {
+		t.Fatalf("expected laterCmd to stay queued unsent, got %v", dc.Commands)
+	}
+
+	dc.Commands[0].NotBefore = time.Now().Add(-time.Minute)
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err = store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.UUID != "laterCmd" {
+		t.Fatalf("expected laterCmd once eligible, got %v", cmd)
+	}
+}
+
+func TestNext_NotAfter(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd", NotAfter: time.Now().Add(-time.Minute)})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := store.nextCommand(context.Background(), mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != nil {
+		t.Fatalf("expected no command past not_after, got %s", cmd.UUID)
+	}
+}
+
+func TestNotifyScheduled(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	pubsub := inmem.NewPubSub()
+	queued, err := pubsub.Subscribe(context.Background(), "test", CommandQueuedTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.fanout = NewFanout(pubsub, 1000, log.NewNopLogger())
+
+	notBefore := time.Now().Add(time.Hour)
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd", NotBefore: notBefore})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.NotifyScheduled(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected no due commands, got %d", n)
+	}
+
+	n, err = store.NotifyScheduled(notBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 due command, got %d", n)
+	}
+
+	select {
+	case ev := <-queued:
+		cq, err := UnmarshalQueuedCommand(ev.Message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := cq.CommandUUID, "xCmd"; have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for command queued event")
+	}
+
+	// the command is only notified once.
+	n, err = store.NotifyScheduled(notBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected scheduled command to be removed, got %d", n)
+	}
+}
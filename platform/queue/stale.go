@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// StaleQueue is a queue with commands which the device did not fetch
+// since they became eligible.
+type StaleQueue struct {
+	DeviceUDID string
+
+	// Since is the time the oldest of the commands became eligible.
+	Since time.Time
+}
+
+// StalePending returns the time the command became eligible if it was
+// not sent yet and is not waiting for other commands, or the zero time.
+// Commands refused with NotNow are not stale, because the device checks
+// in again on its own. Neither are commands which are past their delivery
+// limits at now, because they can't be delivered anymore.
+func (c *Command) StalePending(now time.Time) time.Time {
+	if c.TimesSent > 0 || len(c.DependsOn) > 0 || c.CreatedAt.IsZero() {
+		return time.Time{}
+	}
+	if c.DeliveryFailure(now) != "" {
+		return time.Time{}
+	}
+	if c.NotBefore.After(c.CreatedAt) {
+		return c.NotBefore
+	}
+	return c.CreatedAt
+}
+
+// StaleQueues returns the queues with unsent commands which became
+// eligible at or before before.
+func (db *Store) StaleQueues(ctx context.Context, before time.Time) ([]StaleQueue, error) {
+	var stale []StaleQueue
+	now := time.Now().UTC()
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(DeviceCommandBucket)).ForEach(func(k, v []byte) error {
+			var dc DeviceCommand
+			if err := UnmarshalDeviceCommand(v, &dc); err != nil {
+				return errors.Wrapf(err, "unmarshal device commands, udid: %s", k)
+			}
+			var since time.Time
+			for _, cmd := range dc.Commands {
+				eligible := cmd.StalePending(now)
+				if eligible.IsZero() || eligible.After(before) {
+					continue
+				}
+				if since.IsZero() || eligible.Before(since) {
+					since = eligible
+				}
+			}
+			if !since.IsZero() {
+				stale = append(stale, StaleQueue{DeviceUDID: string(k), Since: since})
+			}
+			return nil
+		})
+	})
+	return stale, errors.Wrap(err, "find stale queues")
+}
@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStaleQueues(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	now := time.Now().UTC()
+	old := now.Add(-time.Hour)
+	devices := []*DeviceCommand{
+		{DeviceUDID: "stale", Commands: []Command{
+			{UUID: "newCmd", CreatedAt: now},
+			{UUID: "oldCmd", CreatedAt: old},
+		}},
+		{DeviceUDID: "recent", Commands: []Command{{UUID: "recentCmd", CreatedAt: now}}},
+		{DeviceUDID: "sent", Commands: []Command{{UUID: "sentCmd", CreatedAt: old, TimesSent: 1}}},
+		{DeviceUDID: "waiting", Commands: []Command{{UUID: "depCmd", CreatedAt: old, DependsOn: []string{"sentCmd"}}}},
+		{DeviceUDID: "scheduled", Commands: []Command{{UUID: "laterCmd", CreatedAt: old, NotBefore: now.Add(time.Hour)}}},
+		{DeviceUDID: "notnow", NotNow: []Command{{UUID: "notNowCmd", CreatedAt: old}}},
+		{DeviceUDID: "expired", Commands: []Command{{UUID: "expiredCmd", CreatedAt: old, ExpiresAt: now.Add(-time.Minute)}}},
+		{DeviceUDID: "late", Commands: []Command{{UUID: "lateCmd", CreatedAt: old, NotAfter: now.Add(-time.Minute)}}},
+	}
+	for _, dc := range devices {
+		if err := store.Save(dc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stale, err := store.StaleQueues(context.Background(), now.Add(-15*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0].DeviceUDID != "stale" || !stale[0].Since.Equal(old) {
+		t.Errorf("have stale queues %v, want only the queue of stale since %s", stale, old)
+	}
+}
@@ -0,0 +1,152 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/command"
+)
+
+// indexCommands records the queue of each command which isn't indexed yet.
+func indexCommands(tx *bolt.Tx, udid string, lists ...[]Command) error {
+	bkt := tx.Bucket([]byte(CommandIndexBucket))
+	if bkt == nil {
+		return fmt.Errorf("bucket %q not found!", CommandIndexBucket)
+	}
+	for _, l := range lists {
+		for _, cmd := range l {
+			if bkt.Get([]byte(cmd.UUID)) != nil {
+				continue
+			}
+			if err := bkt.Put([]byte(cmd.UUID), []byte(udid)); err != nil {
+				return errors.Wrap(err, "put command index")
+			}
+		}
+	}
+	return nil
+}
+
+func unindexCommands(tx *bolt.Tx, cmds []Command) error {
+	bkt := tx.Bucket([]byte(CommandIndexBucket))
+	if bkt == nil {
+		return fmt.Errorf("bucket %q not found!", CommandIndexBucket)
+	}
+	for _, cmd := range cmds {
+		if err := bkt.Delete([]byte(cmd.UUID)); err != nil {
+			return errors.Wrap(err, "delete command index")
+		}
+	}
+	return nil
+}
+
+// CommandStatus looks up a queued or finished command by its UUID.
+func (db *Store) CommandStatus(ctx context.Context, uuid string) (*command.CommandStatus, error) {
+	var status *command.CommandStatus
+	err := db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(CommandIndexBucket))
+		if index == nil {
+			return &notFound{"Command", fmt.Sprintf("uuid %s", uuid)}
+		}
+		udid := string(index.Get([]byte(uuid)))
+		if udid == "" {
+			return &notFound{"Command", fmt.Sprintf("uuid %s", uuid)}
+		}
+
+		if v := tx.Bucket([]byte(DeviceCommandBucket)).Get([]byte(udid)); v != nil {
+			var dc DeviceCommand
+			if err := UnmarshalDeviceCommand(v, &dc); err != nil {
+				return err
+			}
+			for _, cmd := range dc.Commands {
+				if cmd.UUID == uuid {
+					state := command.StateQueued
+					if cmd.TimesSent > 0 {
+						state = command.StateSent
+					}
+					status = commandStatus(udid, state, cmd)
+					return nil
+				}
+			}
+			for _, cmd := range dc.NotNow {
+				if cmd.UUID == uuid {
+					status = commandStatus(udid, command.StateNotNow, cmd)
+					return nil
+				}
+			}
+		}
+
+		cmd, err := findHistory(tx, udid, uuid)
+		if err != nil {
+			return err
+		}
+		if cmd == nil {
+			return &notFound{"Command", fmt.Sprintf("uuid %s", uuid)}
+		}
+		status = commandStatus(udid, finishedState(cmd.LastStatus), *cmd)
+		return nil
+	})
+	return status, err
+}
+
+// findHistory returns the finished command uuid of udid, or nil if it is
+// not in the command history.
+func findHistory(tx *bolt.Tx, udid, uuid string) (*Command, error) {
+	history := tx.Bucket([]byte(CommandHistoryBucket))
+	if history == nil {
+		return nil, nil
+	}
+	prefix := historyPrefix(udid)
+	c := history.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if string(k[len(prefix)+8:]) != uuid {
+			continue
+		}
+		var cmd Command
+		if err := UnmarshalCommand(v, &cmd); err != nil {
+			return nil, err
+		}
+		if finished, err := historyKeyTime(k); err == nil && cmd.Acknowledged.IsZero() {
+			cmd.Acknowledged = finished
+		}
+		return &cmd, nil
+	}
+	return nil, nil
+}
+
+func finishedState(lastStatus string) string {
+	switch lastStatus {
+	case "Acknowledged":
+		return command.StateAcknowledged
+	case StatusExpired:
+		return command.StateExpired
+	case StatusDependencyFailed:
+		return command.StateDependencyFailed
+	case StatusCanceled:
+		return command.StateCanceled
+	default:
+		return command.StateError
+	}
+}
+
+func commandStatus(udid, state string, cmd Command) *command.CommandStatus {
+	status := &command.CommandStatus{
+		CommandUUID: cmd.UUID,
+		UDID:        udid,
+		State:       state,
+		CreatedAt:   cmd.CreatedAt,
+		LastSentAt:  cmd.LastSentAt,
+		CompletedAt: cmd.Acknowledged,
+		TimesSent:   cmd.TimesSent,
+		Payload:     cmd.Payload,
+		RawResponse: cmd.Response,
+		DependsOn:   cmd.DependsOn,
+	}
+	if state == command.StateExpired || state == command.StateDependencyFailed {
+		status.FailureReason = string(cmd.FailureMessage)
+	}
+	return status
+}
@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/liuds832/micromdm/mdm"
+	"github.com/liuds832/micromdm/platform/command"
+)
+
+func TestCommandStatus(t *testing.T) {
+	store, teardown := setupDB(t)
+	defer teardown()
+
+	dc := &DeviceCommand{DeviceUDID: "TestDevice"}
+	dc.Commands = append(dc.Commands, Command{UUID: "xCmd", Payload: []byte("payload")})
+	if err := store.Save(dc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	checkState := func(want string) *command.CommandStatus {
+		t.Helper()
+		status, err := store.CommandStatus(ctx, "xCmd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := status.State; have != want {
+			t.Errorf("have state %s, want %s", have, want)
+		}
+		if have, want := status.UDID, dc.DeviceUDID; have != want {
+			t.Errorf("have udid %s, want %s", have, want)
+		}
+		return status
+	}
+
+	checkState(command.StateQueued)
+
+	if _, err := store.nextCommand(ctx, mdm.Response{UDID: dc.DeviceUDID, Status: "Idle"}); err != nil {
+		t.Fatal(err)
+	}
+	checkState(command.StateSent)
+
+	resp := mdm.Response{
+		UDID:        dc.DeviceUDID,
+		CommandUUID: "xCmd",
+		Status:      "Acknowledged",
+		Raw:         []byte("response"),
+	}
+	if _, err := store.nextCommand(ctx, resp); err != nil {
+		t.Fatal(err)
+	}
+	status := checkState(command.StateAcknowledged)
+	if have, want := string(status.RawResponse), "response"; have != want {
+		t.Errorf("have response %q, want %q", have, want)
+	}
+	if status.CompletedAt.IsZero() {
+		t.Error("expected CompletedAt to be set")
+	}
+
+	_, err := store.CommandStatus(ctx, "unknown")
+	if !isNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
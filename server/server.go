@@ -12,8 +12,10 @@ import (
 	"github.com/liuds832/micromdm/dep"
 	"github.com/liuds832/micromdm/mdm"
 	"github.com/liuds832/micromdm/mdm/enroll"
+	"github.com/liuds832/micromdm/pg"
 	"github.com/liuds832/micromdm/platform/apns"
 	apnsbuiltin "github.com/liuds832/micromdm/platform/apns/builtin"
+	apnspg "github.com/liuds832/micromdm/platform/apns/pg"
 	"github.com/liuds832/micromdm/platform/command"
 	"github.com/liuds832/micromdm/platform/config"
 	configbuiltin "github.com/liuds832/micromdm/platform/config/builtin"
@@ -21,12 +23,18 @@ import (
 	syncbuiltin "github.com/liuds832/micromdm/platform/dep/sync/builtin"
 	"github.com/liuds832/micromdm/platform/device"
 	devicebuiltin "github.com/liuds832/micromdm/platform/device/builtin"
+	devicepg "github.com/liuds832/micromdm/platform/device/pg"
+	"github.com/liuds832/micromdm/platform/leader"
+	leaderpg "github.com/liuds832/micromdm/platform/leader/pg"
 	"github.com/liuds832/micromdm/platform/profile"
 	profilebuiltin "github.com/liuds832/micromdm/platform/profile/builtin"
 	"github.com/liuds832/micromdm/platform/pubsub"
+	pubsubbuiltin "github.com/liuds832/micromdm/platform/pubsub/builtin"
 	"github.com/liuds832/micromdm/platform/pubsub/inmem"
+	pubsubpg "github.com/liuds832/micromdm/platform/pubsub/pg"
 	"github.com/liuds832/micromdm/platform/queue"
 	queueinmem "github.com/liuds832/micromdm/platform/queue/inmem"
+	queuepg "github.com/liuds832/micromdm/platform/queue/pg"
 	block "github.com/liuds832/micromdm/platform/remove"
 	blockbuiltin "github.com/liuds832/micromdm/platform/remove/builtin"
 	"github.com/liuds832/micromdm/workflow/webhook"
@@ -34,6 +42,8 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	"github.com/micromdm/scep/v2/challenge"
 	boltchallenge "github.com/micromdm/scep/v2/challenge/bolt"
 	"github.com/micromdm/scep/v2/depot"
@@ -42,11 +52,22 @@ import (
 	"github.com/pkg/errors"
 )
 
+// DeviceStore is implemented by the builtin and pg device datastores.
+type DeviceStore interface {
+	device.Store
+	device.DeviceWorkerStore
+	device.UDIDCertAuthStore
+	mdm.BootstrapTokenRetriever
+}
+
 type Server struct {
 	ConfigPath             string
 	Depsim                 string
 	PubClient              pubsub.PublishSubscriber
 	DB                     *bolt.DB
+	Datastore              string
+	PostgresDSN            string
+	PG                     *sqlx.DB
 	ServerPublicURL        string
 	SCEPChallenge          string
 	SCEPClientValidity     int
@@ -56,19 +77,35 @@ type Server struct {
 	GenDynSCEPChallenge    bool
 	SCEPChallengeDepot     challenge.Store
 	ProfileDB              profile.Store
+	DeviceDB               DeviceStore
 	ConfigDB               config.Store
 	RemoveDB               block.Store
 	CommandWebhookURL      string
 	DEPClient              *dep.Client
 	SyncDB                 *syncbuiltin.DB
 	NoCmdHistory           bool
+	CmdHistoryMaxAge       time.Duration
+	CmdHistoryMaxEntries   int
+	BulkPushRate           int
+	CommandCoalescing      bool
+	PushConcurrency        int
+	PushRate               int
+	PushCoalesceWindow     time.Duration
+	RenotifyAfter          time.Duration
+	RenotifyMaxInterval    time.Duration
+	PushCertExpiryWarnings []time.Duration
 	ValidateSCEPIssuer     bool
 	ValidateSCEPExpiration bool
 	UDIDCertAuthWarnOnly   bool
 	Queue                  string
+	PubSub                 string
+	PubSubBufferSize       int
+	PubSubOverflow         string
+	PubSubSpillLimit       int64
 	DMURL                  string
 
 	APNSPushService apns.Service
+	PushDB          apns.Store
 	CommandService  command.Service
 	MDMService      mdm.Service
 	EnrollService   enroll.Service
@@ -77,15 +114,30 @@ type Server struct {
 
 	CommandQueue mdm.Queue
 
+	// Elector runs the workers which must run in a single instance.
+	Elector leader.Elector
+
 	WebhooksHTTPClient *http.Client
 }
 
 func (c *Server) Setup(logger log.Logger) error {
-	if err := c.setupPubSub(); err != nil {
+	if err := c.setupBolt(); err != nil {
 		return err
 	}
 
-	if err := c.setupBolt(); err != nil {
+	if err := c.setupPostgres(logger); err != nil {
+		return err
+	}
+
+	if err := c.setupPubSub(logger); err != nil {
+		return err
+	}
+
+	if err := c.setupElector(logger); err != nil {
+		return err
+	}
+
+	if err := c.setupDeviceDB(); err != nil {
 		return err
 	}
 
@@ -93,7 +145,7 @@ func (c *Server) Setup(logger log.Logger) error {
 		return err
 	}
 
-	if err := c.setupConfigStore(); err != nil {
+	if err := c.setupConfigStore(logger); err != nil {
 		return err
 	}
 
@@ -101,15 +153,15 @@ func (c *Server) Setup(logger log.Logger) error {
 		return err
 	}
 
-	if err := c.setupPushService(logger); err != nil {
+	if err := c.setupCommandQueue(logger); err != nil {
 		return err
 	}
 
-	if err := c.setupWebhooks(logger); err != nil {
+	if err := c.setupPushService(logger); err != nil {
 		return err
 	}
 
-	if err := c.setupCommandQueue(logger); err != nil {
+	if err := c.setupWebhooks(logger); err != nil {
 		return err
 	}
 
@@ -139,8 +191,89 @@ func (c *Server) setupProfileDB() error {
 	return nil
 }
 
-func (c *Server) setupPubSub() error {
-	c.PubClient = inmem.NewPubSub()
+// broadcastSubscriptions keep state of the instance current, so every
+// instance receives their events when they share a postgres pubsub. The
+// user worker saves to the bolt database of each instance, so it receives
+// every event too. The other competing subscriptions write to postgres.
+var broadcastSubscriptions = []string{
+	"push-server-configs",
+	"enroll-server-configs",
+	"list-token-events",
+	"token-events",
+	"user_worker",
+}
+
+func (c *Server) setupPubSub(logger log.Logger) error {
+	switch c.PubSub {
+	case "inmem":
+		policy, err := inmem.ParseOverflowPolicy(c.PubSubOverflow)
+		if err != nil {
+			return err
+		}
+		c.PubClient = inmem.NewPubSub(
+			inmem.WithLogger(log.With(logger, "component", "pubsub")),
+			inmem.WithBufferSize(c.PubSubBufferSize),
+			inmem.WithOverflowPolicy(policy),
+			inmem.WithSpillDir(filepath.Join(c.ConfigPath, "pubsub-spill")),
+			inmem.WithSpillLimit(c.PubSubSpillLimit),
+		)
+	case "builtin":
+		ps, err := pubsubbuiltin.NewPubSub(c.DB, pubsubbuiltin.WithLogger(log.With(logger, "component", "pubsub")))
+		if err != nil {
+			return err
+		}
+		c.PubClient = ps
+	case "postgres":
+		if c.PG == nil {
+			return errors.New("postgres pubsub requires a postgres connection")
+		}
+		// the competing subscriptions of the command queue and the device
+		// and push info workers must write to stores shared by all
+		// instances.
+		if c.Datastore != "postgres" || c.Queue != "postgres" {
+			return errors.New("postgres pubsub requires the postgres datastore and queue")
+		}
+		ps, err := pubsubpg.NewPubSub(c.PG, c.PostgresDSN,
+			pubsubpg.WithLogger(log.With(logger, "component", "pubsub")),
+			pubsubpg.WithBroadcast(broadcastSubscriptions...),
+		)
+		if err != nil {
+			return err
+		}
+		c.PubClient = ps
+	case "":
+		return errors.New("empty pubsub type")
+	default:
+		return fmt.Errorf("invalid pubsub type: %s", c.PubSub)
+	}
+	return nil
+}
+
+// boltInstanceLease is held by the instance using a postgres pubsub. The
+// config, push certificates, profiles, SCEP and DEP tokens are only stored
+// in the bolt database of an instance, so a second instance would not see
+// them.
+const boltInstanceLease = "bolt-instance"
+
+// setupElector elects a leader among the instances which share a
+// postgres pubsub. Otherwise this instance runs all workers.
+func (c *Server) setupElector(logger log.Logger) error {
+	if c.PubSub != "postgres" {
+		c.Elector = leader.Local()
+		return nil
+	}
+	lease := leader.NewLease(leaderpg.New(c.PG),
+		leader.WithLogger(log.With(logger, "component", "leader")),
+	)
+	// wait for an instance which is shutting down to release the lease.
+	err := lease.Hold(context.Background(), boltInstanceLease, 2*leader.DefaultTTL)
+	if err == leader.ErrHeld {
+		return errors.New("another instance uses the postgres pubsub: config, push certificates, profiles, SCEP and DEP tokens are stored in boltdb, so only one instance may run")
+	}
+	if err != nil {
+		return errors.Wrap(err, "take postgres pubsub instance lease")
+	}
+	c.Elector = lease
 	return nil
 }
 
@@ -165,7 +298,7 @@ func (c *Server) setupRemoveService() error {
 }
 
 func (c *Server) setupCommandService() error {
-	commandService, err := command.New(c.PubClient, c.CommandQueue)
+	commandService, err := command.New(c.PubClient, c.CommandQueue, command.WithDeviceStore(c.DeviceDB))
 	if err != nil {
 		return err
 	}
@@ -177,17 +310,48 @@ func (c *Server) setupCommandQueue(logger log.Logger) error {
 	var q mdm.Queue
 	switch c.Queue {
 	case "inmem":
-		q = queueinmem.New(c.PubClient, logger)
+		var opts []queueinmem.Option
+		if c.CommandCoalescing {
+			opts = append(opts, queueinmem.WithCoalescing())
+		}
+		q = queueinmem.New(c.PubClient, logger, opts...)
 	case "builtin":
-		opts := []queue.Option{queue.WithLogger(logger)}
+		opts := []queue.Option{
+			queue.WithLogger(logger),
+			queue.WithHistoryRetention(c.CmdHistoryMaxAge, c.CmdHistoryMaxEntries),
+			queue.WithBulkPushRate(c.BulkPushRate),
+		}
 		if c.NoCmdHistory {
 			opts = append(opts, queue.WithoutHistory())
 		}
+		if c.CommandCoalescing {
+			opts = append(opts, queue.WithCoalescing())
+		}
 		var err error
 		q, err = queue.NewQueue(c.DB, c.PubClient, opts...)
 		if err != nil {
 			return err
 		}
+	case "postgres":
+		if c.PG == nil {
+			return errors.New("postgres command queue requires a postgres connection")
+		}
+		opts := []queuepg.Option{
+			queuepg.WithLogger(logger),
+			queuepg.WithBulkPushRate(c.BulkPushRate),
+			queuepg.WithElector(c.Elector),
+		}
+		if c.NoCmdHistory {
+			opts = append(opts, queuepg.WithoutHistory())
+		}
+		if c.CommandCoalescing {
+			opts = append(opts, queuepg.WithCoalescing())
+		}
+		var err error
+		q, err = queuepg.NewQueue(c.PG, c.PubClient, opts...)
+		if err != nil {
+			return err
+		}
 	case "":
 		return errors.New("empty command queue type")
 	default:
@@ -195,15 +359,14 @@ func (c *Server) setupCommandQueue(logger log.Logger) error {
 	}
 
 	c.CommandQueue = q
-
-	devDB, err := devicebuiltin.NewDB(c.DB)
-	if err != nil {
-		return errors.Wrap(err, "new device db")
-	}
+	devDB := c.DeviceDB
 
 	var mdmService mdm.Service
 	{
-		var dm mdm.DeclarativeManagement
+		var (
+			dm  mdm.DeclarativeManagement
+			err error
+		)
 		if c.DMURL != "" {
 			dm, err = NewDeclarativeManagementHTTPCaller(c.DMURL, http.DefaultClient)
 			if err != nil {
@@ -237,12 +400,64 @@ func (c *Server) setupBolt() error {
 	return nil
 }
 
+func (c *Server) setupPostgres(logger log.Logger) error {
+	switch c.Datastore {
+	case "builtin":
+	case "postgres":
+		if c.PostgresDSN == "" {
+			return errors.New("postgres datastore requires a postgres DSN")
+		}
+	case "":
+		return errors.New("empty datastore type")
+	default:
+		return fmt.Errorf("invalid datastore type: %s", c.Datastore)
+	}
+
+	if c.PostgresDSN == "" {
+		return nil
+	}
+	db, err := sqlx.Connect("postgres", c.PostgresDSN)
+	if err != nil {
+		return errors.Wrap(err, "connecting to postgres")
+	}
+	if err := pg.Migrate(context.Background(), db); err != nil {
+		return errors.Wrap(err, "migrating postgres schema")
+	}
+	c.PG = db
+
+	if c.Datastore == "postgres" {
+		// Only devices, UDID cert auth, bootstrap tokens and push info
+		// have a pg store so far. Everything else stays in bolt.
+		level.Info(logger).Log(
+			"msg", "using postgres datastore",
+			"postgres", "devices,udid_cert_auth,bootstrap_tokens,push_info",
+			"boltdb", "config,scep,profiles,blueprints,users,dep_sync,remove",
+		)
+	}
+
+	return nil
+}
+
+func (c *Server) setupDeviceDB() error {
+	if c.Datastore == "postgres" {
+		c.DeviceDB = devicepg.New(c.PG)
+		return nil
+	}
+
+	devDB, err := devicebuiltin.NewDB(c.DB)
+	if err != nil {
+		return errors.Wrap(err, "new device db")
+	}
+	c.DeviceDB = devDB
+	return nil
+}
+
 type pushServiceCert struct {
 	*x509.Certificate
 	PrivateKey interface{}
 }
 
-func (c *Server) setupConfigStore() error {
+func (c *Server) setupConfigStore(logger log.Logger) error {
 	db, err := configbuiltin.NewDB(c.DB, c.PubClient)
 	if err != nil {
 		return err
@@ -250,16 +465,46 @@ func (c *Server) setupConfigStore() error {
 	c.ConfigDB = db
 	c.ConfigService = config.New(db)
 
+	if len(c.PushCertExpiryWarnings) > 0 {
+		monitor := config.NewExpiryMonitor(db, c.PubClient,
+			log.With(logger, "component", "push-certificate-expiry"),
+			c.PushCertExpiryWarnings,
+		)
+		c.Elector.Go("push-certificate-expiry", monitor.Run)
+	}
+
 	return nil
 }
 
 func (c *Server) setupPushService(logger log.Logger) error {
-	db, err := apnsbuiltin.NewDB(c.DB, c.PubClient)
-	if err != nil {
-		return err
+	var db interface {
+		apns.Store
+		apns.WorkerStore
+	}
+	if c.Datastore == "postgres" {
+		db = apnspg.New(c.PG)
+	} else {
+		boltDB, err := apnsbuiltin.NewDB(c.DB, c.PubClient)
+		if err != nil {
+			return err
+		}
+		db = boltDB
 	}
 
-	service, err := apns.New(db, c.ConfigDB, c.PubClient)
+	c.PushDB = db
+
+	opts := []apns.Option{
+		apns.WithElector(c.Elector),
+		apns.WithPublisher(c.PubClient),
+		apns.WithDeviceLister(c.DeviceDB),
+		apns.WithPushConcurrency(c.PushConcurrency),
+		apns.WithPushRate(c.PushRate),
+		apns.WithCoalesceWindow(c.PushCoalesceWindow),
+	}
+	if queues, ok := c.CommandQueue.(apns.StaleQueueLister); ok && c.RenotifyAfter > 0 {
+		opts = append(opts, apns.WithRenotifier(queues, c.RenotifyAfter, c.RenotifyMaxInterval))
+	}
+	service, err := apns.New(db, c.ConfigDB, c.PubClient, opts...)
 	if err != nil {
 		return errors.Wrap(err, "starting micromdm push service")
 	}
@@ -350,6 +595,7 @@ func (c *Server) CreateDEPSyncer(logger log.Logger) (sync.Syncer, error) {
 	client := c.DEPClient
 	opts := []sync.Option{
 		sync.WithLogger(log.With(logger, "component", "depsync")),
+		sync.WithElector(c.Elector),
 	}
 	if client != nil {
 		opts = append(opts, sync.WithClient(client))
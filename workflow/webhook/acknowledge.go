@@ -7,12 +7,14 @@ import (
 )
 
 type AcknowledgeEvent struct {
-	UDID         string            `json:"udid,omitempty"`
-	EnrollmentID string            `json:"enrollment_id,omitempty"`
-	Status       string            `json:"status"`
-	CommandUUID  string            `json:"command_uuid,omitempty"`
-	Params       map[string]string `json:"url_params,omitempty"`
-	RawPayload   []byte            `json:"raw_payload"`
+	UDID             string            `json:"udid,omitempty"`
+	UserID           string            `json:"user_id,omitempty"`
+	EnrollmentID     string            `json:"enrollment_id,omitempty"`
+	EnrollmentUserID string            `json:"enrollment_user_id,omitempty"`
+	Status           string            `json:"status"`
+	CommandUUID      string            `json:"command_uuid,omitempty"`
+	Params           map[string]string `json:"url_params,omitempty"`
+	RawPayload       []byte            `json:"raw_payload"`
 }
 
 func acknowledgeEvent(topic string, data []byte) (*Event, error) {
@@ -36,6 +38,12 @@ func acknowledgeEvent(topic string, data []byte) (*Event, error) {
 	if ev.Response.EnrollmentID != nil {
 		webhookEvent.AcknowledgeEvent.EnrollmentID = *ev.Response.EnrollmentID
 	}
+	if ev.Response.UserID != nil {
+		webhookEvent.AcknowledgeEvent.UserID = *ev.Response.UserID
+	}
+	if ev.Response.EnrollmentUserID != nil {
+		webhookEvent.AcknowledgeEvent.EnrollmentUserID = *ev.Response.EnrollmentUserID
+	}
 
 	return &webhookEvent, nil
 }
@@ -7,10 +7,12 @@ import (
 )
 
 type CheckinEvent struct {
-	UDID         string            `json:"udid,omitempty"`
-	EnrollmentID string            `json:"enrollment_id,omitempty"`
-	Params       map[string]string `json:"url_params"`
-	RawPayload   []byte            `json:"raw_payload"`
+	UDID             string            `json:"udid,omitempty"`
+	UserID           string            `json:"user_id,omitempty"`
+	EnrollmentID     string            `json:"enrollment_id,omitempty"`
+	EnrollmentUserID string            `json:"enrollment_user_id,omitempty"`
+	Params           map[string]string `json:"url_params"`
+	RawPayload       []byte            `json:"raw_payload"`
 }
 
 func checkinEvent(topic string, data []byte) (*Event, error) {
@@ -25,10 +27,12 @@ func checkinEvent(topic string, data []byte) (*Event, error) {
 		CreatedAt: ev.Time,
 
 		CheckinEvent: &CheckinEvent{
-			UDID:         ev.Command.UDID,
-			EnrollmentID: ev.Command.EnrollmentID,
-			Params:       ev.Params,
-			RawPayload:   ev.Raw,
+			UDID:             ev.Command.UDID,
+			UserID:           ev.Command.UserID,
+			EnrollmentID:     ev.Command.EnrollmentID,
+			EnrollmentUserID: ev.Command.EnrollmentUserID,
+			Params:           ev.Params,
+			RawPayload:       ev.Raw,
 		},
 	}
 
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/queue"
+)
+
+type CommandEvent struct {
+	UDID        string `json:"udid"`
+	CommandUUID string `json:"command_uuid"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+func commandCanceledEvent(topic string, data []byte) (*Event, error) {
+	var ev command.CanceledEvent
+	if err := command.UnmarshalCanceledEvent(data, &ev); err != nil {
+		return nil, errors.Wrap(err, "unmarshal command canceled event for webhook")
+	}
+	webhookEvent := Event{
+		Topic:     topic,
+		EventID:   ev.ID,
+		CreatedAt: ev.Time,
+
+		CommandEvent: &CommandEvent{
+			UDID:        ev.DeviceUDID,
+			CommandUUID: ev.CommandUUID,
+		},
+	}
+	return &webhookEvent, nil
+}
+
+func commandFailedEvent(topic string, data []byte) (*Event, error) {
+	ev, err := queue.UnmarshalFailedCommand(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal command failed event for webhook")
+	}
+	webhookEvent := Event{
+		Topic:     topic,
+		EventID:   ev.ID,
+		CreatedAt: ev.Time,
+
+		CommandEvent: &CommandEvent{
+			UDID:        ev.DeviceUDID,
+			CommandUUID: ev.CommandUUID,
+			Reason:      ev.Reason,
+		},
+	}
+	return &webhookEvent, nil
+}
@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/liuds832/micromdm/platform/apns"
+	"github.com/liuds832/micromdm/platform/config"
+)
+
+func pushResultEvent(topic string, data []byte) (*Event, error) {
+	var ev apns.PushResult
+	if err := apns.UnmarshalPushResult(data, &ev); err != nil {
+		return nil, errors.Wrap(err, "unmarshal push result event for webhook")
+	}
+	webhookEvent := Event{
+		Topic:     topic,
+		CreatedAt: ev.PushedAt,
+		PushEvent: &ev,
+	}
+	return &webhookEvent, nil
+}
+
+func pushCertificateExpiryEvent(topic string, data []byte) (*Event, error) {
+	var ev config.PushCertificateExpiry
+	if err := config.UnmarshalPushCertificateExpiry(data, &ev); err != nil {
+		return nil, errors.Wrap(err, "unmarshal push certificate expiry event for webhook")
+	}
+	webhookEvent := Event{
+		Topic:                      topic,
+		CreatedAt:                  time.Now().UTC(),
+		PushCertificateExpiryEvent: &ev,
+	}
+	return &webhookEvent, nil
+}
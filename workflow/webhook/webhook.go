@@ -10,8 +10,12 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/liuds832/micromdm/mdm"
-	"github.com/liuds832/micromdm/platform/pubsub"
+	"github.com/liuds832/micromdm/platform/apns"
+	"github.com/liuds832/micromdm/platform/command"
+	"github.com/liuds832/micromdm/platform/config"
 	"github.com/liuds832/micromdm/platform/dep/sync"
+	"github.com/liuds832/micromdm/platform/pubsub"
+	"github.com/liuds832/micromdm/platform/queue"
 )
 
 type Event struct {
@@ -21,7 +25,11 @@ type Event struct {
 
 	AcknowledgeEvent *AcknowledgeEvent `json:"acknowledge_event,omitempty"`
 	CheckinEvent     *CheckinEvent     `json:"checkin_event,omitempty"`
-	DepSyncEvent	 *sync.Event	   `json:"sync_event,omitempty"`
+	DepSyncEvent     *sync.Event       `json:"sync_event,omitempty"`
+	CommandEvent     *CommandEvent     `json:"command_event,omitempty"`
+	PushEvent        *apns.PushResult  `json:"push_event,omitempty"`
+
+	PushCertificateExpiryEvent *config.PushCertificateExpiry `json:"push_certificate_expiry_event,omitempty"`
 }
 
 type Worker struct {
@@ -99,6 +107,26 @@ func (w *Worker) Run(ctx context.Context) error {
 	}
 	// end liuds
 
+	commandCanceledEvents, err := w.sub.Subscribe(ctx, subscription, command.CommandCanceledTopic)
+	if err != nil {
+		return errors.Wrapf(err, "subscribe %s to %s", subscription, command.CommandCanceledTopic)
+	}
+
+	commandFailedEvents, err := w.sub.Subscribe(ctx, subscription, queue.CommandFailedTopic)
+	if err != nil {
+		return errors.Wrapf(err, "subscribe %s to %s", subscription, queue.CommandFailedTopic)
+	}
+
+	pushResultEvents, err := w.sub.Subscribe(ctx, subscription, apns.PushResultTopic)
+	if err != nil {
+		return errors.Wrapf(err, "subscribe %s to %s", subscription, apns.PushResultTopic)
+	}
+
+	pushCertExpiryEvents, err := w.sub.Subscribe(ctx, subscription, config.PushCertificateExpiryTopic)
+	if err != nil {
+		return errors.Wrapf(err, "subscribe %s to %s", subscription, config.PushCertificateExpiryTopic)
+	}
+
 	for {
 		var (
 			event *Event
@@ -121,6 +149,14 @@ func (w *Worker) Run(ctx context.Context) error {
 			event, err = checkinEvent(ev.Topic, ev.Message)
 		case ev := <-depSyncEvents:
 			event, err = depSyncEvent(ev.Topic, ev.Message)
+		case ev := <-commandCanceledEvents:
+			event, err = commandCanceledEvent(ev.Topic, ev.Message)
+		case ev := <-commandFailedEvents:
+			event, err = commandFailedEvent(ev.Topic, ev.Message)
+		case ev := <-pushResultEvents:
+			event, err = pushResultEvent(ev.Topic, ev.Message)
+		case ev := <-pushCertExpiryEvents:
+			event, err = pushCertificateExpiryEvent(ev.Topic, ev.Message)
 		}
 
 		if err != nil {